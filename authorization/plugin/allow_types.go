@@ -28,6 +28,20 @@ func NewAllowTypesPlugin(args []string) (*AllowTypesPlugin, error) {
 			reqType = authorization.RequestType_REQUEST_TYPE_LIST
 		case "delete":
 			reqType = authorization.RequestType_REQUEST_TYPE_DELETE
+		case "list_versions":
+			reqType = authorization.RequestType_REQUEST_TYPE_LIST_VERSIONS
+		case "restore_version":
+			reqType = authorization.RequestType_REQUEST_TYPE_RESTORE_VERSION
+		case "list_trash":
+			reqType = authorization.RequestType_REQUEST_TYPE_LIST_TRASH
+		case "restore_trash":
+			reqType = authorization.RequestType_REQUEST_TYPE_RESTORE_TRASH
+		case "delete_prefix":
+			reqType = authorization.RequestType_REQUEST_TYPE_DELETE_PREFIX
+		case "set_tags":
+			reqType = authorization.RequestType_REQUEST_TYPE_SET_TAGS
+		case "restore_object":
+			reqType = authorization.RequestType_REQUEST_TYPE_RESTORE_OBJECT
 		default:
 			return nil, status.Error(codes.InvalidArgument, "unknown request type: "+arg)
 		}
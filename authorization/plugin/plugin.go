@@ -41,7 +41,7 @@ type Config struct {
 	Args []string
 
 	// Name is the unique name of the plugin used to identify it.
-	Name string
+	Name string `required:"true"`
 
 	LogLevel hclog.Level
 }
@@ -23,31 +23,52 @@ const (
 type RequestType int32
 
 const (
-	RequestType_REQUEST_TYPE_UNSPECIFIED  RequestType = 0
-	RequestType_REQUEST_TYPE_DOWNLOAD     RequestType = 1
-	RequestType_REQUEST_TYPE_UPLOAD       RequestType = 2
-	RequestType_REQUEST_TYPE_GET_METADATA RequestType = 3
-	RequestType_REQUEST_TYPE_LIST         RequestType = 4
-	RequestType_REQUEST_TYPE_DELETE       RequestType = 5
+	RequestType_REQUEST_TYPE_UNSPECIFIED     RequestType = 0
+	RequestType_REQUEST_TYPE_DOWNLOAD        RequestType = 1
+	RequestType_REQUEST_TYPE_UPLOAD          RequestType = 2
+	RequestType_REQUEST_TYPE_GET_METADATA    RequestType = 3
+	RequestType_REQUEST_TYPE_LIST            RequestType = 4
+	RequestType_REQUEST_TYPE_DELETE          RequestType = 5
+	RequestType_REQUEST_TYPE_LIST_VERSIONS   RequestType = 6
+	RequestType_REQUEST_TYPE_RESTORE_VERSION RequestType = 7
+	RequestType_REQUEST_TYPE_LIST_TRASH      RequestType = 8
+	RequestType_REQUEST_TYPE_RESTORE_TRASH   RequestType = 9
+	RequestType_REQUEST_TYPE_DELETE_PREFIX   RequestType = 10
+	RequestType_REQUEST_TYPE_SET_TAGS        RequestType = 11
+	RequestType_REQUEST_TYPE_RESTORE_OBJECT  RequestType = 12
 )
 
 // Enum value maps for RequestType.
 var (
 	RequestType_name = map[int32]string{
-		0: "REQUEST_TYPE_UNSPECIFIED",
-		1: "REQUEST_TYPE_DOWNLOAD",
-		2: "REQUEST_TYPE_UPLOAD",
-		3: "REQUEST_TYPE_GET_METADATA",
-		4: "REQUEST_TYPE_LIST",
-		5: "REQUEST_TYPE_DELETE",
+		0:  "REQUEST_TYPE_UNSPECIFIED",
+		1:  "REQUEST_TYPE_DOWNLOAD",
+		2:  "REQUEST_TYPE_UPLOAD",
+		3:  "REQUEST_TYPE_GET_METADATA",
+		4:  "REQUEST_TYPE_LIST",
+		5:  "REQUEST_TYPE_DELETE",
+		6:  "REQUEST_TYPE_LIST_VERSIONS",
+		7:  "REQUEST_TYPE_RESTORE_VERSION",
+		8:  "REQUEST_TYPE_LIST_TRASH",
+		9:  "REQUEST_TYPE_RESTORE_TRASH",
+		10: "REQUEST_TYPE_DELETE_PREFIX",
+		11: "REQUEST_TYPE_SET_TAGS",
+		12: "REQUEST_TYPE_RESTORE_OBJECT",
 	}
 	RequestType_value = map[string]int32{
-		"REQUEST_TYPE_UNSPECIFIED":  0,
-		"REQUEST_TYPE_DOWNLOAD":     1,
-		"REQUEST_TYPE_UPLOAD":       2,
-		"REQUEST_TYPE_GET_METADATA": 3,
-		"REQUEST_TYPE_LIST":         4,
-		"REQUEST_TYPE_DELETE":       5,
+		"REQUEST_TYPE_UNSPECIFIED":     0,
+		"REQUEST_TYPE_DOWNLOAD":        1,
+		"REQUEST_TYPE_UPLOAD":          2,
+		"REQUEST_TYPE_GET_METADATA":    3,
+		"REQUEST_TYPE_LIST":            4,
+		"REQUEST_TYPE_DELETE":          5,
+		"REQUEST_TYPE_LIST_VERSIONS":   6,
+		"REQUEST_TYPE_RESTORE_VERSION": 7,
+		"REQUEST_TYPE_LIST_TRASH":      8,
+		"REQUEST_TYPE_RESTORE_TRASH":   9,
+		"REQUEST_TYPE_DELETE_PREFIX":   10,
+		"REQUEST_TYPE_SET_TAGS":        11,
+		"REQUEST_TYPE_RESTORE_OBJECT":  12,
 	}
 )
 
@@ -264,7 +285,7 @@ var file_authorization_v1_authorization_proto_rawDesc = []byte{
 	0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x16, 0x0a,
 	0x06, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x09, 0x52, 0x06, 0x76,
 	0x61, 0x6c, 0x75, 0x65, 0x73, 0x22, 0x13, 0x0a, 0x11, 0x41, 0x75, 0x74, 0x68, 0x6f, 0x72, 0x69,
-	0x7a, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x2a, 0xae, 0x01, 0x0a, 0x0b, 0x52,
+	0x7a, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x2a, 0x89, 0x03, 0x0a, 0x0b, 0x52,
 	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x54, 0x79, 0x70, 0x65, 0x12, 0x1c, 0x0a, 0x18, 0x52, 0x45,
 	0x51, 0x55, 0x45, 0x53, 0x54, 0x5f, 0x54, 0x59, 0x50, 0x45, 0x5f, 0x55, 0x4e, 0x53, 0x50, 0x45,
 	0x43, 0x49, 0x46, 0x49, 0x45, 0x44, 0x10, 0x00, 0x12, 0x19, 0x0a, 0x15, 0x52, 0x45, 0x51, 0x55,
@@ -275,18 +296,31 @@ var file_authorization_v1_authorization_proto_rawDesc = []byte{
 	0x5f, 0x4d, 0x45, 0x54, 0x41, 0x44, 0x41, 0x54, 0x41, 0x10, 0x03, 0x12, 0x15, 0x0a, 0x11, 0x52,
 	0x45, 0x51, 0x55, 0x45, 0x53, 0x54, 0x5f, 0x54, 0x59, 0x50, 0x45, 0x5f, 0x4c, 0x49, 0x53, 0x54,
 	0x10, 0x04, 0x12, 0x17, 0x0a, 0x13, 0x52, 0x45, 0x51, 0x55, 0x45, 0x53, 0x54, 0x5f, 0x54, 0x59,
-	0x50, 0x45, 0x5f, 0x44, 0x45, 0x4c, 0x45, 0x54, 0x45, 0x10, 0x05, 0x32, 0x6c, 0x0a, 0x14, 0x41,
-	0x75, 0x74, 0x68, 0x6f, 0x72, 0x69, 0x7a, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x53, 0x65, 0x72, 0x76,
-	0x69, 0x63, 0x65, 0x12, 0x54, 0x0a, 0x09, 0x41, 0x75, 0x74, 0x68, 0x6f, 0x72, 0x69, 0x7a, 0x65,
-	0x12, 0x22, 0x2e, 0x61, 0x75, 0x74, 0x68, 0x6f, 0x72, 0x69, 0x7a, 0x61, 0x74, 0x69, 0x6f, 0x6e,
-	0x2e, 0x76, 0x31, 0x2e, 0x41, 0x75, 0x74, 0x68, 0x6f, 0x72, 0x69, 0x7a, 0x65, 0x52, 0x65, 0x71,
-	0x75, 0x65, 0x73, 0x74, 0x1a, 0x23, 0x2e, 0x61, 0x75, 0x74, 0x68, 0x6f, 0x72, 0x69, 0x7a, 0x61,
-	0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x76, 0x31, 0x2e, 0x41, 0x75, 0x74, 0x68, 0x6f, 0x72, 0x69, 0x7a,
-	0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x42, 0x32, 0x5a, 0x30, 0x67, 0x69, 0x74,
-	0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x74, 0x68, 0x65, 0x6c, 0x65, 0x65, 0x65, 0x6f,
-	0x2f, 0x66, 0x69, 0x6c, 0x65, 0x2d, 0x62, 0x75, 0x74, 0x6c, 0x65, 0x72, 0x2f, 0x61, 0x75, 0x74,
-	0x68, 0x6f, 0x72, 0x69, 0x7a, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2f, 0x76, 0x31, 0x62, 0x06, 0x70,
-	0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x50, 0x45, 0x5f, 0x44, 0x45, 0x4c, 0x45, 0x54, 0x45, 0x10, 0x05, 0x12, 0x1e, 0x0a, 0x1a, 0x52,
+	0x45, 0x51, 0x55, 0x45, 0x53, 0x54, 0x5f, 0x54, 0x59, 0x50, 0x45, 0x5f, 0x4c, 0x49, 0x53, 0x54,
+	0x5f, 0x56, 0x45, 0x52, 0x53, 0x49, 0x4f, 0x4e, 0x53, 0x10, 0x06, 0x12, 0x20, 0x0a, 0x1c, 0x52,
+	0x45, 0x51, 0x55, 0x45, 0x53, 0x54, 0x5f, 0x54, 0x59, 0x50, 0x45, 0x5f, 0x52, 0x45, 0x53, 0x54,
+	0x4f, 0x52, 0x45, 0x5f, 0x56, 0x45, 0x52, 0x53, 0x49, 0x4f, 0x4e, 0x10, 0x07, 0x12, 0x1b, 0x0a,
+	0x17, 0x52, 0x45, 0x51, 0x55, 0x45, 0x53, 0x54, 0x5f, 0x54, 0x59, 0x50, 0x45, 0x5f, 0x4c, 0x49,
+	0x53, 0x54, 0x5f, 0x54, 0x52, 0x41, 0x53, 0x48, 0x10, 0x08, 0x12, 0x1e, 0x0a, 0x1a, 0x52, 0x45,
+	0x51, 0x55, 0x45, 0x53, 0x54, 0x5f, 0x54, 0x59, 0x50, 0x45, 0x5f, 0x52, 0x45, 0x53, 0x54, 0x4f,
+	0x52, 0x45, 0x5f, 0x54, 0x52, 0x41, 0x53, 0x48, 0x10, 0x09, 0x12, 0x1e, 0x0a, 0x1a, 0x52, 0x45,
+	0x51, 0x55, 0x45, 0x53, 0x54, 0x5f, 0x54, 0x59, 0x50, 0x45, 0x5f, 0x44, 0x45, 0x4c, 0x45, 0x54,
+	0x45, 0x5f, 0x50, 0x52, 0x45, 0x46, 0x49, 0x58, 0x10, 0x0a, 0x12, 0x19, 0x0a, 0x15, 0x52, 0x45,
+	0x51, 0x55, 0x45, 0x53, 0x54, 0x5f, 0x54, 0x59, 0x50, 0x45, 0x5f, 0x53, 0x45, 0x54, 0x5f, 0x54,
+	0x41, 0x47, 0x53, 0x10, 0x0b, 0x12, 0x1f, 0x0a, 0x1b, 0x52, 0x45, 0x51, 0x55, 0x45, 0x53, 0x54,
+	0x5f, 0x54, 0x59, 0x50, 0x45, 0x5f, 0x52, 0x45, 0x53, 0x54, 0x4f, 0x52, 0x45, 0x5f, 0x4f, 0x42,
+	0x4a, 0x45, 0x43, 0x54, 0x10, 0x0c, 0x32, 0x6c, 0x0a, 0x14, 0x41, 0x75, 0x74, 0x68, 0x6f, 0x72,
+	0x69, 0x7a, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x54,
+	0x0a, 0x09, 0x41, 0x75, 0x74, 0x68, 0x6f, 0x72, 0x69, 0x7a, 0x65, 0x12, 0x22, 0x2e, 0x61, 0x75,
+	0x74, 0x68, 0x6f, 0x72, 0x69, 0x7a, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x76, 0x31, 0x2e, 0x41,
+	0x75, 0x74, 0x68, 0x6f, 0x72, 0x69, 0x7a, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a,
+	0x23, 0x2e, 0x61, 0x75, 0x74, 0x68, 0x6f, 0x72, 0x69, 0x7a, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e,
+	0x76, 0x31, 0x2e, 0x41, 0x75, 0x74, 0x68, 0x6f, 0x72, 0x69, 0x7a, 0x65, 0x52, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x42, 0x32, 0x5a, 0x30, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63,
+	0x6f, 0x6d, 0x2f, 0x74, 0x68, 0x65, 0x6c, 0x65, 0x65, 0x65, 0x6f, 0x2f, 0x66, 0x69, 0x6c, 0x65,
+	0x2d, 0x62, 0x75, 0x74, 0x6c, 0x65, 0x72, 0x2f, 0x61, 0x75, 0x74, 0x68, 0x6f, 0x72, 0x69, 0x7a,
+	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2f, 0x76, 0x31, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
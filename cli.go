@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/theleeeo/file-butler/client"
+)
+
+const (
+	defaultButlerURL = "http://localhost:8080"
+
+	// The environment variables that set the defaults of the flags shared by the client commands.
+	// The headers are separated by newlines so that tokens can be kept out of the shell history.
+	butlerURLEnv     = "FILE_BUTLER_URL"
+	butlerHeadersEnv = "FILE_BUTLER_HEADERS"
+)
+
+// errUsage is returned by a command when its arguments are invalid, the usage has already been printed
+var errUsage = errors.New("invalid usage")
+
+// listFlag is a flag that can be given multiple times
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// clientOptions are the flags shared by the commands that talk to a running butler
+type clientOptions struct {
+	url     string
+	headers listFlag
+	json    bool
+}
+
+// newClientFlags returns the flags of a client command with the shared flags already defined
+func newClientFlags(name, args, desc string) (*flag.FlagSet, *clientOptions) {
+	opts := &clientOptions{}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&opts.url, "url", envOr(butlerURLEnv, defaultButlerURL), "the base URL of the butler, default from $"+butlerURLEnv)
+	fs.Var(&opts.headers, "H", "a header sent with every request, eg. \"Authorization: Bearer <token>\", can be repeated. Also read from $"+butlerHeadersEnv)
+	fs.BoolVar(&opts.json, "json", false, "print the output as JSON")
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: file-butler %s [flags] %s\n\n%s\n\nFlags:\n", name, args, desc)
+		fs.PrintDefaults()
+	}
+
+	return fs, opts
+}
+
+// parseClientFlags parses the arguments and checks that the number of positional arguments is within the limits
+func parseClientFlags(fs *flag.FlagSet, args []string, minArgs, maxArgs int) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return errUsage
+	}
+
+	if fs.NArg() < minArgs || fs.NArg() > maxArgs {
+		fs.Usage()
+		return errUsage
+	}
+
+	return nil
+}
+
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+
+	return def
+}
+
+// butlerClient is the client of a command and how it prints its output
+type butlerClient struct {
+	*client.Client
+	out  io.Writer
+	json bool
+}
+
+func (o *clientOptions) client() (*butlerClient, error) {
+	var envLines []string
+	if env := os.Getenv(butlerHeadersEnv); env != "" {
+		envLines = strings.Split(env, "\n")
+	}
+
+	headers, err := parseHeaders(envLines)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", butlerHeadersEnv, err)
+	}
+
+	flagHeaders, err := parseHeaders(o.headers)
+	if err != nil {
+		return nil, err
+	}
+
+	// The flags replace the headers of the environment with the same name
+	for name, values := range flagHeaders {
+		headers[name] = values
+	}
+
+	c, err := client.New(client.Config{BaseURL: o.url, Header: headers})
+	if err != nil {
+		return nil, err
+	}
+
+	return &butlerClient{Client: c, out: os.Stdout, json: o.json}, nil
+}
+
+// parseHeaders parses headers formatted as "Name: value", empty lines are skipped
+func parseHeaders(lines []string) (http.Header, error) {
+	headers := make(http.Header)
+
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid header %q, must be formatted as \"Name: value\"", line)
+		}
+
+		headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	return headers, nil
+}
+
+// location is a file or prefix in a provider, written as <provider>/<key>
+type location struct {
+	provider string
+	key      string
+}
+
+func parseLocation(s string) (location, error) {
+	providerName, key, _ := strings.Cut(s, "/")
+	if providerName == "" {
+		return location{}, fmt.Errorf("invalid location %q, must be formatted as <provider>/<key>", s)
+	}
+
+	return location{provider: providerName, key: key}, nil
+}
+
+func (l location) String() string {
+	return l.provider + "/" + l.key
+}
+
+// isPrefix reports if the location refers to a directory-like prefix instead of a single file
+func (l location) isPrefix() bool {
+	return l.key == "" || strings.HasSuffix(l.key, "/")
+}
+
+// relativeKey returns the key relative to the last / of the prefix, the same way as the files of an archive are named
+func relativeKey(prefix, key string) string {
+	return key[strings.LastIndex(prefix, "/")+1:]
+}
+
+// printJSON writes v as a line of JSON to the output
+func (c *butlerClient) printJSON(v any) error {
+	return json.NewEncoder(c.out).Encode(v)
+}
+
+// printf writes a line of human readable output
+func (c *butlerClient) printf(format string, args ...any) {
+	fmt.Fprintf(c.out, format+"\n", args...)
+}
@@ -0,0 +1,195 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// MaxBatchOperations is the maximum number of operations the server accepts in one batch
+const MaxBatchOperations = 1000
+
+type BatchOp string
+
+const (
+	BatchOpDelete  BatchOp = "delete"
+	BatchOpCopy    BatchOp = "copy"
+	BatchOpSetTags BatchOp = "set-tags"
+	BatchOpStat    BatchOp = "stat"
+)
+
+type BatchOperation struct {
+	Op       BatchOp `json:"op"`
+	Provider string  `json:"provider"`
+	Key      string  `json:"key"`
+
+	// The destination of a copy, the destination provider defaults to the source provider
+	DestProvider string `json:"dest_provider,omitempty"`
+	DestKey      string `json:"dest_key,omitempty"`
+
+	// The tags to set with set-tags, replacing any existing tags
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+type BatchResult struct {
+	// Status is the status code the operation would have had as a single request
+	Status int         `json:"status"`
+	Error  string      `json:"error,omitempty"`
+	Info   *ObjectStat `json:"info,omitempty"`
+}
+
+// Err returns the error of a failed operation as an *Error, or nil if it succeeded
+func (r BatchResult) Err() error {
+	if r.Status < http.StatusBadRequest {
+		return nil
+	}
+
+	return &Error{StatusCode: r.Status, Message: r.Error, err: matchError(r.Status, r.Error)}
+}
+
+// ObjectStat is the result of a stat operation
+type ObjectStat struct {
+	LastModified  *time.Time        `json:"last_modified,omitempty"`
+	ContentLength *int64            `json:"content_length,omitempty"`
+	ContentType   *string           `json:"content_type,omitempty"`
+	VersionID     *string           `json:"version_id,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+}
+
+// Batch executes the operations in a single request, the results are in the same order as the operations.
+// The returned error is only set if the whole batch failed, the errors of the operations are in the results.
+// Batches are not retried since they can contain operations that are not idempotent.
+func (c *Client) Batch(ctx context.Context, ops []BatchOperation) ([]BatchResult, error) {
+	if len(ops) > MaxBatchOperations {
+		return nil, fmt.Errorf("a batch can contain at most %d operations", MaxBatchOperations)
+	}
+
+	r, err := jsonBody(ops)
+	if err != nil {
+		return nil, err
+	}
+	r.method = http.MethodPost
+	r.path = "/batch"
+
+	var results []BatchResult
+	if err := c.doJSON(ctx, r, &results); err != nil {
+		return nil, err
+	}
+
+	if len(results) != len(ops) {
+		return nil, fmt.Errorf("invalid response: %d results for %d operations", len(results), len(ops))
+	}
+
+	return results, nil
+}
+
+// single executes one operation as a batch and returns its error
+func (c *Client) single(ctx context.Context, op BatchOperation) (BatchResult, error) {
+	results, err := c.Batch(ctx, []BatchOperation{op})
+	if err != nil {
+		return BatchResult{}, err
+	}
+
+	return results[0], results[0].Err()
+}
+
+// Copy copies the object including its content type and tags, without downloading it
+func (c *Client) Copy(ctx context.Context, providerID, key, destProviderID, destKey string) error {
+	_, err := c.single(ctx, BatchOperation{Op: BatchOpCopy, Provider: providerID, Key: key, DestProvider: destProviderID, DestKey: destKey})
+	return err
+}
+
+// SetTags replaces the tags of the object
+func (c *Client) SetTags(ctx context.Context, providerID, key string, tags map[string]string) error {
+	_, err := c.single(ctx, BatchOperation{Op: BatchOpSetTags, Provider: providerID, Key: key, Tags: tags})
+	return err
+}
+
+// Stat returns the content type, length, last modification time and metadata of the object without downloading it
+func (c *Client) Stat(ctx context.Context, providerID, key string) (ObjectStat, error) {
+	result, err := c.single(ctx, BatchOperation{Op: BatchOpStat, Provider: providerID, Key: key})
+	if err != nil || result.Info == nil {
+		return ObjectStat{}, err
+	}
+
+	return *result.Info, nil
+}
+
+// DeletePrefixProgress is a line of the progress of a prefix delete
+type DeletePrefixProgress struct {
+	// Key is set when reporting a single object, either one that would be deleted in a dry-run or one that failed
+	Key   string `json:"key,omitempty"`
+	Error string `json:"error,omitempty"`
+
+	Deleted int  `json:"deleted"`
+	Failed  int  `json:"failed"`
+	Total   int  `json:"total"`
+	DryRun  bool `json:"dry_run,omitempty"`
+	Done    bool `json:"done,omitempty"`
+}
+
+type DeletePrefixOptions struct {
+	// DryRun reports the objects that would be deleted without deleting them
+	DryRun bool
+
+	// Progress is called with each line of the progress as it is received
+	Progress func(DeletePrefixProgress)
+}
+
+// DeletePrefix deletes all objects that start with the prefix and returns the final progress.
+// The returned error is only set if the delete could not be done, the objects that failed are counted in the progress.
+func (c *Client) DeletePrefix(ctx context.Context, providerID, prefix string, opts DeletePrefixOptions) (DeletePrefixProgress, error) {
+	if prefix == "" {
+		return DeletePrefixProgress{}, errors.New("a prefix is required, deleting everything in a provider is not allowed")
+	}
+
+	// The prefix is repeated to confirm the delete
+	query := url.Values{"confirm": {prefix}}
+	if opts.DryRun {
+		query = url.Values{"dry-run": {"true"}}
+	}
+
+	resp, err := c.do(ctx, request{
+		method: http.MethodDelete,
+		path:   objectPath("prefix", providerID, prefix),
+		query:  query,
+	})
+	if err != nil {
+		return DeletePrefixProgress{}, err
+	}
+	defer resp.Body.Close()
+
+	var last DeletePrefixProgress
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var p DeletePrefixProgress
+		if err := json.Unmarshal(scanner.Bytes(), &p); err != nil {
+			return last, fmt.Errorf("invalid response: %w", err)
+		}
+		last = p
+
+		if opts.Progress != nil {
+			opts.Progress(p)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return last, err
+	}
+
+	if !last.Done {
+		return last, errors.New("the server stopped before the delete was done")
+	}
+
+	// The delete was aborted, eg. because the provider failed
+	if last.Error != "" {
+		return last, errors.New(last.Error)
+	}
+
+	return last, nil
+}
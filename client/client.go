@@ -0,0 +1,235 @@
+// Package client is a Go client of the file-butler HTTP API.
+//
+// All methods take the ID of a provider and a key, the same way as the endpoints of the server.
+// Errors returned by the server are returned as *Error, which can be matched against the error values of this package with errors.Is.
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxRetries = 3
+	defaultRetryDelay = 200 * time.Millisecond
+
+	// maxRetryDelay caps the delay between retries, including the delays asked for by the server
+	maxRetryDelay = 30 * time.Second
+
+	// maxErrorBody is how much of an error response is read as the error message
+	maxErrorBody = 4096
+)
+
+type Config struct {
+	// BaseURL is the URL of the server, eg. http://localhost:8080
+	BaseURL string
+
+	// Header is sent with every request, eg. the headers that the auth plugin of the server authorizes the requests with
+	Header http.Header
+
+	// HTTPClient sends the requests, default is http.DefaultClient
+	HTTPClient *http.Client
+
+	// MaxRetries is how many times an idempotent request is retried after a network error or a 429, 502, 503 or 504 response.
+	// Default is 3, set it to a negative number to disable retries.
+	MaxRetries int
+
+	// RetryDelay is the delay before the first retry, it is doubled for each retry.
+	// A Retry-After header of the response is used instead if it is longer. Default is 200ms.
+	RetryDelay time.Duration
+}
+
+// Client sends requests to a file-butler server, it is safe for concurrent use
+type Client struct {
+	baseURL    string
+	header     http.Header
+	httpClient *http.Client
+	maxRetries int
+	retryDelay time.Duration
+}
+
+func New(cfg Config) (*Client, error) {
+	u, err := url.Parse(cfg.BaseURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid base URL %q", cfg.BaseURL)
+	}
+
+	c := &Client{
+		baseURL:    strings.TrimRight(cfg.BaseURL, "/"),
+		header:     cfg.Header.Clone(),
+		httpClient: cfg.HTTPClient,
+		maxRetries: cfg.MaxRetries,
+		retryDelay: cfg.RetryDelay,
+	}
+
+	if c.httpClient == nil {
+		c.httpClient = http.DefaultClient
+	}
+
+	if c.maxRetries == 0 {
+		c.maxRetries = defaultMaxRetries
+	} else if c.maxRetries < 0 {
+		c.maxRetries = 0
+	}
+
+	if c.retryDelay <= 0 {
+		c.retryDelay = defaultRetryDelay
+	}
+
+	return c, nil
+}
+
+// request is a request to the server, it is created again for each attempt since the http.Request can not be reused
+type request struct {
+	method string
+	// The path of the request without the base URL, eg. /file/<provider>/<key>
+	path   string
+	query  url.Values
+	header http.Header
+
+	// body returns a new body for each attempt, it is nil for requests without a body
+	body          func() (io.Reader, error)
+	contentLength int64
+
+	// idempotent requests are retried
+	idempotent bool
+
+	// notFoundAfterRetry makes a 404 of a retry a success without a response, since the first attempt may have deleted the object
+	notFoundAfterRetry bool
+}
+
+// objectPath returns the path of a request for a key in a provider, eg. /file/<provider>/<key>
+func objectPath(request, providerID, key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+
+	return "/" + request + "/" + url.PathEscape(providerID) + "/" + strings.Join(segments, "/")
+}
+
+// do sends the request, retrying it if it is idempotent and the failure is temporary.
+// A response with an error status is returned as an *Error, the caller must close the body of a successful response.
+func (c *Client) do(ctx context.Context, r request) (*http.Response, error) {
+	delay := c.retryDelay
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, r)
+		if err == nil {
+			return resp, nil
+		}
+
+		if attempt > 0 && r.notFoundAfterRetry && errors.Is(err, ErrNotFound) {
+			return nil, nil
+		}
+
+		if !r.idempotent || attempt >= c.maxRetries || !retryable(err) {
+			return nil, err
+		}
+
+		wait := delay
+		var statusErr *Error
+		if errors.As(err, &statusErr) && statusErr.RetryAfter > wait {
+			wait = statusErr.RetryAfter
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(min(wait, maxRetryDelay)):
+		}
+
+		delay *= 2
+	}
+}
+
+func (c *Client) send(ctx context.Context, r request) (*http.Response, error) {
+	u := c.baseURL + r.path
+	if len(r.query) > 0 {
+		u += "?" + r.query.Encode()
+	}
+
+	var body io.Reader
+	if r.body != nil {
+		var err error
+		if body, err = r.body(); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, r.method, u, body)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range c.header {
+		req.Header[name] = values
+	}
+	for name, values := range r.header {
+		req.Header[name] = values
+	}
+
+	// The server rejects uploads of unknown length, and the body is not always a type that the http package knows the length of
+	if r.body != nil {
+		req.ContentLength = r.contentLength
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest || resp.StatusCode == http.StatusNotModified {
+		defer resp.Body.Close()
+
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return nil, newError(resp, strings.TrimSpace(string(msg)))
+	}
+
+	return resp, nil
+}
+
+// retryable reports if a failed request may succeed if it is sent again
+func retryable(err error) bool {
+	var statusErr *Error
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			// A misconfigured provider is also returned as 502, it will not fix itself
+			return !errors.Is(err, ErrMisconfigured)
+		}
+		return false
+	}
+
+	// The request was canceled by the caller
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	// Any other error is from the connection
+	return true
+}
+
+// parseRetryAfter returns the delay of a Retry-After header, in either seconds or as a time
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(v); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+
+	return 0
+}
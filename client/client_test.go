@@ -0,0 +1,351 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	authPlugin "github.com/theleeeo/file-butler/authorization/plugin"
+	"github.com/theleeeo/file-butler/provider"
+	"github.com/theleeeo/file-butler/server"
+)
+
+// newTestServer starts a server with a memory provider "mem" that allows everything,
+// and a memory provider "readonly" whose plugin only allows downloads and listing
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	allowAll, err := authPlugin.NewPlugin(authPlugin.Config{
+		Name:    "default",
+		BuiltIn: "allow-types",
+		Args:    []string{"download", "upload", "get_metadata", "list", "delete", "delete_prefix", "set_tags"},
+	})
+	assert.NoError(t, err)
+
+	readOnly, err := authPlugin.NewPlugin(authPlugin.Config{
+		Name:    "readonly",
+		BuiltIn: "allow-types",
+		Args:    []string{"download", "list"},
+	})
+	assert.NoError(t, err)
+
+	// The address is not used since the handler is served by the test server
+	srv, err := server.NewServer(server.Config{
+		Addr:              "localhost:0",
+		DefaultAuthPlugin: "default",
+		AllowRawBody:      true,
+	}, []authPlugin.Plugin{allowAll, readOnly})
+	assert.NoError(t, err)
+
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+
+	mem, err := provider.NewMemoryProvider(&provider.MemoryConfig{
+		ConfigBase:     provider.ConfigBase{ID: "mem"},
+		PresignBaseURL: ts.URL,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, srv.RegisterProvider(mem))
+
+	ro, err := provider.NewMemoryProvider(&provider.MemoryConfig{
+		ConfigBase: provider.ConfigBase{ID: "readonly", AuthPlugin: "readonly"},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, srv.RegisterProvider(ro))
+
+	return ts
+}
+
+func newTestClient(t *testing.T, baseURL string) *Client {
+	t.Helper()
+
+	c, err := New(Config{BaseURL: baseURL, RetryDelay: 1})
+	assert.NoError(t, err)
+
+	return c
+}
+
+func Test_New(t *testing.T) {
+	_, err := New(Config{BaseURL: "localhost:8080"})
+	assert.Error(t, err)
+
+	c, err := New(Config{BaseURL: "http://localhost:8080/"})
+	assert.NoError(t, err)
+	assert.Equal(t, "http://localhost:8080", c.baseURL)
+	assert.Equal(t, defaultMaxRetries, c.maxRetries)
+
+	c, err = New(Config{BaseURL: "http://localhost:8080", MaxRetries: -1})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, c.maxRetries)
+}
+
+func Test_UploadDownload(t *testing.T) {
+	ts := newTestServer(t)
+	c := newTestClient(t, ts.URL)
+	ctx := context.Background()
+
+	t.Run("Raw", func(t *testing.T) {
+		err := c.Upload(ctx, "mem", "docs/a file.txt", strings.NewReader("hello"), UploadOptions{
+			ContentType: "text/plain",
+			Tags:        map[string]string{"author": "john"},
+			Raw:         true,
+		})
+		assert.NoError(t, err)
+
+		data, info, err := c.Download(ctx, "mem", "docs/a file.txt", DownloadOptions{})
+		assert.NoError(t, err)
+		defer data.Close()
+
+		b, err := io.ReadAll(data)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(b))
+		assert.Equal(t, "text/plain", info.ContentType)
+		assert.Equal(t, int64(5), info.ContentLength)
+		assert.False(t, info.LastModified.IsZero())
+
+		tags, err := c.Tags(ctx, "mem", "docs/a file.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"author": "john"}, tags)
+	})
+
+	t.Run("Multipart from a reader of unknown length", func(t *testing.T) {
+		err := c.Upload(ctx, "mem", "docs/b.txt", io.MultiReader(strings.NewReader("multi"), strings.NewReader("part")), UploadOptions{})
+		assert.NoError(t, err)
+
+		data, _, err := c.Download(ctx, "mem", "docs/b.txt", DownloadOptions{})
+		assert.NoError(t, err)
+		defer data.Close()
+
+		b, err := io.ReadAll(data)
+		assert.NoError(t, err)
+		assert.Equal(t, "multipart", string(b))
+	})
+
+	t.Run("Range", func(t *testing.T) {
+		data, info, err := c.Download(ctx, "mem", "docs/a file.txt", DownloadOptions{Range: "bytes=1-3"})
+		assert.NoError(t, err)
+		defer data.Close()
+
+		b, err := io.ReadAll(data)
+		assert.NoError(t, err)
+		assert.Equal(t, "ell", string(b))
+		assert.Equal(t, "bytes 1-3/5", info.ContentRange)
+	})
+
+	t.Run("Not found", func(t *testing.T) {
+		_, _, err := c.Download(ctx, "mem", "missing.txt", DownloadOptions{})
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		var statusErr *Error
+		assert.True(t, errors.As(err, &statusErr))
+		assert.Equal(t, http.StatusNotFound, statusErr.StatusCode)
+	})
+
+	t.Run("Provider not found", func(t *testing.T) {
+		_, _, err := c.Download(ctx, "nope", "a.txt", DownloadOptions{})
+		assert.ErrorIs(t, err, ErrProviderNotFound)
+		assert.NotErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("Denied", func(t *testing.T) {
+		err := c.Upload(ctx, "readonly", "a.txt", bytes.NewReader([]byte("data")), UploadOptions{})
+		assert.ErrorIs(t, err, ErrDenied)
+	})
+}
+
+func Test_ListDelete(t *testing.T) {
+	ts := newTestServer(t)
+	c := newTestClient(t, ts.URL)
+	ctx := context.Background()
+
+	for _, key := range []string{"a.txt", "dir/b.txt", "dir/sub/c.txt"} {
+		assert.NoError(t, c.Upload(ctx, "mem", key, strings.NewReader(key), UploadOptions{}))
+	}
+
+	result, err := c.List(ctx, "mem", "", ListOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.txt", "dir/b.txt", "dir/sub/c.txt"}, result.Keys)
+
+	result, err = c.List(ctx, "mem", "dir/", ListOptions{Delimiter: "/"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dir/b.txt"}, result.Keys)
+	assert.Equal(t, []string{"dir/sub/"}, result.CommonPrefixes)
+
+	assert.NoError(t, c.Delete(ctx, "mem", "a.txt", DeleteOptions{}))
+	assert.ErrorIs(t, c.Delete(ctx, "mem", "a.txt", DeleteOptions{}), ErrNotFound)
+	assert.ErrorIs(t, c.Delete(ctx, "mem", "dir/b.txt", DeleteOptions{VersionID: "1"}), ErrNoVersioning)
+
+	t.Run("Prefix", func(t *testing.T) {
+		var lines []DeletePrefixProgress
+		progress, err := c.DeletePrefix(ctx, "mem", "dir/", DeletePrefixOptions{
+			DryRun:   true,
+			Progress: func(p DeletePrefixProgress) { lines = append(lines, p) },
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, progress.Total)
+		assert.True(t, progress.Done)
+		assert.Len(t, lines, 3)
+
+		progress, err = c.DeletePrefix(ctx, "mem", "dir/", DeletePrefixOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, progress.Deleted)
+
+		result, err := c.List(ctx, "mem", "", ListOptions{})
+		assert.NoError(t, err)
+		assert.Empty(t, result.Keys)
+
+		_, err = c.DeletePrefix(ctx, "mem", "", DeletePrefixOptions{})
+		assert.Error(t, err)
+	})
+}
+
+func Test_Batch(t *testing.T) {
+	ts := newTestServer(t)
+	c := newTestClient(t, ts.URL)
+	ctx := context.Background()
+
+	assert.NoError(t, c.Upload(ctx, "mem", "a.txt", strings.NewReader("hello"), UploadOptions{ContentType: "text/plain", Raw: true}))
+
+	assert.NoError(t, c.Copy(ctx, "mem", "a.txt", "mem", "b.txt"))
+	assert.NoError(t, c.SetTags(ctx, "mem", "b.txt", map[string]string{"state": "copied"}))
+
+	stat, err := c.Stat(ctx, "mem", "b.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), *stat.ContentLength)
+	assert.Equal(t, "text/plain", *stat.ContentType)
+
+	meta, err := c.Metadata(ctx, "mem", "b.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"state": "copied"}, meta.Tags)
+
+	assert.ErrorIs(t, c.Copy(ctx, "mem", "missing.txt", "mem", "c.txt"), ErrNotFound)
+	// The readonly plugin does not allow uploads
+	assert.ErrorIs(t, c.Copy(ctx, "mem", "a.txt", "readonly", "a.txt"), ErrDenied)
+
+	results, err := c.Batch(ctx, []BatchOperation{
+		{Op: BatchOpDelete, Provider: "mem", Key: "a.txt"},
+		{Op: BatchOpDelete, Provider: "mem", Key: "missing.txt"},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, results[0].Err())
+	assert.ErrorIs(t, results[1].Err(), ErrNotFound)
+
+	_, err = c.Batch(ctx, make([]BatchOperation, MaxBatchOperations+1))
+	assert.Error(t, err)
+}
+
+func Test_Presign(t *testing.T) {
+	ts := newTestServer(t)
+	c := newTestClient(t, ts.URL)
+	ctx := context.Background()
+
+	assert.NoError(t, c.Upload(ctx, "mem", "a.txt", strings.NewReader("hello"), UploadOptions{}))
+
+	presigned, err := c.Presign(ctx, "mem", "a.txt", PresignOperationDownload, PresignOptions{})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(presigned.URL, ts.URL))
+
+	// The URL is authorized by its signature, so it works without the headers of the client
+	resp, err := http.Get(presigned.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	_, err = c.Presign(ctx, "readonly", "a.txt", PresignOperationDownload, PresignOptions{})
+	assert.ErrorIs(t, err, ErrNoPresign)
+}
+
+func Test_Retry(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.Background()
+
+	// failing answers the first requests with 503 before passing them on to the server
+	var requests, failures atomic.Int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if failures.Add(-1) >= 0 {
+			http.Error(w, ErrUnavailable.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		r.URL.Scheme, r.URL.Host, r.RequestURI = "http", strings.TrimPrefix(ts.URL, "http://"), ""
+		resp, err := http.DefaultTransport.RoundTrip(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+
+		for name, values := range resp.Header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, resp.Body)
+	}))
+	defer failing.Close()
+
+	c := newTestClient(t, failing.URL)
+
+	t.Run("Idempotent requests are retried", func(t *testing.T) {
+		requests.Store(0)
+		failures.Store(2)
+
+		_, err := c.List(ctx, "mem", "", ListOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, int32(3), requests.Load())
+	})
+
+	t.Run("Retries are limited", func(t *testing.T) {
+		requests.Store(0)
+		failures.Store(10)
+
+		_, err := c.List(ctx, "mem", "", ListOptions{})
+		assert.ErrorIs(t, err, ErrUnavailable)
+		assert.Equal(t, int32(defaultMaxRetries+1), requests.Load())
+	})
+
+	t.Run("Uploads are not retried", func(t *testing.T) {
+		requests.Store(0)
+		failures.Store(1)
+
+		err := c.Upload(ctx, "mem", "a.txt", strings.NewReader("hello"), UploadOptions{})
+		assert.ErrorIs(t, err, ErrUnavailable)
+		assert.Equal(t, int32(1), requests.Load())
+	})
+
+	t.Run("Not found after a retried delete", func(t *testing.T) {
+		requests.Store(0)
+		failures.Store(1)
+
+		assert.NoError(t, c.Delete(ctx, "mem", "missing.txt", DeleteOptions{}))
+		assert.Equal(t, int32(2), requests.Load())
+	})
+
+	t.Run("Errors that are not temporary are not retried", func(t *testing.T) {
+		requests.Store(0)
+		failures.Store(0)
+
+		_, _, err := c.Download(ctx, "mem", "missing.txt", DownloadOptions{})
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.Equal(t, int32(1), requests.Load())
+	})
+}
+
+func Test_MatchError(t *testing.T) {
+	assert.Equal(t, ErrArchived, matchError(http.StatusConflict, "the object is archived and must be restored before it can be read: storage class GLACIER"))
+	assert.Equal(t, ErrNotArchived, matchError(http.StatusConflict, "the object is not archived"))
+	assert.Equal(t, ErrMisconfigured, matchError(http.StatusBadGateway, "the provider is misconfigured: NoSuchBucket"))
+	assert.Nil(t, matchError(http.StatusBadGateway, "Bad Gateway"))
+	assert.Equal(t, ErrThrottled, matchError(http.StatusTooManyRequests, "slow down"))
+	assert.Nil(t, matchError(http.StatusInternalServerError, "internal error, id: 1"))
+}
@@ -0,0 +1,110 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The errors mirror the errors of the providers in the server, they are matched against an *Error with errors.Is
+var (
+	ErrNotFound            = errors.New("resource not found")
+	ErrDenied              = errors.New("access denied")
+	ErrNoPresign           = errors.New("presigning is not allowed for this provider")
+	ErrNoVersioning        = errors.New("versioning is not supported for this provider")
+	ErrNotModified         = errors.New("resource not modified")
+	ErrRangeNotSatisfiable = errors.New("the requested range is not satisfiable")
+	ErrTooLarge            = errors.New("the object is too large")
+	ErrNotSupported        = errors.New("the operation is not supported by this provider")
+	ErrPreconditionFailed  = errors.New("a precondition of the request failed")
+	ErrThrottled           = errors.New("the provider is throttling requests")
+	ErrUnavailable         = errors.New("the provider is temporarily unavailable")
+	ErrArchived            = errors.New("the object is archived and must be restored before it can be read")
+	ErrNotArchived         = errors.New("the object is not archived")
+	ErrMisconfigured       = errors.New("the provider is misconfigured")
+
+	// ErrProviderNotFound is returned when the server has no provider with the ID
+	ErrProviderNotFound = errors.New("provider not found")
+)
+
+// messageErrors are the errors that are recognized by the message of the response, since they share the status code with other errors.
+// The server writes the message of the whole error chain, so the message of the error is a part of it.
+var messageErrors = []error{
+	ErrNoPresign,
+	ErrNoVersioning,
+	ErrArchived,
+	ErrNotArchived,
+	ErrMisconfigured,
+}
+
+// statusErrors are the errors of each status code that are not recognized by the message.
+// 502 is not included since it is also returned by proxies in front of the server, a misconfigured provider is recognized by the message.
+var statusErrors = map[int]error{
+	http.StatusNotModified:                  ErrNotModified,
+	http.StatusForbidden:                    ErrDenied,
+	http.StatusNotFound:                     ErrNotFound,
+	http.StatusPreconditionFailed:           ErrPreconditionFailed,
+	http.StatusRequestEntityTooLarge:        ErrTooLarge,
+	http.StatusRequestedRangeNotSatisfiable: ErrRangeNotSatisfiable,
+	http.StatusTooManyRequests:              ErrThrottled,
+	http.StatusNotImplemented:               ErrNotSupported,
+	http.StatusServiceUnavailable:           ErrUnavailable,
+}
+
+// Error is an error response from the server
+type Error struct {
+	// StatusCode is the HTTP status of the response
+	StatusCode int
+
+	// Message is the body of the response
+	Message string
+
+	// RetryAfter is the delay the server asked for with a Retry-After header, it is set for 429 and 503 responses
+	RetryAfter time.Duration
+
+	// err is the error value of this package that matches the response, if any
+	err error
+}
+
+func newError(resp *http.Response, msg string) *Error {
+	return &Error{
+		StatusCode: resp.StatusCode,
+		Message:    msg,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		err:        matchError(resp.StatusCode, msg),
+	}
+}
+
+// matchError returns the error value that matches the status code and message of a response
+func matchError(code int, msg string) error {
+	if code == http.StatusNotFound && msg == ErrProviderNotFound.Error() {
+		return ErrProviderNotFound
+	}
+
+	for _, err := range messageErrors {
+		if strings.Contains(msg, err.Error()) {
+			return err
+		}
+	}
+
+	return statusErrors[code]
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Code returns the status code of the response, the same way as the errors of the lerr package
+func (e *Error) Code() int {
+	return e.StatusCode
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
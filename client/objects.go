@@ -0,0 +1,412 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+type UploadOptions struct {
+	// ContentType is stored with the object, if empty the server detects it
+	ContentType string
+
+	// ContentLength is the length of the data.
+	// If it is not set, the length is read from a *bytes.Buffer, *bytes.Reader, *strings.Reader or *os.File, and any other reader is read into memory first.
+	ContentLength int64
+
+	// Tags are stored with the object, if the provider supports it
+	Tags map[string]string
+
+	// RetainUntil is the time before which the object can not be deleted, see the modes of the providers
+	RetainUntil time.Time
+
+	// ExpiresIn is how long until the object is deleted, it can not be longer than the max-expiry of the provider
+	ExpiresIn time.Duration
+
+	// StorageClass is the storage class of the object, it must be one of the allowed storage classes of the provider
+	StorageClass string
+
+	// Raw sends the data as the body of the request instead of as multipart form data.
+	// The server must have server.allow_raw_body enabled.
+	Raw bool
+}
+
+// Upload uploads the data of r to the key.
+// Uploads are not retried since the reader can only be read once.
+func (c *Client) Upload(ctx context.Context, providerID, key string, r io.Reader, opts UploadOptions) error {
+	size, r, err := readerSize(r, opts.ContentLength)
+	if err != nil {
+		return err
+	}
+
+	header := make(http.Header)
+	if !opts.RetainUntil.IsZero() {
+		header.Set("X-Retain-Until", opts.RetainUntil.Format(time.RFC3339))
+	}
+	if opts.ExpiresIn > 0 {
+		header.Set("X-Expires-In", opts.ExpiresIn.String())
+	}
+	if opts.StorageClass != "" {
+		header.Set("X-Storage-Class", opts.StorageClass)
+	}
+
+	var query url.Values
+	if len(opts.Tags) > 0 {
+		query = url.Values{"tag": formatTags(opts.Tags)}
+	}
+
+	body := r
+	if opts.Raw {
+		if opts.ContentType != "" {
+			header.Set("Content-Type", opts.ContentType)
+		}
+	} else {
+		// The form is built around the reader instead of in memory so that large uploads are streamed
+		var head bytes.Buffer
+		mw := multipart.NewWriter(&head)
+
+		partHeader := make(textproto.MIMEHeader)
+		partHeader.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename=%q`, path.Base(key)))
+		if opts.ContentType != "" {
+			partHeader.Set("Content-Type", opts.ContentType)
+		}
+
+		if _, err := mw.CreatePart(partHeader); err != nil {
+			return err
+		}
+		tail := "\r\n--" + mw.Boundary() + "--\r\n"
+
+		body = io.MultiReader(&head, r, strings.NewReader(tail))
+		size += int64(head.Len() + len(tail))
+		header.Set("Content-Type", mw.FormDataContentType())
+	}
+
+	resp, err := c.do(ctx, request{
+		method:        http.MethodPut,
+		path:          objectPath("file", providerID, key),
+		query:         query,
+		header:        header,
+		body:          func() (io.Reader, error) { return body, nil },
+		contentLength: size,
+	})
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// readerSize returns the length of the data of r, reading it into memory if the length can not be found otherwise
+func readerSize(r io.Reader, size int64) (int64, io.Reader, error) {
+	if size > 0 {
+		return size, r, nil
+	}
+
+	switch r := r.(type) {
+	case *bytes.Buffer:
+		return int64(r.Len()), r, nil
+	case *bytes.Reader:
+		return int64(r.Len()), r, nil
+	case *strings.Reader:
+		return int64(r.Len()), r, nil
+	case *os.File:
+		info, err := r.Stat()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if info.Mode().IsRegular() {
+			offset, err := r.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return 0, nil, err
+			}
+			return info.Size() - offset, r, nil
+		}
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return int64(len(data)), bytes.NewReader(data), nil
+}
+
+// formatTags returns the tags as key:value sorted by key so that the requests are deterministic
+func formatTags(tags map[string]string) []string {
+	formatted := make([]string, 0, len(tags))
+	for k, v := range tags {
+		formatted = append(formatted, k+":"+v)
+	}
+	sort.Strings(formatted)
+
+	return formatted
+}
+
+type DownloadOptions struct {
+	// VersionID downloads a specific version of the object
+	VersionID string
+
+	// Range is a single byte range, eg. bytes=0-1023. The response is only a part of the object if the provider supports ranges.
+	Range string
+
+	// IfModifiedSince returns ErrNotModified if the object has not been modified since the time
+	IfModifiedSince time.Time
+}
+
+// ObjectInfo is the information about a downloaded object that the server returns with it
+type ObjectInfo struct {
+	// When the object was last modified, zero if unknown
+	LastModified time.Time
+
+	// The length of the returned data in bytes, -1 if unknown
+	ContentLength int64
+
+	// The content type of the object
+	ContentType string
+
+	// The version of the object that was returned, if the provider supports versioning
+	VersionID string
+
+	// The value of the Content-Range header if only a part of the object was returned
+	ContentRange string
+}
+
+// Download returns the data of the object, which must be closed by the caller
+func (c *Client) Download(ctx context.Context, providerID, key string, opts DownloadOptions) (io.ReadCloser, ObjectInfo, error) {
+	header := make(http.Header)
+	if opts.Range != "" {
+		header.Set("Range", opts.Range)
+	}
+	if !opts.IfModifiedSince.IsZero() {
+		header.Set("If-Modified-Since", opts.IfModifiedSince.UTC().Format(http.TimeFormat))
+	}
+
+	var query url.Values
+	if opts.VersionID != "" {
+		query = url.Values{"version": {opts.VersionID}}
+	}
+
+	resp, err := c.do(ctx, request{
+		method:     http.MethodGet,
+		path:       objectPath("file", providerID, key),
+		query:      query,
+		header:     header,
+		idempotent: true,
+	})
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	info := ObjectInfo{
+		ContentLength: resp.ContentLength,
+		ContentType:   resp.Header.Get("Content-Type"),
+		VersionID:     resp.Header.Get("X-Version-Id"),
+		ContentRange:  resp.Header.Get("Content-Range"),
+	}
+
+	if lm := resp.Header.Get("Last-Modified"); lm != "" {
+		if t, err := http.ParseTime(lm); err == nil {
+			info.LastModified = t
+		}
+	}
+
+	return resp.Body, info, nil
+}
+
+type ListOptions struct {
+	// Delimiter groups the keys like directories, eg. / to only list one level of a hierarchy
+	Delimiter string
+}
+
+type ListResult struct {
+	// The keys of the objects found
+	Keys []string
+
+	// The prefixes that keys were grouped into when listing with a delimiter
+	CommonPrefixes []string `json:",omitempty"`
+}
+
+// List returns the keys that start with the prefix.
+// The server pages through the provider itself and returns every key in one response, so the result is never partial.
+func (c *Client) List(ctx context.Context, providerID, prefix string, opts ListOptions) (ListResult, error) {
+	var query url.Values
+	if opts.Delimiter != "" {
+		query = url.Values{"delimiter": {opts.Delimiter}}
+	}
+
+	var result ListResult
+	err := c.doJSON(ctx, request{
+		method:     http.MethodGet,
+		path:       objectPath("list", providerID, prefix),
+		query:      query,
+		idempotent: true,
+	}, &result)
+
+	return result, err
+}
+
+type DeleteOptions struct {
+	// VersionID deletes a specific version of the object permanently
+	VersionID string
+}
+
+// Delete deletes the object.
+// If the response of a delete is lost and the retry finds that the object does not exist, it is not an error.
+func (c *Client) Delete(ctx context.Context, providerID, key string, opts DeleteOptions) error {
+	var query url.Values
+	if opts.VersionID != "" {
+		query = url.Values{"version": {opts.VersionID}}
+	}
+
+	resp, err := c.do(ctx, request{
+		method:             http.MethodDelete,
+		path:               objectPath("file", providerID, key),
+		query:              query,
+		idempotent:         true,
+		notFoundAfterRetry: true,
+	})
+	if err != nil || resp == nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// ArchiveStatus is the status of an object in an archive storage class
+type ArchiveStatus struct {
+	StorageClass string `json:"storage_class,omitempty"`
+
+	// If the object is in an archive storage class
+	Archived bool `json:"archived"`
+
+	// If a restore has been requested and is not finished yet
+	RestoreInProgress bool `json:"restore_in_progress,omitempty"`
+
+	// When the restored copy expires, it can be read until then
+	RestoredUntil *time.Time `json:"restored_until,omitempty"`
+}
+
+type Metadata struct {
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// Archive is set for providers that archive objects
+	Archive *ArchiveStatus `json:"archive,omitempty"`
+}
+
+// Metadata returns the tags and the archive status of the object
+func (c *Client) Metadata(ctx context.Context, providerID, key string) (Metadata, error) {
+	var meta Metadata
+	err := c.doJSON(ctx, request{
+		method:     http.MethodGet,
+		path:       objectPath("meta", providerID, key),
+		idempotent: true,
+	}, &meta)
+
+	return meta, err
+}
+
+// Tags returns the tags of the object
+func (c *Client) Tags(ctx context.Context, providerID, key string) (map[string]string, error) {
+	meta, err := c.Metadata(ctx, providerID, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return meta.Tags, nil
+}
+
+type PresignOperation string
+
+const (
+	PresignOperationDownload PresignOperation = "download"
+	PresignOperationUpload   PresignOperation = "upload"
+)
+
+type PresignOptions struct {
+	// StorageClass is the storage class of a presigned upload
+	StorageClass string
+}
+
+type PresignedURL struct {
+	URL string
+
+	// Header must be sent with the request to the URL since it is covered by the signature
+	Header http.Header
+}
+
+// Presign returns a URL that can be used to download or upload the object directly from the provider
+func (c *Client) Presign(ctx context.Context, providerID, key string, op PresignOperation, opts PresignOptions) (PresignedURL, error) {
+	header := make(http.Header)
+	if opts.StorageClass != "" {
+		header.Set("X-Storage-Class", opts.StorageClass)
+	}
+
+	// Presigning does not change anything, so it is safe to retry even if it is a POST
+	resp, err := c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       objectPath("presign", providerID, key),
+		query:      url.Values{"op": {string(op)}},
+		header:     header,
+		idempotent: true,
+	})
+	if err != nil {
+		return PresignedURL{}, err
+	}
+	defer resp.Body.Close()
+
+	u, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return PresignedURL{}, err
+	}
+
+	presigned := PresignedURL{URL: string(u), Header: make(http.Header)}
+
+	// The headers are returned as "<name>: <value>"
+	for _, h := range resp.Header.Values("X-Presign-Header") {
+		name, value, _ := strings.Cut(h, ":")
+		presigned.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	return presigned, nil
+}
+
+// doJSON sends the request and decodes the JSON response into v
+func (c *Client) doJSON(ctx context.Context, r request, v any) error {
+	resp, err := c.do(ctx, r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+
+	return nil
+}
+
+// jsonBody returns the body of a request with v encoded as JSON
+func jsonBody(v any) (request, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return request{}, err
+	}
+
+	return request{
+		header:        http.Header{"Content-Type": {"application/json"}},
+		body:          func() (io.Reader, error) { return bytes.NewReader(data), nil },
+		contentLength: int64(len(data)),
+	}, nil
+}
@@ -0,0 +1,609 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/theleeeo/file-butler/client"
+)
+
+// clientCommands are the commands that talk to a running butler over HTTP
+var clientCommands = map[string]func(ctx context.Context, args []string) error{
+	"get":     runGet,
+	"put":     runPut,
+	"ls":      runList,
+	"rm":      runRemove,
+	"meta":    runMeta,
+	"presign": runPresign,
+	"cp":      runCopy,
+}
+
+func runGet(ctx context.Context, args []string) error {
+	flags, opts := newClientFlags("get", "<provider>/<key> [destination]",
+		"Download a file to the destination, which defaults to the name of the file. Use - to write it to stdout.\n"+
+			"With -r all files under the prefix are downloaded into the destination directory, which defaults to the current directory.")
+	recursive := flags.Bool("r", false, "download all files under the prefix")
+	if err := parseClientFlags(flags, args, 1, 2); err != nil || flags.NArg() == 0 {
+		return err
+	}
+
+	c, err := opts.client()
+	if err != nil {
+		return err
+	}
+
+	loc, err := parseLocation(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if !*recursive {
+		if loc.isPrefix() {
+			return fmt.Errorf("%s is a prefix, use -r to download all files under it", loc)
+		}
+
+		dest := flags.Arg(1)
+		if dest == "" {
+			dest = path.Base(loc.key)
+		} else if info, err := os.Stat(dest); err == nil && info.IsDir() {
+			dest = filepath.Join(dest, path.Base(loc.key))
+		}
+
+		return c.download(ctx, loc, dest)
+	}
+
+	destDir := flags.Arg(1)
+	if destDir == "" {
+		destDir = "."
+	}
+
+	// The listing is not paged, it contains every key under the prefix
+	objects, err := c.List(ctx, loc.provider, loc.key, client.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, key := range objects.Keys {
+		rel := relativeKey(loc.key, key)
+		// The keys come from the server, a key like ../x must not be written outside of the destination
+		if !filepath.IsLocal(filepath.FromSlash(rel)) {
+			c.printError(location{loc.provider, key}, errors.New("the key is outside of the destination directory"))
+			failed++
+			continue
+		}
+
+		dest := filepath.Join(destDir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+			return err
+		}
+
+		if err := c.download(ctx, location{loc.provider, key}, dest); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			c.printError(location{loc.provider, key}, err)
+			failed++
+		}
+	}
+
+	return failedError(failed, len(objects.Keys))
+}
+
+// download writes the file to dest, or to stdout if dest is -
+func (c *butlerClient) download(ctx context.Context, loc location, dest string) error {
+	data, _, err := c.Download(ctx, loc.provider, loc.key, client.DownloadOptions{})
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	if dest == "-" {
+		_, err := io.Copy(os.Stdout, data)
+		return err
+	}
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+
+	n, err := io.Copy(f, data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// A partial file is worse than no file
+		_ = os.Remove(dest)
+		return err
+	}
+
+	if c.json {
+		return c.printJSON(transferResult{Key: loc.key, Provider: loc.provider, Path: dest, Bytes: n})
+	}
+
+	c.printf("downloaded %s to %s (%d bytes)", loc, dest, n)
+	return nil
+}
+
+// transferResult is the JSON output of a file that was downloaded or uploaded
+type transferResult struct {
+	Provider string `json:"provider"`
+	Key      string `json:"key"`
+	Path     string `json:"path"`
+	Bytes    int64  `json:"bytes"`
+}
+
+func runPut(ctx context.Context, args []string) error {
+	flags, opts := newClientFlags("put", "<source> <provider>/<key>",
+		"Upload a file, or stdin if the source is -. If the key is empty or ends with / the name of the file is appended to it.\n"+
+			"With -r all files in the source directory are uploaded under the key as a prefix.")
+	recursive := flags.Bool("r", false, "upload all files in the source directory")
+	contentType := flags.String("content-type", "", "the content type of the file, default is detected from the extension")
+	raw := flags.Bool("raw", false, "send the file as the raw body instead of multipart form data, the server must have server.allow_raw_body enabled")
+	var tags listFlag
+	flags.Var(&tags, "tag", "a tag of the file formatted as key:value, can be repeated")
+	if err := parseClientFlags(flags, args, 2, 2); err != nil || flags.NArg() == 0 {
+		return err
+	}
+
+	c, err := opts.client()
+	if err != nil {
+		return err
+	}
+
+	src := flags.Arg(0)
+	loc, err := parseLocation(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	uploadOpts := client.UploadOptions{ContentType: *contentType, Raw: *raw}
+	if len(tags) > 0 {
+		uploadOpts.Tags = make(map[string]string, len(tags))
+		for _, tag := range tags {
+			k, v, ok := strings.Cut(tag, ":")
+			if !ok {
+				return fmt.Errorf("invalid tag %q, must be formatted as key:value", tag)
+			}
+			uploadOpts.Tags[k] = v
+		}
+	}
+
+	if !*recursive {
+		if loc.isPrefix() {
+			if src == "-" {
+				return errors.New("a key is required when uploading from stdin")
+			}
+			loc.key += filepath.Base(src)
+		}
+
+		return c.uploadFile(ctx, src, loc, uploadOpts)
+	}
+
+	if loc.key != "" && !loc.isPrefix() {
+		loc.key += "/"
+	}
+
+	var files []string
+	err = filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, file := range files {
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+
+		dest := location{loc.provider, loc.key + filepath.ToSlash(rel)}
+		if err := c.uploadFile(ctx, file, dest, uploadOpts); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			c.printError(dest, err)
+			failed++
+		}
+	}
+
+	return failedError(failed, len(files))
+}
+
+// uploadFile uploads a local file, or stdin if src is -
+func (c *butlerClient) uploadFile(ctx context.Context, src string, loc location, opts client.UploadOptions) error {
+	var (
+		r    io.Reader
+		size int64
+	)
+
+	if src == "-" {
+		// The server requires the length of the upload, so stdin is read to the end first
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		r, size = bytes.NewReader(data), int64(len(data))
+	} else {
+		f, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return fmt.Errorf("%s is a directory, use -r to upload all files in it", src)
+		}
+		r, size = f, info.Size()
+
+		if opts.ContentType == "" {
+			opts.ContentType = mime.TypeByExtension(filepath.Ext(src))
+		}
+	}
+
+	opts.ContentLength = size
+	if err := c.Upload(ctx, loc.provider, loc.key, r, opts); err != nil {
+		return err
+	}
+
+	if c.json {
+		return c.printJSON(transferResult{Provider: loc.provider, Key: loc.key, Path: src, Bytes: size})
+	}
+
+	c.printf("uploaded %s to %s (%d bytes)", src, loc, size)
+	return nil
+}
+
+func runList(ctx context.Context, args []string) error {
+	flags, opts := newClientFlags("ls", "<provider>/[prefix]",
+		"List the files and directories directly under the prefix, or all files under it with -r.")
+	recursive := flags.Bool("r", false, "list all files under the prefix instead of grouping them by directory")
+	if err := parseClientFlags(flags, args, 1, 1); err != nil || flags.NArg() == 0 {
+		return err
+	}
+
+	c, err := opts.client()
+	if err != nil {
+		return err
+	}
+
+	loc, err := parseLocation(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	delimiter := "/"
+	if *recursive {
+		delimiter = ""
+	}
+
+	objects, err := c.List(ctx, loc.provider, loc.key, client.ListOptions{Delimiter: delimiter})
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		return c.printJSON(objects)
+	}
+
+	for _, p := range objects.CommonPrefixes {
+		c.printf("%s", p)
+	}
+	for _, k := range objects.Keys {
+		c.printf("%s", k)
+	}
+
+	return nil
+}
+
+func runRemove(ctx context.Context, args []string) error {
+	flags, opts := newClientFlags("rm", "<provider>/<key>",
+		"Delete a file, or all files under the prefix with -r.")
+	recursive := flags.Bool("r", false, "delete all files under the prefix")
+	dryRun := flags.Bool("dry-run", false, "list the files that would be deleted by -r without deleting them")
+	version := flags.String("version", "", "delete a specific version of the file")
+	if err := parseClientFlags(flags, args, 1, 1); err != nil || flags.NArg() == 0 {
+		return err
+	}
+
+	c, err := opts.client()
+	if err != nil {
+		return err
+	}
+
+	loc, err := parseLocation(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if *recursive {
+		return c.deletePrefix(ctx, loc, *dryRun)
+	}
+
+	if *dryRun {
+		return errors.New("-dry-run can only be used with -r")
+	}
+
+	if err := c.Delete(ctx, loc.provider, loc.key, client.DeleteOptions{VersionID: *version}); err != nil {
+		return err
+	}
+
+	if c.json {
+		return c.printJSON(map[string]string{"provider": loc.provider, "key": loc.key})
+	}
+
+	c.printf("deleted %s", loc)
+	return nil
+}
+
+// deletePrefix deletes all files under the prefix and prints the progress as it is streamed from the server
+func (c *butlerClient) deletePrefix(ctx context.Context, loc location, dryRun bool) error {
+	var printErr error
+
+	last, err := c.DeletePrefix(ctx, loc.provider, loc.key, client.DeletePrefixOptions{
+		DryRun: dryRun,
+		Progress: func(p client.DeletePrefixProgress) {
+			if c.json {
+				if err := c.printJSON(p); err != nil && printErr == nil {
+					printErr = err
+				}
+				return
+			}
+
+			switch {
+			case p.Key != "" && p.DryRun:
+				c.printf("would delete %s", p.Key)
+			case p.Key != "":
+				c.printError(location{loc.provider, p.Key}, errors.New(p.Error))
+			case !p.Done && !p.DryRun:
+				c.printf("deleted %d of %d files", p.Deleted, p.Total)
+			}
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if printErr != nil {
+		return printErr
+	}
+
+	if !c.json {
+		if last.DryRun {
+			c.printf("%d files would be deleted", last.Total)
+		} else {
+			c.printf("deleted %d of %d files, %d failed", last.Deleted, last.Total, last.Failed)
+		}
+	}
+
+	return failedError(last.Failed, last.Total)
+}
+
+func runMeta(ctx context.Context, args []string) error {
+	flags, opts := newClientFlags("meta", "<provider>/<key>",
+		"Show the tags and archive status of a file.")
+	if err := parseClientFlags(flags, args, 1, 1); err != nil || flags.NArg() == 0 {
+		return err
+	}
+
+	c, err := opts.client()
+	if err != nil {
+		return err
+	}
+
+	loc, err := parseLocation(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	meta, err := c.Metadata(ctx, loc.provider, loc.key)
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		return c.printJSON(meta)
+	}
+
+	keys := make([]string, 0, len(meta.Tags))
+	for k := range meta.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		c.printf("tag %s: %s", k, meta.Tags[k])
+	}
+
+	if a := meta.Archive; a != nil {
+		c.printf("storage class: %s", a.StorageClass)
+		c.printf("archived: %t", a.Archived)
+		if a.RestoreInProgress {
+			c.printf("restore in progress")
+		}
+		if a.RestoredUntil != nil {
+			c.printf("restored until: %s", a.RestoredUntil.Format("2006-01-02 15:04:05 MST"))
+		}
+	}
+
+	return nil
+}
+
+func runPresign(ctx context.Context, args []string) error {
+	flags, opts := newClientFlags("presign", "<provider>/<key>",
+		"Create a presigned URL of a file. The headers that must be sent with the URL are printed after it.")
+	op := flags.String("op", "download", "the operation to presign, download or upload")
+	storageClass := flags.String("storage-class", "", "the storage class of a presigned upload")
+	if err := parseClientFlags(flags, args, 1, 1); err != nil || flags.NArg() == 0 {
+		return err
+	}
+
+	c, err := opts.client()
+	if err != nil {
+		return err
+	}
+
+	loc, err := parseLocation(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	presigned, err := c.Presign(ctx, loc.provider, loc.key, client.PresignOperation(*op), client.PresignOptions{StorageClass: *storageClass})
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		result := struct {
+			URL     string            `json:"url"`
+			Headers map[string]string `json:"headers,omitempty"`
+		}{URL: presigned.URL}
+
+		for name := range presigned.Header {
+			if result.Headers == nil {
+				result.Headers = make(map[string]string)
+			}
+			result.Headers[name] = presigned.Header.Get(name)
+		}
+
+		return c.printJSON(result)
+	}
+
+	c.printf("%s", presigned.URL)
+
+	names := make([]string, 0, len(presigned.Header))
+	for name := range presigned.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		c.printf("%s: %s", name, presigned.Header.Get(name))
+	}
+
+	return nil
+}
+
+func runCopy(ctx context.Context, args []string) error {
+	flags, opts := newClientFlags("cp", "<provider>/<key> <provider>/<key>",
+		"Copy a file within or between providers, without downloading it. If the destination key is empty or ends with / the name of the file is appended to it.\n"+
+			"With -r all files under the source prefix are copied under the destination prefix.")
+	recursive := flags.Bool("r", false, "copy all files under the prefix")
+	if err := parseClientFlags(flags, args, 2, 2); err != nil || flags.NArg() == 0 {
+		return err
+	}
+
+	c, err := opts.client()
+	if err != nil {
+		return err
+	}
+
+	src, err := parseLocation(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	dest, err := parseLocation(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	var ops []client.BatchOperation
+
+	if !*recursive {
+		if src.isPrefix() {
+			return fmt.Errorf("%s is a prefix, use -r to copy all files under it", src)
+		}
+		if dest.isPrefix() {
+			dest.key += path.Base(src.key)
+		}
+
+		ops = append(ops, client.BatchOperation{Op: client.BatchOpCopy, Provider: src.provider, Key: src.key, DestProvider: dest.provider, DestKey: dest.key})
+	} else {
+		if dest.key != "" && !dest.isPrefix() {
+			dest.key += "/"
+		}
+
+		// The listing is not paged, it contains every key under the prefix
+		objects, err := c.List(ctx, src.provider, src.key, client.ListOptions{})
+		if err != nil {
+			return err
+		}
+
+		for _, key := range objects.Keys {
+			ops = append(ops, client.BatchOperation{Op: client.BatchOpCopy, Provider: src.provider, Key: key, DestProvider: dest.provider, DestKey: dest.key + relativeKey(src.key, key)})
+		}
+	}
+
+	failed := 0
+	for start := 0; start < len(ops); start += client.MaxBatchOperations {
+		batch := ops[start:min(start+client.MaxBatchOperations, len(ops))]
+
+		results, err := c.Batch(ctx, batch)
+		if err != nil {
+			return err
+		}
+
+		for i, op := range batch {
+			from, to := location{op.Provider, op.Key}, location{op.DestProvider, op.DestKey}
+			err := results[i].Err()
+
+			if c.json {
+				jsonErr := c.printJSON(struct {
+					From string `json:"from"`
+					To   string `json:"to"`
+					client.BatchResult
+				}{from.String(), to.String(), results[i]})
+				if jsonErr != nil {
+					return jsonErr
+				}
+			} else if err == nil {
+				c.printf("copied %s to %s", from, to)
+			} else {
+				c.printError(from, err)
+			}
+
+			if err != nil {
+				failed++
+			}
+		}
+	}
+
+	return failedError(failed, len(ops))
+}
+
+// printError prints an error of a single file of a command that handles multiple files, the command continues with the rest
+func (c *butlerClient) printError(loc location, err error) {
+	fmt.Fprintln(color.Error, color.RedString("failed %s: %s", loc, err))
+}
+
+// failedError returns an error if any of the files of a command failed
+func failedError(failed, total int) error {
+	if failed == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%d of %d files failed", failed, total)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	authPlugin "github.com/theleeeo/file-butler/authorization/plugin"
+	"github.com/theleeeo/file-butler/client"
+	"github.com/theleeeo/file-butler/provider"
+	"github.com/theleeeo/file-butler/server"
+)
+
+func Test_ParseLocation(t *testing.T) {
+	tests := []struct {
+		in       string
+		want     location
+		isPrefix bool
+		wantErr  bool
+	}{
+		{in: "docs/report.pdf", want: location{"docs", "report.pdf"}},
+		{in: "docs/2024/report.pdf", want: location{"docs", "2024/report.pdf"}},
+		{in: "docs/2024/", want: location{"docs", "2024/"}, isPrefix: true},
+		{in: "docs/", want: location{"docs", ""}, isPrefix: true},
+		{in: "docs", want: location{"docs", ""}, isPrefix: true},
+		{in: "/report.pdf", wantErr: true},
+		{in: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			loc, err := parseLocation(tt.in)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, loc)
+			assert.Equal(t, tt.isPrefix, loc.isPrefix())
+		})
+	}
+}
+
+func Test_RelativeKey(t *testing.T) {
+	tests := []struct {
+		prefix string
+		key    string
+		want   string
+	}{
+		{prefix: "", key: "a/b.txt", want: "a/b.txt"},
+		{prefix: "docs/", key: "docs/a.txt", want: "a.txt"},
+		{prefix: "docs/", key: "docs/sub/a.txt", want: "sub/a.txt"},
+		// Like archives, a prefix that does not end with / keeps the last part of it
+		{prefix: "docs/rep", key: "docs/report.pdf", want: "report.pdf"},
+		{prefix: "rep", key: "report.pdf", want: "report.pdf"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.prefix+"|"+tt.key, func(t *testing.T) {
+			assert.Equal(t, tt.want, relativeKey(tt.prefix, tt.key))
+		})
+	}
+}
+
+// newTestButler starts a server with a memory provider "mem" that allows everything and returns the URL of it
+func newTestButler(t *testing.T) (string, provider.Provider) {
+	t.Helper()
+
+	allowAll, err := authPlugin.NewPlugin(authPlugin.Config{
+		Name:    "default",
+		BuiltIn: "allow-types",
+		Args:    []string{"download", "upload", "get_metadata", "list", "delete", "delete_prefix", "set_tags"},
+	})
+	assert.NoError(t, err)
+
+	// The address is not used since the handler is served by the test server
+	srv, err := server.NewServer(server.Config{
+		Addr:              "localhost:0",
+		DefaultAuthPlugin: "default",
+	}, []authPlugin.Plugin{allowAll})
+	assert.NoError(t, err)
+
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+
+	mem, err := provider.NewMemoryProvider(&provider.MemoryConfig{
+		ConfigBase: provider.ConfigBase{ID: "mem"},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, srv.RegisterProvider(mem))
+
+	return ts.URL, mem
+}
+
+// listKeys returns the keys under the prefix of the memory provider
+func listKeys(t *testing.T, url, prefix string) []string {
+	t.Helper()
+
+	c, err := client.New(client.Config{BaseURL: url})
+	assert.NoError(t, err)
+
+	res, err := c.List(context.Background(), "mem", prefix, client.ListOptions{})
+	assert.NoError(t, err)
+
+	return res.Keys
+}
+
+func Test_RecursiveCommands(t *testing.T) {
+	ctx := context.Background()
+	url, mem := newTestButler(t)
+
+	src := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(src, "sub"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "a.txt"), []byte("file a"), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "sub", "b.txt"), []byte("file b"), 0o644))
+
+	t.Run("Put", func(t *testing.T) {
+		assert.NoError(t, runPut(ctx, []string{"-url", url, "-r", src, "mem/docs"}))
+		assert.ElementsMatch(t, []string{"docs/a.txt", "docs/sub/b.txt"}, listKeys(t, url, "docs/"))
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		dest := t.TempDir()
+		assert.NoError(t, runGet(ctx, []string{"-url", url, "-r", "mem/docs/", dest}))
+
+		data, err := os.ReadFile(filepath.Join(dest, "a.txt"))
+		assert.NoError(t, err)
+		assert.Equal(t, "file a", string(data))
+
+		data, err = os.ReadFile(filepath.Join(dest, "sub", "b.txt"))
+		assert.NoError(t, err)
+		assert.Equal(t, "file b", string(data))
+	})
+
+	t.Run("Get a prefix without -r", func(t *testing.T) {
+		assert.ErrorContains(t, runGet(ctx, []string{"-url", url, "mem/docs/", t.TempDir()}), "use -r")
+	})
+
+	t.Run("Copy", func(t *testing.T) {
+		assert.NoError(t, runCopy(ctx, []string{"-url", url, "-r", "mem/docs/", "mem/copy"}))
+		assert.ElementsMatch(t, []string{"copy/a.txt", "copy/sub/b.txt"}, listKeys(t, url, "copy/"))
+	})
+
+	t.Run("Copy more than a batch", func(t *testing.T) {
+		// The copies are split into batches, every key of the listing must be copied
+		n := client.MaxBatchOperations + 1
+		for i := range n {
+			key := fmt.Sprintf("many/%04d", i)
+			assert.NoError(t, mem.PutObject(ctx, key, strings.NewReader(key), provider.PutOptions{}))
+		}
+
+		assert.NoError(t, runCopy(ctx, []string{"-url", url, "-r", "mem/many/", "mem/many-copy/"}))
+		assert.Len(t, listKeys(t, url, "many-copy/"), n)
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		assert.NoError(t, runRemove(ctx, []string{"-url", url, "-r", "-dry-run", "mem/docs/"}))
+		assert.Len(t, listKeys(t, url, "docs/"), 2)
+
+		assert.NoError(t, runRemove(ctx, []string{"-url", url, "-r", "mem/docs/"}))
+		assert.Empty(t, listKeys(t, url, "docs/"))
+		assert.Len(t, listKeys(t, url, "copy/"), 2)
+	})
+
+	t.Run("Missing files", func(t *testing.T) {
+		err := runGet(ctx, []string{"-url", url, "mem/missing.txt", filepath.Join(t.TempDir(), "missing.txt")})
+		assert.ErrorIs(t, err, client.ErrNotFound)
+	})
+}
@@ -0,0 +1,143 @@
+// Package interpolate resolves references to environment variables and files in config values,
+// and redacts the resolved values from output so that secrets do not leak into logs.
+//
+// The supported references are:
+//   - ${NAME} is replaced with the value of the environment variable NAME
+//   - ${file:/path} is replaced with the content of the file, without trailing newlines
+//   - ${NAME:-default} and ${file:/path:-default} use the default if the variable is unset or empty, or the file does not exist
+//   - $${ is replaced with a literal ${
+//
+// Values shorter than 6 characters are deliberately not redacted, since they are rarely secrets
+// and replacing every occurrence of eg. a port number or "true" would make the output unreadable.
+package interpolate
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+const filePrefix = "file:"
+
+// String resolves all references in the string.
+// It returns an error if a reference can not be resolved and has no default.
+// The resolved values are registered to be redacted.
+func String(s string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+
+	var b strings.Builder
+
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			break
+		}
+
+		// An escaped reference is kept as it is, without the escaping $
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i-1])
+			b.WriteString("${")
+			s = s[i+2:]
+			continue
+		}
+
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated reference in %q", s)
+		}
+
+		value, err := resolve(s[i+2 : i+end])
+		if err != nil {
+			return "", err
+		}
+
+		b.WriteString(s[:i])
+		b.WriteString(value)
+		s = s[i+end+1:]
+	}
+
+	return b.String(), nil
+}
+
+// Value resolves the references in all strings of a value as it is decoded from a config file,
+// which is strings, numbers and booleans in any combination of maps and slices.
+// The value is not modified, a resolved copy is returned.
+func Value(v any) (any, error) {
+	switch v := v.(type) {
+	case string:
+		return String(v)
+
+	case map[string]any:
+		resolved := make(map[string]any, len(v))
+		for k, val := range v {
+			r, err := Value(val)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			resolved[k] = r
+		}
+		return resolved, nil
+
+	case []any:
+		resolved := make([]any, len(v))
+		for i, val := range v {
+			r, err := Value(val)
+			if err != nil {
+				return nil, fmt.Errorf("%d: %w", i, err)
+			}
+			resolved[i] = r
+		}
+		return resolved, nil
+	}
+
+	return v, nil
+}
+
+// resolve returns the value of the content of a reference, eg. "NAME:-default"
+func resolve(ref string) (string, error) {
+	name, def, hasDefault := strings.Cut(ref, ":-")
+
+	var (
+		value string
+		found bool
+	)
+
+	if path, ok := strings.CutPrefix(name, filePrefix); ok {
+		if path == "" {
+			return "", errors.New("empty file reference")
+		}
+
+		b, err := os.ReadFile(path)
+		if err != nil && (!errors.Is(err, fs.ErrNotExist) || !hasDefault) {
+			return "", fmt.Errorf("unable to read referenced file: %w", err)
+		}
+
+		// Files usually end with a newline that is not part of the secret
+		value, found = strings.TrimRight(string(b), "\r\n"), err == nil
+	} else {
+		if name == "" {
+			return "", errors.New("empty variable reference")
+		}
+
+		value, found = os.LookupEnv(name)
+		found = found && value != ""
+	}
+
+	if !found {
+		if !hasDefault {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+
+		// The default is written in the config file, so it is not a secret
+		return def, nil
+	}
+
+	addSecret(value)
+
+	return value, nil
+}
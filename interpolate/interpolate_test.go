@@ -0,0 +1,103 @@
+package interpolate
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_String(t *testing.T) {
+	t.Setenv("INTERPOLATE_TEST_USER", "butler")
+	t.Setenv("INTERPOLATE_TEST_EMPTY", "")
+
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "secret")
+	assert.NoError(t, os.WriteFile(secretFile, []byte("file-secret\n"), 0o600))
+	missingFile := filepath.Join(dir, "missing")
+
+	for _, tc := range []struct {
+		name  string
+		input string
+		want  string
+		err   bool
+	}{
+		{name: "No references", input: "plain value", want: "plain value"},
+		{name: "Variable", input: "user=${INTERPOLATE_TEST_USER}!", want: "user=butler!"},
+		{name: "Multiple references", input: "${INTERPOLATE_TEST_USER}:${INTERPOLATE_TEST_USER}", want: "butler:butler"},
+		{name: "Escaped reference", input: "$${INTERPOLATE_TEST_USER}", want: "${INTERPOLATE_TEST_USER}"},
+		{name: "Escaped and resolved reference", input: "$${A} ${INTERPOLATE_TEST_USER}", want: "${A} butler"},
+		{name: "Unterminated reference", input: "${INTERPOLATE_TEST_USER", err: true},
+		{name: "Unterminated after resolved reference", input: "${INTERPOLATE_TEST_USER} ${", err: true},
+		{name: "Empty reference", input: "${}", err: true},
+		{name: "Unset variable", input: "${INTERPOLATE_TEST_UNSET}", err: true},
+		{name: "Unset variable with default", input: "${INTERPOLATE_TEST_UNSET:-fallback}", want: "fallback"},
+		{name: "Empty variable", input: "${INTERPOLATE_TEST_EMPTY}", err: true},
+		{name: "Empty variable with default", input: "${INTERPOLATE_TEST_EMPTY:-fallback}", want: "fallback"},
+		{name: "Empty default", input: "${INTERPOLATE_TEST_UNSET:-}", want: ""},
+		{name: "File", input: "${file:" + secretFile + "}", want: "file-secret"},
+		{name: "Missing file", input: "${file:" + missingFile + "}", err: true},
+		{name: "Missing file with default", input: "${file:" + missingFile + ":-fallback}", want: "fallback"},
+		{name: "Empty file reference", input: "${file:}", err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := String(tc.input)
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func Test_Value(t *testing.T) {
+	t.Setenv("INTERPOLATE_TEST_REGION", "eu-north-1")
+
+	input := map[string]any{
+		"region":  "${INTERPOLATE_TEST_REGION}",
+		"retries": 3,
+		"nested":  []any{"${INTERPOLATE_TEST_REGION}", true},
+	}
+
+	resolved, err := Value(input)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]any{
+		"region":  "eu-north-1",
+		"retries": 3,
+		"nested":  []any{"eu-north-1", true},
+	}, resolved)
+
+	// The input is not modified
+	assert.Equal(t, "${INTERPOLATE_TEST_REGION}", input["region"])
+
+	_, err = Value(map[string]any{"nested": map[string]any{"key": "${INTERPOLATE_TEST_UNSET}"}})
+	assert.ErrorContains(t, err, "nested: key:")
+}
+
+func Test_Redact(t *testing.T) {
+	t.Setenv("INTERPOLATE_TEST_TOKEN", "s3cr3t-token")
+	t.Setenv("INTERPOLATE_TEST_LONG_TOKEN", "s3cr3t-token-and-more")
+	t.Setenv("INTERPOLATE_TEST_SHORT", "abc")
+
+	for _, ref := range []string{"${INTERPOLATE_TEST_TOKEN}", "${INTERPOLATE_TEST_LONG_TOKEN}", "${INTERPOLATE_TEST_SHORT}", "${INTERPOLATE_TEST_UNSET:-default-value}"} {
+		_, err := String(ref)
+		assert.NoError(t, err)
+	}
+
+	var buf bytes.Buffer
+	logger := log.New(NewRedactWriter(&buf), "", 0)
+
+	logger.Printf("token=%s long=%s short=%s default=%s", "s3cr3t-token", "s3cr3t-token-and-more", "abc", "default-value")
+
+	// The longer secret is redacted as a whole even though it contains the shorter one.
+	// Values shorter than the minimum length and defaults written in the config are not redacted.
+	assert.Equal(t, "token=[REDACTED] long=[REDACTED] short=abc default=default-value\n", buf.String())
+	assert.False(t, strings.Contains(Redact("s3cr3t-token"), "s3cr3t"))
+}
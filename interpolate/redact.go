@@ -0,0 +1,70 @@
+package interpolate
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces the resolved values in redacted output
+const Redacted = "[REDACTED]"
+
+// minSecretLength is the length of the shortest value that is redacted.
+// Shorter values are not secret in practice and redacting them would make the output unreadable.
+const minSecretLength = 6
+
+var (
+	secretsMx sync.RWMutex
+	// secrets is sorted from longest to shortest so that a secret that contains another one is redacted as a whole
+	secrets []string
+)
+
+func addSecret(s string) {
+	if len(s) < minSecretLength {
+		return
+	}
+
+	secretsMx.Lock()
+	defer secretsMx.Unlock()
+
+	for _, existing := range secrets {
+		if existing == s {
+			return
+		}
+	}
+
+	secrets = append(secrets, s)
+	sort.SliceStable(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+}
+
+// Redact replaces all values that have been resolved from references in s
+func Redact(s string) string {
+	secretsMx.RLock()
+	defer secretsMx.RUnlock()
+
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, Redacted)
+	}
+
+	return s
+}
+
+// NewRedactWriter returns a writer that redacts the resolved values before writing to w.
+// A value is only redacted if it is written in a single call, which is the case for the log package.
+func NewRedactWriter(w io.Writer) io.Writer {
+	return &redactWriter{w: w}
+}
+
+type redactWriter struct {
+	w io.Writer
+}
+
+func (r *redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, Redact(string(p))); err != nil {
+		return 0, err
+	}
+
+	// The length of the original is returned since that is what the caller has written
+	return len(p), nil
+}
@@ -2,72 +2,85 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"reflect"
+	"sync"
 	"syscall"
 
 	"github.com/fatih/color"
 	"github.com/fsnotify/fsnotify"
+	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
 	authPlugin "github.com/theleeeo/file-butler/authorization/plugin"
-	"github.com/theleeeo/file-butler/provider"
+	"github.com/theleeeo/file-butler/interpolate"
 	"github.com/theleeeo/file-butler/server"
 )
 
 func main() {
-	viper.SetConfigName("config")
-	viper.AddConfigPath(".")
-	viper.AddConfigPath("$HOME/.filebutler")
-	viper.AddConfigPath("/etc/filebutler/")
-	err := viper.ReadInConfig()
-	if err != nil {
-		var notFoundErr viper.ConfigFileNotFoundError
-		if errors.As(err, &notFoundErr) {
-			color.Red("config file not found")
-			return
-		}
+	// The values that are resolved from references in the configs are often secrets that must not be written to the logs
+	log.SetOutput(interpolate.NewRedactWriter(log.Writer()))
+	color.Output = interpolate.NewRedactWriter(color.Output)
+	color.Error = interpolate.NewRedactWriter(color.Error)
+
+	// Without a command the server is started, as before there were commands
+	cmd, args := "serve", []string(nil)
+	if len(os.Args) > 1 {
+		cmd, args = os.Args[1], os.Args[2:]
+	}
+
+	os.Exit(runCommand(cmd, args))
+}
 
-		color.Red("error reading config file: %v", err)
+// serve runs the server until it is stopped by a signal
+func serve() {
+	if err := readMainConfig(); err != nil {
+		color.Red("%s", err)
 		return
 	}
 
-	// Create a new viper instance for provider configs to not conflict with the main config
-	pvp := viper.New()
-	providers, err := loadProviders(pvp)
+	sources, err := findProviderSources()
 	if err != nil {
 		color.Red("ERROR: %s", err)
 		return
 	}
 
-	plugins, err := loadPlugins()
+	providers, err := loadProviders(sources, nil)
 	if err != nil {
 		color.Red("ERROR: %s", err)
 		return
 	}
 
-	srv, err := server.NewServer(server.Config{
-		Addr:              viper.GetString("server.addr"),
-		AllowRawBody:      viper.GetBool("server.allow_raw_body"),
-		DefaultAuthPlugin: viper.GetString("server.default_auth_plugin"),
-	}, plugins)
+	plugins, err := loadPlugins(nil)
+	if err != nil {
+		color.Red("ERROR: %s", err)
+		return
+	}
+
+	srvCfg := serverConfig()
+	srv, err := server.NewServer(srvCfg, pluginList(plugins))
 	if err != nil {
 		color.Red("ERROR creating server: %s", err)
 		return
 	}
 
-	for _, p := range providers {
-		if err := srv.RegisterProvider(p); err != nil {
-			color.Red("ERROR registering provider %s: %s", p.Id(), err)
+	for _, lp := range providers {
+		if err := srv.RegisterProvider(lp.provider); err != nil {
+			color.Red("ERROR registering provider %s: %s", lp.provider.Id(), err)
 			return
 		}
 	}
 
-	pvp.WatchConfig()
-	pvp.OnConfigChange(reloadProvidersFunc(pvp, srv))
+	if err := watchProviders(sources, reloadProvidersFunc(sources, srv, providers)); err != nil {
+		color.Red("ERROR: %s", err)
+		return
+	}
+
+	viper.WatchConfig()
+	viper.OnConfigChange(reloadConfigFunc(srv, srvCfg.Addr, plugins))
 
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -89,167 +102,217 @@ func main() {
 		color.Red("ERROR running server: %s", err)
 	}
 
+	// The server stops the plugins when it has stopped
 	log.Println("Server stopped")
+}
+
+const usage = `Usage: file-butler <command> [flags] [arguments]
+
+Server commands:
+  serve      Run the server, this is the default if no command is given
+  validate   Check the config files without starting the server
+  schema     Print the JSON Schema of the config files, either config or providers
+
+Client commands, they talk to a running server:
+  get        Download files
+  put        Upload files
+  ls         List files
+  rm         Delete files
+  meta       Show the tags and archive status of a file
+  presign    Create a presigned URL of a file
+  cp         Copy files within or between providers
+
+Run file-butler <command> -h for the flags of a client command.
+`
+
+// runCommand runs a command and returns the exit code
+func runCommand(cmd string, args []string) int {
+	if run, ok := clientCommands[cmd]; ok {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		if err := run(ctx, args); err != nil {
+			if errors.Is(err, errUsage) {
+				return 2
+			}
+
+			fmt.Fprintln(color.Error, color.RedString("ERROR: %s", err))
+			return 1
+		}
 
-	for _, p := range plugins {
-		if err := p.Stop(); err != nil {
-			color.Red("ERROR stopping plugin %s: %s", p.Name(), err)
+		return 0
+	}
+
+	switch cmd {
+	case "serve":
+		serve()
+	case "validate":
+		if !runValidate(os.Stdout) {
+			return 1
+		}
+	case "schema":
+		if err := runSchema(os.Stdout, args); err != nil {
+			color.Red("ERROR: %s", err)
+			return 2
 		}
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintln(color.Error, color.RedString("unknown command %q", cmd))
+		fmt.Fprint(os.Stderr, usage)
+		return 2
 	}
+
+	return 0
 }
 
-func loadPlugins() ([]authPlugin.Plugin, error) {
+// readMainConfig reads the main config file from the first of the config paths it is found in
+func readMainConfig() error {
+	viper.SetConfigName("config")
+	for _, p := range configPaths {
+		viper.AddConfigPath(p)
+	}
+
+	if err := viper.ReadInConfig(); err != nil {
+		var notFoundErr viper.ConfigFileNotFoundError
+		if errors.As(err, &notFoundErr) {
+			return errors.New("config file not found")
+		}
+
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+
+	return nil
+}
+
+// serverConfig reads the config of the server from the main config file
+func serverConfig() server.Config {
+	return server.Config{
+		Addr:                viper.GetString("server.addr"),
+		AllowRawBody:        viper.GetBool("server.allow_raw_body"),
+		DefaultAuthPlugin:   viper.GetString("server.default_auth_plugin"),
+		TrashPurgeInterval:  viper.GetDuration("server.trash_purge_interval"),
+		ExpirySweepInterval: viper.GetDuration("server.expiry_sweep_interval"),
+		MaxArchiveFiles:     viper.GetInt("server.max_archive_files"),
+		MaxArchiveBytes:     viper.GetInt64("server.max_archive_bytes"),
+	}
+}
+
+// loadedPlugin is a started plugin and the config it was started from
+type loadedPlugin struct {
+	cfg    authPlugin.Config
+	plugin authPlugin.Plugin
+}
+
+// loadPlugins starts the plugins from the main config file.
+// The plugins in previous whose config has not changed are reused instead of being started again.
+func loadPlugins(previous []loadedPlugin) ([]loadedPlugin, error) {
 	var cfgs []authPlugin.Config
-	err := viper.UnmarshalKey("auth-plugins", &cfgs)
-	if err != nil {
+	if err := unmarshalPluginConfigs(&cfgs); err != nil {
 		return nil, fmt.Errorf("failed to parse configs: %w", err)
 	}
 
-	var plugins []authPlugin.Plugin
+	var plugins []loadedPlugin
 
 	for _, cfg := range cfgs {
+		if prev, ok := findLoadedPlugin(previous, cfg.Name); ok && reflect.DeepEqual(prev.cfg, cfg) {
+			plugins = append(plugins, prev)
+			continue
+		}
+
 		pg, err := authPlugin.NewPlugin(cfg)
 		if err != nil {
+			stopNewPlugins(plugins, previous)
 			return nil, fmt.Errorf("failed to create plugin %s: %w", cfg.Name, err)
 		}
-		plugins = append(plugins, pg)
+		plugins = append(plugins, loadedPlugin{cfg: cfg, plugin: pg})
 	}
 
 	return plugins, nil
 }
 
-func reloadProvidersFunc(pvp *viper.Viper, srv *server.Server) func(fsnotify.Event) {
-	return func(in fsnotify.Event) {
-		log.Println("Reloading providers")
-
-		newProviders, err := loadProviders(pvp)
-		if err != nil {
-			log.Println(color.RedString("ERROR: %s", err))
-			return
-		}
-
-		// All the providers that are in the new config shuld be registered
-		// They are removed first in case they existed before and should be reloaded
-		for _, p := range newProviders {
-			srv.RemoveProvider(p.Id())
+// unmarshalPluginConfigs decodes the configs of the auth plugins in the main config file
+func unmarshalPluginConfigs(cfgs *[]authPlugin.Config) error {
+	return viper.UnmarshalKey("auth-plugins", cfgs, viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		interpolateHook,
+		// The default hooks of viper
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+	)))
+}
 
-			if err := srv.RegisterProvider(p); err != nil {
-				log.Println(color.RedString("ERROR registering provider %s: %s", p.Id(), err))
-			}
-		}
+// interpolateHook resolves the references to environment variables and files in the strings of a config
+func interpolateHook(_ reflect.Type, _ reflect.Type, data any) (any, error) {
+	if s, ok := data.(string); ok {
+		return interpolate.String(s)
+	}
 
-		// All the providers that are not in the new config but existed before should be removed
-		var providersToDelete []string
-		for _, p := range srv.ProviderIds() {
-			if !providersContains(newProviders, p) {
-				providersToDelete = append(providersToDelete, p)
-			}
-		}
+	return data, nil
+}
 
-		for _, id := range providersToDelete {
-			srv.RemoveProvider(id)
+func findLoadedPlugin(plugins []loadedPlugin, name string) (loadedPlugin, bool) {
+	for _, lp := range plugins {
+		if lp.cfg.Name == name {
+			return lp, true
 		}
-
-		log.Println("Providers reloaded")
 	}
+
+	return loadedPlugin{}, false
 }
 
-func providersContains(providers []provider.Provider, id string) bool {
-	for _, p := range providers {
-		if p.Id() == id {
-			return true
-		}
+func pluginList(plugins []loadedPlugin) []authPlugin.Plugin {
+	list := make([]authPlugin.Plugin, 0, len(plugins))
+	for _, lp := range plugins {
+		list = append(list, lp.plugin)
 	}
-	return false
 
+	return list
 }
 
-// loadProviders loads the provider configurations from the provider config file
-func loadProviders(pvp *viper.Viper) ([]provider.Provider, error) {
-	pvp.SetConfigName("providers")
-	pvp.AddConfigPath(".")
-	pvp.AddConfigPath("$HOME/.filebutler")
-	pvp.AddConfigPath("/etc/filebutler/")
-	err := pvp.ReadInConfig()
-	if err != nil {
-		var notFoundErr viper.ConfigFileNotFoundError
-		if errors.As(err, &notFoundErr) {
-			return nil, fmt.Errorf("provider file not found")
+// stopNewPlugins stops the plugins that were started by loadPlugins and not reused from previous
+func stopNewPlugins(plugins, previous []loadedPlugin) {
+	for _, lp := range plugins {
+		if prev, ok := findLoadedPlugin(previous, lp.cfg.Name); ok && prev.plugin == lp.plugin {
+			continue
 		}
 
-		return nil, fmt.Errorf("error reading provider file: %w", err)
+		if err := lp.plugin.Stop(); err != nil {
+			log.Println(color.RedString("ERROR stopping plugin %s: %s", lp.cfg.Name, err))
+		}
 	}
+}
 
-	var providers []provider.Provider
-	for id, v := range pvp.AllSettings() {
-		providerType := pvp.GetString(fmt.Sprintf("%s.type", id))
-		if providerType == "" {
-			return nil, fmt.Errorf("type is required, missing for: %s", id)
-		}
+func reloadConfigFunc(srv *server.Server, addr string, current []loadedPlugin) func(fsnotify.Event) {
+	// The callbacks of the watcher are not run concurrently, but the mutex makes sure of it if that ever changes
+	var mx sync.Mutex
 
-		var cfg provider.Config
-		var err error
-
-		// Parse the provider config based on the provider type
-		switch providerType {
-		case string(provider.ProviderTypeVoid):
-			cfg, err = unmarshalProviderCfg[*provider.VoidConfig](id, v)
-		case string(provider.ProviderTypeLog):
-			cfg, err = unmarshalProviderCfg[*provider.LogConfig](id, v)
-		case string(provider.ProviderTypeS3):
-			cfg, err = unmarshalProviderCfg[*provider.S3Config](id, v)
-		case string(provider.ProviderTypeGocloud):
-			cfg, err = unmarshalProviderCfg[*provider.GocloudConfig](id, v)
-		default:
-			return nil, fmt.Errorf("unknown provider type: %s", providerType)
-		}
-		if err != nil {
-			return nil, fmt.Errorf("unable to parse config of provider %s: %w", id, err)
-		}
+	return func(in fsnotify.Event) {
+		mx.Lock()
+		defer mx.Unlock()
+
+		log.Println("Reloading config")
 
-		// Create the provider based on the config
-		// It is kept separate from the above part because IMO it is cleaner
-		var p provider.Provider
-
-		switch cfg := cfg.(type) {
-		case *provider.S3Config:
-			p, err = provider.NewS3Provider(cfg)
-		case *provider.VoidConfig:
-			p = provider.NewVoidProvider(cfg)
-		case *provider.LogConfig:
-			p = provider.NewLogProvider(cfg)
-		case *provider.GocloudConfig:
-			p, err = provider.NewGocloudProvider(cfg)
+		srvCfg := serverConfig()
+		if srvCfg.Addr != addr {
+			log.Println(color.YellowString("WARNING: server.addr can not be changed while the server is running, restart the server to use %s", srvCfg.Addr))
 		}
+
+		plugins, err := loadPlugins(current)
 		if err != nil {
-			return nil, fmt.Errorf("unable to create provider %s: %w", id, err)
+			log.Println(color.RedString("ERROR: %s", err))
+			return
 		}
 
-		providers = append(providers, p)
-	}
-
-	return providers, nil
-}
-
-func unmarshalProviderCfg[T provider.Config](id string, v any) (T, error) {
-	var cfg T
-
-	vMap, ok := v.(map[string]any)
-	if !ok {
-		return cfg, fmt.Errorf("invalid config type: %T", v)
-	}
-
-	vMap["id"] = id
+		// The settings and plugins are swapped at once, if the new config is invalid the server keeps the previous ones.
+		// The plugins that are replaced or removed are stopped by the server when they are no longer used.
+		if err := srv.Reconfigure(srvCfg, pluginList(plugins)); err != nil {
+			log.Println(color.RedString("ERROR: %s", err))
+			stopNewPlugins(plugins, current)
+			return
+		}
 
-	jsonBytes, err := json.Marshal(vMap)
-	if err != nil {
-		return cfg, fmt.Errorf("unable to marshal config: %w", err)
-	}
+		current = plugins
 
-	err = json.Unmarshal(jsonBytes, &cfg)
-	if err != nil {
-		return cfg, fmt.Errorf("unable to unmarshal config: %w", err)
+		log.Println("Config reloaded")
 	}
-
-	return cfg, nil
 }
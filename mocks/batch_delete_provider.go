@@ -0,0 +1,31 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/theleeeo/file-butler/provider"
+)
+
+var _ provider.Provider = (*BatchDeleteProvider)(nil)
+var _ provider.BatchDeleter = (*BatchDeleteProvider)(nil)
+
+func NewBatchDeleteProvider(cfg provider.ConfigBase) *BatchDeleteProvider {
+	return &BatchDeleteProvider{
+		Provider: Provider{
+			cfg: cfg,
+		},
+	}
+}
+
+type BatchDeleteProvider struct {
+	Provider
+}
+
+func (p *BatchDeleteProvider) DeleteObjects(ctx context.Context, keys []string) (map[string]error, error) {
+	called := p.Called(ctx, keys)
+	if called.Get(0) == nil {
+		return nil, called.Error(1)
+	}
+
+	return called.Get(0).(map[string]error), called.Error(1)
+}
@@ -0,0 +1,27 @@
+package mocks
+
+import (
+	"io"
+
+	"github.com/theleeeo/file-butler/provider"
+)
+
+var _ provider.Provider = (*CloseProvider)(nil)
+var _ io.Closer = (*CloseProvider)(nil)
+
+func NewCloseProvider(cfg provider.ConfigBase) *CloseProvider {
+	return &CloseProvider{
+		Provider: Provider{
+			cfg: cfg,
+		},
+	}
+}
+
+type CloseProvider struct {
+	Provider
+}
+
+func (p *CloseProvider) Close() error {
+	called := p.Called()
+	return called.Error(0)
+}
@@ -28,6 +28,10 @@ func (p *Provider) AuthPlugin() string {
 	return p.cfg.AuthPlugin
 }
 
+func (p *Provider) Policy() provider.Policy {
+	return p.cfg.Policy
+}
+
 func (p *Provider) Id() string {
 	return p.cfg.ID
 }
@@ -63,7 +67,8 @@ func (p *Provider) GetObject(ctx context.Context, key string, opts provider.GetO
 func (p *Provider) PutObject(ctx context.Context, key string, data io.Reader, opts provider.PutOptions) error {
 	completeData, err := io.ReadAll(data)
 	if err != nil {
-		panic(err)
+		// A real provider would fail the upload if the data can not be read
+		return err
 	}
 
 	called := p.Called(ctx, key, completeData, opts)
@@ -88,8 +93,8 @@ func (p *Provider) ListObjects(ctx context.Context, prefix string) (provider.Lis
 	return called.Get(0).(provider.ListObjectsResponse), called.Error(1)
 }
 
-func (p *Provider) DeleteObject(ctx context.Context, key string) error {
-	called := p.Called(ctx, key)
+func (p *Provider) DeleteObject(ctx context.Context, key string, opts provider.DeleteOptions) error {
+	called := p.Called(ctx, key, opts)
 	if called.Get(0) == nil {
 		return nil
 	}
@@ -0,0 +1,36 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/theleeeo/file-butler/provider"
+)
+
+var _ provider.Provider = (*VersionProvider)(nil)
+var _ provider.Versioner = (*VersionProvider)(nil)
+
+func NewVersionProvider(cfg provider.ConfigBase) *VersionProvider {
+	return &VersionProvider{
+		Provider: Provider{
+			cfg: cfg,
+		},
+	}
+}
+
+type VersionProvider struct {
+	Provider
+}
+
+func (p *VersionProvider) ListVersions(ctx context.Context, key string) (provider.ListVersionsResponse, error) {
+	called := p.Called(ctx, key)
+	if called.Get(0) == nil {
+		return provider.ListVersionsResponse{}, called.Error(1)
+	}
+
+	return called.Get(0).(provider.ListVersionsResponse), called.Error(1)
+}
+
+func (p *VersionProvider) RestoreVersion(ctx context.Context, key string, versionID string) error {
+	called := p.Called(ctx, key, versionID)
+	return called.Error(0)
+}
@@ -0,0 +1,81 @@
+package provider
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ButlerSignatureParam is the query parameter that the signature of a presigned butler URL is stored in
+	ButlerSignatureParam = "X-Butler-Signature"
+	// ButlerExpiresParam is the query parameter with the unix time that a presigned butler URL expires at
+	ButlerExpiresParam = "X-Butler-Expires"
+
+	defaultButlerPresignExpiry = 15 * time.Minute
+)
+
+// butlerPresigner creates and verifies presigned URLs that point back to the file-butler server itself.
+// It is used by providers that have no service of their own that a client could be sent to.
+type butlerPresigner struct {
+	baseURL    string
+	providerID string
+	expiry     time.Duration
+	secret     []byte
+}
+
+// newButlerPresigner creates a presigner with a random secret, so the URLs are only valid until the provider is recreated
+func newButlerPresigner(baseURL, providerID string, expiry time.Duration) (*butlerPresigner, error) {
+	if _, err := url.Parse(baseURL); err != nil {
+		return nil, fmt.Errorf("invalid presign base url: %w", err)
+	}
+
+	if expiry <= 0 {
+		expiry = defaultButlerPresignExpiry
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("unable to generate presign secret: %w", err)
+	}
+
+	return &butlerPresigner{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		providerID: providerID,
+		expiry:     expiry,
+		secret:     secret,
+	}, nil
+}
+
+func (b *butlerPresigner) sign(key string, op PresignOperation, expires string) string {
+	mac := hmac.New(sha256.New, b.secret)
+	mac.Write([]byte(b.providerID + "\n" + string(op) + "\n" + key + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (b *butlerPresigner) presign(key string, op PresignOperation) string {
+	expires := strconv.FormatInt(time.Now().Add(b.expiry).Unix(), 10)
+
+	query := url.Values{}
+	query.Set(ButlerExpiresParam, expires)
+	query.Set(ButlerSignatureParam, b.sign(key, op, expires))
+
+	return b.baseURL + "/file/" + url.PathEscape(b.providerID) + "/" + (&url.URL{Path: key}).EscapedPath() + "?" + query.Encode()
+}
+
+func (b *butlerPresigner) verify(key string, op PresignOperation, query url.Values) bool {
+	expires := query.Get(ButlerExpiresParam)
+
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return false
+	}
+
+	return hmac.Equal([]byte(query.Get(ButlerSignatureParam)), []byte(b.sign(key, op, expires)))
+}
@@ -0,0 +1,581 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// filesystemReservedDir is the directory in the root that the provider keeps its own files in, it can not be used for objects
+const filesystemReservedDir = ".file-butler"
+
+var _ Stater = &FilesystemProvider{}
+var _ TagSetter = &FilesystemProvider{}
+var _ DelimitedLister = &FilesystemProvider{}
+var _ ConditionalGetter = &FilesystemProvider{}
+
+type FilesystemConfig struct {
+	ConfigBase
+	// Root is the directory that the objects are stored in, it is created if it does not exist
+	Root string `required:"true"`
+}
+
+func NewFilesystemProvider(cfg *FilesystemConfig) (*FilesystemProvider, error) {
+	if cfg.Root == "" {
+		return nil, fmt.Errorf("root is required")
+	}
+
+	root, err := filepath.Abs(cfg.Root)
+	if err != nil {
+		return nil, fmt.Errorf("invalid root: %w", err)
+	}
+
+	for _, dir := range []string{root, filepath.Join(root, filesystemReservedDir, "meta"), filepath.Join(root, filesystemReservedDir, "tmp")} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("could not create directory: %w", err)
+		}
+	}
+
+	// The root itself may be a symlink, the paths of the objects are compared to where it leads
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, fmt.Errorf("invalid root: %w", err)
+	}
+
+	return &FilesystemProvider{
+		id:         cfg.ID,
+		authPlugin: cfg.AuthPlugin,
+		policy:     cfg.Policy,
+		root:       root,
+		realRoot:   realRoot,
+	}, nil
+}
+
+// FilesystemProvider stores each object as a file under the root directory.
+// The content type, tags and metadata are stored in a sidecar JSON file in the reserved directory.
+type FilesystemProvider struct {
+	id         string
+	authPlugin string
+	policy     Policy
+
+	root string
+	// realRoot is the root with its symlinks resolved
+	realRoot string
+
+	// commitMx is held while the sidecar and the file of an object are changed, so that they are always changed together
+	commitMx sync.Mutex
+}
+
+// filesystemSidecar is the content of the sidecar file of an object
+type filesystemSidecar struct {
+	ContentType string            `json:"content_type,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+func (f *FilesystemProvider) Id() string {
+	return f.id
+}
+
+func (f *FilesystemProvider) AuthPlugin() string {
+	return f.authPlugin
+}
+
+func (f *FilesystemProvider) Policy() Policy {
+	return f.policy
+}
+
+// objectPath returns the path of the file that the object is stored in.
+// Keys that are not clean relative paths are rejected, and so are keys that lead outside of the root or into the reserved directory through a symlink.
+func (f *FilesystemProvider) objectPath(key string) (string, error) {
+	if !isCleanKey(key) || isReservedKey(key) {
+		return "", ErrDenied
+	}
+
+	p := filepath.Join(f.root, filepath.FromSlash(key))
+	if err := f.checkSymlinks(p); err != nil {
+		return "", err
+	}
+
+	return p, nil
+}
+
+// checkSymlinks returns ErrDenied if the path leads outside of the root or into the reserved directory once its symlinks are followed.
+// Only the part of the path that exists is resolved, the rest is created by an upload and can not contain symlinks.
+func (f *FilesystemProvider) checkSymlinks(p string) error {
+	for existing := p; ; existing = filepath.Dir(existing) {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err != nil {
+			// A parent that is a file is reported when the path is used
+			if (errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR)) && existing != f.root {
+				continue
+			}
+			return mapFilesystemError(err)
+		}
+
+		rel, err := filepath.Rel(f.realRoot, resolved)
+		if err != nil {
+			return ErrDenied
+		}
+
+		if rel == "." {
+			return nil
+		}
+
+		key := filepath.ToSlash(rel)
+		if !isCleanKey(key) || isReservedKey(key) {
+			return ErrDenied
+		}
+
+		return nil
+	}
+}
+
+// isReservedKey reports if the key is in the reserved directory of the providers that store objects in a directory tree
+func isReservedKey(key string) bool {
+	return key == filesystemReservedDir || strings.HasPrefix(key, filesystemReservedDir+"/")
+}
+
+func (f *FilesystemProvider) sidecarPath(key string) string {
+	return filepath.Join(f.root, filesystemReservedDir, "meta", filepath.FromSlash(sidecarName(key)))
+}
+
+// sidecarName returns the path of the sidecar of an object relative to the meta directory.
+// The directories of the key get a ".d" suffix and the file a ".json" suffix, so that the sidecar of one key can never be a directory of the sidecar of another,
+// eg. the sidecars of "a" and "a.json/b" are "a.json" and "a.json.d/b.json".
+func sidecarName(key string) string {
+	dir, name := path.Split(key)
+	if dir == "" {
+		return name + ".json"
+	}
+
+	return strings.ReplaceAll(strings.TrimSuffix(dir, "/"), "/", ".d/") + ".d/" + name + ".json"
+}
+
+// isCleanKey reports if the key is a clean relative path.
+// Providers that store objects in a directory tree only accept those keys, so that no key can reach outside of the root or alias another key.
+func isCleanKey(key string) bool {
+	return key != "" && !path.IsAbs(key) && path.Clean(key) == key && key != ".." && !strings.HasPrefix(key, "../") && !strings.Contains(key, "\\")
+}
+
+// mapFilesystemError translates the errors of the os package into the errors of the provider package
+func mapFilesystemError(err error) error {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return ErrNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return ErrDenied
+	}
+
+	return err
+}
+
+func (f *FilesystemProvider) readSidecar(key string) (filesystemSidecar, error) {
+	var sidecar filesystemSidecar
+
+	b, err := os.ReadFile(f.sidecarPath(key))
+	if err != nil {
+		// Files that were put in the root by other means have no sidecar
+		if errors.Is(err, fs.ErrNotExist) {
+			return sidecar, nil
+		}
+		return sidecar, err
+	}
+
+	if err := json.Unmarshal(b, &sidecar); err != nil {
+		return sidecar, fmt.Errorf("invalid sidecar of %s: %w", key, err)
+	}
+
+	return sidecar, nil
+}
+
+// writeTemp writes the data to a new temporary file and returns its path.
+// The temporary file is in the root so that it can be renamed to its destination without crossing filesystems.
+func (f *FilesystemProvider) writeTemp(data io.Reader) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Join(f.root, filesystemReservedDir, "tmp"), "upload-*")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := io.Copy(tmp, data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	return tmp.Name(), nil
+}
+
+// moveTemp moves a temporary file to its destination, creating the directories of the destination if needed
+func moveTemp(tmp, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return mapFilesystemError(err)
+	}
+
+	return mapFilesystemError(os.Rename(tmp, dst))
+}
+
+// writeAtomic writes the data to a temporary file and renames it to the destination once it is complete,
+// so that readers never see a partially written file
+func (f *FilesystemProvider) writeAtomic(dst string, data io.Reader) error {
+	tmp, err := f.writeTemp(data)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	return moveTemp(tmp, dst)
+}
+
+func (f *FilesystemProvider) writeSidecar(key string, sidecar filesystemSidecar) error {
+	b, err := json.Marshal(sidecar)
+	if err != nil {
+		return err
+	}
+
+	return f.writeAtomic(f.sidecarPath(key), strings.NewReader(string(b)))
+}
+
+// stat returns the info of the file of an object together with its sidecar
+func (f *FilesystemProvider) stat(key string) (string, fs.FileInfo, filesystemSidecar, error) {
+	p, err := f.objectPath(key)
+	if err != nil {
+		return "", nil, filesystemSidecar{}, err
+	}
+
+	fi, err := os.Stat(p)
+	if err != nil {
+		return "", nil, filesystemSidecar{}, mapFilesystemError(err)
+	}
+
+	if !fi.Mode().IsRegular() {
+		return "", nil, filesystemSidecar{}, ErrNotFound
+	}
+
+	sidecar, err := f.readSidecar(key)
+	if err != nil {
+		return "", nil, filesystemSidecar{}, err
+	}
+
+	return p, fi, sidecar, nil
+}
+
+func objectInfoFromFile(fi fs.FileInfo, sidecar filesystemSidecar) ObjectInfo {
+	size := fi.Size()
+	modTime := fi.ModTime()
+
+	info := ObjectInfo{
+		LastModified:  &modTime,
+		ContentLength: &size,
+		Metadata:      sidecar.Metadata,
+		ETag:          objectETag(size, modTime),
+	}
+
+	if sidecar.ContentType != "" {
+		info.ContentType = &sidecar.ContentType
+	}
+
+	return info
+}
+
+// readCloser combines a reader of a part of a file with the file so that it can be closed
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func (f *FilesystemProvider) ConditionalGet() {}
+
+func (f *FilesystemProvider) GetObject(ctx context.Context, key string, opts GetOptions) (io.ReadCloser, ObjectInfo, error) {
+	if opts.VersionID != "" {
+		return nil, ObjectInfo{}, ErrNoVersioning
+	}
+
+	p, fi, sidecar, err := f.stat(key)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	info := objectInfoFromFile(fi, sidecar)
+
+	if err := checkConditions(opts, info); err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	start, length, partial, err := ParseByteRange(opts.Range, fi.Size())
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	file, err := os.Open(p)
+	if err != nil {
+		return nil, ObjectInfo{}, mapFilesystemError(err)
+	}
+
+	if !partial {
+		return file, info, nil
+	}
+
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		file.Close()
+		return nil, ObjectInfo{}, err
+	}
+
+	info.ContentLength = &length
+	info.ContentRange = ContentRange(start, length, fi.Size())
+
+	return readCloser{Reader: io.LimitReader(file, length), Closer: file}, info, nil
+}
+
+func (f *FilesystemProvider) StatObject(ctx context.Context, key string) (ObjectInfo, error) {
+	_, fi, sidecar, err := f.stat(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	return objectInfoFromFile(fi, sidecar), nil
+}
+
+func (f *FilesystemProvider) PutObject(ctx context.Context, key string, data io.Reader, opts PutOptions) error {
+	p, err := f.objectPath(key)
+	if err != nil {
+		return err
+	}
+
+	// The data is written before anything is changed, so that a failed upload leaves the object as it was
+	tmp, err := f.writeTemp(data)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	sidecar, err := json.Marshal(filesystemSidecar{
+		ContentType: opts.ContentType,
+		Tags:        opts.Tags,
+		Metadata:    opts.Metadata,
+	})
+	if err != nil {
+		return err
+	}
+
+	f.commitMx.Lock()
+	defer f.commitMx.Unlock()
+
+	// The previous sidecar is put back if the file can not be replaced, so that the old file is never paired with the new sidecar
+	previous, err := os.ReadFile(f.sidecarPath(key))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("unable to read sidecar: %w", err)
+	}
+	hadSidecar := err == nil
+
+	// The sidecar is written first so that the object never becomes visible without it
+	if err := f.writeAtomic(f.sidecarPath(key), strings.NewReader(string(sidecar))); err != nil {
+		return fmt.Errorf("unable to write sidecar: %w", err)
+	}
+
+	if err := moveTemp(tmp, p); err != nil {
+		var rollbackErr error
+		if hadSidecar {
+			rollbackErr = f.writeAtomic(f.sidecarPath(key), strings.NewReader(string(previous)))
+		} else {
+			rollbackErr = os.Remove(f.sidecarPath(key))
+		}
+
+		if rollbackErr != nil {
+			return fmt.Errorf("%w, and the previous sidecar could not be restored: %s", err, rollbackErr)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func (f *FilesystemProvider) GetTags(ctx context.Context, key string) (map[string]string, error) {
+	_, _, sidecar, err := f.stat(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return sidecar.Tags, nil
+}
+
+func (f *FilesystemProvider) SetTags(ctx context.Context, key string, tags map[string]string) error {
+	_, _, sidecar, err := f.stat(key)
+	if err != nil {
+		return err
+	}
+
+	f.commitMx.Lock()
+	defer f.commitMx.Unlock()
+
+	// The object may have been replaced since the sidecar was read
+	sidecar, err = f.readSidecar(key)
+	if err != nil {
+		return err
+	}
+
+	sidecar.Tags = tags
+	return f.writeSidecar(key, sidecar)
+}
+
+// listDir returns the directory to start listing from for a prefix, which is the directory part of the prefix.
+// The directory is checked the same way as the keys, so that no prefix can list anything outside of the root.
+func (f *FilesystemProvider) listDir(prefix string) (string, error) {
+	dir := prefix[:max(strings.LastIndex(prefix, "/"), 0)]
+	if dir == "" {
+		return f.root, nil
+	}
+
+	return f.objectPath(dir)
+}
+
+func (f *FilesystemProvider) ListObjects(ctx context.Context, prefix string) (ListObjectsResponse, error) {
+	dir, err := f.listDir(prefix)
+	if err != nil {
+		return ListObjectsResponse{}, err
+	}
+
+	keys := []string{}
+
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// The prefix does not have to match an existing directory
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+
+		rel, err := filepath.Rel(f.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if key == filesystemReservedDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.Type().IsRegular() && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return ListObjectsResponse{}, mapFilesystemError(err)
+	}
+
+	return ListObjectsResponse{Keys: keys}, nil
+}
+
+// ListObjectsDelimited only reads a single directory when the delimiter is a slash,
+// other delimiters are handled by grouping all the keys with the prefix.
+func (f *FilesystemProvider) ListObjectsDelimited(ctx context.Context, prefix, delimiter string) (ListObjectsResponse, error) {
+	if delimiter != "/" {
+		resp, err := f.ListObjects(ctx, prefix)
+		if err != nil {
+			return ListObjectsResponse{}, err
+		}
+
+		return GroupByDelimiter(resp.Keys, prefix, delimiter), nil
+	}
+
+	dir, err := f.listDir(prefix)
+	if err != nil {
+		return ListObjectsResponse{}, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ListObjectsResponse{Keys: []string{}}, nil
+		}
+		return ListObjectsResponse{}, mapFilesystemError(err)
+	}
+
+	dirPrefix := prefix[:strings.LastIndex(prefix, "/")+1]
+	resp := ListObjectsResponse{Keys: []string{}}
+
+	for _, e := range entries {
+		key := dirPrefix + e.Name()
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		switch {
+		case e.IsDir():
+			if key != filesystemReservedDir {
+				resp.CommonPrefixes = append(resp.CommonPrefixes, key+"/")
+			}
+		case e.Type().IsRegular():
+			resp.Keys = append(resp.Keys, key)
+		}
+	}
+
+	sort.Strings(resp.CommonPrefixes)
+
+	return resp, nil
+}
+
+func (f *FilesystemProvider) DeleteObject(ctx context.Context, key string, opts DeleteOptions) error {
+	if opts.VersionID != "" {
+		return ErrNoVersioning
+	}
+
+	p, _, _, err := f.stat(key)
+	if err != nil {
+		return err
+	}
+
+	f.commitMx.Lock()
+	defer f.commitMx.Unlock()
+
+	if err := os.Remove(p); err != nil {
+		return mapFilesystemError(err)
+	}
+
+	if err := os.Remove(f.sidecarPath(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("unable to delete sidecar: %w", err)
+	}
+
+	// Directories only exist to hold objects, so the ones that became empty are removed
+	removeEmptyParents(f.root, p)
+	removeEmptyParents(filepath.Join(f.root, filesystemReservedDir, "meta"), f.sidecarPath(key))
+
+	return nil
+}
+
+// removeEmptyParents removes the parent directories of the path until a directory that is not empty or the root is reached
+func removeEmptyParents(root, p string) {
+	for dir := filepath.Dir(p); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+	}
+}
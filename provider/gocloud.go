@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"sync"
 
 	"gocloud.dev/blob"
 	_ "gocloud.dev/blob/azureblob"
@@ -16,9 +17,16 @@ import (
 	"gocloud.dev/gcerrors"
 )
 
+// gocloudDeleteConcurrency is how many objects are deleted at the same time by DeleteObjects
+const gocloudDeleteConcurrency = 16
+
+var _ BatchDeleter = &GocloudProvider{}
+var _ Stater = &GocloudProvider{}
+var _ io.Closer = &GocloudProvider{}
+
 type GocloudConfig struct {
 	ConfigBase
-	DriverURL string `json:"driver-url"`
+	DriverURL string `json:"driver-url" required:"true"`
 }
 
 func NewGocloudProvider(cfg *GocloudConfig) (*GocloudProvider, error) {
@@ -31,11 +39,10 @@ func NewGocloudProvider(cfg *GocloudConfig) (*GocloudProvider, error) {
 		return nil, fmt.Errorf("could not open bucket: %w", err)
 	}
 
-	// defer bucket.Close()
-
 	return &GocloudProvider{
 		id:         cfg.ID,
 		authPlugin: cfg.AuthPlugin,
+		policy:     cfg.Policy,
 		bucket:     bucket,
 	}, nil
 }
@@ -43,6 +50,7 @@ func NewGocloudProvider(cfg *GocloudConfig) (*GocloudProvider, error) {
 type GocloudProvider struct {
 	id         string
 	authPlugin string
+	policy     Policy
 
 	bucket *blob.Bucket
 }
@@ -55,7 +63,19 @@ func (n *GocloudProvider) AuthPlugin() string {
 	return n.authPlugin
 }
 
+func (n *GocloudProvider) Policy() Policy {
+	return n.policy
+}
+
+func (n *GocloudProvider) Close() error {
+	return n.bucket.Close()
+}
+
 func (n *GocloudProvider) GetObject(ctx context.Context, key string, opts GetOptions) (io.ReadCloser, ObjectInfo, error) {
+	if opts.VersionID != "" {
+		return nil, ObjectInfo{}, ErrNoVersioning
+	}
+
 	r, err := n.bucket.NewReader(ctx, key, nil)
 	if err != nil {
 		if gcerrors.Code(err) == gcerrors.NotFound {
@@ -64,11 +84,22 @@ func (n *GocloudProvider) GetObject(ctx context.Context, key string, opts GetOpt
 		return nil, ObjectInfo{}, err
 	}
 
+	// The reader does not expose the user metadata so it has to be fetched separately
+	attrs, err := n.bucket.Attributes(ctx, key)
+	if err != nil {
+		r.Close()
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return nil, ObjectInfo{}, ErrNotFound
+		}
+		return nil, ObjectInfo{}, err
+	}
+
 	contentSize := r.Size()
 	lastMod := r.ModTime()
 	return r, ObjectInfo{
 		ContentLength: &contentSize,
 		LastModified:  &lastMod,
+		Metadata:      attrs.Metadata,
 	}, nil
 }
 
@@ -76,7 +107,9 @@ func (n *GocloudProvider) PutObject(ctx context.Context, key string, data io.Rea
 	writeCtx, cancelWrite := context.WithCancel(ctx)
 	defer cancelWrite()
 
-	w, err := n.bucket.NewWriter(writeCtx, key, nil)
+	w, err := n.bucket.NewWriter(writeCtx, key, &blob.WriterOptions{
+		Metadata: opts.Metadata,
+	})
 	if err != nil {
 		return err
 	}
@@ -91,6 +124,23 @@ func (n *GocloudProvider) PutObject(ctx context.Context, key string, data io.Rea
 	return nil
 }
 
+func (n *GocloudProvider) StatObject(ctx context.Context, key string) (ObjectInfo, error) {
+	attrs, err := n.bucket.Attributes(ctx, key)
+	if err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return ObjectInfo{}, ErrNotFound
+		}
+		return ObjectInfo{}, err
+	}
+
+	return ObjectInfo{
+		ContentLength: &attrs.Size,
+		LastModified:  &attrs.ModTime,
+		ContentType:   &attrs.ContentType,
+		Metadata:      attrs.Metadata,
+	}, nil
+}
+
 func (n *GocloudProvider) GetTags(ctx context.Context, key string) (map[string]string, error) {
 	log.Println("GetTags not implemented for gocloud provider")
 	return nil, nil
@@ -127,7 +177,11 @@ func (n *GocloudProvider) ListObjects(ctx context.Context, prefix string) (ListO
 	}, nil
 }
 
-func (n *GocloudProvider) DeleteObject(ctx context.Context, key string) error {
+func (n *GocloudProvider) DeleteObject(ctx context.Context, key string, opts DeleteOptions) error {
+	if opts.VersionID != "" {
+		return ErrNoVersioning
+	}
+
 	if err := n.bucket.Delete(ctx, key); err != nil {
 		if gcerrors.Code(err) == gcerrors.NotFound {
 			return ErrNotFound
@@ -138,3 +192,32 @@ func (n *GocloudProvider) DeleteObject(ctx context.Context, key string) error {
 
 	return nil
 }
+
+// DeleteObjects deletes the objects concurrently since the gocloud CDK has no batch delete
+func (n *GocloudProvider) DeleteObjects(ctx context.Context, keys []string) (map[string]error, error) {
+	var mx sync.Mutex
+	failed := make(map[string]error)
+
+	sem := make(chan struct{}, gocloudDeleteConcurrency)
+	var wg sync.WaitGroup
+
+	for _, k := range keys {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(key string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := n.DeleteObject(ctx, key, DeleteOptions{}); err != nil && !errors.Is(err, ErrNotFound) {
+				mx.Lock()
+				failed[key] = err
+				mx.Unlock()
+			}
+		}(k)
+	}
+
+	wg.Wait()
+
+	return failed, ctx.Err()
+}
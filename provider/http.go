@@ -0,0 +1,278 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultHTTPTimeout = 30 * time.Second
+
+var _ Stater = &HTTPProvider{}
+var _ io.Closer = &HTTPProvider{}
+var _ ConditionalGetter = &HTTPProvider{}
+
+type HTTPConfig struct {
+	ConfigBase
+	// BaseURL is the URL that the keys are appended to
+	BaseURL string `json:"base-url" required:"true"`
+	// Headers are added to all requests to the origin, eg. for authentication
+	Headers map[string]string
+	// Timeout is how long to wait for the origin to start responding
+	// Default is 30 seconds
+	Timeout Duration
+}
+
+func NewHTTPProvider(cfg *HTTPConfig) (*HTTPProvider, error) {
+	if cfg.BaseURL == "" {
+		return nil, fmt.Errorf("base-url is required")
+	}
+
+	baseURL, err := url.Parse(cfg.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base-url: %w", err)
+	}
+
+	if baseURL.Scheme != "http" && baseURL.Scheme != "https" {
+		return nil, fmt.Errorf("base-url must be an http or https URL")
+	}
+
+	// The origin can not be written to so the provider is always read-only
+	policy := cfg.Policy
+	if policy.Mode != ModeReadWrite && policy.Mode != ModeReadOnly {
+		return nil, fmt.Errorf("the http provider is always read-only, mode can not be %s", policy.Mode)
+	}
+	policy.Mode = ModeReadOnly
+
+	timeout := time.Duration(cfg.Timeout)
+	if timeout <= 0 {
+		timeout = defaultHTTPTimeout
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Only the time until the response starts is limited, downloading a large object may take longer
+	transport.ResponseHeaderTimeout = timeout
+
+	return &HTTPProvider{
+		id:         cfg.ID,
+		authPlugin: cfg.AuthPlugin,
+		policy:     policy,
+		baseURL:    strings.TrimSuffix(baseURL.String(), "/"),
+		headers:    cfg.Headers,
+		client:     &http.Client{Transport: transport},
+	}, nil
+}
+
+// HTTPProvider serves the objects from an existing HTTP server, where each key is a path under the base URL
+type HTTPProvider struct {
+	id         string
+	authPlugin string
+	policy     Policy
+
+	baseURL string
+	headers map[string]string
+	client  *http.Client
+}
+
+func (h *HTTPProvider) Id() string {
+	return h.id
+}
+
+func (h *HTTPProvider) AuthPlugin() string {
+	return h.authPlugin
+}
+
+func (h *HTTPProvider) Policy() Policy {
+	return h.policy
+}
+
+// Close closes the idle connections to the origin
+func (h *HTTPProvider) Close() error {
+	h.client.CloseIdleConnections()
+	return nil
+}
+
+// request sends a request for the key to the origin and maps the error statuses to the errors of the provider package.
+// The conditions of the options are forwarded so that the origin decides if they hold.
+func (h *HTTPProvider) request(ctx context.Context, method, key string, opts GetOptions) (*http.Response, error) {
+	// A key with dot segments could reach a path of the origin outside of the base URL
+	if !isCleanKey(key) {
+		return nil, ErrDenied
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, h.baseURL+"/"+(&url.URL{Path: key}).EscapedPath(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range h.headers {
+		req.Header.Set(k, v)
+	}
+
+	if opts.Range != "" {
+		req.Header.Set("Range", opts.Range)
+	}
+
+	if opts.LastModified != nil {
+		req.Header.Set("If-Modified-Since", opts.LastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if opts.IfUnmodifiedSince != nil {
+		req.Header.Set("If-Unmodified-Since", opts.IfUnmodifiedSince.UTC().Format(http.TimeFormat))
+	}
+
+	if opts.IfNoneMatch != "" {
+		req.Header.Set("If-None-Match", opts.IfNoneMatch)
+	}
+
+	if opts.IfMatch != "" {
+		req.Header.Set("If-Match", opts.IfMatch)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+
+	resp.Body.Close()
+
+	return nil, errorFromStatus(resp)
+}
+
+// errorFromStatus returns the error of the provider package that corresponds to an unsuccessful response from a server
+func errorFromStatus(resp *http.Response) error {
+	if err := errorFromStatusCode(resp.StatusCode, resp.Header); err != nil {
+		return err
+	}
+
+	return fmt.Errorf("unexpected status: %s", resp.Status)
+}
+
+// errorFromStatusCode returns the error of the provider package that corresponds to the status code, or nil if there is none
+func errorFromStatusCode(code int, header http.Header) error {
+	switch code {
+	case http.StatusNotFound, http.StatusGone:
+		return ErrNotFound
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusLocked:
+		return ErrDenied
+	case http.StatusNotModified:
+		return ErrNotModified
+	case http.StatusPreconditionFailed:
+		return ErrPreconditionFailed
+	case http.StatusRequestedRangeNotSatisfiable:
+		return ErrRangeNotSatisfiable
+	case http.StatusRequestEntityTooLarge, http.StatusInsufficientStorage:
+		return ErrTooLarge
+	case http.StatusTooManyRequests:
+		return withRetryAfter(ErrThrottled, header)
+	case http.StatusServiceUnavailable:
+		return withRetryAfter(ErrUnavailable, header)
+	}
+
+	return nil
+}
+
+// withRetryAfter wraps the error in a RetryAfterError if the response has a valid Retry-After header
+func withRetryAfter(err error, header http.Header) error {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return err
+	}
+
+	// The header is either a number of seconds or a date
+	if seconds, parseErr := strconv.Atoi(value); parseErr == nil && seconds >= 0 {
+		return &RetryAfterError{Err: err, RetryAfter: time.Duration(seconds) * time.Second}
+	}
+
+	if t, parseErr := http.ParseTime(value); parseErr == nil {
+		return &RetryAfterError{Err: err, RetryAfter: max(time.Until(t), 0)}
+	}
+
+	return err
+}
+
+func objectInfoFromResponse(resp *http.Response) ObjectInfo {
+	info := ObjectInfo{}
+
+	if resp.ContentLength >= 0 {
+		info.ContentLength = &resp.ContentLength
+	}
+
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		info.ContentType = &contentType
+	}
+
+	if lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.LastModified = &lastModified
+	}
+
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		info.ETag = &etag
+	}
+
+	if resp.StatusCode == http.StatusPartialContent {
+		if contentRange := resp.Header.Get("Content-Range"); contentRange != "" {
+			info.ContentRange = &contentRange
+		}
+	}
+
+	return info
+}
+
+func (h *HTTPProvider) ConditionalGet() {}
+
+func (h *HTTPProvider) GetObject(ctx context.Context, key string, opts GetOptions) (io.ReadCloser, ObjectInfo, error) {
+	if opts.VersionID != "" {
+		return nil, ObjectInfo{}, ErrNoVersioning
+	}
+
+	resp, err := h.request(ctx, http.MethodGet, key, opts)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	return resp.Body, objectInfoFromResponse(resp), nil
+}
+
+func (h *HTTPProvider) StatObject(ctx context.Context, key string) (ObjectInfo, error) {
+	resp, err := h.request(ctx, http.MethodHead, key, GetOptions{})
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	resp.Body.Close()
+
+	return objectInfoFromResponse(resp), nil
+}
+
+func (h *HTTPProvider) PutObject(ctx context.Context, key string, data io.Reader, opts PutOptions) error {
+	return ErrNotSupported
+}
+
+// GetTags returns no tags since an HTTP origin has no concept of them.
+// The object is still looked up at the origin, so that a missing object is reported as such.
+func (h *HTTPProvider) GetTags(ctx context.Context, key string) (map[string]string, error) {
+	resp, err := h.request(ctx, http.MethodHead, key, GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	return nil, nil
+}
+
+func (h *HTTPProvider) ListObjects(ctx context.Context, prefix string) (ListObjectsResponse, error) {
+	return ListObjectsResponse{}, ErrNotSupported
+}
+
+func (h *HTTPProvider) DeleteObject(ctx context.Context, key string, opts DeleteOptions) error {
+	return ErrNotSupported
+}
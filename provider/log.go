@@ -16,12 +16,14 @@ func NewLogProvider(cfg *LogConfig) *LogProvider {
 	return &LogProvider{
 		id:         cfg.ID,
 		authPlugin: cfg.AuthPlugin,
+		policy:     cfg.Policy,
 	}
 }
 
 type LogProvider struct {
 	id         string
 	authPlugin string
+	policy     Policy
 }
 
 func (n *LogProvider) Id() string {
@@ -32,6 +34,10 @@ func (n *LogProvider) AuthPlugin() string {
 	return n.authPlugin
 }
 
+func (n *LogProvider) Policy() Policy {
+	return n.policy
+}
+
 func (n *LogProvider) GetObject(ctx context.Context, key string, opts GetOptions) (io.ReadCloser, ObjectInfo, error) {
 	log.Printf("GetObject %s, opts=%v\n", key, opts)
 	return io.NopCloser(strings.NewReader("Hello World!\n")), ObjectInfo{}, nil
@@ -61,7 +67,7 @@ func (n *LogProvider) ListObjects(ctx context.Context, prefix string) (ListObjec
 	return ListObjectsResponse{}, nil
 }
 
-func (n *LogProvider) DeleteObject(ctx context.Context, key string) error {
-	log.Printf("DeleteObject %s\n", key)
+func (n *LogProvider) DeleteObject(ctx context.Context, key string, opts DeleteOptions) error {
+	log.Printf("DeleteObject %s, opts=%v\n", key, opts)
 	return nil
 }
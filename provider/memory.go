@@ -0,0 +1,297 @@
+package provider
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"io"
+	"maps"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ Presigner = &MemoryProvider{}
+var _ PresignVerifier = &MemoryProvider{}
+var _ Stater = &MemoryProvider{}
+var _ TagSetter = &MemoryProvider{}
+var _ ConditionalGetter = &MemoryProvider{}
+
+type MemoryConfig struct {
+	ConfigBase
+	// MaxSize is the maximum total size in bytes of the stored objects.
+	// The least recently used objects are evicted to make room for new ones. If zero there is no limit.
+	MaxSize int64 `json:"max-size"`
+	// PresignBaseURL is the URL that clients reach the file-butler server at.
+	// If set, presigned URLs pointing back to the server are supported.
+	PresignBaseURL string `json:"presign-base-url"`
+	// PresignExpiry is how long a presigned URL is valid
+	// Default is 15 minutes
+	PresignExpiry Duration `json:"presign-expiry"`
+}
+
+func NewMemoryProvider(cfg *MemoryConfig) (*MemoryProvider, error) {
+	m := &MemoryProvider{
+		id:         cfg.ID,
+		authPlugin: cfg.AuthPlugin,
+		policy:     cfg.Policy,
+		maxSize:    cfg.MaxSize,
+		objects:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+
+	if cfg.PresignBaseURL != "" {
+		presigner, err := newButlerPresigner(cfg.PresignBaseURL, cfg.ID, time.Duration(cfg.PresignExpiry))
+		if err != nil {
+			return nil, err
+		}
+		m.presigner = presigner
+	}
+
+	return m, nil
+}
+
+// MemoryProvider keeps all objects in memory, they are lost when the provider is removed or the server is stopped
+type MemoryProvider struct {
+	id         string
+	authPlugin string
+	policy     Policy
+
+	maxSize   int64
+	presigner *butlerPresigner
+
+	mx   sync.Mutex
+	size int64
+	// objects points to the elements of the lru list
+	objects map[string]*list.Element
+	// lru contains the objects with the most recently used first
+	lru *list.List
+}
+
+// memoryObject is never modified once it is stored, an update replaces it
+type memoryObject struct {
+	key         string
+	data        []byte
+	contentType string
+	tags        map[string]string
+	metadata    map[string]string
+	modTime     time.Time
+}
+
+func (m *MemoryProvider) Id() string {
+	return m.id
+}
+
+func (m *MemoryProvider) AuthPlugin() string {
+	return m.authPlugin
+}
+
+func (m *MemoryProvider) Policy() Policy {
+	return m.policy
+}
+
+// get returns the object and marks it as recently used
+func (m *MemoryProvider) get(key string) (*memoryObject, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	elem, ok := m.objects[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	m.lru.MoveToFront(elem)
+	return elem.Value.(*memoryObject), nil
+}
+
+func (o *memoryObject) info() ObjectInfo {
+	size := int64(len(o.data))
+	modTime := o.modTime
+
+	info := ObjectInfo{
+		LastModified:  &modTime,
+		ContentLength: &size,
+		Metadata:      maps.Clone(o.metadata),
+		ETag:          objectETag(size, modTime),
+	}
+
+	if o.contentType != "" {
+		contentType := o.contentType
+		info.ContentType = &contentType
+	}
+
+	return info
+}
+
+func (m *MemoryProvider) ConditionalGet() {}
+
+func (m *MemoryProvider) GetObject(ctx context.Context, key string, opts GetOptions) (io.ReadCloser, ObjectInfo, error) {
+	if opts.VersionID != "" {
+		return nil, ObjectInfo{}, ErrNoVersioning
+	}
+
+	obj, err := m.get(key)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	info := obj.info()
+
+	if err := checkConditions(opts, info); err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	size := int64(len(obj.data))
+
+	start, length, partial, err := ParseByteRange(opts.Range, size)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	if !partial {
+		return io.NopCloser(bytes.NewReader(obj.data)), info, nil
+	}
+
+	info.ContentLength = &length
+	info.ContentRange = ContentRange(start, length, size)
+
+	return io.NopCloser(bytes.NewReader(obj.data[start : start+length])), info, nil
+}
+
+func (m *MemoryProvider) StatObject(ctx context.Context, key string) (ObjectInfo, error) {
+	obj, err := m.get(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	return obj.info(), nil
+}
+
+func (m *MemoryProvider) PutObject(ctx context.Context, key string, data io.Reader, opts PutOptions) error {
+	if m.maxSize > 0 {
+		data = io.LimitReader(data, m.maxSize+1)
+	}
+
+	b, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+
+	if m.maxSize > 0 && int64(len(b)) > m.maxSize {
+		return ErrTooLarge
+	}
+
+	m.store(&memoryObject{
+		key:         key,
+		data:        b,
+		contentType: opts.ContentType,
+		tags:        maps.Clone(opts.Tags),
+		metadata:    maps.Clone(opts.Metadata),
+		modTime:     time.Now(),
+	})
+
+	return nil
+}
+
+// store adds or replaces the object, evicting the least recently used objects if the size limit is reached
+func (m *MemoryProvider) store(obj *memoryObject) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	m.remove(obj.key)
+
+	for m.maxSize > 0 && m.size+int64(len(obj.data)) > m.maxSize && m.lru.Len() > 0 {
+		m.remove(m.lru.Back().Value.(*memoryObject).key)
+	}
+
+	m.objects[obj.key] = m.lru.PushFront(obj)
+	m.size += int64(len(obj.data))
+}
+
+// remove deletes the object if it exists, the lock must be held
+func (m *MemoryProvider) remove(key string) bool {
+	elem, ok := m.objects[key]
+	if !ok {
+		return false
+	}
+
+	m.lru.Remove(elem)
+	delete(m.objects, key)
+	m.size -= int64(len(elem.Value.(*memoryObject).data))
+
+	return true
+}
+
+func (m *MemoryProvider) GetTags(ctx context.Context, key string) (map[string]string, error) {
+	obj, err := m.get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return maps.Clone(obj.tags), nil
+}
+
+func (m *MemoryProvider) SetTags(ctx context.Context, key string, tags map[string]string) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	elem, ok := m.objects[key]
+	if !ok {
+		return ErrNotFound
+	}
+
+	updated := *elem.Value.(*memoryObject)
+	updated.tags = maps.Clone(tags)
+	elem.Value = &updated
+
+	return nil
+}
+
+func (m *MemoryProvider) ListObjects(ctx context.Context, prefix string) (ListObjectsResponse, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	keys := []string{}
+	for k := range m.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return ListObjectsResponse{Keys: keys}, nil
+}
+
+func (m *MemoryProvider) DeleteObject(ctx context.Context, key string, opts DeleteOptions) error {
+	if opts.VersionID != "" {
+		return ErrNoVersioning
+	}
+
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	if !m.remove(key) {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// PresignURL returns a URL to the file-butler server that is authorized by its signature instead of the auth plugin
+func (m *MemoryProvider) PresignURL(ctx context.Context, key string, op PresignOperation) (string, error) {
+	if m.presigner == nil {
+		return "", ErrNoPresign
+	}
+
+	return m.presigner.presign(key, op), nil
+}
+
+func (m *MemoryProvider) VerifyPresigned(key string, op PresignOperation, query url.Values) bool {
+	if m.presigner == nil {
+		return false
+	}
+
+	return m.presigner.verify(key, op, query)
+}
@@ -2,8 +2,14 @@ package provider
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -11,11 +17,45 @@ var (
 	ErrNotFound  = errors.New("resource not found")
 	ErrDenied    = errors.New("access denied")
 	ErrNoPresign = errors.New("presigning is not allowed for this provider")
+	// ErrNoVersioning is returned when a specific version is requested from a provider that does not support object versioning
+	ErrNoVersioning = errors.New("versioning is not supported for this provider")
 	// This is a special error that the provider can return to indicate that the object has not been modified since the specified time
 	// It will be translated into a 304 Not Modified response by the server
 	ErrNotModified = errors.New("resource not modified")
+	// ErrRangeNotSatisfiable is returned when the requested range starts after the end of the object
+	ErrRangeNotSatisfiable = errors.New("the requested range is not satisfiable")
+	// ErrTooLarge is returned when an object is larger than the provider can store
+	ErrTooLarge = errors.New("the object is too large")
+	// ErrNotSupported is returned by providers that can not perform an operation at all, eg. listing an HTTP origin
+	ErrNotSupported = errors.New("the operation is not supported by this provider")
+	// ErrPreconditionFailed is returned when a condition of the request, eg. an If-Match, does not hold on the backend
+	ErrPreconditionFailed = errors.New("a precondition of the request failed")
+	// ErrThrottled is returned when the backend rejects the request because too many requests are being made
+	ErrThrottled = errors.New("the provider is throttling requests")
+	// ErrUnavailable is returned when the backend is temporarily unable to handle the request
+	ErrUnavailable = errors.New("the provider is temporarily unavailable")
+	// ErrArchived is returned when an object is in an archive storage class and must be restored before it can be read
+	ErrArchived = errors.New("the object is archived and must be restored before it can be read")
+	// ErrNotArchived is returned when restoring an object that is not in an archive storage class
+	ErrNotArchived = errors.New("the object is not archived")
+	// ErrMisconfigured is returned when the backend rejects the request because of the provider configuration, eg. a bucket that does not exist or invalid credentials
+	ErrMisconfigured = errors.New("the provider is misconfigured")
 )
 
+// RetryAfterError is returned together with ErrThrottled or ErrUnavailable when the backend said how long to wait before retrying
+type RetryAfterError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryAfterError) Unwrap() error {
+	return e.Err
+}
+
 type ProviderType string
 
 const (
@@ -28,39 +68,193 @@ const (
 	// ProviderTypeGocloud is a provider that uses the gocloud.dev CDK
 	// This will support all the providers that gocloud supports including S3, Azure, Google Cloud, file and in-memory
 	ProviderTypeGocloud ProviderType = "gocloud"
+	// ProviderTypeFilesystem is a provider that stores the objects in a directory on the local filesystem
+	ProviderTypeFilesystem ProviderType = "filesystem"
+	// ProviderTypeMemory is a provider that keeps the objects in memory, eg. for tests
+	ProviderTypeMemory ProviderType = "memory"
+	// ProviderTypeHTTP is a read-only provider that serves the objects from an existing HTTP server
+	ProviderTypeHTTP ProviderType = "http"
+	// ProviderTypeWebDAV is a provider that stores the objects on a WebDAV server
+	ProviderTypeWebDAV ProviderType = "webdav"
+	// ProviderTypeSFTP is a provider that stores the objects in a directory on an SFTP server
+	ProviderTypeSFTP ProviderType = "sftp"
+	// ProviderTypeSQLite is a provider that stores the objects in a single SQLite database file
+	ProviderTypeSQLite ProviderType = "sqlite"
 )
 
 type Config interface {
 	Id() string
+	// Base returns the settings that are common to all provider types
+	Base() *ConfigBase
 }
 
 type ConfigBase struct {
 	ID         string
 	AuthPlugin string `json:"auth-plugin"`
+	Policy
+}
+
+// Policy contains the settings that are available for all provider types.
+// They are enforced by the server and not by the providers themselves.
+type Policy struct {
+	// Trash enables soft deletes for the provider, if it is nil objects are deleted immediately
+	Trash *TrashConfig `json:"trash"`
+
+	// Mode restricts which write operations are allowed on the provider
+	// Default is ModeReadWrite
+	Mode Mode `json:"mode"`
+
+	// Retention is the minimum time an uploaded object must be kept before it can be deleted
+	// Uploads may extend it for a single object, but never shorten it
+	Retention Duration `json:"retention"`
+
+	// MaxExpiry is the longest expiry an upload can set on an object
+	// If zero, uploads can not set an expiry at all
+	MaxExpiry Duration `json:"max-expiry"`
+}
+
+// Validate checks that the policy settings are valid
+func (p Policy) Validate() error {
+	switch p.Mode {
+	case ModeReadWrite, ModeReadOnly, ModeWriteOnce, ModeAppendOnly:
+	default:
+		return fmt.Errorf("unknown mode: %s", p.Mode)
+	}
+
+	if p.Retention < 0 {
+		return fmt.Errorf("retention can not be negative")
+	}
+
+	if p.MaxExpiry < 0 {
+		return fmt.Errorf("max-expiry can not be negative")
+	}
+
+	return nil
+}
+
+type Mode string
+
+const (
+	// ModeReadWrite allows all operations
+	ModeReadWrite Mode = ""
+	// ModeReadOnly does not allow any uploads or deletes, eg. for mirrors of other sources
+	ModeReadOnly Mode = "read-only"
+	// ModeWriteOnce allows new objects to be uploaded but never overwritten
+	// They can be deleted once their retention has expired
+	ModeWriteOnce Mode = "write-once"
+	// ModeAppendOnly allows new objects to be uploaded but never overwritten or deleted
+	ModeAppendOnly Mode = "append-only"
+)
+
+type TrashConfig struct {
+	// Prefix is the hidden prefix that deleted objects are moved to
+	// Default is ".trash/"
+	Prefix string `json:"prefix"`
+
+	// Retention is how long deleted objects are kept in the trash before they are permanently deleted
+	// If zero, the objects are kept until they are restored or removed manually
+	Retention Duration `json:"retention"`
+}
+
+// TrashPrefix returns the prefix that deleted objects are moved to, with the default applied
+func (c *TrashConfig) TrashPrefix() string {
+	if c.Prefix == "" {
+		return ".trash/"
+	}
+
+	if !strings.HasSuffix(c.Prefix, "/") {
+		return c.Prefix + "/"
+	}
+
+	return c.Prefix
+}
+
+// Duration is a time.Duration that can be unmarshaled from a string like "1h30m" in the config files
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	switch v := v.(type) {
+	case float64:
+		*d = Duration(time.Duration(v))
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid duration: %w", err)
+		}
+		*d = Duration(parsed)
+	default:
+		return fmt.Errorf("invalid duration: %v", v)
+	}
+
+	return nil
 }
 
 func (c *ConfigBase) Id() string {
 	return c.ID
 }
 
+func (c *ConfigBase) Base() *ConfigBase {
+	return c
+}
+
 type Provider interface {
 	// Id returns the provider ID which must be unique among all providers
 	Id() string
 	// AuthPlugin optionally returns the name of the auth plugin that should be used for this provider
 	// If the provider does not specify an auth plugin the default one will be used
 	AuthPlugin() string
+	// Policy returns the provider independent settings that the server should enforce for this provider
+	Policy() Policy
 
 	GetObject(ctx context.Context, key string, opts GetOptions) (io.ReadCloser, ObjectInfo, error)
 	PutObject(ctx context.Context, key string, data io.Reader, opts PutOptions) error
 	GetTags(ctx context.Context, key string) (map[string]string, error)
 	ListObjects(ctx context.Context, prefix string) (ListObjectsResponse, error)
-	DeleteObject(ctx context.Context, key string) error
+	DeleteObject(ctx context.Context, key string, opts DeleteOptions) error
 }
 
 type GetOptions struct {
 	// If specified, the provider should only return the object if it has not been modified since this time, otherwise return ErrNotModified
 	// If zero, the provider should return the object regardless of its modification time
 	LastModified *time.Time
+
+	// If specified, the provider should return this version of the object instead of the latest one
+	// Providers that does not support versioning should return ErrNoVersioning
+	VersionID string
+
+	// If specified, the value of a Range header like "bytes=0-99"
+	// Providers that support ranges should only return that part of the object and set ContentRange in the ObjectInfo, others return the whole object
+	Range string
+
+	// If specified, the value of an If-None-Match header
+	// The provider should return ErrNotModified if it matches the entity tag of the object
+	// Only providers that implement ConditionalGetter evaluate this
+	IfNoneMatch string
+
+	// If specified, the value of an If-Match header
+	// The provider should return ErrPreconditionFailed if it does not match the entity tag of the object
+	// Only providers that implement ConditionalGetter evaluate this
+	IfMatch string
+
+	// If specified, the provider should return ErrPreconditionFailed if the object has been modified since this time
+	// Only providers that implement ConditionalGetter evaluate this
+	IfUnmodifiedSince *time.Time
+}
+
+// HasConditions reports if any condition other than LastModified is set, which only providers that implement ConditionalGetter evaluate
+func (o GetOptions) HasConditions() bool {
+	return o.IfNoneMatch != "" || o.IfMatch != "" || o.IfUnmodifiedSince != nil
+}
+
+type DeleteOptions struct {
+	// If specified, only this version of the object is deleted instead of the object itself
+	// Providers that does not support versioning should return ErrNoVersioning
+	VersionID string
 }
 
 type PutOptions struct {
@@ -71,6 +265,17 @@ type PutOptions struct {
 
 	// The tags to apply to the object
 	Tags map[string]string
+
+	// User defined metadata to store with the object, if the provider supports it
+	Metadata map[string]string
+
+	// If set, the object must not be deleted before this time
+	// Providers that can enforce this themselves should do so, it is always enforced by the server as well
+	RetainUntil *time.Time
+
+	// The storage class to store the object in, only set for providers that implement StorageClasser
+	// If empty, the default storage class of the provider is used
+	StorageClass string
 }
 
 // ObjectInfo contains metadata about an object
@@ -84,11 +289,26 @@ type ObjectInfo struct {
 
 	// The content type of the object
 	ContentType *string
+
+	// The version of the object that was returned, if the provider supports versioning
+	VersionID *string
+
+	// User defined metadata stored with the object, if the provider supports it
+	Metadata map[string]string
+
+	// The value of the Content-Range header if only a part of the object was returned
+	ContentRange *string
+
+	// The entity tag of the object, if the provider has one
+	ETag *string
 }
 
 type ListObjectsResponse struct {
 	// The keys of the objects found
 	Keys []string
+
+	// The prefixes that keys were grouped into when listing with a delimiter
+	CommonPrefixes []string `json:",omitempty"`
 }
 
 type PresignOperation string
@@ -101,3 +321,314 @@ const (
 type Presigner interface {
 	PresignURL(ctx context.Context, key string, direction PresignOperation) (string, error)
 }
+
+// PresignVerifier is implemented by providers whose presigned URLs point back to the file-butler server itself.
+// The server uses it to authorize requests to those URLs instead of calling the auth plugin.
+type PresignVerifier interface {
+	// VerifyPresigned reports if the query of a request contains a valid signature for the operation on the key
+	VerifyPresigned(key string, op PresignOperation, query url.Values) bool
+}
+
+// PresignOptions are the settings of a presigned upload that are chosen by the request for the URL
+type PresignOptions struct {
+	// The storage class to store the object in, if empty the default storage class of the provider is used
+	StorageClass string
+}
+
+// HeaderPresigner is implemented by presigners whose URLs are only valid if the client sends some headers with the request,
+// eg. the encryption settings of an S3 upload which are part of the signature but can not be put in the URL.
+type HeaderPresigner interface {
+	// PresignURLWithHeaders returns the presigned URL and the headers that the client must send with the request
+	PresignURLWithHeaders(ctx context.Context, key string, op PresignOperation, opts PresignOptions) (string, http.Header, error)
+}
+
+// StorageClasser is implemented by providers where an upload can choose the storage class of the object
+type StorageClasser interface {
+	// AllowedStorageClasses returns the storage classes that an upload may request
+	AllowedStorageClasses() []string
+}
+
+// RestoreOptions are the options of a restore of an archived object
+type RestoreOptions struct {
+	// Tier is how fast the object is restored, Expedited, Standard or Bulk. Faster tiers cost more.
+	Tier string
+	// Days is how many days the restored copy is kept before the object is only in the archive again
+	Days int32
+}
+
+// ArchiveStatus describes if an object is archived and the progress of restoring it
+type ArchiveStatus struct {
+	StorageClass string `json:"storage_class,omitempty"`
+
+	// If the object is in an archive storage class
+	Archived bool `json:"archived"`
+
+	// If a restore has been requested and is not finished yet
+	RestoreInProgress bool `json:"restore_in_progress,omitempty"`
+
+	// When the restored copy expires, it can be read until then
+	RestoredUntil *time.Time `json:"restored_until,omitempty"`
+}
+
+// ArchiveRestorer is implemented by providers where objects can be archived in a storage class that must be restored before the object can be read
+type ArchiveRestorer interface {
+	// RestoreArchived starts a restore of an archived object, it returns ErrNotArchived if the object is not archived
+	// Restoring an object that is already being restored is not an error
+	RestoreArchived(ctx context.Context, key string, opts RestoreOptions) error
+
+	// ArchiveStatus returns if the object is archived and the status of its restore
+	ArchiveStatus(ctx context.Context, key string) (ArchiveStatus, error)
+}
+
+// ConditionalGetter is implemented by providers that evaluate the IfNoneMatch, IfMatch and IfUnmodifiedSince conditions of GetOptions.
+// The server rejects requests with those conditions for other providers, since ignoring them would return the object when the client expects it not to be.
+type ConditionalGetter interface {
+	// ConditionalGet only marks the provider, it is never called
+	ConditionalGet()
+}
+
+// objectETag returns an entity tag that changes whenever the size or the modification time of the object changes.
+// It is used by providers whose backend does not have entity tags of its own.
+func objectETag(size int64, modTime time.Time) *string {
+	etag := fmt.Sprintf(`"%x-%x"`, size, modTime.UnixNano())
+	return &etag
+}
+
+// checkConditions evaluates the conditions of the options against the info of an object in the order of RFC 9110.
+// It is used by providers that can not send the conditions to their backend.
+func checkConditions(opts GetOptions, info ObjectInfo) error {
+	var modTime time.Time
+	if info.LastModified != nil {
+		// HTTP dates only have second precision, so the modification time is truncated to not report a change that can not be seen by the client
+		modTime = info.LastModified.Truncate(time.Second)
+	}
+
+	var etag string
+	if info.ETag != nil {
+		etag = *info.ETag
+	}
+
+	if opts.IfMatch != "" {
+		if !etagMatches(opts.IfMatch, etag, false) {
+			return ErrPreconditionFailed
+		}
+	} else if opts.IfUnmodifiedSince != nil && modTime.After(*opts.IfUnmodifiedSince) {
+		return ErrPreconditionFailed
+	}
+
+	if opts.IfNoneMatch != "" {
+		if etagMatches(opts.IfNoneMatch, etag, true) {
+			return ErrNotModified
+		}
+	} else if opts.LastModified != nil && !modTime.After(*opts.LastModified) {
+		return ErrNotModified
+	}
+
+	return nil
+}
+
+// etagMatches reports if the entity tag is in the list of an If-Match or If-None-Match header.
+// If-None-Match uses the weak comparison where the W/ prefix of weak tags is ignored, If-Match only matches strong tags.
+func etagMatches(header, etag string, weak bool) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+
+	if etag == "" {
+		return false
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+			etag = strings.TrimPrefix(etag, "W/")
+		} else if strings.HasPrefix(tag, "W/") || strings.HasPrefix(etag, "W/") {
+			continue
+		}
+
+		if tag == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Stater is implemented by providers that can get the info of an object without reading its content
+type Stater interface {
+	StatObject(ctx context.Context, key string) (ObjectInfo, error)
+}
+
+// Stat returns the info of an object.
+// If the provider does not implement Stater the object is opened using GetObject and closed without being read.
+func Stat(ctx context.Context, p Provider, key string) (ObjectInfo, error) {
+	if s, ok := p.(Stater); ok {
+		return s.StatObject(ctx, key)
+	}
+
+	data, info, err := p.GetObject(ctx, key, GetOptions{})
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	return info, data.Close()
+}
+
+// Close releases the resources held by the provider, such as connections and open files.
+// Providers that hold no resources do not implement io.Closer and nothing is done for them.
+// The provider must not be used after it has been closed.
+func Close(p Provider) error {
+	if c, ok := p.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+// TagSetter is implemented by providers that can replace the tags of an existing object
+type TagSetter interface {
+	SetTags(ctx context.Context, key string, tags map[string]string) error
+}
+
+// BatchDeleter is implemented by providers that can delete many objects more efficiently than one at a time
+type BatchDeleter interface {
+	// DeleteObjects deletes all objects with the given keys.
+	// The returned map contains the keys that could not be deleted and the reason, the error is only set if the whole operation failed.
+	DeleteObjects(ctx context.Context, keys []string) (map[string]error, error)
+}
+
+// ObjectVersion describes one version of an object in a provider with versioning enabled
+type ObjectVersion struct {
+	VersionID string `json:"version_id"`
+
+	// If this is the current version of the object
+	IsLatest bool `json:"is_latest"`
+
+	// If the version is a delete marker, meaning that the object was deleted at this point
+	DeleteMarker bool `json:"delete_marker,omitempty"`
+
+	LastModified *time.Time `json:"last_modified,omitempty"`
+
+	ContentLength *int64 `json:"content_length,omitempty"`
+}
+
+type ListVersionsResponse struct {
+	// The versions of the object, newest first
+	Versions []ObjectVersion `json:"versions"`
+}
+
+// Versioner is implemented by providers that keep old versions of objects
+type Versioner interface {
+	// ListVersions returns all versions of the object with the given key
+	ListVersions(ctx context.Context, key string) (ListVersionsResponse, error)
+	// RestoreVersion makes the given version the current version of the object by copying it over the current one
+	RestoreVersion(ctx context.Context, key string, versionID string) error
+}
+
+// DelimitedLister is implemented by providers that can group the keys by a delimiter when listing, like directories in a filesystem
+type DelimitedLister interface {
+	// ListObjectsDelimited returns the keys with the prefix that do not contain the delimiter after the prefix.
+	// The keys that do are grouped into CommonPrefixes, up to and including the first delimiter after the prefix.
+	ListObjectsDelimited(ctx context.Context, prefix, delimiter string) (ListObjectsResponse, error)
+}
+
+// ListDelimited lists the objects with the prefix grouped by the delimiter.
+// If the provider does not implement DelimitedLister all keys with the prefix are listed and grouped afterwards.
+func ListDelimited(ctx context.Context, p Provider, prefix, delimiter string) (ListObjectsResponse, error) {
+	if l, ok := p.(DelimitedLister); ok {
+		return l.ListObjectsDelimited(ctx, prefix, delimiter)
+	}
+
+	resp, err := p.ListObjects(ctx, prefix)
+	if err != nil {
+		return ListObjectsResponse{}, err
+	}
+
+	return GroupByDelimiter(resp.Keys, prefix, delimiter), nil
+}
+
+// GroupByDelimiter groups the keys with the prefix the same way as DelimitedLister
+func GroupByDelimiter(keys []string, prefix, delimiter string) ListObjectsResponse {
+	resp := ListObjectsResponse{Keys: []string{}}
+	seen := make(map[string]bool)
+
+	for _, k := range keys {
+		rest, ok := strings.CutPrefix(k, prefix)
+		if !ok {
+			continue
+		}
+
+		i := strings.Index(rest, delimiter)
+		if i < 0 {
+			resp.Keys = append(resp.Keys, k)
+			continue
+		}
+
+		commonPrefix := prefix + rest[:i+len(delimiter)]
+		if !seen[commonPrefix] {
+			seen[commonPrefix] = true
+			resp.CommonPrefixes = append(resp.CommonPrefixes, commonPrefix)
+		}
+	}
+
+	return resp
+}
+
+// ParseByteRange parses a Range header with a single byte range for an object of the given size.
+// ok is false if there is no range or if it can not be handled, in which case the whole object should be returned.
+func ParseByteRange(header string, size int64) (start, length int64, ok bool, err error) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	// Multiple ranges are not supported, the whole object is returned instead as allowed by RFC 9110
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false, nil
+	}
+
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false, nil
+	}
+
+	// A suffix range like "bytes=-500" is the last 500 bytes
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, false, nil
+		}
+
+		if n == 0 || size == 0 {
+			return 0, 0, false, ErrRangeNotSatisfiable
+		}
+
+		n = min(n, size)
+		return size - n, n, true, nil
+	}
+
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false, nil
+	}
+
+	if start >= size {
+		return 0, 0, false, ErrRangeNotSatisfiable
+	}
+
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false, nil
+		}
+
+		end = min(end, size-1)
+	}
+
+	return start, end - start + 1, true, nil
+}
+
+// ContentRange returns the value of the Content-Range header for a part of an object
+func ContentRange(start, length, size int64) *string {
+	s := fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, size)
+	return &s
+}
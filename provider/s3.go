@@ -2,25 +2,83 @@ package provider
 
 import (
 	"context"
+	"crypto/md5"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 )
 
 var _ Provider = &S3Provider{}
 var _ Presigner = &S3Provider{}
+var _ Versioner = &S3Provider{}
+var _ Stater = &S3Provider{}
+var _ BatchDeleter = &S3Provider{}
+var _ TagSetter = &S3Provider{}
+var _ DelimitedLister = &S3Provider{}
+var _ HeaderPresigner = &S3Provider{}
+var _ StorageClasser = &S3Provider{}
+var _ ArchiveRestorer = &S3Provider{}
+var _ ConditionalGetter = &S3Provider{}
+
+// s3MaxDeleteObjects is the maximum number of keys that S3 accepts in a single DeleteObjects request
+const s3MaxDeleteObjects = 1000
 
 type S3Config struct {
 	ConfigBase
-	Bucket         string
+	Bucket         string `required:"true"`
 	Region         string
 	Profile        string
 	PresignEnabled bool `json:"presign-enabled"`
+	// ObjectLockMode is the S3 Object Lock mode (GOVERNANCE or COMPLIANCE) applied to objects uploaded with a retention.
+	// The bucket must have Object Lock enabled. If empty, the retention is only enforced by file-butler.
+	ObjectLockMode string `json:"object-lock-mode"`
+
+	// Endpoint is the URL of an S3 compatible service like MinIO or Ceph RGW, if empty AWS is used
+	Endpoint string
+	// PathStyle puts the bucket name in the path of the URL instead of the host name, which most S3 compatible services require
+	PathStyle bool `json:"path-style"`
+
+	// AccessKey and SecretKey are static credentials that are used instead of the default AWS credential chain
+	AccessKey    string `json:"access-key"`
+	SecretKey    string `json:"secret-key"`
+	SessionToken string `json:"session-token"`
+
+	// CABundle is the path of a PEM file with certificates that are trusted in addition to the system ones
+	CABundle string `json:"ca-bundle"`
+	// InsecureSkipVerify disables the verification of the TLS certificate of the endpoint, it should only be used for tests
+	InsecureSkipVerify bool `json:"insecure-skip-verify"`
+
+	// SSE is the server-side encryption of uploaded objects, "s3" for keys managed by S3, "kms" for keys in AWS KMS or "customer" for a key of our own.
+	// If empty, the default encryption of the bucket is used.
+	SSE string `json:"sse"`
+	// SSEKMSKeyID is the KMS key used with "kms" encryption, if empty the AWS managed key for S3 is used
+	SSEKMSKeyID string `json:"sse-kms-key-id"`
+	// SSECustomerKeyFile is a file with the 256-bit key used with "customer" encryption, either as 32 raw bytes or base64 encoded.
+	// S3 does not store the key, so the objects can not be read without it.
+	SSECustomerKeyFile string `json:"sse-customer-key-file"`
+
+	// StorageClass is the storage class of uploaded objects, eg. STANDARD_IA. If empty, STANDARD is used.
+	StorageClass string `json:"storage-class"`
+	// AllowedStorageClasses are the storage classes that an upload may choose instead of the default with the X-Storage-Class header
+	AllowedStorageClasses []string `json:"allowed-storage-classes"`
 }
 
 func NewS3Provider(cfg *S3Config) (*S3Provider, error) {
@@ -28,15 +86,65 @@ func NewS3Provider(cfg *S3Config) (*S3Provider, error) {
 		return nil, fmt.Errorf("bucket name is required")
 	}
 
+	objectLockMode := types.ObjectLockMode(strings.ToUpper(cfg.ObjectLockMode))
+	if objectLockMode != "" && objectLockMode != types.ObjectLockModeGovernance && objectLockMode != types.ObjectLockModeCompliance {
+		return nil, fmt.Errorf("unknown object lock mode: %s", cfg.ObjectLockMode)
+	}
+
+	if (cfg.AccessKey == "") != (cfg.SecretKey == "") {
+		return nil, fmt.Errorf("access-key and secret-key must be set together")
+	}
+
+	encryption, err := newS3Encryption(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// A presigned request with a customer key must contain the key, which would give it away to the client
+	if cfg.PresignEnabled && encryption.customerKey != "" {
+		return nil, fmt.Errorf("presign-enabled can not be used with customer provided encryption keys")
+	}
+
+	region := cfg.Region
+	// S3 compatible services often ignore the region, but the requests must still be signed for one
+	if region == "" && cfg.Endpoint != "" {
+		region = "us-east-1"
+	}
+
+	loadOpts := []func(*config.LoadOptions) error{
+		config.WithRegion(region),
+		config.WithSharedConfigProfile(cfg.Profile),
+	}
+
+	if cfg.AccessKey != "" {
+		loadOpts = append(loadOpts, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(cfg.AccessKey, cfg.SecretKey, cfg.SessionToken)))
+	}
+
+	if cfg.CABundle != "" || cfg.InsecureSkipVerify {
+		tlsConfig, err := s3TLSConfig(cfg.CABundle, cfg.InsecureSkipVerify)
+		if err != nil {
+			return nil, err
+		}
+
+		loadOpts = append(loadOpts, config.WithHTTPClient(awshttp.NewBuildableClient().WithTransportOptions(func(t *http.Transport) {
+			t.TLSClientConfig = tlsConfig
+		})))
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	clientCfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(cfg.Region), config.WithSharedConfigProfile(cfg.Profile))
+	clientCfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("unable to load SDK config, %w", err)
 	}
 
-	client := s3.NewFromConfig(clientCfg)
+	client := s3.NewFromConfig(clientCfg, func(o *s3.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = &cfg.Endpoint
+		}
+		o.UsePathStyle = cfg.PathStyle
+	})
 
 	var presignClient *s3.PresignClient
 	if cfg.PresignEnabled {
@@ -44,19 +152,133 @@ func NewS3Provider(cfg *S3Config) (*S3Provider, error) {
 	}
 
 	return &S3Provider{
-		id:            cfg.ID,
-		authPlugin:    cfg.AuthPlugin,
-		bucketName:    cfg.Bucket,
-		client:        client,
-		presignClient: presignClient,
+		id:                    cfg.ID,
+		authPlugin:            cfg.AuthPlugin,
+		policy:                cfg.Policy,
+		bucketName:            cfg.Bucket,
+		objectLockMode:        objectLockMode,
+		encryption:            encryption,
+		storageClass:          types.StorageClass(cfg.StorageClass),
+		allowedStorageClasses: cfg.AllowedStorageClasses,
+		client:                client,
+		presignClient:         presignClient,
 	}, nil
 }
 
+// s3Encryption is the server-side encryption that is requested for the objects
+type s3Encryption struct {
+	mode     types.ServerSideEncryption
+	kmsKeyID string
+
+	// The base64 encoded customer provided key and its MD5 digest, only set for "customer" encryption
+	customerKey    string
+	customerKeyMD5 string
+}
+
+func newS3Encryption(cfg *S3Config) (s3Encryption, error) {
+	if cfg.SSE != "kms" && cfg.SSEKMSKeyID != "" {
+		return s3Encryption{}, fmt.Errorf("sse-kms-key-id can only be used with kms encryption")
+	}
+
+	if cfg.SSE != "customer" && cfg.SSECustomerKeyFile != "" {
+		return s3Encryption{}, fmt.Errorf("sse-customer-key-file can only be used with customer encryption")
+	}
+
+	switch cfg.SSE {
+	case "":
+		return s3Encryption{}, nil
+	case "s3":
+		return s3Encryption{mode: types.ServerSideEncryptionAes256}, nil
+	case "kms":
+		return s3Encryption{mode: types.ServerSideEncryptionAwsKms, kmsKeyID: cfg.SSEKMSKeyID}, nil
+	case "customer":
+		if cfg.SSECustomerKeyFile == "" {
+			return s3Encryption{}, fmt.Errorf("sse-customer-key-file is required for customer encryption")
+		}
+
+		key, err := readSSECustomerKey(cfg.SSECustomerKeyFile)
+		if err != nil {
+			return s3Encryption{}, err
+		}
+
+		digest := md5.Sum(key) //nolint:gosec // S3 requires the MD5 digest of the key to check that it was not corrupted
+		return s3Encryption{
+			customerKey:    base64.StdEncoding.EncodeToString(key),
+			customerKeyMD5: base64.StdEncoding.EncodeToString(digest[:]),
+		}, nil
+	}
+
+	return s3Encryption{}, fmt.Errorf("unknown sse: %s, must be s3, kms or customer", cfg.SSE)
+}
+
+// readSSECustomerKey reads a 256-bit key that is stored either as raw bytes or base64 encoded
+func readSSECustomerKey(path string) ([]byte, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read sse-customer-key-file: %w", err)
+	}
+
+	if len(raw) == 32 {
+		return raw, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("sse-customer-key-file must contain a 256-bit key, as raw bytes or base64 encoded")
+	}
+
+	return key, nil
+}
+
+// customer returns the algorithm, key and key digest that must be sent with every request for an object encrypted with a customer provided key.
+// They are all nil if no customer key is used.
+func (e s3Encryption) customer() (algorithm, key, keyMD5 *string) {
+	if e.customerKey == "" {
+		return nil, nil, nil
+	}
+
+	return aws.String("AES256"), aws.String(e.customerKey), aws.String(e.customerKeyMD5)
+}
+
+// s3TLSConfig returns the TLS config for the connections to the endpoint
+func s3TLSConfig(caBundle string, insecureSkipVerify bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+
+	if caBundle != "" {
+		pem, err := os.ReadFile(caBundle)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read ca-bundle: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ca-bundle")
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
 type S3Provider struct {
 	id         string
 	authPlugin string
+	policy     Policy
 	bucketName string
 
+	objectLockMode types.ObjectLockMode
+
+	encryption            s3Encryption
+	storageClass          types.StorageClass
+	allowedStorageClasses []string
+
 	client        *s3.Client
 	presignClient *s3.PresignClient
 }
@@ -69,24 +291,57 @@ func (s *S3Provider) AuthPlugin() string {
 	return s.authPlugin
 }
 
+func (s *S3Provider) Policy() Policy {
+	return s.policy
+}
+
+func (s *S3Provider) AllowedStorageClasses() []string {
+	return s.allowedStorageClasses
+}
+
+// uploadStorageClass returns the storage class requested for an upload, or the default of the provider
+func (s *S3Provider) uploadStorageClass(requested string) types.StorageClass {
+	if requested != "" {
+		return types.StorageClass(requested)
+	}
+
+	return s.storageClass
+}
+
+// putObjectInput returns the input for an upload of the key with the encryption and storage class of the provider
+func (s *S3Provider) putObjectInput(key string, storageClass string) *s3.PutObjectInput {
+	input := &s3.PutObjectInput{
+		Bucket:               &s.bucketName,
+		Key:                  &key,
+		ServerSideEncryption: s.encryption.mode,
+		SSEKMSKeyId:          optionalString(s.encryption.kmsKeyID),
+		StorageClass:         s.uploadStorageClass(storageClass),
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.encryption.customer()
+
+	return input
+}
+
+func (s *S3Provider) ConditionalGet() {}
+
 func (s *S3Provider) GetObject(ctx context.Context, key string, opts GetOptions) (io.ReadCloser, ObjectInfo, error) {
 	// Only return the object if it has been modified since the specified time
 	// Otherwise return the ErrNotModified error
-	if opts.LastModified != nil {
-		headResp, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
-			Bucket: &s.bucketName,
-			Key:    &key,
-		})
-		if err != nil {
-			if strings.Contains(err.Error(), "NoSuchKey") {
-				return nil, ObjectInfo{}, ErrNotFound
-			}
-
-			if strings.Contains(err.Error(), "AccessDenied") {
-				return nil, ObjectInfo{}, ErrDenied
-			}
+	// An If-None-Match takes precedence over the modification time, so it is left to S3 then
+	if opts.LastModified != nil && opts.IfNoneMatch == "" {
+		// The other conditions are sent with the HEAD as well so that they are checked before the modification time
+		headInput := &s3.HeadObjectInput{
+			Bucket:            &s.bucketName,
+			Key:               &key,
+			VersionId:         optionalString(opts.VersionID),
+			IfMatch:           optionalString(opts.IfMatch),
+			IfUnmodifiedSince: opts.IfUnmodifiedSince,
+		}
+		headInput.SSECustomerAlgorithm, headInput.SSECustomerKey, headInput.SSECustomerKeyMD5 = s.encryption.customer()
 
-			return nil, ObjectInfo{}, err
+		headResp, err := s.client.HeadObject(ctx, headInput)
+		if err != nil {
+			return nil, ObjectInfo{}, mapS3Error(err)
 		}
 
 		// If the object has not been modified since the specified time, return ErrNotModified which will be translated into a 304 Not Modified response by the server
@@ -96,36 +351,67 @@ func (s *S3Provider) GetObject(ctx context.Context, key string, opts GetOptions)
 		}
 	}
 
-	getResp, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+	getInput := &s3.GetObjectInput{
+		Bucket:            &s.bucketName,
+		Key:               &key,
+		VersionId:         optionalString(opts.VersionID),
+		Range:             optionalString(opts.Range),
+		IfMatch:           optionalString(opts.IfMatch),
+		IfNoneMatch:       optionalString(opts.IfNoneMatch),
+		IfUnmodifiedSince: opts.IfUnmodifiedSince,
+	}
+	getInput.SSECustomerAlgorithm, getInput.SSECustomerKey, getInput.SSECustomerKeyMD5 = s.encryption.customer()
+
+	getResp, err := s.client.GetObject(ctx, getInput)
+	if err != nil {
+		return nil, ObjectInfo{}, mapS3Error(err)
+	}
+
+	return getResp.Body, ObjectInfo{LastModified: getResp.LastModified, ContentLength: getResp.ContentLength, ContentType: getResp.ContentType, VersionID: getResp.VersionId, Metadata: getResp.Metadata, ContentRange: getResp.ContentRange, ETag: getResp.ETag}, nil
+}
+
+func (s *S3Provider) StatObject(ctx context.Context, key string) (ObjectInfo, error) {
+	input := &s3.HeadObjectInput{
 		Bucket: &s.bucketName,
 		Key:    &key,
-	})
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.encryption.customer()
+
+	headResp, err := s.client.HeadObject(ctx, input)
 	if err != nil {
-		if strings.Contains(err.Error(), "NoSuchKey") {
-			return nil, ObjectInfo{}, ErrNotFound
-		}
+		return ObjectInfo{}, mapS3Error(err)
+	}
 
-		if strings.Contains(err.Error(), "AccessDenied") {
-			return nil, ObjectInfo{}, ErrDenied
-		}
+	return ObjectInfo{LastModified: headResp.LastModified, ContentLength: headResp.ContentLength, ContentType: headResp.ContentType, VersionID: headResp.VersionId, Metadata: headResp.Metadata, ETag: headResp.ETag}, nil
+}
 
-		return nil, ObjectInfo{}, err
+// optionalString returns nil for an empty string so that optional fields are left out of the request
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
 	}
 
-	return getResp.Body, ObjectInfo{LastModified: getResp.LastModified, ContentLength: getResp.ContentLength, ContentType: getResp.ContentType}, nil
+	return &s
 }
 
 func (s *S3Provider) PutObject(ctx context.Context, key string, data io.Reader, opts PutOptions) error {
-	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket:        &s.bucketName,
-		Key:           &key,
-		Body:          data,
-		ContentLength: &opts.ContentLength,
-		Tagging:       buildTagging(opts.Tags),
-		ContentType:   &opts.ContentType,
-	})
+	input := s.putObjectInput(key, opts.StorageClass)
+	input.Body = data
+	input.ContentLength = &opts.ContentLength
+	input.Tagging = buildTagging(opts.Tags)
+	input.ContentType = &opts.ContentType
+	input.Metadata = opts.Metadata
+
+	if opts.RetainUntil != nil && s.objectLockMode != "" {
+		input.ObjectLockMode = s.objectLockMode
+		input.ObjectLockRetainUntilDate = opts.RetainUntil
+		// S3 requires a checksum of the content for all uploads with object lock settings
+		input.ChecksumAlgorithm = types.ChecksumAlgorithmCrc32
+	}
+
+	_, err := s.client.PutObject(ctx, input)
 	if err != nil {
-		return err
+		return mapS3Error(err)
 	}
 
 	return nil
@@ -145,9 +431,16 @@ func buildTagging(tags map[string]string) *string {
 	return &tagging
 }
 
+// PresignURL returns a presigned URL without the headers that it requires.
+// If the provider encrypts the uploads, PresignURLWithHeaders must be used to get the headers that the client has to send.
 func (s *S3Provider) PresignURL(ctx context.Context, key string, op PresignOperation) (string, error) {
+	presigned, _, err := s.PresignURLWithHeaders(ctx, key, op, PresignOptions{})
+	return presigned, err
+}
+
+func (s *S3Provider) PresignURLWithHeaders(ctx context.Context, key string, op PresignOperation, opts PresignOptions) (string, http.Header, error) {
 	if s.presignClient == nil {
-		return "", ErrNoPresign
+		return "", nil, ErrNoPresign
 	}
 
 	var req *v4.PresignedHTTPRequest
@@ -159,19 +452,21 @@ func (s *S3Provider) PresignURL(ctx context.Context, key string, op PresignOpera
 			Key:    &key,
 		})
 	} else if op == PresignOperationUpload {
-		req, err = s.presignClient.PresignPutObject(ctx, &s3.PutObjectInput{
-			Bucket: &s.bucketName,
-			Key:    &key,
-		})
+		// The encryption and storage class are signed headers, S3 rejects the upload if the client does not send them
+		req, err = s.presignClient.PresignPutObject(ctx, s.putObjectInput(key, opts.StorageClass))
 	} else {
-		return "", fmt.Errorf("unsupported presign operation: %s", op)
+		return "", nil, fmt.Errorf("unsupported presign operation: %s", op)
 	}
 
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	return req.URL, nil
+	// The host is sent by every client anyway
+	headers := req.SignedHeader.Clone()
+	headers.Del("Host")
+
+	return req.URL, headers, nil
 }
 
 func (s *S3Provider) GetTags(ctx context.Context, key string) (map[string]string, error) {
@@ -180,15 +475,7 @@ func (s *S3Provider) GetTags(ctx context.Context, key string) (map[string]string
 		Key:    &key,
 	})
 	if err != nil {
-		if strings.Contains(err.Error(), "NoSuchKey") {
-			return nil, ErrNotFound
-		}
-
-		if strings.Contains(err.Error(), "AccessDenied") {
-			return nil, ErrDenied
-		}
-
-		return nil, err
+		return nil, mapS3Error(err)
 	}
 
 	tags := make(map[string]string, len(output.TagSet))
@@ -199,33 +486,23 @@ func (s *S3Provider) GetTags(ctx context.Context, key string) (map[string]string
 	return tags, nil
 }
 
-// Does currently not support pagination but the default max is 1000 so it is fine for now.
-// Note for future developers: output.IsTruncated is a boolean that indicates if there are more objects to retrieve and output.NextContinuationToken is the token to use for the next request.
 func (s *S3Provider) ListObjects(ctx context.Context, prefix string) (ListObjectsResponse, error) {
-	output, err := s.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+	files := []string{}
+
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
 		Bucket: &s.bucketName,
 		Prefix: &prefix,
 	})
-	if err != nil {
-		return ListObjectsResponse{}, err
-	}
-
-	files := make([]string, len(output.Contents))
-	for i, obj := range output.Contents {
-		files[i] = *obj.Key
 
-		// objects[i] = ObjectInfo{
-		// 	Key:      *obj.Key,
-		// 	Metadata: map[string]interface{}{},
-		// }
-
-		// if obj.Size != nil {
-		// 	objects[i].Metadata["size"] = *obj.Size
-		// }
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return ListObjectsResponse{}, mapS3Error(err)
+		}
 
-		// if obj.LastModified != nil {
-		// 	objects[i].Metadata["last_modified"] = *obj.LastModified
-		// }
+		for _, obj := range output.Contents {
+			files = append(files, *obj.Key)
+		}
 	}
 
 	return ListObjectsResponse{
@@ -233,15 +510,368 @@ func (s *S3Provider) ListObjects(ctx context.Context, prefix string) (ListObject
 	}, nil
 }
 
-func (s *S3Provider) DeleteObject(ctx context.Context, key string) error {
+func (s *S3Provider) ListObjectsDelimited(ctx context.Context, prefix, delimiter string) (ListObjectsResponse, error) {
+	resp := ListObjectsResponse{Keys: []string{}}
+
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket:    &s.bucketName,
+		Prefix:    &prefix,
+		Delimiter: &delimiter,
+	})
+
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return ListObjectsResponse{}, mapS3Error(err)
+		}
+
+		for _, obj := range output.Contents {
+			resp.Keys = append(resp.Keys, *obj.Key)
+		}
+
+		for _, p := range output.CommonPrefixes {
+			resp.CommonPrefixes = append(resp.CommonPrefixes, *p.Prefix)
+		}
+	}
+
+	return resp, nil
+}
+
+func (s *S3Provider) DeleteObject(ctx context.Context, key string, opts DeleteOptions) error {
 	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket:    &s.bucketName,
+		Key:       &key,
+		VersionId: optionalString(opts.VersionID),
+	})
+	if err != nil {
+		// S3 seems to return a 200 OK even if the object does not exist so no need to check for that.
+		return mapS3Error(err)
+	}
+
+	return nil
+}
+
+func (s *S3Provider) SetTags(ctx context.Context, key string, tags map[string]string) error {
+	tagSet := make([]types.Tag, 0, len(tags))
+	for k, v := range tags {
+		tagSet = append(tagSet, types.Tag{Key: &k, Value: &v})
+	}
+
+	_, err := s.client.PutObjectTagging(ctx, &s3.PutObjectTaggingInput{
 		Bucket: &s.bucketName,
 		Key:    &key,
+		Tagging: &types.Tagging{
+			TagSet: tagSet,
+		},
 	})
 	if err != nil {
-		// S3 seems to return a 200 OK even if the object does not exist so no need to check for that.
+		return mapS3Error(err)
+	}
+
+	return nil
+}
+
+// ListVersions lists all versions and delete markers of a single key.
+// S3 can only filter versions on a prefix, so versions of other keys sharing the prefix are skipped.
+func (s *S3Provider) ListVersions(ctx context.Context, key string) (ListVersionsResponse, error) {
+	var versions []ObjectVersion
+
+	input := &s3.ListObjectVersionsInput{
+		Bucket: &s.bucketName,
+		Prefix: &key,
+	}
+
+	for {
+		output, err := s.client.ListObjectVersions(ctx, input)
+		if err != nil {
+			return ListVersionsResponse{}, mapS3Error(err)
+		}
+
+		for _, v := range output.Versions {
+			if v.Key == nil || *v.Key != key || v.VersionId == nil {
+				continue
+			}
+
+			versions = append(versions, ObjectVersion{
+				VersionID:     *v.VersionId,
+				IsLatest:      v.IsLatest != nil && *v.IsLatest,
+				LastModified:  v.LastModified,
+				ContentLength: v.Size,
+			})
+		}
+
+		for _, m := range output.DeleteMarkers {
+			if m.Key == nil || *m.Key != key || m.VersionId == nil {
+				continue
+			}
+
+			versions = append(versions, ObjectVersion{
+				VersionID:    *m.VersionId,
+				IsLatest:     m.IsLatest != nil && *m.IsLatest,
+				DeleteMarker: true,
+				LastModified: m.LastModified,
+			})
+		}
+
+		if output.IsTruncated == nil || !*output.IsTruncated {
+			break
+		}
+
+		input.KeyMarker = output.NextKeyMarker
+		input.VersionIdMarker = output.NextVersionIdMarker
+	}
+
+	if len(versions) == 0 {
+		return ListVersionsResponse{}, ErrNotFound
+	}
+
+	// Versions and delete markers are returned in separate lists by S3, merge them so the newest is first
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].LastModified == nil || versions[j].LastModified == nil {
+			return versions[i].LastModified != nil
+		}
+
+		return versions[i].LastModified.After(*versions[j].LastModified)
+	})
+
+	return ListVersionsResponse{
+		Versions: versions,
+	}, nil
+}
+
+// RestoreVersion copies the given version of the object over the current one.
+// The old version is kept, so the restore itself can be undone by restoring the version that was current before.
+func (s *S3Provider) RestoreVersion(ctx context.Context, key string, versionID string) error {
+	if versionID == "" {
+		return fmt.Errorf("version id is required")
+	}
+
+	// The copy source must be URL-encoded, but the slashes in the key should be kept as they are
+	escapedKey := (&url.URL{Path: key}).EscapedPath()
+	copySource := fmt.Sprintf("%s/%s?versionId=%s", s.bucketName, escapedKey, url.QueryEscape(versionID))
+
+	// The copy is a new object, so it must be encrypted and stored the same way as an upload
+	input := &s3.CopyObjectInput{
+		Bucket:               &s.bucketName,
+		Key:                  &key,
+		CopySource:           &copySource,
+		ServerSideEncryption: s.encryption.mode,
+		SSEKMSKeyId:          optionalString(s.encryption.kmsKeyID),
+		StorageClass:         s.storageClass,
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.encryption.customer()
+	input.CopySourceSSECustomerAlgorithm, input.CopySourceSSECustomerKey, input.CopySourceSSECustomerKeyMD5 = s.encryption.customer()
+
+	_, err := s.client.CopyObject(ctx, input)
+	if err != nil {
+		return mapS3Error(err)
+	}
+
+	return nil
+}
+
+// DeleteObjects deletes the objects in batches of up to 1000 keys, which is the most S3 allows in one request
+func (s *S3Provider) DeleteObjects(ctx context.Context, keys []string) (map[string]error, error) {
+	failed := make(map[string]error)
+
+	for start := 0; start < len(keys); start += s3MaxDeleteObjects {
+		end := min(start+s3MaxDeleteObjects, len(keys))
+
+		objects := make([]types.ObjectIdentifier, 0, end-start)
+		for i := start; i < end; i++ {
+			objects = append(objects, types.ObjectIdentifier{Key: &keys[i]})
+		}
+
+		quiet := true
+		output, err := s.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: &s.bucketName,
+			Delete: &types.Delete{
+				Objects: objects,
+				// Only the failed deletes are returned in quiet mode
+				Quiet: &quiet,
+			},
+		})
+		if err != nil {
+			return failed, mapS3Error(err)
+		}
+
+		for _, e := range output.Errors {
+			if e.Key == nil {
+				continue
+			}
+
+			if mapped := s3ErrorFromCode(derefString(e.Code)); mapped != nil {
+				failed[*e.Key] = mapped
+				continue
+			}
+
+			failed[*e.Key] = fmt.Errorf("%s: %s", derefString(e.Code), derefString(e.Message))
+		}
+	}
+
+	return failed, nil
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+
+	return *s
+}
+
+// isArchiveStorageClass reports if objects in the storage class must be restored before they can be read
+func isArchiveStorageClass(storageClass types.StorageClass) bool {
+	return storageClass == types.StorageClassGlacier || storageClass == types.StorageClassDeepArchive
+}
+
+func (s *S3Provider) ArchiveStatus(ctx context.Context, key string) (ArchiveStatus, error) {
+	input := &s3.HeadObjectInput{
+		Bucket: &s.bucketName,
+		Key:    &key,
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.encryption.customer()
+
+	headResp, err := s.client.HeadObject(ctx, input)
+	if err != nil {
+		return ArchiveStatus{}, mapS3Error(err)
+	}
+
+	status := ArchiveStatus{
+		StorageClass: string(headResp.StorageClass),
+		// Objects in the archive tiers of Intelligent-Tiering must be restored as well
+		Archived: isArchiveStorageClass(headResp.StorageClass) || headResp.ArchiveStatus != "",
+	}
+
+	// S3 leaves out the storage class of objects in the default class
+	if status.StorageClass == "" {
+		status.StorageClass = string(types.StorageClassStandard)
+	}
+
+	if headResp.Restore != nil {
+		status.RestoreInProgress, status.RestoredUntil = parseS3Restore(*headResp.Restore)
+	}
+
+	return status, nil
+}
+
+// parseS3Restore parses the x-amz-restore header, eg. `ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`
+func parseS3Restore(header string) (bool, *time.Time) {
+	ongoing := strings.Contains(header, `ongoing-request="true"`)
+
+	_, expiry, found := strings.Cut(header, `expiry-date="`)
+	if !found {
+		return ongoing, nil
+	}
+	expiry, _, _ = strings.Cut(expiry, `"`)
+
+	t, err := http.ParseTime(expiry)
+	if err != nil {
+		return ongoing, nil
+	}
+
+	return ongoing, &t
+}
+
+func (s *S3Provider) RestoreArchived(ctx context.Context, key string, opts RestoreOptions) error {
+	status, err := s.ArchiveStatus(ctx, key)
+	if err != nil {
 		return err
 	}
 
+	if !status.Archived {
+		return ErrNotArchived
+	}
+
+	restoreRequest := &types.RestoreRequest{
+		GlacierJobParameters: &types.GlacierJobParameters{Tier: types.Tier(opts.Tier)},
+	}
+
+	// Intelligent-Tiering moves the object back to a frequent access tier instead of making a temporary copy, so the number of days must be left out
+	if types.StorageClass(status.StorageClass) != types.StorageClassIntelligentTiering {
+		restoreRequest.Days = &opts.Days
+	}
+
+	_, err = s.client.RestoreObject(ctx, &s3.RestoreObjectInput{
+		Bucket:         &s.bucketName,
+		Key:            &key,
+		RestoreRequest: restoreRequest,
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "RestoreAlreadyInProgress" {
+			return nil
+		}
+
+		return mapS3Error(err)
+	}
+
+	return nil
+}
+
+// mapS3Error translates the errors returned by the S3 client into the errors of the provider package.
+// Errors that can not be mapped are returned as they are.
+func mapS3Error(err error) error {
+	var header http.Header
+	var statusCode int
+
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) && respErr.Response != nil {
+		header = respErr.Response.Header
+		statusCode = respErr.HTTPStatusCode()
+	}
+
+	// The storage class is included so that the client knows how long a restore may take
+	var stateErr *types.InvalidObjectState
+	if errors.As(err, &stateErr) && stateErr.StorageClass != "" {
+		return fmt.Errorf("%w: storage class %s", ErrArchived, stateErr.StorageClass)
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		if mapped := s3ErrorFromCode(apiErr.ErrorCode()); mapped != nil {
+			if errors.Is(mapped, ErrThrottled) || errors.Is(mapped, ErrUnavailable) {
+				return withRetryAfter(mapped, header)
+			}
+			return mapped
+		}
+	}
+
+	// Responses without a body, eg. to HeadObject, have no error code so the status is all there is to go on
+	if statusCode != 0 {
+		if mapped := errorFromStatusCode(statusCode, header); mapped != nil {
+			return mapped
+		}
+	}
+
+	return err
+}
+
+// s3ErrorFromCode returns the error of the provider package that corresponds to an S3 error code, or nil if there is none
+func s3ErrorFromCode(code string) error {
+	switch code {
+	case "NoSuchKey", "NotFound", "NoSuchVersion", "NoSuchUpload":
+		return ErrNotFound
+	case "AccessDenied", "Forbidden", "AllAccessDisabled":
+		return ErrDenied
+	case "NotModified":
+		return ErrNotModified
+	case "PreconditionFailed":
+		return ErrPreconditionFailed
+	case "InvalidRange":
+		return ErrRangeNotSatisfiable
+	case "EntityTooLarge":
+		return ErrTooLarge
+	case "InvalidObjectState":
+		return ErrArchived
+	case "SlowDown", "Throttling", "ThrottlingException", "RequestThrottled", "RequestLimitExceeded", "TooManyRequests", "TooManyRequestsException":
+		return ErrThrottled
+	case "ServiceUnavailable", "InternalError":
+		return ErrUnavailable
+	case "NoSuchBucket", "PermanentRedirect", "InvalidBucketName", "InvalidAccessKeyId", "SignatureDoesNotMatch", "AuthorizationHeaderMalformed", "InvalidToken", "ExpiredToken":
+		// The code is kept since it tells the operator what is wrong, the details may contain the bucket name so they are left out
+		return fmt.Errorf("%w: %s", ErrMisconfigured, code)
+	}
+
 	return nil
 }
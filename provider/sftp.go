@@ -0,0 +1,561 @@
+package provider
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const defaultSFTPTimeout = 30 * time.Second
+
+var _ Stater = &SFTPProvider{}
+var _ TagSetter = &SFTPProvider{}
+var _ io.Closer = &SFTPProvider{}
+var _ ConditionalGetter = &SFTPProvider{}
+
+type SFTPConfig struct {
+	ConfigBase
+	// Host is the address of the SFTP server, the port defaults to 22
+	Host string `required:"true"`
+	User string `required:"true"`
+	// Password is used to authenticate if it is set
+	Password string
+	// PrivateKeyFile is the path of a PEM encoded private key that is used to authenticate if it is set
+	PrivateKeyFile string `json:"private-key-file"`
+	// PrivateKeyPassphrase decrypts the private key if it is encrypted
+	PrivateKeyPassphrase string `json:"private-key-passphrase"`
+	// HostKey is the public key of the server in the authorized_keys format, eg. "ssh-ed25519 AAAA...".
+	// The connection is refused if the server presents any other key.
+	HostKey string `json:"host-key" required:"true"`
+	// Root is the directory on the server that the objects are stored in
+	// Default is the directory that the user logs in to
+	Root string
+	// Timeout is how long to wait for the connection to the server to be established
+	// Default is 30 seconds
+	Timeout Duration
+}
+
+func NewSFTPProvider(cfg *SFTPConfig) (*SFTPProvider, error) {
+	if cfg.Host == "" {
+		return nil, fmt.Errorf("host is required")
+	}
+
+	if cfg.User == "" {
+		return nil, fmt.Errorf("user is required")
+	}
+
+	if cfg.HostKey == "" {
+		return nil, fmt.Errorf("host-key is required")
+	}
+
+	hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(cfg.HostKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid host-key: %w", err)
+	}
+
+	var auth []ssh.AuthMethod
+
+	if cfg.PrivateKeyFile != "" {
+		signer, err := loadSFTPPrivateKey(cfg.PrivateKeyFile, cfg.PrivateKeyPassphrase)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+
+	if cfg.Password != "" {
+		auth = append(auth, ssh.Password(cfg.Password))
+	}
+
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("password or private-key-file is required")
+	}
+
+	timeout := time.Duration(cfg.Timeout)
+	if timeout <= 0 {
+		timeout = defaultSFTPTimeout
+	}
+
+	addr := cfg.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+
+	return &SFTPProvider{
+		id:         cfg.ID,
+		authPlugin: cfg.AuthPlugin,
+		policy:     cfg.Policy,
+		addr:       addr,
+		configRoot: path.Clean(cfg.Root),
+		sshConfig: &ssh.ClientConfig{
+			User:            cfg.User,
+			Auth:            auth,
+			HostKeyCallback: ssh.FixedHostKey(hostKey),
+			Timeout:         timeout,
+		},
+	}, nil
+}
+
+func loadSFTPPrivateKey(file, passphrase string) (ssh.Signer, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read private-key-file: %w", err)
+	}
+
+	var signer ssh.Signer
+	if passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(b, []byte(passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(b)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+
+	return signer, nil
+}
+
+// SFTPProvider stores each object as a file under the root directory on an SFTP server.
+// Like the filesystem provider, the content type, tags and metadata are stored in a sidecar JSON file in the reserved directory.
+type SFTPProvider struct {
+	id         string
+	authPlugin string
+	policy     Policy
+
+	addr       string
+	configRoot string
+	sshConfig  *ssh.ClientConfig
+
+	mx sync.Mutex
+	// root is the absolute path of the root directory, it is resolved when the first connection is established
+	root string
+	// conn is nil until the first operation, and after the connection is lost
+	conn *sftp.Client
+	// closed is set by Close to stop new connections from being established
+	closed bool
+}
+
+func (s *SFTPProvider) Id() string {
+	return s.id
+}
+
+func (s *SFTPProvider) AuthPlugin() string {
+	return s.authPlugin
+}
+
+func (s *SFTPProvider) Policy() Policy {
+	return s.policy
+}
+
+// client returns the connection to the server, it is established if there is none
+func (s *SFTPProvider) client() (*sftp.Client, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.closed {
+		return nil, errors.New("sftp provider is closed")
+	}
+
+	if s.conn != nil {
+		return s.conn, nil
+	}
+
+	sshClient, err := ssh.Dial("tcp", s.addr, s.sshConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to sftp server: %w", err)
+	}
+
+	c, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, fmt.Errorf("unable to start sftp session: %w", err)
+	}
+
+	if s.root == "" {
+		root, err := c.RealPath(s.configRoot)
+		if err != nil {
+			c.Close()
+			sshClient.Close()
+			return nil, fmt.Errorf("unable to resolve root: %w", mapFilesystemError(err))
+		}
+		s.root = root
+	}
+
+	for _, dir := range []string{path.Join(s.root, filesystemReservedDir, "meta"), path.Join(s.root, filesystemReservedDir, "tmp")} {
+		if err := c.MkdirAll(dir); err != nil {
+			c.Close()
+			sshClient.Close()
+			return nil, fmt.Errorf("could not create directory: %w", err)
+		}
+	}
+
+	s.conn = c
+
+	// The next operation reconnects if the connection is lost
+	go func() {
+		c.Wait()
+		sshClient.Close()
+
+		s.mx.Lock()
+		defer s.mx.Unlock()
+		if s.conn == c {
+			s.conn = nil
+		}
+	}()
+
+	return c, nil
+}
+
+// Close closes the connection to the server
+func (s *SFTPProvider) Close() error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.closed = true
+
+	if s.conn == nil {
+		return nil
+	}
+
+	// The goroutine started by client closes the ssh connection once the session has ended
+	err := s.conn.Close()
+	s.conn = nil
+
+	return err
+}
+
+// objectPath returns the path on the server of the file that the object is stored in.
+// The root is only known once client has been called.
+func (s *SFTPProvider) objectPath(key string) (string, error) {
+	if !isCleanKey(key) {
+		return "", ErrDenied
+	}
+
+	if isReservedKey(key) {
+		return "", ErrDenied
+	}
+
+	return path.Join(s.root, key), nil
+}
+
+func (s *SFTPProvider) sidecarPath(key string) string {
+	return path.Join(s.root, filesystemReservedDir, "meta", sidecarName(key))
+}
+
+func (s *SFTPProvider) readSidecar(c *sftp.Client, key string) (filesystemSidecar, error) {
+	var sidecar filesystemSidecar
+
+	file, err := c.Open(s.sidecarPath(key))
+	if err != nil {
+		// Files delivered by other SFTP users have no sidecar
+		if errors.Is(err, fs.ErrNotExist) {
+			return sidecar, nil
+		}
+		return sidecar, mapFilesystemError(err)
+	}
+	defer file.Close()
+
+	if err := json.NewDecoder(file).Decode(&sidecar); err != nil {
+		return sidecar, fmt.Errorf("invalid sidecar of %s: %w", key, err)
+	}
+
+	return sidecar, nil
+}
+
+// writeAtomic uploads the data to a temporary file and renames it to the destination once it is complete,
+// so that readers never see a partially written file
+func (s *SFTPProvider) writeAtomic(c *sftp.Client, dst string, data io.Reader) error {
+	if err := c.MkdirAll(path.Dir(dst)); err != nil {
+		return mapFilesystemError(err)
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	tmp := path.Join(s.root, filesystemReservedDir, "tmp", "upload-"+hex.EncodeToString(suffix))
+
+	file, err := c.Create(tmp)
+	if err != nil {
+		return mapFilesystemError(err)
+	}
+	defer c.Remove(tmp)
+
+	if _, err := file.ReadFrom(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	// A plain SFTP rename fails if the destination exists, the extension replaces it atomically
+	if _, ok := c.HasExtension("posix-rename@openssh.com"); ok {
+		return mapFilesystemError(c.PosixRename(tmp, dst))
+	}
+
+	if err := c.Remove(dst); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return mapFilesystemError(err)
+	}
+
+	return mapFilesystemError(c.Rename(tmp, dst))
+}
+
+func (s *SFTPProvider) writeSidecar(c *sftp.Client, key string, sidecar filesystemSidecar) error {
+	b, err := json.Marshal(sidecar)
+	if err != nil {
+		return err
+	}
+
+	return s.writeAtomic(c, s.sidecarPath(key), strings.NewReader(string(b)))
+}
+
+// stat returns the info of the file of an object together with its sidecar
+func (s *SFTPProvider) stat(c *sftp.Client, key string) (string, fs.FileInfo, filesystemSidecar, error) {
+	p, err := s.objectPath(key)
+	if err != nil {
+		return "", nil, filesystemSidecar{}, err
+	}
+
+	fi, err := c.Stat(p)
+	if err != nil {
+		return "", nil, filesystemSidecar{}, mapFilesystemError(err)
+	}
+
+	if !fi.Mode().IsRegular() {
+		return "", nil, filesystemSidecar{}, ErrNotFound
+	}
+
+	sidecar, err := s.readSidecar(c, key)
+	if err != nil {
+		return "", nil, filesystemSidecar{}, err
+	}
+
+	return p, fi, sidecar, nil
+}
+
+func (s *SFTPProvider) ConditionalGet() {}
+
+func (s *SFTPProvider) GetObject(ctx context.Context, key string, opts GetOptions) (io.ReadCloser, ObjectInfo, error) {
+	if opts.VersionID != "" {
+		return nil, ObjectInfo{}, ErrNoVersioning
+	}
+
+	c, err := s.client()
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	p, fi, sidecar, err := s.stat(c, key)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	// SFTP only has second precision for the modification time, so the entity tag only changes within a second if the size does
+	info := objectInfoFromFile(fi, sidecar)
+
+	if err := checkConditions(opts, info); err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	start, length, partial, err := ParseByteRange(opts.Range, fi.Size())
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	file, err := c.Open(p)
+	if err != nil {
+		return nil, ObjectInfo{}, mapFilesystemError(err)
+	}
+
+	if !partial {
+		return file, info, nil
+	}
+
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		file.Close()
+		return nil, ObjectInfo{}, err
+	}
+
+	info.ContentLength = &length
+	info.ContentRange = ContentRange(start, length, fi.Size())
+
+	return readCloser{Reader: io.LimitReader(file, length), Closer: file}, info, nil
+}
+
+func (s *SFTPProvider) StatObject(ctx context.Context, key string) (ObjectInfo, error) {
+	c, err := s.client()
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	_, fi, sidecar, err := s.stat(c, key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	return objectInfoFromFile(fi, sidecar), nil
+}
+
+func (s *SFTPProvider) PutObject(ctx context.Context, key string, data io.Reader, opts PutOptions) error {
+	c, err := s.client()
+	if err != nil {
+		return err
+	}
+
+	p, err := s.objectPath(key)
+	if err != nil {
+		return err
+	}
+
+	// The sidecar is written first so that the object never becomes visible without it
+	if err := s.writeSidecar(c, key, filesystemSidecar{
+		ContentType: opts.ContentType,
+		Tags:        opts.Tags,
+		Metadata:    opts.Metadata,
+	}); err != nil {
+		return fmt.Errorf("unable to write sidecar: %w", err)
+	}
+
+	return s.writeAtomic(c, p, data)
+}
+
+func (s *SFTPProvider) GetTags(ctx context.Context, key string) (map[string]string, error) {
+	c, err := s.client()
+	if err != nil {
+		return nil, err
+	}
+
+	_, _, sidecar, err := s.stat(c, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return sidecar.Tags, nil
+}
+
+func (s *SFTPProvider) SetTags(ctx context.Context, key string, tags map[string]string) error {
+	c, err := s.client()
+	if err != nil {
+		return err
+	}
+
+	_, _, sidecar, err := s.stat(c, key)
+	if err != nil {
+		return err
+	}
+
+	sidecar.Tags = tags
+	return s.writeSidecar(c, key, sidecar)
+}
+
+// listDir returns the directory to start listing from for a prefix, which is the directory part of the prefix.
+// The directory is checked the same way as the keys, so that no prefix can list anything outside of the root.
+func (s *SFTPProvider) listDir(prefix string) (string, error) {
+	dir := prefix[:max(strings.LastIndex(prefix, "/"), 0)]
+	if dir == "" {
+		return s.root, nil
+	}
+
+	return s.objectPath(dir)
+}
+
+func (s *SFTPProvider) ListObjects(ctx context.Context, prefix string) (ListObjectsResponse, error) {
+	dir, err := s.listDir(prefix)
+	if err != nil {
+		return ListObjectsResponse{}, err
+	}
+
+	c, err := s.client()
+	if err != nil {
+		return ListObjectsResponse{}, err
+	}
+
+	keys := []string{}
+
+	walker := c.Walk(dir)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			// The prefix does not have to match an existing directory
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return ListObjectsResponse{}, mapFilesystemError(err)
+		}
+
+		key, ok := strings.CutPrefix(walker.Path(), strings.TrimSuffix(s.root, "/")+"/")
+		if !ok {
+			continue
+		}
+
+		if walker.Stat().IsDir() {
+			if key == filesystemReservedDir {
+				walker.SkipDir()
+			}
+			continue
+		}
+
+		if walker.Stat().Mode().IsRegular() && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return ListObjectsResponse{Keys: keys}, nil
+}
+
+func (s *SFTPProvider) DeleteObject(ctx context.Context, key string, opts DeleteOptions) error {
+	if opts.VersionID != "" {
+		return ErrNoVersioning
+	}
+
+	c, err := s.client()
+	if err != nil {
+		return err
+	}
+
+	p, _, _, err := s.stat(c, key)
+	if err != nil {
+		return err
+	}
+
+	if err := c.Remove(p); err != nil {
+		return mapFilesystemError(err)
+	}
+
+	if err := c.Remove(s.sidecarPath(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("unable to delete sidecar: %w", err)
+	}
+
+	// Directories only exist to hold objects, so the ones that became empty are removed
+	s.removeEmptyParents(c, s.root, p)
+	s.removeEmptyParents(c, path.Join(s.root, filesystemReservedDir, "meta"), s.sidecarPath(key))
+
+	return nil
+}
+
+// removeEmptyParents removes the parent directories of the path until a directory that is not empty or the root is reached
+func (s *SFTPProvider) removeEmptyParents(c *sftp.Client, root, p string) {
+	for dir := path.Dir(p); dir != root && strings.HasPrefix(dir, root); dir = path.Dir(dir) {
+		if err := c.RemoveDirectory(dir); err != nil {
+			return
+		}
+	}
+}
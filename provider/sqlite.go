@@ -0,0 +1,443 @@
+package provider
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	// The pure Go driver keeps the binary free of cgo
+	_ "modernc.org/sqlite"
+)
+
+const defaultSQLiteChunkSize = 1 << 20
+
+var _ Stater = &SQLiteProvider{}
+var _ TagSetter = &SQLiteProvider{}
+var _ io.Closer = &SQLiteProvider{}
+var _ ConditionalGetter = &SQLiteProvider{}
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS objects (
+	key          TEXT PRIMARY KEY,
+	content_type TEXT NOT NULL DEFAULT '',
+	size         INTEGER NOT NULL,
+	chunk_size   INTEGER NOT NULL,
+	mod_time     INTEGER NOT NULL,
+	tags         TEXT NOT NULL DEFAULT '{}',
+	metadata     TEXT NOT NULL DEFAULT '{}'
+);
+
+CREATE TABLE IF NOT EXISTS chunks (
+	key  TEXT NOT NULL,
+	seq  INTEGER NOT NULL,
+	data BLOB NOT NULL,
+	PRIMARY KEY (key, seq)
+);
+`
+
+type SQLiteConfig struct {
+	ConfigBase
+	// Path is the database file, it is created if it does not exist
+	Path string `required:"true"`
+	// ChunkSize is the size in bytes of the parts that the data of an object is split into
+	// Default is 1 MiB
+	ChunkSize int64 `json:"chunk-size"`
+}
+
+func NewSQLiteProvider(cfg *SQLiteConfig) (*SQLiteProvider, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("path is required")
+	}
+
+	chunkSize := cfg.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultSQLiteChunkSize
+	}
+
+	query := url.Values{}
+	// WAL lets downloads read a consistent snapshot while an upload is written
+	query.Add("_pragma", "journal_mode(WAL)")
+	query.Add("_pragma", "busy_timeout(5000)")
+
+	db, err := sql.Open("sqlite", "file:"+cfg.Path+"?"+query.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("unable to open database: %w", err)
+	}
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to create tables: %w", err)
+	}
+
+	return &SQLiteProvider{
+		id:         cfg.ID,
+		authPlugin: cfg.AuthPlugin,
+		policy:     cfg.Policy,
+		db:         db,
+		chunkSize:  chunkSize,
+	}, nil
+}
+
+// SQLiteProvider stores the objects in a single SQLite database file.
+// The data is split into chunks so that large objects never have to be held in memory.
+type SQLiteProvider struct {
+	id         string
+	authPlugin string
+	policy     Policy
+
+	db        *sql.DB
+	chunkSize int64
+}
+
+// sqliteObject is a row of the objects table
+type sqliteObject struct {
+	contentType string
+	size        int64
+	chunkSize   int64
+	modTime     time.Time
+	tags        map[string]string
+	metadata    map[string]string
+}
+
+func (s *SQLiteProvider) Id() string {
+	return s.id
+}
+
+func (s *SQLiteProvider) AuthPlugin() string {
+	return s.authPlugin
+}
+
+func (s *SQLiteProvider) Policy() Policy {
+	return s.policy
+}
+
+func (s *SQLiteProvider) Close() error {
+	return s.db.Close()
+}
+
+// queryer is implemented by both the database and its transactions
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func (s *SQLiteProvider) getObject(ctx context.Context, q queryer, key string) (sqliteObject, error) {
+	var (
+		obj            sqliteObject
+		modTime        int64
+		tags, metadata string
+	)
+
+	err := q.QueryRowContext(ctx, `SELECT content_type, size, chunk_size, mod_time, tags, metadata FROM objects WHERE key = ?`, key).
+		Scan(&obj.contentType, &obj.size, &obj.chunkSize, &modTime, &tags, &metadata)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return sqliteObject{}, ErrNotFound
+		}
+		return sqliteObject{}, err
+	}
+
+	obj.modTime = time.Unix(0, modTime)
+
+	if err := json.Unmarshal([]byte(tags), &obj.tags); err != nil {
+		return sqliteObject{}, fmt.Errorf("invalid tags of %s: %w", key, err)
+	}
+
+	if err := json.Unmarshal([]byte(metadata), &obj.metadata); err != nil {
+		return sqliteObject{}, fmt.Errorf("invalid metadata of %s: %w", key, err)
+	}
+
+	return obj, nil
+}
+
+func (o sqliteObject) info() ObjectInfo {
+	size := o.size
+	modTime := o.modTime
+
+	info := ObjectInfo{
+		LastModified:  &modTime,
+		ContentLength: &size,
+		ETag:          objectETag(size, modTime),
+	}
+
+	if len(o.metadata) > 0 {
+		info.Metadata = o.metadata
+	}
+
+	if o.contentType != "" {
+		contentType := o.contentType
+		info.ContentType = &contentType
+	}
+
+	return info
+}
+
+func (s *SQLiteProvider) ConditionalGet() {}
+
+func (s *SQLiteProvider) GetObject(ctx context.Context, key string, opts GetOptions) (io.ReadCloser, ObjectInfo, error) {
+	if opts.VersionID != "" {
+		return nil, ObjectInfo{}, ErrNoVersioning
+	}
+
+	// The transaction is kept open until the reader is closed so that an upload that replaces the object is not seen halfway through
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	obj, err := s.getObject(ctx, tx, key)
+	if err != nil {
+		tx.Rollback()
+		return nil, ObjectInfo{}, err
+	}
+
+	info := obj.info()
+
+	if err := checkConditions(opts, info); err != nil {
+		tx.Rollback()
+		return nil, ObjectInfo{}, err
+	}
+
+	start, length, partial, err := ParseByteRange(opts.Range, obj.size)
+	if err != nil {
+		tx.Rollback()
+		return nil, ObjectInfo{}, err
+	}
+
+	if !partial {
+		start, length = 0, obj.size
+	} else {
+		info.ContentLength = &length
+		info.ContentRange = ContentRange(start, length, obj.size)
+	}
+
+	return &sqliteReader{
+		ctx:       ctx,
+		tx:        tx,
+		key:       key,
+		chunkSize: obj.chunkSize,
+		offset:    start,
+		remaining: length,
+	}, info, nil
+}
+
+// sqliteReader reads the chunks of an object one at a time
+type sqliteReader struct {
+	ctx       context.Context
+	tx        *sql.Tx
+	key       string
+	chunkSize int64
+
+	// offset is the position in the object of the next byte to read
+	offset    int64
+	remaining int64
+	buf       []byte
+}
+
+func (r *sqliteReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+
+	if len(r.buf) == 0 {
+		var chunk []byte
+		err := r.tx.QueryRowContext(r.ctx, `SELECT data FROM chunks WHERE key = ? AND seq = ?`, r.key, r.offset/r.chunkSize).Scan(&chunk)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+
+		skip := r.offset % r.chunkSize
+		if skip >= int64(len(chunk)) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		r.buf = chunk[skip:]
+	}
+
+	n := copy(p, r.buf[:min(int64(len(r.buf)), r.remaining)])
+	r.buf = r.buf[n:]
+	r.offset += int64(n)
+	r.remaining -= int64(n)
+
+	return n, nil
+}
+
+func (r *sqliteReader) Close() error {
+	return r.tx.Rollback()
+}
+
+func (s *SQLiteProvider) StatObject(ctx context.Context, key string) (ObjectInfo, error) {
+	obj, err := s.getObject(ctx, s.db, key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	return obj.info(), nil
+}
+
+// PutObject writes the object in a single transaction, so it replaces the previous version completely or not at all
+func (s *SQLiteProvider) PutObject(ctx context.Context, key string, data io.Reader, opts PutOptions) error {
+	tags, err := json.Marshal(nonNilMap(opts.Tags))
+	if err != nil {
+		return err
+	}
+
+	metadata, err := json.Marshal(nonNilMap(opts.Metadata))
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM chunks WHERE key = ?`, key); err != nil {
+		return err
+	}
+
+	var size int64
+	buf := make([]byte, s.chunkSize)
+
+	for seq := 0; ; seq++ {
+		n, err := io.ReadFull(data, buf)
+		if n > 0 {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO chunks (key, seq, data) VALUES (?, ?, ?)`, key, seq, buf[:n]); err != nil {
+				return err
+			}
+			size += int64(n)
+		}
+
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO objects (key, content_type, size, chunk_size, mod_time, tags, metadata) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			content_type = excluded.content_type,
+			size = excluded.size,
+			chunk_size = excluded.chunk_size,
+			mod_time = excluded.mod_time,
+			tags = excluded.tags,
+			metadata = excluded.metadata`,
+		key, opts.ContentType, size, s.chunkSize, time.Now().UnixNano(), string(tags), string(metadata))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// nonNilMap returns an empty map instead of nil so that it is stored as an empty JSON object
+func nonNilMap(m map[string]string) map[string]string {
+	if m == nil {
+		return map[string]string{}
+	}
+	return m
+}
+
+func (s *SQLiteProvider) GetTags(ctx context.Context, key string) (map[string]string, error) {
+	obj, err := s.getObject(ctx, s.db, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return obj.tags, nil
+}
+
+func (s *SQLiteProvider) SetTags(ctx context.Context, key string, tags map[string]string) error {
+	b, err := json.Marshal(nonNilMap(tags))
+	if err != nil {
+		return err
+	}
+
+	res, err := s.db.ExecContext(ctx, `UPDATE objects SET tags = ? WHERE key = ?`, string(b), key)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *SQLiteProvider) ListObjects(ctx context.Context, prefix string) (ListObjectsResponse, error) {
+	// The keys are read in order from the first one with the prefix, so that the primary key index is used
+	rows, err := s.db.QueryContext(ctx, `SELECT key FROM objects WHERE key >= ? ORDER BY key`, prefix)
+	if err != nil {
+		return ListObjectsResponse{}, err
+	}
+	defer rows.Close()
+
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return ListObjectsResponse{}, err
+		}
+
+		if !strings.HasPrefix(key, prefix) {
+			break
+		}
+
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return ListObjectsResponse{}, err
+	}
+
+	return ListObjectsResponse{Keys: keys}, nil
+}
+
+func (s *SQLiteProvider) DeleteObject(ctx context.Context, key string, opts DeleteOptions) error {
+	if opts.VersionID != "" {
+		return ErrNoVersioning
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM objects WHERE key = ?`, key)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM chunks WHERE key = ?`, key); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
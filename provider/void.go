@@ -14,12 +14,14 @@ func NewVoidProvider(cfg *VoidConfig) *VoidProvider {
 	return &VoidProvider{
 		id:         cfg.ID,
 		authPlugin: cfg.AuthPlugin,
+		policy:     cfg.Policy,
 	}
 }
 
 type VoidProvider struct {
 	id         string
 	authPlugin string
+	policy     Policy
 }
 
 func (n *VoidProvider) Id() string {
@@ -30,6 +32,10 @@ func (n *VoidProvider) AuthPlugin() string {
 	return n.authPlugin
 }
 
+func (n *VoidProvider) Policy() Policy {
+	return n.policy
+}
+
 func (n *VoidProvider) GetObject(ctx context.Context, key string, opts GetOptions) (io.ReadCloser, ObjectInfo, error) {
 	return io.NopCloser(strings.NewReader("null\n")), ObjectInfo{}, nil
 }
@@ -46,6 +52,6 @@ func (n *VoidProvider) ListObjects(ctx context.Context, prefix string) (ListObje
 	return ListObjectsResponse{}, nil
 }
 
-func (n *VoidProvider) DeleteObject(ctx context.Context, key string) error {
+func (n *VoidProvider) DeleteObject(ctx context.Context, key string, opts DeleteOptions) error {
 	return nil
 }
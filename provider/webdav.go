@@ -0,0 +1,577 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// webdavNamespace is the XML namespace of the dead properties that the content type, tags and metadata of the objects are stored in
+const webdavNamespace = "https://github.com/theleeeo/file-butler/"
+
+var _ Stater = &WebDAVProvider{}
+var _ TagSetter = &WebDAVProvider{}
+var _ io.Closer = &WebDAVProvider{}
+
+type WebDAVConfig struct {
+	ConfigBase
+	// URL is the collection on the WebDAV server that the objects are stored in
+	URL string `required:"true"`
+	// Username and Password are sent as basic auth credentials if the username is set
+	Username string
+	Password string
+	// Timeout is how long to wait for the server to start responding
+	// Default is 30 seconds
+	Timeout Duration
+}
+
+func NewWebDAVProvider(cfg *WebDAVConfig) (*WebDAVProvider, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("url is required")
+	}
+
+	baseURL, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	}
+
+	if baseURL.Scheme != "http" && baseURL.Scheme != "https" {
+		return nil, fmt.Errorf("url must be an http or https URL")
+	}
+
+	timeout := time.Duration(cfg.Timeout)
+	if timeout <= 0 {
+		timeout = defaultHTTPTimeout
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = timeout
+
+	return &WebDAVProvider{
+		id:         cfg.ID,
+		authPlugin: cfg.AuthPlugin,
+		policy:     cfg.Policy,
+		baseURL:    strings.TrimSuffix(baseURL.String(), "/"),
+		basePath:   strings.TrimSuffix(baseURL.Path, "/"),
+		username:   cfg.Username,
+		password:   cfg.Password,
+		client:     &http.Client{Transport: transport},
+	}, nil
+}
+
+// WebDAVProvider stores each object as a resource in a collection on a WebDAV server.
+// The content type, tags and metadata are stored as dead properties of the resource.
+type WebDAVProvider struct {
+	id         string
+	authPlugin string
+	policy     Policy
+
+	baseURL string
+	// basePath is the path of the base URL, it is removed from the hrefs of the listed resources to get their keys
+	basePath string
+	username string
+	password string
+	client   *http.Client
+}
+
+func (w *WebDAVProvider) Id() string {
+	return w.id
+}
+
+func (w *WebDAVProvider) AuthPlugin() string {
+	return w.authPlugin
+}
+
+func (w *WebDAVProvider) Policy() Policy {
+	return w.policy
+}
+
+// Close closes the idle connections to the server
+func (w *WebDAVProvider) Close() error {
+	w.client.CloseIdleConnections()
+	return nil
+}
+
+// webdavMultistatus is the body of the response to a PROPFIND request
+type webdavMultistatus struct {
+	Responses []webdavResponse `xml:"DAV: response"`
+}
+
+type webdavResponse struct {
+	Href      string           `xml:"DAV: href"`
+	Propstats []webdavPropstat `xml:"DAV: propstat"`
+}
+
+type webdavPropstat struct {
+	Status string     `xml:"DAV: status"`
+	Prop   webdavProp `xml:"DAV: prop"`
+}
+
+type webdavProp struct {
+	ResourceType struct {
+		Collection *struct{} `xml:"DAV: collection"`
+	} `xml:"DAV: resourcetype"`
+	ContentLength string `xml:"DAV: getcontentlength"`
+	LastModified  string `xml:"DAV: getlastmodified"`
+	ContentType   string `xml:"DAV: getcontenttype"`
+
+	ButlerContentType string `xml:"https://github.com/theleeeo/file-butler/ content-type"`
+	Tags              string `xml:"https://github.com/theleeeo/file-butler/ tags"`
+	Metadata          string `xml:"https://github.com/theleeeo/file-butler/ metadata"`
+}
+
+const webdavPropfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:" xmlns:fb="` + webdavNamespace + `">
+	<D:prop>
+		<D:resourcetype/>
+		<D:getcontentlength/>
+		<D:getlastmodified/>
+		<D:getcontenttype/>
+		<fb:content-type/>
+		<fb:tags/>
+		<fb:metadata/>
+	</D:prop>
+</D:propfind>`
+
+// props returns the properties that were found, a server reports the missing ones in a separate propstat
+func (r webdavResponse) props() webdavProp {
+	var props webdavProp
+
+	for _, ps := range r.Propstats {
+		if !strings.Contains(ps.Status, " 200 ") {
+			continue
+		}
+
+		if ps.Prop.ResourceType.Collection != nil {
+			props.ResourceType = ps.Prop.ResourceType
+		}
+
+		for _, field := range []struct{ dst, src *string }{
+			{&props.ContentLength, &ps.Prop.ContentLength},
+			{&props.LastModified, &ps.Prop.LastModified},
+			{&props.ContentType, &ps.Prop.ContentType},
+			{&props.ButlerContentType, &ps.Prop.ButlerContentType},
+			{&props.Tags, &ps.Prop.Tags},
+			{&props.Metadata, &ps.Prop.Metadata},
+		} {
+			if *field.src != "" {
+				*field.dst = *field.src
+			}
+		}
+	}
+
+	return props
+}
+
+func (p webdavProp) isCollection() bool {
+	return p.ResourceType.Collection != nil
+}
+
+func (p webdavProp) info() (ObjectInfo, error) {
+	info := ObjectInfo{}
+
+	if p.ContentLength != "" {
+		size, err := strconv.ParseInt(p.ContentLength, 10, 64)
+		if err != nil {
+			return ObjectInfo{}, fmt.Errorf("invalid content length: %w", err)
+		}
+		info.ContentLength = &size
+	}
+
+	if lastModified, err := http.ParseTime(p.LastModified); err == nil {
+		info.LastModified = &lastModified
+	}
+
+	// The content type of the upload is preferred over the one the server guessed
+	contentType := p.ButlerContentType
+	if contentType == "" {
+		contentType = p.ContentType
+	}
+	if contentType != "" {
+		info.ContentType = &contentType
+	}
+
+	if p.Metadata != "" {
+		if err := json.Unmarshal([]byte(p.Metadata), &info.Metadata); err != nil {
+			return ObjectInfo{}, fmt.Errorf("invalid metadata property: %w", err)
+		}
+	}
+
+	return info, nil
+}
+
+func (p webdavProp) tags() (map[string]string, error) {
+	if p.Tags == "" {
+		return nil, nil
+	}
+
+	var tags map[string]string
+	if err := json.Unmarshal([]byte(p.Tags), &tags); err != nil {
+		return nil, fmt.Errorf("invalid tags property: %w", err)
+	}
+
+	return tags, nil
+}
+
+// resourceURL returns the URL of the resource at the path relative to the base URL
+func (w *WebDAVProvider) resourceURL(p string) string {
+	return w.baseURL + "/" + (&url.URL{Path: p}).EscapedPath()
+}
+
+// send sends a request for the resource at the path to the server
+func (w *WebDAVProvider) send(ctx context.Context, method, p string, header http.Header, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, w.resourceURL(p), body)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	if w.username != "" {
+		req.SetBasicAuth(w.username, w.password)
+	}
+
+	return w.client.Do(req)
+}
+
+// do sends a request to the server and maps the error statuses to the errors of the provider package
+func (w *WebDAVProvider) do(ctx context.Context, method, p string, header http.Header, body io.Reader) (*http.Response, error) {
+	resp, err := w.send(ctx, method, p, header, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+
+	resp.Body.Close()
+
+	return nil, errorFromStatus(resp)
+}
+
+// propfind returns the properties of the resource at the path, and of its members if depth is "1"
+func (w *WebDAVProvider) propfind(ctx context.Context, p, depth string) ([]webdavResponse, error) {
+	header := http.Header{}
+	header.Set("Depth", depth)
+	header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	resp, err := w.do(ctx, "PROPFIND", p, header, strings.NewReader(webdavPropfindBody))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var ms webdavMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("invalid multistatus response: %w", err)
+	}
+
+	return ms.Responses, nil
+}
+
+// stat returns the properties of the object, collections are not objects
+func (w *WebDAVProvider) stat(ctx context.Context, key string) (webdavProp, error) {
+	if !isCleanKey(key) {
+		return webdavProp{}, ErrDenied
+	}
+
+	responses, err := w.propfind(ctx, key, "0")
+	if err != nil {
+		return webdavProp{}, err
+	}
+
+	if len(responses) == 0 {
+		return webdavProp{}, ErrNotFound
+	}
+
+	props := responses[0].props()
+	if props.isCollection() {
+		return webdavProp{}, ErrNotFound
+	}
+
+	return props, nil
+}
+
+// proppatch sets the dead properties of the resource, the values are XML escaped
+func (w *WebDAVProvider) proppatch(ctx context.Context, key string, props map[string]string) error {
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0" encoding="utf-8"?>`)
+	body.WriteString(`<D:propertyupdate xmlns:D="DAV:" xmlns:fb="` + webdavNamespace + `"><D:set><D:prop>`)
+	for _, name := range names {
+		body.WriteString("<fb:" + name + ">")
+		if err := xml.EscapeText(&body, []byte(props[name])); err != nil {
+			return err
+		}
+		body.WriteString("</fb:" + name + ">")
+	}
+	body.WriteString(`</D:prop></D:set></D:propertyupdate>`)
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	resp, err := w.do(ctx, "PROPPATCH", key, header, &body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// A failed PROPPATCH is still answered with a multistatus, where the status of each property is reported
+	var ms webdavMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return fmt.Errorf("invalid multistatus response: %w", err)
+	}
+
+	for _, r := range ms.Responses {
+		for _, ps := range r.Propstats {
+			if !strings.Contains(ps.Status, " 200 ") {
+				return fmt.Errorf("could not set the properties of %s: %s", key, ps.Status)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (w *WebDAVProvider) GetObject(ctx context.Context, key string, opts GetOptions) (io.ReadCloser, ObjectInfo, error) {
+	if opts.VersionID != "" {
+		return nil, ObjectInfo{}, ErrNoVersioning
+	}
+
+	props, err := w.stat(ctx, key)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	info, err := props.info()
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	header := http.Header{}
+	if opts.Range != "" {
+		header.Set("Range", opts.Range)
+	}
+	if opts.LastModified != nil {
+		header.Set("If-Modified-Since", opts.LastModified.UTC().Format(http.TimeFormat))
+	}
+
+	resp, err := w.do(ctx, http.MethodGet, key, header, nil)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	if resp.ContentLength >= 0 {
+		info.ContentLength = &resp.ContentLength
+	}
+
+	if resp.StatusCode == http.StatusPartialContent {
+		if contentRange := resp.Header.Get("Content-Range"); contentRange != "" {
+			info.ContentRange = &contentRange
+		}
+	}
+
+	return resp.Body, info, nil
+}
+
+func (w *WebDAVProvider) StatObject(ctx context.Context, key string) (ObjectInfo, error) {
+	props, err := w.stat(ctx, key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	return props.info()
+}
+
+// mkcolParents creates the collections that the key is in, since a PUT can not create them
+func (w *WebDAVProvider) mkcolParents(ctx context.Context, key string) error {
+	parts := strings.Split(key, "/")
+
+	for i := 1; i < len(parts); i++ {
+		resp, err := w.send(ctx, "MKCOL", strings.Join(parts[:i], "/")+"/", nil, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		// An existing collection is reported as method not allowed
+		if resp.StatusCode == http.StatusCreated || resp.StatusCode == http.StatusMethodNotAllowed {
+			continue
+		}
+
+		return errorFromStatus(resp)
+	}
+
+	return nil
+}
+
+func (w *WebDAVProvider) PutObject(ctx context.Context, key string, data io.Reader, opts PutOptions) error {
+	if !isCleanKey(key) {
+		return ErrDenied
+	}
+
+	if err := w.mkcolParents(ctx, key); err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	if opts.ContentType != "" {
+		header.Set("Content-Type", opts.ContentType)
+	}
+
+	resp, err := w.do(ctx, http.MethodPut, key, header, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	props := map[string]string{}
+
+	if opts.ContentType != "" {
+		props["content-type"] = opts.ContentType
+	}
+
+	// The properties are always written to replace the ones of an object that was overwritten
+	tags, err := json.Marshal(opts.Tags)
+	if err != nil {
+		return err
+	}
+	props["tags"] = string(tags)
+
+	metadata, err := json.Marshal(opts.Metadata)
+	if err != nil {
+		return err
+	}
+	props["metadata"] = string(metadata)
+
+	return w.proppatch(ctx, key, props)
+}
+
+func (w *WebDAVProvider) GetTags(ctx context.Context, key string) (map[string]string, error) {
+	props, err := w.stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return props.tags()
+}
+
+func (w *WebDAVProvider) SetTags(ctx context.Context, key string, tags map[string]string) error {
+	if _, err := w.stat(ctx, key); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(tags)
+	if err != nil {
+		return err
+	}
+
+	return w.proppatch(ctx, key, map[string]string{"tags": string(b)})
+}
+
+// keyFromHref returns the path of the resource relative to the base URL
+func (w *WebDAVProvider) keyFromHref(href string) (string, error) {
+	u, err := url.Parse(href)
+	if err != nil {
+		return "", fmt.Errorf("invalid href: %w", err)
+	}
+
+	p, ok := strings.CutPrefix(u.Path, w.basePath+"/")
+	if !ok {
+		return "", fmt.Errorf("href %s is outside of the base url", href)
+	}
+
+	return strings.TrimSuffix(p, "/"), nil
+}
+
+// ListObjects walks the collections that can contain keys with the prefix, one level at a time
+// since many servers do not allow PROPFIND with infinite depth
+func (w *WebDAVProvider) ListObjects(ctx context.Context, prefix string) (ListObjectsResponse, error) {
+	keys := []string{}
+
+	start := ""
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		start = prefix[:i+1]
+	}
+
+	queue := []string{start}
+	for len(queue) > 0 {
+		collection := queue[0]
+		queue = queue[1:]
+
+		responses, err := w.propfind(ctx, collection, "1")
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			return ListObjectsResponse{}, err
+		}
+
+		for _, r := range responses {
+			key, err := w.keyFromHref(r.Href)
+			if err != nil {
+				return ListObjectsResponse{}, err
+			}
+
+			props := r.props()
+
+			if props.isCollection() {
+				dir := key + "/"
+				// The collection itself is part of the response
+				if dir == collection || key == "" {
+					continue
+				}
+				if strings.HasPrefix(dir, prefix) || strings.HasPrefix(prefix, dir) {
+					queue = append(queue, dir)
+				}
+				continue
+			}
+
+			if strings.HasPrefix(key, prefix) && path.Clean(key) == key {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	sort.Strings(keys)
+
+	return ListObjectsResponse{Keys: keys}, nil
+}
+
+func (w *WebDAVProvider) DeleteObject(ctx context.Context, key string, opts DeleteOptions) error {
+	if opts.VersionID != "" {
+		return ErrNoVersioning
+	}
+
+	// A collection would be deleted with all its members
+	if _, err := w.stat(ctx, key); err != nil {
+		return err
+	}
+
+	resp, err := w.do(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
@@ -0,0 +1,532 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+	"github.com/theleeeo/file-butler/interpolate"
+	"github.com/theleeeo/file-butler/provider"
+	"github.com/theleeeo/file-butler/server"
+)
+
+// configPaths are the directories that the config files are searched for in
+var configPaths = []string{".", "$HOME/.filebutler", "/etc/filebutler/"}
+
+// reloadDelay is how long to wait after a provider config file has changed before the providers are reloaded,
+// so that the many events of a single save only cause one reload
+const reloadDelay = 100 * time.Millisecond
+
+// providerSources are the files that the providers are configured in
+type providerSources struct {
+	// file is the providers.<ext> file, it is empty if there is none
+	file string
+	// dir is the providers directory where every file is read, it is empty if there is none
+	dir string
+}
+
+// findProviderSources finds the providers.<ext> file and the providers directory.
+// The directory is the providers-dir of the main config, or providers.d in the config paths if it is not set.
+func findProviderSources() (providerSources, error) {
+	var sources providerSources
+
+	for _, p := range configPaths {
+		if sources.file != "" {
+			break
+		}
+
+		for _, ext := range viper.SupportedExts {
+			file := filepath.Join(os.ExpandEnv(p), "providers."+ext)
+			if info, err := os.Stat(file); err == nil && !info.IsDir() {
+				sources.file = file
+				break
+			}
+		}
+	}
+
+	if dir := viper.GetString("providers-dir"); dir != "" {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return providerSources{}, fmt.Errorf("providers-dir %s is not a directory", dir)
+		}
+		sources.dir = dir
+	} else {
+		for _, p := range configPaths {
+			dir := filepath.Join(os.ExpandEnv(p), "providers.d")
+			if info, err := os.Stat(dir); err == nil && info.IsDir() {
+				sources.dir = dir
+				break
+			}
+		}
+	}
+
+	if sources.file == "" && sources.dir == "" {
+		return providerSources{}, fmt.Errorf("provider file not found")
+	}
+
+	var err error
+	if sources.file != "" {
+		if sources.file, err = filepath.Abs(sources.file); err != nil {
+			return providerSources{}, err
+		}
+	}
+
+	if sources.dir != "" {
+		if sources.dir, err = filepath.Abs(sources.dir); err != nil {
+			return providerSources{}, err
+		}
+	}
+
+	return sources, nil
+}
+
+// isProviderFile returns if the file is one that the providers are read from
+func (s providerSources) isProviderFile(file string) bool {
+	if file == s.file {
+		return true
+	}
+
+	if s.dir == "" || filepath.Dir(file) != s.dir {
+		return false
+	}
+
+	// Hidden files are skipped to not read the swap files of editors
+	if strings.HasPrefix(filepath.Base(file), ".") {
+		return false
+	}
+
+	return slices.Contains(viper.SupportedExts, strings.TrimPrefix(filepath.Ext(file), "."))
+}
+
+// files returns all files that the providers are read from, in the order they are read
+func (s providerSources) files() ([]string, error) {
+	var files []string
+	if s.file != "" {
+		files = append(files, s.file)
+	}
+
+	if s.dir == "" {
+		return files, nil
+	}
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read providers directory: %w", err)
+	}
+
+	// The entries are sorted by name
+	for _, entry := range entries {
+		file := filepath.Join(s.dir, entry.Name())
+		if !entry.IsDir() && s.isProviderFile(file) {
+			files = append(files, file)
+		}
+	}
+
+	return files, nil
+}
+
+// watchProviders calls reload when the providers file or any file in the providers directory is created, changed or removed
+func watchProviders(sources providerSources, reload func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	// The directories are watched instead of the files, since editors often save a file by replacing it
+	for _, dir := range []string{filepath.Dir(sources.file), sources.dir} {
+		if dir == "." || dir == "" {
+			continue
+		}
+
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return fmt.Errorf("unable to watch %s: %w", dir, err)
+		}
+	}
+
+	go func() {
+		var timer *time.Timer
+
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+
+				if event.Has(fsnotify.Chmod) || !sources.isProviderFile(filepath.Clean(event.Name)) {
+					continue
+				}
+
+				if timer == nil {
+					timer = time.AfterFunc(reloadDelay, reload)
+				} else {
+					timer.Reset(reloadDelay)
+				}
+
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Println(color.RedString("ERROR watching provider files: %s", err))
+			}
+		}
+	}()
+
+	return nil
+}
+
+func reloadProvidersFunc(sources providerSources, srv *server.Server, current map[string]loadedProvider) func() {
+	// The timer of the watcher can fire again while a reload is still running
+	var mx sync.Mutex
+
+	return func() {
+		mx.Lock()
+		defer mx.Unlock()
+
+		log.Println("Reloading providers")
+
+		newProviders, err := loadProviders(sources, current)
+		if err != nil {
+			log.Println(color.RedString("ERROR: %s", err))
+			return
+		}
+
+		providers := make([]provider.Provider, 0, len(newProviders))
+		for _, lp := range newProviders {
+			providers = append(providers, lp.provider)
+		}
+
+		// The whole set is swapped at once so that no request sees a provider missing while it is reloaded.
+		// The providers that are replaced or removed are closed by the server when they are no longer used.
+		if err := srv.ReplaceProviders(providers); err != nil {
+			log.Println(color.RedString("ERROR: %s", err))
+			closeNewProviders(newProviders, current)
+			return
+		}
+
+		current = newProviders
+
+		log.Println("Providers reloaded")
+	}
+}
+
+// loadedProvider is a created provider and the config it was created from
+type loadedProvider struct {
+	// file is the config file that the provider is defined in
+	file     string
+	cfg      provider.Config
+	provider provider.Provider
+}
+
+// loadProviders creates the providers from all provider config files.
+// The providers in previous whose config has not changed are reused instead of being created again.
+//
+// When previous is nil, which is when the server starts, any error in any of the files is returned.
+// Otherwise the errors are isolated to the file they are in: they are logged, and the providers of that file are kept as they were in previous.
+// A file is also treated as broken if it defines a provider that is defined in another file, or if one of its providers can not be created.
+func loadProviders(sources providerSources, previous map[string]loadedProvider) (map[string]loadedProvider, error) {
+	files, err := sources.files()
+	if err != nil {
+		return nil, err
+	}
+
+	parsed := make(map[string]map[string]provider.Config, len(files))
+	broken := make(map[string]error)
+
+	for _, file := range files {
+		cfgs, err := readProviderFile(file)
+		if err != nil {
+			broken[file] = err
+			continue
+		}
+		parsed[file] = cfgs
+	}
+
+	for {
+		if previous == nil {
+			for _, file := range files {
+				if err := broken[file]; err != nil {
+					return nil, fmt.Errorf("%s: %w", file, err)
+				}
+			}
+		}
+
+		cfgs := make(map[string]map[string]provider.Config, len(files))
+		for _, file := range files {
+			if _, ok := broken[file]; !ok {
+				cfgs[file] = parsed[file]
+				continue
+			}
+
+			// The previous providers of a broken file are kept
+			cfgs[file] = make(map[string]provider.Config)
+			for id, lp := range previous {
+				if lp.file == file {
+					cfgs[file][id] = lp.cfg
+				}
+			}
+		}
+
+		if brokenBefore := len(broken); markDuplicateProviders(files, cfgs, previous, broken) {
+			// The previous providers were valid together, so the duplicates are always resolved by falling back to them
+			if len(broken) == brokenBefore {
+				return nil, errors.New("duplicate providers could not be resolved")
+			}
+			continue
+		}
+
+		providers, err := createProviders(files, cfgs, previous)
+		if err != nil {
+			var fileErr *providerFileError
+			if previous == nil || !errors.As(err, &fileErr) {
+				return nil, err
+			}
+
+			broken[fileErr.file] = fileErr.err
+			continue
+		}
+
+		for _, file := range files {
+			if err := broken[file]; err != nil {
+				log.Println(color.RedString("ERROR: %s: %s, the previous providers of the file are kept", file, err))
+			}
+		}
+
+		return providers, nil
+	}
+}
+
+// markDuplicateProviders marks the files that define a provider that is also defined in another file as broken.
+// If the provider was previously defined in one of the files, that file keeps it and only the others are marked.
+// It returns true if there are any duplicates.
+func markDuplicateProviders(files []string, cfgs map[string]map[string]provider.Config, previous map[string]loadedProvider, broken map[string]error) bool {
+	definedIn := make(map[string][]string)
+	for _, file := range files {
+		for id := range cfgs[file] {
+			definedIn[id] = append(definedIn[id], file)
+		}
+	}
+
+	found := false
+	for id, idFiles := range definedIn {
+		if len(idFiles) < 2 {
+			continue
+		}
+
+		found = true
+		for _, file := range idFiles {
+			if prev, ok := previous[id]; ok && prev.file == file {
+				continue
+			}
+
+			if _, ok := broken[file]; !ok {
+				broken[file] = fmt.Errorf("provider %s is defined in multiple files: %s", id, strings.Join(idFiles, ", "))
+			}
+		}
+	}
+
+	return found
+}
+
+// providerFileError is an error creating one of the providers of a file
+type providerFileError struct {
+	file string
+	err  error
+}
+
+func (e *providerFileError) Error() string {
+	return fmt.Sprintf("%s: %s", e.file, e.err)
+}
+
+// createProviders creates the providers of all files, reusing the ones in previous whose config has not changed.
+// If a provider can not be created, the providers that were created are closed and a providerFileError is returned.
+func createProviders(files []string, cfgs map[string]map[string]provider.Config, previous map[string]loadedProvider) (map[string]loadedProvider, error) {
+	providers := make(map[string]loadedProvider)
+
+	for _, file := range files {
+		ids := make([]string, 0, len(cfgs[file]))
+		for id := range cfgs[file] {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		for _, id := range ids {
+			cfg := cfgs[file][id]
+
+			if prev, ok := previous[id]; ok && reflect.DeepEqual(prev.cfg, cfg) {
+				if prev.file != file {
+					log.Printf("Provider %s moved from %s to %s", id, prev.file, file)
+				}
+				providers[id] = loadedProvider{file: file, cfg: cfg, provider: prev.provider}
+				continue
+			}
+
+			p, err := newProvider(cfg)
+			if err != nil {
+				closeNewProviders(providers, previous)
+				return nil, &providerFileError{file: file, err: fmt.Errorf("unable to create provider %s: %w", id, err)}
+			}
+
+			log.Printf("Loaded provider %s from %s", id, file)
+			providers[id] = loadedProvider{file: file, cfg: cfg, provider: p}
+		}
+	}
+
+	return providers, nil
+}
+
+// closeNewProviders closes the providers that were created by loadProviders and not reused from previous
+func closeNewProviders(providers, previous map[string]loadedProvider) {
+	for id, lp := range providers {
+		if prev, ok := previous[id]; ok && prev.provider == lp.provider {
+			continue
+		}
+
+		if err := provider.Close(lp.provider); err != nil {
+			log.Println(color.RedString("ERROR closing provider %s: %s", id, err))
+		}
+	}
+}
+
+// readProviderFile reads the provider configurations from a file, the format is chosen from the extension
+func readProviderFile(file string) (map[string]provider.Config, error) {
+	v := viper.New()
+	v.SetConfigFile(file)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("error reading provider file: %w", err)
+	}
+
+	cfgs := make(map[string]provider.Config)
+	for id, val := range v.AllSettings() {
+		cfg, err := decodeProviderConfig(id, val)
+		if err != nil {
+			return nil, err
+		}
+
+		// Unknown keys are usually typos, they are not an error to not break a running server but the validate command rejects them
+		for _, key := range unknownProviderKeys(cfg, val) {
+			log.Println(color.YellowString("WARNING: %s: provider %s: unknown key %q is ignored", file, id, key))
+		}
+
+		cfgs[id] = cfg
+	}
+
+	return cfgs, nil
+}
+
+// decodeProviderConfig decodes the config of a provider into the config type of its provider type
+func decodeProviderConfig(id string, val any) (provider.Config, error) {
+	vMap, ok := val.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("invalid config of provider %s: %T", id, val)
+	}
+
+	providerType, _ := vMap["type"].(string)
+	if providerType == "" {
+		return nil, fmt.Errorf("type is required, missing for: %s", id)
+	}
+
+	var cfg provider.Config
+	var err error
+
+	// Parse the provider config based on the provider type
+	switch providerType {
+	case string(provider.ProviderTypeVoid):
+		cfg, err = unmarshalProviderCfg[*provider.VoidConfig](id, val)
+	case string(provider.ProviderTypeLog):
+		cfg, err = unmarshalProviderCfg[*provider.LogConfig](id, val)
+	case string(provider.ProviderTypeS3):
+		cfg, err = unmarshalProviderCfg[*provider.S3Config](id, val)
+	case string(provider.ProviderTypeGocloud):
+		cfg, err = unmarshalProviderCfg[*provider.GocloudConfig](id, val)
+	case string(provider.ProviderTypeFilesystem):
+		cfg, err = unmarshalProviderCfg[*provider.FilesystemConfig](id, val)
+	case string(provider.ProviderTypeMemory):
+		cfg, err = unmarshalProviderCfg[*provider.MemoryConfig](id, val)
+	case string(provider.ProviderTypeHTTP):
+		cfg, err = unmarshalProviderCfg[*provider.HTTPConfig](id, val)
+	case string(provider.ProviderTypeWebDAV):
+		cfg, err = unmarshalProviderCfg[*provider.WebDAVConfig](id, val)
+	case string(provider.ProviderTypeSFTP):
+		cfg, err = unmarshalProviderCfg[*provider.SFTPConfig](id, val)
+	case string(provider.ProviderTypeSQLite):
+		cfg, err = unmarshalProviderCfg[*provider.SQLiteConfig](id, val)
+	default:
+		return nil, fmt.Errorf("unknown provider type: %s", providerType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse config of provider %s: %w", id, err)
+	}
+
+	return cfg, nil
+}
+
+// newProvider creates a provider based on the type of the config
+func newProvider(cfg provider.Config) (provider.Provider, error) {
+	switch cfg := cfg.(type) {
+	case *provider.S3Config:
+		return provider.NewS3Provider(cfg)
+	case *provider.VoidConfig:
+		return provider.NewVoidProvider(cfg), nil
+	case *provider.LogConfig:
+		return provider.NewLogProvider(cfg), nil
+	case *provider.GocloudConfig:
+		return provider.NewGocloudProvider(cfg)
+	case *provider.FilesystemConfig:
+		return provider.NewFilesystemProvider(cfg)
+	case *provider.MemoryConfig:
+		return provider.NewMemoryProvider(cfg)
+	case *provider.HTTPConfig:
+		return provider.NewHTTPProvider(cfg)
+	case *provider.WebDAVConfig:
+		return provider.NewWebDAVProvider(cfg)
+	case *provider.SFTPConfig:
+		return provider.NewSFTPProvider(cfg)
+	case *provider.SQLiteConfig:
+		return provider.NewSQLiteProvider(cfg)
+	}
+
+	return nil, fmt.Errorf("unknown provider config type: %T", cfg)
+}
+
+func unmarshalProviderCfg[T provider.Config](id string, v any) (T, error) {
+	var cfg T
+
+	vMap, ok := v.(map[string]any)
+	if !ok {
+		return cfg, fmt.Errorf("invalid config type: %T", v)
+	}
+
+	// The references to environment variables and files are resolved before the config is decoded, so that they can be used for any field
+	resolved, err := interpolate.Value(vMap)
+	if err != nil {
+		return cfg, fmt.Errorf("unable to resolve references: %w", err)
+	}
+
+	vMap = resolved.(map[string]any)
+	vMap["id"] = id
+
+	jsonBytes, err := json.Marshal(vMap)
+	if err != nil {
+		return cfg, fmt.Errorf("unable to marshal config: %w", err)
+	}
+
+	err = json.Unmarshal(jsonBytes, &cfg)
+	if err != nil {
+		return cfg, fmt.Errorf("unable to unmarshal config: %w", err)
+	}
+
+	return cfg, nil
+}
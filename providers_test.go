@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeProviderFile writes a provider file to the directory
+func writeProviderFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+}
+
+// providerFiles returns the file that each loaded provider is defined in, by the name of the file
+func providerFiles(providers map[string]loadedProvider) map[string]string {
+	files := make(map[string]string, len(providers))
+	for id, lp := range providers {
+		files[id] = filepath.Base(lp.file)
+	}
+
+	return files
+}
+
+func Test_LoadProviders(t *testing.T) {
+	dir := t.TempDir()
+	sources := providerSources{dir: dir}
+
+	writeProviderFile(t, dir, "a.toml", "[mem1]\ntype = \"memory\"\n")
+	writeProviderFile(t, dir, "b.toml", "[mem2]\ntype = \"memory\"\n")
+
+	current, err := loadProviders(sources, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"mem1": "a.toml", "mem2": "b.toml"}, providerFiles(current))
+
+	t.Run("Broken file keeps its previous providers", func(t *testing.T) {
+		writeProviderFile(t, dir, "a.toml", "[mem1]\ntype = \"memory\"\n\n[mem3]\ntype = \"memory\"\n")
+		writeProviderFile(t, dir, "b.toml", "[mem2\ntype = \"memory\"\n")
+
+		// Any broken file is an error when the server starts
+		_, err := loadProviders(sources, nil)
+		assert.Error(t, err)
+
+		providers, err := loadProviders(sources, current)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"mem1": "a.toml", "mem2": "b.toml", "mem3": "a.toml"}, providerFiles(providers))
+		// The unchanged providers are reused instead of being created again
+		assert.Same(t, current["mem1"].provider, providers["mem1"].provider)
+		assert.Same(t, current["mem2"].provider, providers["mem2"].provider)
+
+		// A provider that can not be created breaks its file the same way
+		writeProviderFile(t, dir, "b.toml", "[mem2]\ntype = \"http\"\nbase-url = \"ftp://localhost\"\n")
+
+		providers, err = loadProviders(sources, current)
+		assert.NoError(t, err)
+		assert.Same(t, current["mem2"].provider, providers["mem2"].provider)
+
+		writeProviderFile(t, dir, "a.toml", "[mem1]\ntype = \"memory\"\n")
+		writeProviderFile(t, dir, "b.toml", "[mem2]\ntype = \"memory\"\n")
+	})
+
+	t.Run("Duplicate provider across files", func(t *testing.T) {
+		writeProviderFile(t, dir, "b.toml", "[mem2]\ntype = \"memory\"\n\n[mem1]\ntype = \"memory\"\nmax-size = 10\n\n[mem4]\ntype = \"memory\"\n")
+
+		_, err := loadProviders(sources, nil)
+		assert.Error(t, err)
+
+		// The file that already defined the provider keeps it, the other file keeps its previous providers
+		providers, err := loadProviders(sources, current)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"mem1": "a.toml", "mem2": "b.toml"}, providerFiles(providers))
+		assert.Same(t, current["mem1"].provider, providers["mem1"].provider)
+
+		writeProviderFile(t, dir, "b.toml", "[mem2]\ntype = \"memory\"\n")
+	})
+
+	t.Run("Provider moved between files", func(t *testing.T) {
+		writeProviderFile(t, dir, "a.toml", "[mem1]\ntype = \"memory\"\n\n[mem2]\ntype = \"memory\"\n")
+		writeProviderFile(t, dir, "b.toml", "")
+
+		providers, err := loadProviders(sources, current)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"mem1": "a.toml", "mem2": "a.toml"}, providerFiles(providers))
+		// The config is the same so the provider is not created again
+		assert.Same(t, current["mem2"].provider, providers["mem2"].provider)
+	})
+
+	t.Run("Provider copied before it is removed from its file", func(t *testing.T) {
+		writeProviderFile(t, dir, "a.toml", "[mem1]\ntype = \"memory\"\n\n[mem2]\ntype = \"memory\"\n")
+		writeProviderFile(t, dir, "b.toml", "[mem2]\ntype = \"memory\"\n")
+
+		// The file the provider was defined in keeps it until it is removed from there
+		providers, err := loadProviders(sources, current)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"mem1": "a.toml", "mem2": "b.toml"}, providerFiles(providers))
+	})
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	authPlugin "github.com/theleeeo/file-butler/authorization/plugin"
+	"github.com/theleeeo/file-butler/provider"
+)
+
+const jsonSchemaVersion = "https://json-schema.org/draft/2020-12/schema"
+
+// providerConfigTypes are the config types of all provider types, it must be kept in sync with decodeProviderConfig
+var providerConfigTypes = []struct {
+	typ provider.ProviderType
+	cfg provider.Config
+}{
+	{provider.ProviderTypeVoid, &provider.VoidConfig{}},
+	{provider.ProviderTypeLog, &provider.LogConfig{}},
+	{provider.ProviderTypeS3, &provider.S3Config{}},
+	{provider.ProviderTypeGocloud, &provider.GocloudConfig{}},
+	{provider.ProviderTypeFilesystem, &provider.FilesystemConfig{}},
+	{provider.ProviderTypeMemory, &provider.MemoryConfig{}},
+	{provider.ProviderTypeHTTP, &provider.HTTPConfig{}},
+	{provider.ProviderTypeWebDAV, &provider.WebDAVConfig{}},
+	{provider.ProviderTypeSFTP, &provider.SFTPConfig{}},
+	{provider.ProviderTypeSQLite, &provider.SQLiteConfig{}},
+}
+
+// serverConfigKeys are the keys of the server section of the main config and their schemas, it must be kept in sync with serverConfig
+var serverConfigKeys = map[string]map[string]any{
+	"addr":                  {"type": "string", "description": "The host:port the server listens on"},
+	"allow_raw_body":        {"type": "boolean", "description": "Allow uploads with a raw body instead of multipart form data"},
+	"default_auth_plugin":   {"type": "string", "description": "The auth plugin of the providers that do not set their own"},
+	"trash_purge_interval":  durationSchema(),
+	"expiry_sweep_interval": durationSchema(),
+	"max_archive_files":     {"type": "integer", "minimum": 0},
+	"max_archive_bytes":     {"type": "integer", "minimum": 0},
+}
+
+// mainConfigKeys are the top level keys of the main config
+var mainConfigKeys = []string{"server", "auth-plugins", "providers-dir"}
+
+var (
+	durationType = reflect.TypeOf(provider.Duration(0))
+	modeType     = reflect.TypeOf(provider.Mode(""))
+)
+
+func durationSchema() map[string]any {
+	return map[string]any{
+		"type":        []string{"string", "integer"},
+		"description": "A duration like 30s or 15m, or a number of nanoseconds",
+	}
+}
+
+// configField is a field of a config struct and the key it is read from
+type configField struct {
+	key   string
+	field reflect.StructField
+}
+
+// configFields returns the fields of a config struct, the fields of embedded structs are included as if they were fields of the struct.
+// The keys are lowercase since viper lowercases all keys, and they are matched case insensitively when decoded.
+func configFields(t reflect.Type) []configField {
+	var fields []configField
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			fields = append(fields, configFields(f.Type)...)
+			continue
+		}
+
+		key := strings.ToLower(f.Name)
+		if tag, _, _ := strings.Cut(f.Tag.Get("json"), ","); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			key = strings.ToLower(tag)
+		}
+
+		fields = append(fields, configField{key: key, field: f})
+	}
+
+	return fields
+}
+
+// providerFields returns the fields of a provider config, without the ID since it is the key of the provider and not a key in its config
+func providerFields(cfg provider.Config) []configField {
+	var fields []configField
+	for _, f := range configFields(reflect.TypeOf(cfg).Elem()) {
+		if f.key != "id" {
+			fields = append(fields, f)
+		}
+	}
+
+	return fields
+}
+
+// unknownKeys returns the keys in the config value that do not match any field of the struct type, nested keys are joined with dots
+func unknownKeys(t reflect.Type, val map[string]any, prefix string) []string {
+	fields := make(map[string]reflect.Type)
+	for _, f := range configFields(t) {
+		fields[f.key] = f.field.Type
+	}
+
+	return unknownKeysOf(fields, val, prefix)
+}
+
+func unknownKeysOf(fields map[string]reflect.Type, val map[string]any, prefix string) []string {
+	var unknown []string
+
+	for key, v := range val {
+		ft, ok := fields[strings.ToLower(key)]
+		if !ok {
+			unknown = append(unknown, prefix+key)
+			continue
+		}
+
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+
+		if nested, ok := v.(map[string]any); ok && ft.Kind() == reflect.Struct {
+			unknown = append(unknown, unknownKeys(ft, nested, prefix+key+".")...)
+		}
+	}
+
+	sort.Strings(unknown)
+	return unknown
+}
+
+// unknownProviderKeys returns the keys in the config of a provider that are not used by its provider type
+func unknownProviderKeys(cfg provider.Config, val any) []string {
+	vMap, ok := val.(map[string]any)
+	if !ok {
+		return nil
+	}
+
+	fields := map[string]reflect.Type{"type": reflect.TypeOf("")}
+	for _, f := range providerFields(cfg) {
+		fields[f.key] = f.field.Type
+	}
+
+	return unknownKeysOf(fields, vMap, "")
+}
+
+// missingRequired returns the keys of the fields tagged as required that are not set in the config struct
+func missingRequired(v reflect.Value) []string {
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+
+	var missing []string
+	for _, f := range configFields(v.Type()) {
+		if f.field.Tag.Get("required") == "true" && v.FieldByIndex(f.field.Index).IsZero() {
+			missing = append(missing, f.key)
+		}
+	}
+
+	return missing
+}
+
+// typeSchema returns the JSON Schema of a config value of the type
+func typeSchema(t reflect.Type) map[string]any {
+	switch t {
+	case durationType:
+		return durationSchema()
+	case modeType:
+		return map[string]any{
+			"type": "string",
+			"enum": []provider.Mode{provider.ModeReadWrite, provider.ModeReadOnly, provider.ModeWriteOnce, provider.ModeAppendOnly},
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		return objectSchema(configFields(t))
+	}
+
+	return map[string]any{}
+}
+
+func objectSchema(fields []configField) map[string]any {
+	properties := make(map[string]any, len(fields))
+	required := []string{}
+
+	for _, f := range fields {
+		properties[f.key] = typeSchema(f.field.Type)
+		if f.field.Tag.Get("required") == "true" {
+			required = append(required, f.key)
+		}
+	}
+
+	return map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+// providersSchema returns the JSON Schema of a provider config file
+func providersSchema() map[string]any {
+	var variants []any
+
+	for _, pt := range providerConfigTypes {
+		s := objectSchema(providerFields(pt.cfg))
+		s["title"] = string(pt.typ)
+		s["properties"].(map[string]any)["type"] = map[string]any{"const": pt.typ}
+		s["required"] = append([]string{"type"}, s["required"].([]string)...)
+		variants = append(variants, s)
+	}
+
+	return map[string]any{
+		"$schema":     jsonSchemaVersion,
+		"title":       "file-butler providers",
+		"description": "Each key is the ID of a provider",
+		"type":        "object",
+		"additionalProperties": map[string]any{
+			"oneOf": variants,
+		},
+	}
+}
+
+// mainSchema returns the JSON Schema of the main config file
+func mainSchema() map[string]any {
+	server := map[string]any{}
+	for key, s := range serverConfigKeys {
+		server[key] = s
+	}
+
+	return map[string]any{
+		"$schema":              jsonSchemaVersion,
+		"title":                "file-butler config",
+		"type":                 "object",
+		"additionalProperties": false,
+		"properties": map[string]any{
+			"server": map[string]any{
+				"type":                 "object",
+				"properties":           server,
+				"required":             []string{"addr", "default_auth_plugin"},
+				"additionalProperties": false,
+			},
+			"auth-plugins": map[string]any{
+				"type":  "array",
+				"items": typeSchema(reflect.TypeOf(authPlugin.Config{})),
+			},
+			"providers-dir": map[string]any{
+				"type":        "string",
+				"description": "The directory that every provider config file is read from, default is providers.d next to the config files",
+			},
+		},
+	}
+}
+
+// runSchema writes the JSON Schema of the main config or the provider config to w
+func runSchema(w io.Writer, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: file-butler schema <config|providers>")
+	}
+
+	var schema map[string]any
+	switch args[0] {
+	case "config":
+		schema = mainSchema()
+	case "providers":
+		schema = providersSchema()
+	default:
+		return fmt.Errorf("unknown schema %q, must be config or providers", args[0])
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(schema)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/theleeeo/file-butler/provider"
+)
+
+func Test_UnknownProviderKeys(t *testing.T) {
+	t.Run("Known keys", func(t *testing.T) {
+		val := map[string]any{
+			"type":            "s3",
+			"bucket":          "files",
+			"presign-enabled": true,
+			"auth-plugin":     "plugin",
+			"mode":            "read-only",
+			"trash":           map[string]any{"prefix": ".trash/", "retention": "24h"},
+		}
+
+		assert.Empty(t, unknownProviderKeys(&provider.S3Config{}, val))
+	})
+
+	t.Run("Keys are matched case insensitively", func(t *testing.T) {
+		assert.Empty(t, unknownProviderKeys(&provider.S3Config{}, map[string]any{"type": "s3", "Bucket": "files"}))
+	})
+
+	t.Run("Typo", func(t *testing.T) {
+		val := map[string]any{
+			"type":            "s3",
+			"bucket":          "files",
+			"presign_enabled": true,
+		}
+
+		assert.Equal(t, []string{"presign_enabled"}, unknownProviderKeys(&provider.S3Config{}, val))
+	})
+
+	t.Run("Key of another provider type", func(t *testing.T) {
+		val := map[string]any{
+			"type": "filesystem",
+			"root": "/data",
+			// The provider id is the key of the provider and not part of its config
+			"id":     "files",
+			"bucket": "files",
+		}
+
+		assert.Equal(t, []string{"bucket", "id"}, unknownProviderKeys(&provider.FilesystemConfig{}, val))
+	})
+
+	t.Run("Nested key", func(t *testing.T) {
+		val := map[string]any{
+			"type":  "memory",
+			"trash": map[string]any{"prefix": ".trash/", "retain": "24h"},
+		}
+
+		assert.Equal(t, []string{"trash.retain"}, unknownProviderKeys(&provider.MemoryConfig{}, val))
+	})
+
+	t.Run("Not a table", func(t *testing.T) {
+		assert.Nil(t, unknownProviderKeys(&provider.MemoryConfig{}, "memory"))
+	})
+}
+
+func Test_MissingRequired(t *testing.T) {
+	assert.Equal(t, []string{"bucket"}, missingRequired(reflect.ValueOf(&provider.S3Config{Region: "eu-north-1"})))
+	assert.Empty(t, missingRequired(reflect.ValueOf(&provider.S3Config{Bucket: "files"})))
+
+	// The key is the one used in the config file and not the name of the field
+	assert.Equal(t, []string{"base-url"}, missingRequired(reflect.ValueOf(provider.HTTPConfig{})))
+	assert.Empty(t, missingRequired(reflect.ValueOf(&provider.MemoryConfig{})))
+}
+
+func Test_ProvidersSchema(t *testing.T) {
+	schema := providersSchema()
+
+	// The schema must be possible to write as JSON
+	_, err := json.Marshal(schema)
+	assert.NoError(t, err)
+
+	variants := schema["additionalProperties"].(map[string]any)["oneOf"].([]any)
+	assert.Len(t, variants, len(providerConfigTypes))
+
+	var httpSchema map[string]any
+	for _, v := range variants {
+		if s := v.(map[string]any); s["title"] == string(provider.ProviderTypeHTTP) {
+			httpSchema = s
+		}
+	}
+	if !assert.NotNil(t, httpSchema) {
+		return
+	}
+
+	assert.Equal(t, []string{"type", "base-url"}, httpSchema["required"])
+	assert.Equal(t, false, httpSchema["additionalProperties"])
+
+	properties := httpSchema["properties"].(map[string]any)
+	assert.Equal(t, map[string]any{"const": provider.ProviderTypeHTTP}, properties["type"])
+	assert.Equal(t, map[string]any{"type": "string"}, properties["base-url"])
+	assert.Equal(t, map[string]any{"type": "object", "additionalProperties": map[string]any{"type": "string"}}, properties["headers"])
+	assert.Equal(t, durationSchema(), properties["timeout"])
+	assert.Contains(t, properties, "auth-plugin")
+	assert.Contains(t, properties, "mode")
+	assert.NotContains(t, properties, "id")
+}
@@ -0,0 +1,300 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	authorization "github.com/theleeeo/file-butler/authorization/v1"
+	"github.com/theleeeo/file-butler/lerr"
+	"github.com/theleeeo/file-butler/provider"
+)
+
+const (
+	defaultMaxArchiveFiles = 1000
+	defaultMaxArchiveBytes = 1 << 30
+)
+
+type archiveFormat string
+
+const (
+	archiveFormatZip   archiveFormat = "zip"
+	archiveFormatTarGz archiveFormat = "tar.gz"
+)
+
+// errArchiveTooLarge is returned when the files of an archive are larger than the limit
+var errArchiveTooLarge = errors.New("the archive is larger than the allowed size")
+
+// archiveWriter adds files to an archive that is streamed to the client
+type archiveWriter interface {
+	// addFile adds a file to the archive. The size is -1 if it is not known.
+	addFile(name string, size int64, modTime time.Time, data io.Reader) error
+	Close() error
+}
+
+type zipArchiveWriter struct {
+	zw *zip.Writer
+}
+
+func (z *zipArchiveWriter) addFile(name string, _ int64, modTime time.Time, data io.Reader) error {
+	w, err := z.zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modTime,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, data)
+	return err
+}
+
+func (z *zipArchiveWriter) Close() error {
+	return z.zw.Close()
+}
+
+type tarGzArchiveWriter struct {
+	gw *gzip.Writer
+	tw *tar.Writer
+}
+
+func (t *tarGzArchiveWriter) addFile(name string, size int64, modTime time.Time, data io.Reader) error {
+	// The size of a file must be written before its content in a tar archive.
+	// The data is limited by the size of the archive so the buffer can not grow larger than that.
+	if size < 0 {
+		b, err := io.ReadAll(data)
+		if err != nil {
+			return err
+		}
+
+		size = int64(len(b))
+		data = bytes.NewReader(b)
+	}
+
+	if err := t.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0o644,
+		ModTime:  modTime,
+	}); err != nil {
+		return err
+	}
+
+	_, err := io.Copy(t.tw, data)
+	return err
+}
+
+func (t *tarGzArchiveWriter) Close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+
+	return t.gw.Close()
+}
+
+// limitReader fails once more than the remaining bytes have been read.
+// The remaining bytes are shared by all files of an archive, so the limit is on the files that are read from the provider and not on the compressed archive.
+type limitReader struct {
+	r         io.Reader
+	remaining *int64
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	// One byte more than the remaining is read to find out if the data is larger than the limit
+	if int64(len(p)) > *l.remaining+1 {
+		p = p[:*l.remaining+1]
+	}
+
+	n, err := l.r.Read(p)
+	if int64(n) > *l.remaining {
+		n = int(*l.remaining)
+		*l.remaining = 0
+		return n, errArchiveTooLarge
+	}
+
+	*l.remaining -= int64(n)
+	return n, err
+}
+
+// flushWriter flushes the response after every write so that the archive is streamed to the client as it is created
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if flusher, ok := f.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+
+	return n, err
+}
+
+func (s *Server) handleArchive(w http.ResponseWriter, r *http.Request) {
+	providerName := r.PathValue("provider")
+	p, release := s.getProvider(providerName)
+	defer release()
+
+	if p == nil {
+		http.Error(w, "provider not found", http.StatusNotFound)
+		return
+	}
+
+	prefix := strings.TrimPrefix(r.URL.Path, "/archive/"+providerName+"/")
+
+	if isHiddenKey(p, prefix) {
+		http.Error(w, provider.ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	format := archiveFormat(r.URL.Query().Get("format"))
+	if format == "" {
+		format = archiveFormatZip
+	}
+
+	if format != archiveFormatZip && format != archiveFormatTarGz {
+		http.Error(w, fmt.Sprint("unsupported archive format: ", format), http.StatusBadRequest)
+		return
+	}
+
+	keys, err := s.archiveKeys(r.Context(), r.Header, p, prefix)
+	if err != nil {
+		writeProviderError(w, err)
+		return
+	}
+
+	if len(keys) == 0 {
+		http.Error(w, "no files found with the prefix", http.StatusNotFound)
+		return
+	}
+
+	// Everything below is streamed, so any errors after this point can not change the status code of the response
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", archiveName(prefix, providerName)+"."+string(format)))
+
+	cfg := s.currentSettings()
+
+	var aw archiveWriter
+	out := &flushWriter{w: w}
+
+	switch format {
+	case archiveFormatZip:
+		w.Header().Set("Content-Type", "application/zip")
+		aw = &zipArchiveWriter{zw: zip.NewWriter(out)}
+	case archiveFormatTarGz:
+		w.Header().Set("Content-Type", "application/gzip")
+		gw := gzip.NewWriter(out)
+		aw = &tarGzArchiveWriter{gw: gw, tw: tar.NewWriter(gw)}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+
+	// The directory part of the prefix is removed from the names of the files in the archive
+	base := prefix[:strings.LastIndex(prefix, "/")+1]
+
+	remaining := cfg.maxArchiveBytes
+	for _, key := range keys {
+		if err := s.addArchiveFile(r.Context(), aw, p, key, strings.TrimPrefix(key, base), &remaining); err != nil {
+			// Aborting the handler closes the connection, so the client will know that the archive is incomplete instead of receiving a truncated but valid-looking file
+			log.Printf("error creating archive of %s/%s: %s", providerName, prefix, err)
+			panic(http.ErrAbortHandler)
+		}
+	}
+
+	if err := aw.Close(); err != nil {
+		log.Printf("error creating archive of %s/%s: %s", providerName, prefix, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// archiveKeys lists the keys to include in the archive and authorizes each of them as a download.
+// This is done before anything is written so that a denied request gets a proper error response.
+func (s *Server) archiveKeys(ctx context.Context, headers http.Header, p provider.Provider, prefix string) ([]string, error) {
+	if err := s.authorizeRequest(ctx, authorization.RequestType_REQUEST_TYPE_LIST, headers, prefix, p); err != nil {
+		return nil, err
+	}
+
+	objects, err := p.ListObjects(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, k := range objects.Keys {
+		if !isHiddenKey(p, k) {
+			keys = append(keys, k)
+		}
+	}
+
+	if maxFiles := s.currentSettings().maxArchiveFiles; len(keys) > maxFiles {
+		return nil, lerr.Newf(http.StatusRequestEntityTooLarge, "the prefix contains %d files, the maximum in an archive is %d", len(keys), maxFiles)
+	}
+
+	for _, k := range keys {
+		if err := s.authorizeRequest(ctx, authorization.RequestType_REQUEST_TYPE_DOWNLOAD, headers, k, p); err != nil {
+			return nil, lerr.Wrap(err, lerr.Code(err), k)
+		}
+	}
+
+	return keys, nil
+}
+
+// addArchiveFile adds the object to the archive, remaining is how many more bytes can be read from the files of the archive
+func (s *Server) addArchiveFile(ctx context.Context, aw archiveWriter, p provider.Provider, key, name string, remaining *int64) error {
+	data, info, err := p.GetObject(ctx, key, provider.GetOptions{})
+	if err != nil {
+		// The object may have been deleted since it was listed
+		if errors.Is(err, provider.ErrNotFound) {
+			return nil
+		}
+
+		return err
+	}
+	defer data.Close()
+
+	// The expiry is checked here instead of when listing since the object has to be fetched anyway
+	if isExpired(info, time.Now()) {
+		return nil
+	}
+
+	size := int64(-1)
+	if info.ContentLength != nil {
+		size = *info.ContentLength
+
+		// There is no need to start reading a file that is known to be too large
+		if size > *remaining {
+			return errArchiveTooLarge
+		}
+	}
+
+	modTime := time.Now()
+	if info.LastModified != nil {
+		modTime = *info.LastModified
+	}
+
+	return aw.addFile(name, size, modTime, &limitReader{r: data, remaining: remaining})
+}
+
+// archiveName returns the name of the archive file without the extension
+func archiveName(prefix, providerName string) string {
+	name := path.Base(strings.TrimSuffix(prefix, "/"))
+	if name == "." || name == "/" || name == "" {
+		return providerName
+	}
+
+	return name
+}
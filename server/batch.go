@@ -0,0 +1,299 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	authorization "github.com/theleeeo/file-butler/authorization/v1"
+	"github.com/theleeeo/file-butler/lerr"
+	"github.com/theleeeo/file-butler/provider"
+)
+
+const (
+	// batchConcurrency is how many operations of a batch are executed at the same time
+	batchConcurrency = 8
+
+	// maxBatchOperations is the maximum number of operations in one batch request
+	maxBatchOperations = 1000
+
+	// maxBatchBodySize is the maximum size of the body of a batch request
+	maxBatchBodySize = 10 << 20
+)
+
+type batchOp string
+
+const (
+	batchOpDelete  batchOp = "delete"
+	batchOpCopy    batchOp = "copy"
+	batchOpSetTags batchOp = "set-tags"
+	batchOpStat    batchOp = "stat"
+)
+
+type batchOperation struct {
+	Op       batchOp `json:"op"`
+	Provider string  `json:"provider"`
+	Key      string  `json:"key"`
+
+	// The destination of a copy, the destination provider defaults to the source provider
+	DestProvider string `json:"dest_provider"`
+	DestKey      string `json:"dest_key"`
+
+	// The tags to set with set-tags, replacing any existing tags
+	Tags map[string]string `json:"tags"`
+}
+
+type batchResult struct {
+	Status int         `json:"status"`
+	Error  string      `json:"error,omitempty"`
+	Info   *objectStat `json:"info,omitempty"`
+}
+
+// objectStat is the JSON representation of a provider.ObjectInfo
+type objectStat struct {
+	LastModified  *time.Time        `json:"last_modified,omitempty"`
+	ContentLength *int64            `json:"content_length,omitempty"`
+	ContentType   *string           `json:"content_type,omitempty"`
+	VersionID     *string           `json:"version_id,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+}
+
+func (s *Server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	var ops []batchOperation
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBodySize)).Decode(&ops); err != nil {
+		http.Error(w, "invalid batch: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(ops) == 0 {
+		http.Error(w, "the batch has no operations", http.StatusBadRequest)
+		return
+	}
+
+	if len(ops) > maxBatchOperations {
+		http.Error(w, "too many operations in the batch", http.StatusBadRequest)
+		return
+	}
+
+	results := make([]batchResult, len(ops))
+
+	sem := make(chan struct{}, batchConcurrency)
+	var wg sync.WaitGroup
+
+	for i, op := range ops {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int, op batchOperation) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			info, err := s.executeBatchOperation(r.Context(), r.Header, op)
+			if err != nil {
+				results[i] = batchResult{Status: providerErrorCode(err), Error: err.Error()}
+
+				// The internal errors are redacted the same way as for the other requests
+				if results[i].Status == http.StatusInternalServerError {
+					responseId := rand.Intn(1000000) //nolint:gosec // This is not for security purposes, it does not have to be cryptographically secure
+					log.Printf("internal error: %s, id: %d, batch operation: %s %s/%s", err, responseId, op.Op, op.Provider, op.Key)
+					results[i].Error = fmt.Sprintf("internal error, id: %d", responseId)
+				}
+				return
+			}
+
+			results[i] = batchResult{Status: http.StatusOK, Info: info}
+		}(i, op)
+	}
+
+	wg.Wait()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		log.Println("error encoding batch results:", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// executeBatchOperation authorizes and executes a single operation of a batch
+// The same restrictions as for the corresponding single requests are applied
+func (s *Server) executeBatchOperation(ctx context.Context, headers http.Header, op batchOperation) (*objectStat, error) {
+	p, release := s.getProvider(op.Provider)
+	defer release()
+
+	if p == nil {
+		return nil, lerr.New(http.StatusNotFound, "provider not found")
+	}
+
+	if op.Key == "" {
+		return nil, lerr.New(http.StatusBadRequest, "key is required")
+	}
+
+	if isHiddenKey(p, op.Key) {
+		return nil, lerr.New(http.StatusNotFound, provider.ErrNotFound.Error())
+	}
+
+	switch op.Op {
+	case batchOpDelete:
+		if err := checkMode(p, authorization.RequestType_REQUEST_TYPE_DELETE); err != nil {
+			return nil, err
+		}
+
+		if err := s.authorizeRequest(ctx, authorization.RequestType_REQUEST_TYPE_DELETE, headers, op.Key, p); err != nil {
+			return nil, err
+		}
+
+		return nil, deleteObject(ctx, p, op.Key, provider.DeleteOptions{})
+
+	case batchOpCopy:
+		dst := p
+		if op.DestProvider != "" {
+			var releaseDst func()
+			dst, releaseDst = s.getProvider(op.DestProvider)
+			defer releaseDst()
+
+			if dst == nil {
+				return nil, lerr.New(http.StatusNotFound, "destination provider not found")
+			}
+		}
+
+		if op.DestKey == "" {
+			return nil, lerr.New(http.StatusBadRequest, "dest_key is required")
+		}
+
+		if isHiddenKey(dst, op.DestKey) {
+			return nil, lerr.New(http.StatusBadRequest, "the destination key is reserved")
+		}
+
+		if err := checkMode(dst, authorization.RequestType_REQUEST_TYPE_UPLOAD); err != nil {
+			return nil, err
+		}
+
+		// A copy reads the source and writes the destination, so it must be allowed to do both
+		if err := s.authorizeRequest(ctx, authorization.RequestType_REQUEST_TYPE_DOWNLOAD, headers, op.Key, p); err != nil {
+			return nil, err
+		}
+
+		if err := s.authorizeRequest(ctx, authorization.RequestType_REQUEST_TYPE_UPLOAD, headers, op.DestKey, dst); err != nil {
+			return nil, err
+		}
+
+		if err := checkOverwrite(ctx, dst, op.DestKey); err != nil {
+			return nil, err
+		}
+
+		return nil, copyObject(ctx, p, op.Key, dst, op.DestKey, copyLifecycle(dst.Policy()))
+
+	case batchOpSetTags:
+		tagSetter, ok := p.(provider.TagSetter)
+		if !ok {
+			return nil, lerr.New(http.StatusBadRequest, "setting tags is not supported for this provider")
+		}
+
+		if err := checkMode(p, authorization.RequestType_REQUEST_TYPE_SET_TAGS); err != nil {
+			return nil, err
+		}
+
+		if err := s.authorizeRequest(ctx, authorization.RequestType_REQUEST_TYPE_SET_TAGS, headers, op.Key, p); err != nil {
+			return nil, err
+		}
+
+		return nil, tagSetter.SetTags(ctx, op.Key, op.Tags)
+
+	case batchOpStat:
+		if err := s.authorizeRequest(ctx, authorization.RequestType_REQUEST_TYPE_GET_METADATA, headers, op.Key, p); err != nil {
+			return nil, err
+		}
+
+		info, err := provider.Stat(ctx, p, op.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		if isExpired(info, time.Now()) {
+			return nil, lerr.New(http.StatusNotFound, provider.ErrNotFound.Error())
+		}
+
+		return &objectStat{
+			LastModified:  info.LastModified,
+			ContentLength: info.ContentLength,
+			ContentType:   info.ContentType,
+			VersionID:     info.VersionID,
+			Metadata:      info.Metadata,
+		}, nil
+	}
+
+	return nil, lerr.Newf(http.StatusBadRequest, "unknown operation: %s", op.Op)
+}
+
+// copyLifecycle returns the retention and expiry of a copy into a provider with the policy.
+// The retention and expiry of the source are checked against the policy of the destination the same way as for an upload,
+// except that a retention is dropped if the destination does not support it since it only protects the source.
+func copyLifecycle(policy provider.Policy) copyOptionsFunc {
+	return func(info provider.ObjectInfo, opts *provider.PutOptions) error {
+		now := time.Now()
+
+		// Expired objects may not have been deleted by the sweeper yet
+		if isExpired(info, now) {
+			return lerr.New(http.StatusNotFound, provider.ErrNotFound.Error())
+		}
+
+		var retainUntil *time.Time
+		if policy.Retention > 0 {
+			t := now.Add(time.Duration(policy.Retention)).UTC().Truncate(time.Second)
+			retainUntil = &t
+		}
+
+		srcRetainUntil, err := metadataTime(info.Metadata, retainUntilMetadataKey)
+		if err != nil {
+			return err
+		}
+
+		if srcRetainUntil != nil && retentionEnabled(policy) && srcRetainUntil.After(now) && (retainUntil == nil || srcRetainUntil.After(*retainUntil)) {
+			retainUntil = srcRetainUntil
+		}
+
+		expiresAt, err := metadataTime(info.Metadata, expiresAtMetadataKey)
+		if err != nil {
+			return err
+		}
+
+		if expiresAt != nil {
+			if policy.MaxExpiry <= 0 {
+				return lerr.New(http.StatusBadRequest, "the object expires and expiry is not enabled for the destination provider")
+			}
+
+			if expiresAt.Sub(now) > time.Duration(policy.MaxExpiry) {
+				return lerr.Newf(http.StatusBadRequest, "the object expires later than the max-expiry of %s of the destination provider", time.Duration(policy.MaxExpiry))
+			}
+		}
+
+		if err := checkExpiry(retainUntil, expiresAt); err != nil {
+			return err
+		}
+
+		setLifecycle(opts, retainUntil, expiresAt)
+
+		return nil
+	}
+}
+
+// metadataTime returns the time stored in the metadata key, or nil if it is not set
+func metadataTime(metadata map[string]string, key string) (*time.Time, error) {
+	raw, ok := metadata[key]
+	if !ok {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return nil, lerr.Newf(http.StatusInternalServerError, "invalid %s %q on the source object", key, raw)
+	}
+
+	t = t.UTC()
+	return &t, nil
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/theleeeo/file-butler/lerr"
+	"github.com/theleeeo/file-butler/provider"
+)
+
+// defaultRetryAfter is how long clients are told to wait when the provider did not say
+const defaultRetryAfter = time.Second
+
+// providerErrorCode returns the HTTP status code that an error returned by a provider should be reported as
+func providerErrorCode(err error) int {
+	var detailedErr *lerr.DetailedError
+	if errors.As(err, &detailedErr) {
+		return detailedErr.Code()
+	}
+
+	switch {
+	case errors.Is(err, provider.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, provider.ErrDenied):
+		return http.StatusForbidden
+	case errors.Is(err, provider.ErrNoVersioning):
+		return http.StatusBadRequest
+	case errors.Is(err, provider.ErrNotModified):
+		return http.StatusNotModified
+	case errors.Is(err, provider.ErrPreconditionFailed):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, provider.ErrRangeNotSatisfiable):
+		return http.StatusRequestedRangeNotSatisfiable
+	case errors.Is(err, provider.ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, provider.ErrNotSupported):
+		return http.StatusNotImplemented
+	case errors.Is(err, provider.ErrArchived), errors.Is(err, provider.ErrNotArchived):
+		return http.StatusConflict
+	case errors.Is(err, provider.ErrThrottled):
+		return http.StatusTooManyRequests
+	case errors.Is(err, provider.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, provider.ErrMisconfigured):
+		return http.StatusBadGateway
+	}
+
+	return lerr.UnknownErrorCode
+}
+
+// writeProviderError writes an error returned by a provider with the status code it corresponds to.
+// Throttled and unavailable responses tell the client when to retry.
+func writeProviderError(w http.ResponseWriter, err error) {
+	code := providerErrorCode(err)
+	if code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(err)))
+	}
+
+	http.Error(w, err.Error(), code)
+}
+
+// retryAfterSeconds returns how many seconds the client should wait before retrying, rounded up so that it never retries too early
+func retryAfterSeconds(err error) int {
+	retryAfter := defaultRetryAfter
+
+	var retryErr *provider.RetryAfterError
+	if errors.As(err, &retryErr) && retryErr.RetryAfter > 0 {
+		retryAfter = retryErr.RetryAfter
+	}
+
+	return int(math.Ceil(retryAfter.Seconds()))
+}
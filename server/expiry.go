@@ -0,0 +1,234 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	authorization "github.com/theleeeo/file-butler/authorization/v1"
+	"github.com/theleeeo/file-butler/lerr"
+	"github.com/theleeeo/file-butler/provider"
+)
+
+const (
+	defaultExpirySweepInterval = 10 * time.Minute
+
+	// expiresAtMetadataKey is the metadata key that the expiry time of an object is stored in
+	expiresAtMetadataKey = "expires-at"
+
+	// expiryStatConcurrency is how many objects are checked for expiry at the same time when listing
+	expiryStatConcurrency = 16
+
+	// maxListExpiryChecks is the most objects that a listing checks for expiry, larger listings are returned unfiltered
+	maxListExpiryChecks = 1000
+)
+
+// parseExpiry returns the time the uploaded object expires, or nil if it does not expire.
+// The expiry is given as a duration from now in either the X-Expires-In header or the expires-in query parameter.
+func parseExpiry(r *http.Request, policy provider.Policy) (*time.Time, error) {
+	raw := r.Header.Get("X-Expires-In")
+	if raw == "" {
+		raw = r.URL.Query().Get("expires-in")
+	}
+
+	if raw == "" {
+		return nil, nil
+	}
+
+	if policy.MaxExpiry <= 0 {
+		return nil, lerr.New(http.StatusBadRequest, "expiry is not enabled for this provider")
+	}
+
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		return nil, lerr.Newf(http.StatusBadRequest, "invalid expiry %q, must be a positive duration like 24h", raw)
+	}
+
+	if d > time.Duration(policy.MaxExpiry) {
+		return nil, lerr.Newf(http.StatusBadRequest, "expiry can not be longer than %s", time.Duration(policy.MaxExpiry))
+	}
+
+	t := time.Now().Add(d).UTC().Truncate(time.Second)
+	return &t, nil
+}
+
+// isExpired reports if the object has an expiry that has passed
+func isExpired(info provider.ObjectInfo, now time.Time) bool {
+	raw, ok := info.Metadata[expiresAtMetadataKey]
+	if !ok {
+		return false
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		log.Printf("invalid expiry %q, the object is treated as not expired", raw)
+		return false
+	}
+
+	return !now.Before(expiresAt)
+}
+
+// expiredKeys returns which of the keys belong to expired objects.
+// The listing of the providers does not include the metadata, so each object is checked separately.
+func expiredKeys(ctx context.Context, p provider.Provider, keys []string) (map[string]bool, error) {
+	now := time.Now()
+
+	var mx sync.Mutex
+	expired := make(map[string]bool)
+	var firstErr error
+
+	sem := make(chan struct{}, expiryStatConcurrency)
+	var wg sync.WaitGroup
+
+	for _, k := range keys {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(key string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			info, err := provider.Stat(ctx, p, key)
+
+			mx.Lock()
+			defer mx.Unlock()
+
+			if err != nil {
+				// The object may have been deleted since it was listed
+				if !errors.Is(err, provider.ErrNotFound) && firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+
+			if isExpired(info, now) {
+				expired[key] = true
+			}
+		}(k)
+	}
+
+	wg.Wait()
+
+	return expired, firstErr
+}
+
+// filterExpired removes the keys of expired objects from the list.
+// Each object has to be checked separately, so a list with more than maxListExpiryChecks keys is returned as it is.
+// The expired objects in it can still not be downloaded and are deleted by the next sweep.
+func filterExpired(ctx context.Context, p provider.Provider, keys []string) ([]string, error) {
+	if p.Policy().MaxExpiry <= 0 || len(keys) > maxListExpiryChecks {
+		return keys, nil
+	}
+
+	expired, err := expiredKeys(ctx, p, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	visible := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if !expired[k] {
+			visible = append(visible, k)
+		}
+	}
+
+	return visible, nil
+}
+
+// sweepExpired deletes all expired objects in the provider.
+// The objects are deleted the same way as by a request, so they are moved to the trash if it is enabled and objects that are still retained are kept.
+func sweepExpired(ctx context.Context, p provider.Provider) (int, error) {
+	if p.Policy().MaxExpiry <= 0 {
+		return 0, nil
+	}
+
+	// Nothing can be deleted from read-only and append-only providers, their expired objects stay hidden instead
+	if checkMode(p, authorization.RequestType_REQUEST_TYPE_DELETE) != nil {
+		return 0, nil
+	}
+
+	resp, err := p.ListObjects(ctx, "")
+	if err != nil {
+		return 0, err
+	}
+
+	var keys []string
+	for _, k := range resp.Keys {
+		if !isHiddenKey(p, k) {
+			keys = append(keys, k)
+		}
+	}
+
+	expired, err := expiredKeys(ctx, p, keys)
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int
+	for k := range expired {
+		err := deleteObject(ctx, p, k, provider.DeleteOptions{})
+		if err != nil {
+			if errors.Is(err, provider.ErrNotFound) {
+				continue
+			}
+
+			// The object is deleted by a later sweep once its retention has passed
+			if providerErrorCode(err) == http.StatusForbidden {
+				log.Printf("expired object %s of provider %s is not deleted: %s", k, p.Id(), err)
+				continue
+			}
+
+			return deleted, err
+		}
+
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+// checkExpiry returns an error if the object would expire before its retention has passed
+func checkExpiry(retainUntil, expiresAt *time.Time) error {
+	if retainUntil == nil || expiresAt == nil || !expiresAt.Before(*retainUntil) {
+		return nil
+	}
+
+	return lerr.Newf(http.StatusBadRequest, "the object can not expire before it is retained until %s", retainUntil.Format(time.RFC3339))
+}
+
+// runExpirySweeper periodically deletes expired objects from all providers until the context is canceled
+func (s *Server) runExpirySweeper(ctx context.Context) {
+	interval := s.currentSettings().expirySweepInterval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// A changed interval takes effect from the next run
+		if current := s.currentSettings().expirySweepInterval; current != interval {
+			interval = current
+			ticker.Reset(interval)
+		}
+
+		providers, release := s.providerList()
+		for _, p := range providers {
+			deleted, err := sweepExpired(ctx, p)
+			if err != nil {
+				log.Printf("error deleting expired objects of provider %s: %s", p.Id(), err)
+			}
+
+			if deleted > 0 {
+				log.Printf("Deleted %d expired objects from provider %s", deleted, p.Id())
+			}
+		}
+		release()
+	}
+}
@@ -0,0 +1,375 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	authorization "github.com/theleeeo/file-butler/authorization/v1"
+	"github.com/theleeeo/file-butler/lerr"
+	"github.com/theleeeo/file-butler/provider"
+)
+
+const (
+	// maxExtractRatio is how many times larger the extracted files may be than the archive
+	maxExtractRatio = 100
+
+	// extractRatioThreshold is how many bytes must have been extracted before the ratio is checked, small archives can compress very well
+	extractRatioThreshold = 1 << 20
+)
+
+var (
+	errExtractTooLarge  = errors.New("the extracted files are larger than the allowed size")
+	errExtractTooMany   = errors.New("the archive contains too many files")
+	errExtractHighRatio = errors.New("the archive expands too much, it may be a zip bomb")
+)
+
+// extractEvent is a line in the streamed response of an extracting upload
+type extractEvent struct {
+	// Entry and Key are set when reporting the result of a single entry in the archive
+	Entry  string `json:"entry,omitempty"`
+	Key    string `json:"key,omitempty"`
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+
+	Uploaded int  `json:"uploaded"`
+	Failed   int  `json:"failed"`
+	Done     bool `json:"done,omitempty"`
+}
+
+// extractEntry is a file in an archive that is being extracted
+type extractEntry struct {
+	name    string
+	size    int64
+	regular bool
+	isDir   bool
+	open    func() (io.ReadCloser, error)
+}
+
+// extractGuard limits how much data can be extracted from an archive, both in total and relative to the size of the archive
+type extractGuard struct {
+	maxBytes  int64
+	extracted int64
+	// err is the limit that has been exceeded, it is kept since the providers may not wrap the errors of the reader
+	err error
+	// archiveBytes returns how many bytes of the archive have been read
+	archiveBytes func() int64
+}
+
+func (g *extractGuard) reader(r io.Reader) io.Reader {
+	return &guardedReader{g: g, r: r}
+}
+
+type guardedReader struct {
+	g *extractGuard
+	r io.Reader
+}
+
+func (gr *guardedReader) Read(p []byte) (int, error) {
+	n, err := gr.r.Read(p)
+	gr.g.extracted += int64(n)
+
+	if gr.g.extracted > gr.g.maxBytes {
+		gr.g.err = errExtractTooLarge
+	} else if gr.g.extracted > extractRatioThreshold && gr.g.extracted > gr.g.archiveBytes()*maxExtractRatio {
+		gr.g.err = errExtractHighRatio
+	}
+
+	if gr.g.err != nil {
+		return n, gr.g.err
+	}
+
+	return n, err
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// extractKey returns the key an archive entry is uploaded to.
+// Entries that would end up outside of the prefix are rejected.
+func extractKey(prefix, name string) (string, bool) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if path.IsAbs(name) {
+		return "", false
+	}
+
+	cleaned := path.Clean(name)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", false
+	}
+
+	return strings.TrimSuffix(prefix, "/") + "/" + cleaned, true
+}
+
+// handleExtract uploads every file in an archive to the prefix, keeping their paths from the archive
+func (s *Server) handleExtract(w http.ResponseWriter, r *http.Request, p provider.Provider, prefix, format string) {
+	if format != string(archiveFormatZip) && format != "tar" && format != string(archiveFormatTarGz) {
+		http.Error(w, fmt.Sprint("unsupported archive format: ", format), http.StatusBadRequest)
+		return
+	}
+
+	cfg := s.currentSettings()
+
+	baseOpts, err := parseUploadOptions(r, p)
+	if err != nil {
+		lerr.ToHTTP(w, err)
+		return
+	}
+
+	dataSrc, err := getDataSource(r, cfg.allowRawBody)
+	if err != nil {
+		lerr.ToHTTP(w, err)
+		return
+	}
+	defer dataSrc.Close()
+
+	body := &countingReader{r: dataSrc}
+	guard := &extractGuard{maxBytes: cfg.maxArchiveBytes, archiveBytes: func() int64 { return body.n }}
+
+	var next func() (extractEntry, error)
+
+	switch format {
+	case string(archiveFormatZip):
+		zr, cleanup, err := openZipUpload(body, cfg.maxArchiveBytes)
+		if err != nil {
+			lerr.ToHTTP(w, err)
+			return
+		}
+		defer cleanup()
+
+		if len(zr.File) > cfg.maxArchiveFiles {
+			lerr.ToHTTP(w, lerr.Newf(http.StatusRequestEntityTooLarge, "the archive contains %d files, the maximum is %d", len(zr.File), cfg.maxArchiveFiles))
+			return
+		}
+
+		i := 0
+		next = func() (extractEntry, error) {
+			if i >= len(zr.File) {
+				return extractEntry{}, io.EOF
+			}
+
+			f := zr.File[i]
+			i++
+
+			return extractEntry{
+				name:    f.Name,
+				size:    int64(f.UncompressedSize64),
+				regular: f.Mode().IsRegular(),
+				isDir:   f.Mode().IsDir(),
+				open:    f.Open,
+			}, nil
+		}
+
+	case "tar", string(archiveFormatTarGz):
+		var src io.Reader = body
+		if format == string(archiveFormatTarGz) {
+			gr, err := gzip.NewReader(body)
+			if err != nil {
+				http.Error(w, "invalid gzip data: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer gr.Close()
+			src = gr
+		}
+
+		tr := tar.NewReader(src)
+		next = func() (extractEntry, error) {
+			hdr, err := tr.Next()
+			if err != nil {
+				return extractEntry{}, err
+			}
+
+			return extractEntry{
+				name:    hdr.Name,
+				size:    hdr.Size,
+				regular: hdr.Typeflag == tar.TypeReg,
+				isDir:   hdr.Typeflag == tar.TypeDir,
+				open:    func() (io.ReadCloser, error) { return io.NopCloser(tr), nil },
+			}, nil
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	emit := func(e extractEvent) {
+		if err := enc.Encode(e); err != nil {
+			log.Println("error encoding extract progress:", err)
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	var progress extractEvent
+	files := 0
+
+	for {
+		entry, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			progress.Error = "invalid archive: " + err.Error()
+			break
+		}
+
+		if entry.isDir {
+			continue
+		}
+
+		files++
+		if files > cfg.maxArchiveFiles {
+			progress.Error = errExtractTooMany.Error()
+			break
+		}
+
+		result := extractEvent{Entry: entry.name}
+
+		key, err := s.uploadEntry(r.Context(), r.Header, p, prefix, entry, guard, baseOpts)
+		result.Key = key
+
+		if err != nil {
+			// The limits apply to the whole archive so there is no point in continuing
+			if errors.Is(err, errExtractTooLarge) || errors.Is(err, errExtractHighRatio) {
+				progress.Error = err.Error()
+				progress.Failed++
+				break
+			}
+
+			result.Status = providerErrorCode(err)
+			result.Error = err.Error()
+
+			// The internal errors are redacted the same way as for the other requests
+			if result.Status == http.StatusInternalServerError {
+				responseId := rand.Intn(1000000) //nolint:gosec // This is not for security purposes, it does not have to be cryptographically secure
+				log.Printf("internal error: %s, id: %d, extracting %s to %s/%s", err, responseId, entry.name, p.Id(), key)
+				result.Error = fmt.Sprintf("internal error, id: %d", responseId)
+			}
+
+			progress.Failed++
+		} else {
+			result.Status = http.StatusOK
+			progress.Uploaded++
+		}
+
+		result.Uploaded = progress.Uploaded
+		result.Failed = progress.Failed
+		emit(result)
+	}
+
+	progress.Done = true
+	emit(progress)
+}
+
+// uploadEntry authorizes and uploads a single entry of an archive, returning the key it was uploaded to
+func (s *Server) uploadEntry(ctx context.Context, headers http.Header, p provider.Provider, prefix string, entry extractEntry, guard *extractGuard, opts provider.PutOptions) (string, error) {
+	if !entry.regular {
+		return "", lerr.New(http.StatusBadRequest, "only regular files can be extracted")
+	}
+
+	key, ok := extractKey(prefix, entry.name)
+	if !ok {
+		return "", lerr.New(http.StatusBadRequest, "the path of the entry is outside of the target prefix")
+	}
+
+	if isHiddenKey(p, key) {
+		return key, lerr.New(http.StatusBadRequest, "the key is reserved")
+	}
+
+	if err := s.authorizeRequest(ctx, authorization.RequestType_REQUEST_TYPE_UPLOAD, headers, key, p); err != nil {
+		return key, err
+	}
+
+	if err := checkOverwrite(ctx, p, key); err != nil {
+		return key, err
+	}
+
+	// The declared size can be checked before anything is read, the guard catches archives that lie about it
+	if entry.size > guard.maxBytes-guard.extracted {
+		return key, errExtractTooLarge
+	}
+
+	data, err := entry.open()
+	if err != nil {
+		return key, lerr.Wrap(err, http.StatusBadRequest, "invalid entry")
+	}
+	defer data.Close()
+
+	opts.ContentLength = entry.size
+	opts.ContentType = mime.TypeByExtension(path.Ext(key))
+	if opts.ContentType == "" {
+		opts.ContentType = "application/octet-stream"
+	}
+
+	if err := p.PutObject(ctx, key, guard.reader(data), opts); err != nil {
+		if guard.err != nil {
+			return key, guard.err
+		}
+
+		if errors.Is(err, provider.ErrDenied) {
+			return key, lerr.Wrap(err, http.StatusForbidden, "error uploading object")
+		}
+
+		return key, err
+	}
+
+	return key, nil
+}
+
+// openZipUpload stores the uploaded zip archive in a temporary file, since a zip archive can not be read without random access
+func openZipUpload(body io.Reader, maxBytes int64) (*zip.Reader, func(), error) {
+	f, err := os.CreateTemp("", "file-butler-extract-*.zip")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cleanup := func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+
+	n, err := io.Copy(f, io.LimitReader(body, maxBytes+1))
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	if n > maxBytes {
+		cleanup()
+		return nil, nil, lerr.New(http.StatusRequestEntityTooLarge, "the archive is larger than the allowed size")
+	}
+
+	zr, err := zip.NewReader(f, n)
+	if err != nil {
+		cleanup()
+		return nil, nil, lerr.Wrap(err, http.StatusBadRequest, "invalid zip archive")
+	}
+
+	return zr, cleanup, nil
+}
@@ -8,7 +8,9 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"slices"
 	"strings"
+	"time"
 
 	authorization "github.com/theleeeo/file-butler/authorization/v1"
 	"github.com/theleeeo/file-butler/lerr"
@@ -36,7 +38,9 @@ func (s *Server) handleFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	providerName := r.PathValue("provider")
-	p := s.getProvider(providerName)
+	p, release := s.getProvider(providerName)
+	defer release()
+
 	if p == nil {
 		http.Error(w, "provider not found", http.StatusNotFound)
 		return
@@ -48,7 +52,24 @@ func (s *Server) handleFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := s.authorizeRequest(r.Context(), reqType, r.Header, key, p); err != nil {
+	if isHiddenKey(p, key) {
+		http.Error(w, provider.ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	// The mode is checked before the auth plugin is called since the request would be rejected regardless of who makes it
+	if err := checkMode(p, reqType); err != nil {
+		lerr.ToHTTP(w, err)
+		return
+	}
+
+	// Presigned URLs that point back to the server are authorized by their signature instead of the auth plugin
+	if r.URL.Query().Has(provider.ButlerSignatureParam) {
+		if !verifyPresigned(r, p, key, reqType) {
+			http.Error(w, "invalid or expired signature", http.StatusForbidden)
+			return
+		}
+	} else if err := s.authorizeRequest(r.Context(), reqType, r.Header, key, p); err != nil {
 		lerr.ToHTTP(w, err)
 		return
 	}
@@ -61,11 +82,17 @@ func (s *Server) handleFile(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
-			lerr.ToHTTP(w, err)
+			writeProviderError(w, err)
 			return
 		}
 		defer data.Close()
 
+		// Expired objects may not have been deleted by the sweeper yet
+		if isExpired(objectInfo, time.Now()) {
+			http.Error(w, provider.ErrNotFound.Error(), http.StatusNotFound)
+			return
+		}
+
 		if objectInfo.LastModified != nil {
 			w.Header().Set("Last-Modified", objectInfo.LastModified.Format(http.TimeFormat))
 		}
@@ -78,6 +105,20 @@ func (s *Server) handleFile(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", *objectInfo.ContentType)
 		}
 
+		if objectInfo.VersionID != nil {
+			w.Header().Set("X-Version-Id", *objectInfo.VersionID)
+		}
+
+		if objectInfo.ETag != nil {
+			w.Header().Set("ETag", *objectInfo.ETag)
+		}
+
+		// The provider only returned the requested part of the object
+		if objectInfo.ContentRange != nil {
+			w.Header().Set("Content-Range", *objectInfo.ContentRange)
+			w.WriteHeader(http.StatusPartialContent)
+		}
+
 		if _, err := io.Copy(w, data); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -87,8 +128,13 @@ func (s *Server) handleFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if reqType == authorization.RequestType_REQUEST_TYPE_UPLOAD {
+		if format := r.URL.Query().Get("extract"); format != "" {
+			s.handleExtract(w, r, p, key, format)
+			return
+		}
+
 		if err := s.handleUpload(r, p, key); err != nil {
-			lerr.ToHTTP(w, err)
+			writeProviderError(w, err)
 			return
 		}
 
@@ -97,13 +143,8 @@ func (s *Server) handleFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if reqType == authorization.RequestType_REQUEST_TYPE_DELETE {
-		if err := p.DeleteObject(r.Context(), key); err != nil {
-			if errors.Is(err, provider.ErrNotFound) {
-				http.Error(w, err.Error(), http.StatusNotFound)
-				return
-			}
-
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if err := deleteObject(r.Context(), p, key, provider.DeleteOptions{VersionID: r.URL.Query().Get("version")}); err != nil {
+			writeProviderError(w, err)
 			return
 		}
 
@@ -114,8 +155,48 @@ func (s *Server) handleFile(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// verifyPresigned reports if the request has a valid signature from a provider that presigns URLs to the server itself
+func verifyPresigned(r *http.Request, p provider.Provider, key string, reqType authorization.RequestType) bool {
+	verifier, ok := p.(provider.PresignVerifier)
+	if !ok {
+		return false
+	}
+
+	switch reqType {
+	case authorization.RequestType_REQUEST_TYPE_DOWNLOAD:
+		return verifier.VerifyPresigned(key, provider.PresignOperationDownload, r.URL.Query())
+	case authorization.RequestType_REQUEST_TYPE_UPLOAD:
+		return verifier.VerifyPresigned(key, provider.PresignOperationUpload, r.URL.Query())
+	}
+
+	return false
+}
+
+// deleteObject deletes the object, or moves it to the trash if the provider has soft deletes enabled.
+// Deleting a specific version is always permanent since the other versions are kept by the provider anyway.
+func deleteObject(ctx context.Context, p provider.Provider, key string, opts provider.DeleteOptions) error {
+	if err := checkRetention(ctx, p, key); err != nil {
+		return err
+	}
+
+	if p.Policy().Trash != nil && opts.VersionID == "" {
+		return trashObject(ctx, p, key)
+	}
+
+	return p.DeleteObject(ctx, key, opts)
+}
+
 func (s *Server) handleUpload(r *http.Request, prov provider.Provider, key string) error {
-	dataSrc, err := getDataSource(r, s.allowRawBody)
+	if err := checkOverwrite(r.Context(), prov, key); err != nil {
+		return err
+	}
+
+	opts, err := parseUploadOptions(r, prov)
+	if err != nil {
+		return err
+	}
+
+	dataSrc, err := getDataSource(r, s.currentSettings().allowRawBody)
 	if err != nil {
 		return err
 	}
@@ -140,23 +221,17 @@ func (s *Server) handleUpload(r *http.Request, prov provider.Provider, key strin
 		dataSrc = io.NopCloser(strings.NewReader(string(body)))
 	}
 
-	tags, err := parseTags(r.URL.Query()["tag"])
-	if err != nil {
-		return err
-	}
-
 	contentType := r.Header.Get("Content-Type")
 	if contentType == "" {
 		contentType = http.DetectContentType(nil)
 	}
 
-	if err := prov.PutObject(r.Context(), key, dataSrc, provider.PutOptions{
-		ContentType:   contentType,
-		ContentLength: contentLength,
-		Tags:          tags,
-	}); err != nil {
-		if errors.Is(err, provider.ErrDenied) {
-			return lerr.Wrap(err, http.StatusForbidden, "error uploading object")
+	opts.ContentType = contentType
+	opts.ContentLength = contentLength
+
+	if err := prov.PutObject(r.Context(), key, dataSrc, opts); err != nil {
+		if code := providerErrorCode(err); code != lerr.UnknownErrorCode {
+			return lerr.Wrap(err, code, "error uploading object")
 		}
 
 		return lerr.New(http.StatusInternalServerError, err.Error())
@@ -165,6 +240,68 @@ func (s *Server) handleUpload(r *http.Request, prov provider.Provider, key strin
 	return nil
 }
 
+// parseUploadOptions returns the tags, retention and expiry of an upload from the request
+func parseUploadOptions(r *http.Request, prov provider.Provider) (provider.PutOptions, error) {
+	retainUntil, err := parseRetainUntil(r, prov.Policy())
+	if err != nil {
+		return provider.PutOptions{}, err
+	}
+
+	expiresAt, err := parseExpiry(r, prov.Policy())
+	if err != nil {
+		return provider.PutOptions{}, err
+	}
+
+	if err := checkExpiry(retainUntil, expiresAt); err != nil {
+		return provider.PutOptions{}, err
+	}
+
+	tags, err := parseTags(r.URL.Query()["tag"])
+	if err != nil {
+		return provider.PutOptions{}, err
+	}
+
+	storageClass, err := parseStorageClass(r, prov)
+	if err != nil {
+		return provider.PutOptions{}, err
+	}
+
+	opts := provider.PutOptions{
+		Tags:         tags,
+		StorageClass: storageClass,
+	}
+
+	setLifecycle(&opts, retainUntil, expiresAt)
+
+	return opts, nil
+}
+
+// setLifecycle sets the retention and expiry that an object is written with, replacing any that are already in the options
+func setLifecycle(opts *provider.PutOptions, retainUntil, expiresAt *time.Time) {
+	delete(opts.Metadata, retainUntilMetadataKey)
+	delete(opts.Metadata, expiresAtMetadataKey)
+	opts.RetainUntil = retainUntil
+
+	if retainUntil == nil && expiresAt == nil {
+		if len(opts.Metadata) == 0 {
+			opts.Metadata = nil
+		}
+		return
+	}
+
+	if opts.Metadata == nil {
+		opts.Metadata = make(map[string]string)
+	}
+
+	if retainUntil != nil {
+		opts.Metadata[retainUntilMetadataKey] = retainUntil.Format(time.RFC3339)
+	}
+
+	if expiresAt != nil {
+		opts.Metadata[expiresAtMetadataKey] = expiresAt.Format(time.RFC3339)
+	}
+}
+
 func parseTags(rawTags []string) (map[string]string, error) {
 	if len(rawTags) == 0 {
 		return nil, nil
@@ -191,6 +328,25 @@ func parseTags(rawTags []string) (map[string]string, error) {
 	return parsedTags, nil
 }
 
+// parseStorageClass returns the storage class requested with the X-Storage-Class header, if the provider allows it
+func parseStorageClass(r *http.Request, prov provider.Provider) (string, error) {
+	storageClass := r.Header.Get("X-Storage-Class")
+	if storageClass == "" {
+		return "", nil
+	}
+
+	classer, ok := prov.(provider.StorageClasser)
+	if !ok {
+		return "", lerr.New(http.StatusBadRequest, "the storage class can not be chosen for this provider")
+	}
+
+	if !slices.Contains(classer.AllowedStorageClasses(), storageClass) {
+		return "", lerr.Newf(http.StatusBadRequest, "storage class %s is not allowed", storageClass)
+	}
+
+	return storageClass, nil
+}
+
 func getDataSource(r *http.Request, allowRawBody bool) (io.ReadCloser, error) {
 	var data io.ReadCloser
 
@@ -234,31 +390,43 @@ func (s *Server) handleDownload(r *http.Request, prov provider.Provider, key str
 		opts.LastModified = &t
 	}
 
+	if r.Header.Get("If-Unmodified-Since") != "" {
+		t, err := http.ParseTime(r.Header.Get("If-Unmodified-Since"))
+		if err != nil {
+			return nil, provider.ObjectInfo{}, lerr.New(http.StatusBadRequest, "invalid If-Unmodified-Since header")
+		}
+
+		opts.IfUnmodifiedSince = &t
+	}
+
+	opts.IfNoneMatch = r.Header.Get("If-None-Match")
+	opts.IfMatch = r.Header.Get("If-Match")
+
+	// Ignoring the conditions would return the object when the client expects it not to be
+	if _, ok := prov.(provider.ConditionalGetter); !ok && opts.HasConditions() {
+		return nil, provider.ObjectInfo{}, lerr.New(http.StatusNotImplemented, "If-Match, If-None-Match and If-Unmodified-Since are not supported by this provider")
+	}
+
+	opts.VersionID = r.URL.Query().Get("version")
+	opts.Range = r.Header.Get("Range")
+
 	data, objectInfo, err := prov.GetObject(r.Context(), key, opts)
 	if err != nil {
-		if errors.Is(err, provider.ErrNotFound) {
-			return nil, provider.ObjectInfo{}, lerr.Newf(http.StatusNotFound, err.Error())
-		}
-		if errors.Is(err, provider.ErrDenied) {
-			return nil, provider.ObjectInfo{}, lerr.New(http.StatusForbidden, err.Error())
-		}
-		if errors.Is(err, provider.ErrNotModified) {
+		// The errors of the provider are returned as they are so that the status code can be chosen from them
+		if providerErrorCode(err) != lerr.UnknownErrorCode {
 			return nil, provider.ObjectInfo{}, err
 		}
 
-		return nil, provider.ObjectInfo{}, lerr.Newf(http.StatusInternalServerError, "error getting object from provider: %s", err.Error())
+		return nil, provider.ObjectInfo{}, fmt.Errorf("error getting object from provider: %w", err)
 	}
 
 	return data, objectInfo, nil
 }
 
 func (s *Server) authorizeRequest(ctx context.Context, reqType authorization.RequestType, headers map[string][]string, key string, p provider.Provider) error {
-	authPluginName := p.AuthPlugin()
-	if authPluginName == "" {
-		authPluginName = s.defaultAuthPlugin
-	}
+	authPlugin, release := s.getPlugin(p.AuthPlugin())
+	defer release()
 
-	authPlugin := s.getPlugin(authPluginName)
 	if authPlugin == nil {
 		return lerr.Newf(http.StatusInternalServerError, "no auth plugin found for provider %s", p.Id())
 	}
@@ -296,7 +464,9 @@ func (s *Server) authorizeRequest(ctx context.Context, reqType authorization.Req
 
 func (s *Server) handlePresign(w http.ResponseWriter, r *http.Request) {
 	providerName := r.PathValue("provider")
-	p := s.getProvider(providerName)
+	p, release := s.getProvider(providerName)
+	defer release()
+
 	if p == nil {
 		http.Error(w, "provider not found", http.StatusNotFound)
 		return
@@ -314,6 +484,11 @@ func (s *Server) handlePresign(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if isHiddenKey(p, key) {
+		http.Error(w, provider.ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
 	op := r.URL.Query().Get("op")
 	if op == "" {
 		http.Error(w, "presign operation is required", http.StatusBadRequest)
@@ -334,33 +509,69 @@ func (s *Server) handlePresign(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := checkMode(p, reqType); err != nil {
+		lerr.ToHTTP(w, err)
+		return
+	}
+
+	// A presigned upload goes directly to the provider so the server can not prevent it from overwriting existing objects
+	if mode := p.Policy().Mode; presignOp == provider.PresignOperationUpload && (mode == provider.ModeWriteOnce || mode == provider.ModeAppendOnly) {
+		http.Error(w, fmt.Sprintf("presigned uploads are not allowed for %s providers", mode), http.StatusForbidden)
+		return
+	}
+
 	if err := s.authorizeRequest(r.Context(), reqType, r.Header, key, p); err != nil {
 		lerr.ToHTTP(w, err)
 		return
 	}
 
-	url, err := presigner.PresignURL(r.Context(), key, presignOp)
-	if err != nil {
-		if errors.Is(err, provider.ErrDenied) {
-			http.Error(w, err.Error(), http.StatusForbidden)
+	var presignOpts provider.PresignOptions
+	if presignOp == provider.PresignOperationUpload {
+		storageClass, err := parseStorageClass(r, p)
+		if err != nil {
+			lerr.ToHTTP(w, err)
 			return
 		}
+		presignOpts.StorageClass = storageClass
+	}
+
+	var url string
+	var headers http.Header
+	var err error
 
+	if headerPresigner, ok := p.(provider.HeaderPresigner); ok {
+		url, headers, err = headerPresigner.PresignURLWithHeaders(r.Context(), key, presignOp, presignOpts)
+	} else if presignOpts.StorageClass != "" {
+		http.Error(w, "the storage class can not be chosen for presigned uploads to this provider", http.StatusBadRequest)
+		return
+	} else {
+		url, err = presigner.PresignURL(r.Context(), key, presignOp)
+	}
+	if err != nil {
 		if errors.Is(err, provider.ErrNoPresign) {
 			http.Error(w, err.Error(), http.StatusNotFound)
 			return
 		}
 
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeProviderError(w, err)
 		return
 	}
 
+	// The headers that the signature covers must be sent by the client, they are listed as "<name>: <value>"
+	for name, values := range headers {
+		for _, v := range values {
+			w.Header().Add("X-Presign-Header", name+": "+v)
+		}
+	}
+
 	_, _ = w.Write([]byte(url))
 }
 
 func (s *Server) handleMetadata(w http.ResponseWriter, r *http.Request) {
 	providerName := r.PathValue("provider")
-	p := s.getProvider(providerName)
+	p, release := s.getProvider(providerName)
+	defer release()
+
 	if p == nil {
 		http.Error(w, "provider not found", http.StatusNotFound)
 		return
@@ -372,6 +583,11 @@ func (s *Server) handleMetadata(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if isHiddenKey(p, key) {
+		http.Error(w, provider.ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
 	if err := s.authorizeRequest(r.Context(), authorization.RequestType_REQUEST_TYPE_GET_METADATA, r.Header, key, p); err != nil {
 		lerr.ToHTTP(w, err)
 		return
@@ -379,23 +595,29 @@ func (s *Server) handleMetadata(w http.ResponseWriter, r *http.Request) {
 
 	tags, err := p.GetTags(r.Context(), key)
 	if err != nil {
-		if errors.Is(err, provider.ErrNotFound) {
-			http.Error(w, err.Error(), http.StatusNotFound)
-			return
-		}
-
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeProviderError(w, err)
 		return
 	}
 
 	type metadataResponse struct {
-		Tags map[string]string `json:"tags,omitempty"`
+		Tags    map[string]string       `json:"tags,omitempty"`
+		Archive *provider.ArchiveStatus `json:"archive,omitempty"`
 	}
 
 	metadata := metadataResponse{
 		Tags: tags,
 	}
 
+	// The archive status tells the client if the object must be restored before it can be downloaded
+	if restorer, ok := p.(provider.ArchiveRestorer); ok {
+		status, err := restorer.ArchiveStatus(r.Context(), key)
+		if err != nil {
+			writeProviderError(w, err)
+			return
+		}
+		metadata.Archive = &status
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(metadata); err != nil {
 		log.Println("error encoding metadata:", err)
@@ -405,7 +627,9 @@ func (s *Server) handleMetadata(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) handleTags(w http.ResponseWriter, r *http.Request) {
 	providerName := r.PathValue("provider")
-	p := s.getProvider(providerName)
+	p, release := s.getProvider(providerName)
+	defer release()
+
 	if p == nil {
 		http.Error(w, "provider not found", http.StatusNotFound)
 		return
@@ -417,6 +641,11 @@ func (s *Server) handleTags(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if isHiddenKey(p, key) {
+		http.Error(w, provider.ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
 	if err := s.authorizeRequest(r.Context(), authorization.RequestType_REQUEST_TYPE_GET_METADATA, r.Header, key, p); err != nil {
 		lerr.ToHTTP(w, err)
 		return
@@ -424,12 +653,7 @@ func (s *Server) handleTags(w http.ResponseWriter, r *http.Request) {
 
 	tags, err := p.GetTags(r.Context(), key)
 	if err != nil {
-		if errors.Is(err, provider.ErrNotFound) {
-			http.Error(w, err.Error(), http.StatusNotFound)
-			return
-		}
-
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeProviderError(w, err)
 		return
 	}
 
@@ -444,7 +668,9 @@ func (s *Server) handleTags(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
 	providerName := r.PathValue("provider")
-	p := s.getProvider(providerName)
+	p, release := s.getProvider(providerName)
+	defer release()
+
 	if p == nil {
 		http.Error(w, "provider not found", http.StatusNotFound)
 		return
@@ -457,9 +683,41 @@ func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	objects, err := p.ListObjects(r.Context(), prefix)
+	var objects provider.ListObjectsResponse
+	var err error
+
+	// With a delimiter the keys are grouped like directories, eg. to only list one level of a hierarchy
+	if delimiter := r.URL.Query().Get("delimiter"); delimiter != "" {
+		objects, err = provider.ListDelimited(r.Context(), p, prefix, delimiter)
+	} else {
+		objects, err = p.ListObjects(r.Context(), prefix)
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeProviderError(w, err)
+		return
+	}
+
+	if p.Policy().Trash != nil {
+		visible := make([]string, 0, len(objects.Keys))
+		for _, k := range objects.Keys {
+			if !isHiddenKey(p, k) {
+				visible = append(visible, k)
+			}
+		}
+		objects.Keys = visible
+
+		var visiblePrefixes []string
+		for _, cp := range objects.CommonPrefixes {
+			if !isHiddenKey(p, cp) {
+				visiblePrefixes = append(visiblePrefixes, cp)
+			}
+		}
+		objects.CommonPrefixes = visiblePrefixes
+	}
+
+	objects.Keys, err = filterExpired(r.Context(), p, objects.Keys)
+	if err != nil {
+		writeProviderError(w, err)
 		return
 	}
 
@@ -470,3 +728,173 @@ func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
+
+func (s *Server) handleListVersions(w http.ResponseWriter, r *http.Request) {
+	providerName := r.PathValue("provider")
+	p, release := s.getProvider(providerName)
+	defer release()
+
+	if p == nil {
+		http.Error(w, "provider not found", http.StatusNotFound)
+		return
+	}
+
+	versioner, ok := p.(provider.Versioner)
+	if !ok {
+		http.Error(w, provider.ErrNoVersioning.Error(), http.StatusNotFound)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/versions/"+providerName+"/")
+	if key == "" {
+		http.Error(w, "key is required", http.StatusBadRequest)
+		return
+	}
+
+	if isHiddenKey(p, key) {
+		http.Error(w, provider.ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err := s.authorizeRequest(r.Context(), authorization.RequestType_REQUEST_TYPE_LIST_VERSIONS, r.Header, key, p); err != nil {
+		lerr.ToHTTP(w, err)
+		return
+	}
+
+	versions, err := versioner.ListVersions(r.Context(), key)
+	if err != nil {
+		writeProviderError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(versions); err != nil {
+		log.Println("error encoding versions:", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) handleRestoreVersion(w http.ResponseWriter, r *http.Request) {
+	providerName := r.PathValue("provider")
+	p, release := s.getProvider(providerName)
+	defer release()
+
+	if p == nil {
+		http.Error(w, "provider not found", http.StatusNotFound)
+		return
+	}
+
+	versioner, ok := p.(provider.Versioner)
+	if !ok {
+		http.Error(w, provider.ErrNoVersioning.Error(), http.StatusNotFound)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/versions/"+providerName+"/")
+	if key == "" {
+		http.Error(w, "key is required", http.StatusBadRequest)
+		return
+	}
+
+	if isHiddenKey(p, key) {
+		http.Error(w, provider.ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	versionID := r.URL.Query().Get("version")
+	if versionID == "" {
+		http.Error(w, "version is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := checkMode(p, authorization.RequestType_REQUEST_TYPE_RESTORE_VERSION); err != nil {
+		lerr.ToHTTP(w, err)
+		return
+	}
+
+	if err := s.authorizeRequest(r.Context(), authorization.RequestType_REQUEST_TYPE_RESTORE_VERSION, r.Header, key, p); err != nil {
+		lerr.ToHTTP(w, err)
+		return
+	}
+
+	if err := versioner.RestoreVersion(r.Context(), key, versionID); err != nil {
+		writeProviderError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleListTrash(w http.ResponseWriter, r *http.Request) {
+	providerName := r.PathValue("provider")
+	p, release := s.getProvider(providerName)
+	defer release()
+
+	if p == nil {
+		http.Error(w, "provider not found", http.StatusNotFound)
+		return
+	}
+
+	if p.Policy().Trash == nil {
+		http.Error(w, "trash is not enabled for this provider", http.StatusNotFound)
+		return
+	}
+
+	prefix := strings.TrimPrefix(r.URL.Path, "/trash/"+providerName+"/")
+
+	if err := s.authorizeRequest(r.Context(), authorization.RequestType_REQUEST_TYPE_LIST_TRASH, r.Header, prefix, p); err != nil {
+		lerr.ToHTTP(w, err)
+		return
+	}
+
+	objects, err := listTrash(r.Context(), p, prefix)
+	if err != nil {
+		writeProviderError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(listTrashResponse{Objects: objects}); err != nil {
+		log.Println("error encoding trash:", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) handleRestoreTrash(w http.ResponseWriter, r *http.Request) {
+	providerName := r.PathValue("provider")
+	p, release := s.getProvider(providerName)
+	defer release()
+
+	if p == nil {
+		http.Error(w, "provider not found", http.StatusNotFound)
+		return
+	}
+
+	if p.Policy().Trash == nil {
+		http.Error(w, "trash is not enabled for this provider", http.StatusNotFound)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/trash/"+providerName+"/")
+	if key == "" {
+		http.Error(w, "key is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := checkMode(p, authorization.RequestType_REQUEST_TYPE_RESTORE_TRASH); err != nil {
+		lerr.ToHTTP(w, err)
+		return
+	}
+
+	if err := s.authorizeRequest(r.Context(), authorization.RequestType_REQUEST_TYPE_RESTORE_TRASH, r.Header, key, p); err != nil {
+		lerr.ToHTTP(w, err)
+		return
+	}
+
+	if err := restoreTrash(r.Context(), p, key, r.URL.Query().Get("id")); err != nil {
+		writeProviderError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
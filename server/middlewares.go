@@ -1,39 +1,85 @@
 package server
 
 import (
+	"bytes"
 	"fmt"
 	"log"
 	"math/rand"
 	"net/http"
-	"net/http/httptest"
 )
 
 // InternalErrorRedacter is a middleware that will redact internal error messages.
 // It will replace the response body with a generic message and an id and log the original message.
+// Other responses are passed through as they are written so that handlers can stream their responses.
 func InternalErrorRedacter(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		respCatcher := httptest.NewRecorder()
-		next.ServeHTTP(respCatcher, r)
+		rw := &redactingResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
 
-		if respCatcher.Code == http.StatusInternalServerError {
+		if rw.redacted {
 			responseId := rand.Intn(1000000) //nolint:gosec // This is not for security purposes, it does not have to be cryptographically secure
 
-			copyHeaders(w.Header(), respCatcher.Header())
+			w.Header().Del("Content-Length")
 			w.WriteHeader(http.StatusInternalServerError)
 			_, _ = w.Write([]byte(fmt.Sprintf("internal error, id: %d", responseId)))
 
-			log.Printf("internal error: %s, id: %d, path: %s", removeTrailingNewline(respCatcher.Body.String()), responseId, r.URL.Path)
+			log.Printf("internal error: %s, id: %d, path: %s", removeTrailingNewline(rw.body.String()), responseId, r.URL.Path)
 			return
 		}
 
-		log.Printf("%s %s %d", r.Method, r.URL.Path, respCatcher.Code)
+		if rw.code == 0 {
+			rw.code = http.StatusOK
+		}
 
-		copyHeaders(w.Header(), respCatcher.Header())
-		w.WriteHeader(respCatcher.Code)
-		_, _ = w.Write(respCatcher.Body.Bytes())
+		log.Printf("%s %s %d", r.Method, r.URL.Path, rw.code)
 	})
 }
 
+// redactingResponseWriter holds back the body of internal errors so that it can be replaced, everything else is written directly
+type redactingResponseWriter struct {
+	http.ResponseWriter
+
+	code     int
+	redacted bool
+	body     bytes.Buffer
+}
+
+func (w *redactingResponseWriter) WriteHeader(code int) {
+	if w.code != 0 {
+		return
+	}
+
+	w.code = code
+	if code == http.StatusInternalServerError {
+		w.redacted = true
+		return
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *redactingResponseWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.redacted {
+		return w.body.Write(b)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *redactingResponseWriter) Flush() {
+	if w.redacted {
+		return
+	}
+
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 func CorsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -55,9 +101,3 @@ func removeTrailingNewline(m string) string {
 	}
 	return m
 }
-
-func copyHeaders(dst, src http.Header) {
-	for k, v := range src {
-		dst[k] = v
-	}
-}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	authorization "github.com/theleeeo/file-butler/authorization/v1"
+	"github.com/theleeeo/file-butler/lerr"
+	"github.com/theleeeo/file-butler/provider"
+)
+
+const (
+	// retainUntilMetadataKey is the metadata key that the retention of an object is stored in
+	retainUntilMetadataKey = "retain-until"
+)
+
+// checkMode returns an error if the mode of the provider does not allow the request type at all.
+// It does not need to look at the object so it is done before the request is authorized.
+func checkMode(p provider.Provider, reqType authorization.RequestType) error {
+	mode := p.Policy().Mode
+
+	switch reqType {
+	case authorization.RequestType_REQUEST_TYPE_UPLOAD, authorization.RequestType_REQUEST_TYPE_SET_TAGS:
+		if mode == provider.ModeReadOnly {
+			return lerr.New(http.StatusForbidden, "the provider is read-only")
+		}
+	case authorization.RequestType_REQUEST_TYPE_DELETE, authorization.RequestType_REQUEST_TYPE_DELETE_PREFIX:
+		if mode == provider.ModeReadOnly {
+			return lerr.New(http.StatusForbidden, "the provider is read-only")
+		}
+
+		if mode == provider.ModeAppendOnly {
+			return lerr.New(http.StatusForbidden, "objects can not be deleted from an append-only provider")
+		}
+	case authorization.RequestType_REQUEST_TYPE_RESTORE_VERSION, authorization.RequestType_REQUEST_TYPE_RESTORE_TRASH:
+		// Restoring always overwrites the current object
+		if mode != provider.ModeReadWrite {
+			return lerr.Newf(http.StatusForbidden, "restoring objects is not allowed for %s providers", mode)
+		}
+	}
+
+	return nil
+}
+
+// checkOverwrite returns an error if the object exists and the provider does not allow objects to be overwritten
+func checkOverwrite(ctx context.Context, p provider.Provider, key string) error {
+	mode := p.Policy().Mode
+	if mode != provider.ModeWriteOnce && mode != provider.ModeAppendOnly {
+		return nil
+	}
+
+	_, err := provider.Stat(ctx, p, key)
+	if err == nil {
+		return lerr.Newf(http.StatusConflict, "the object already exists and can not be overwritten in a %s provider", mode)
+	}
+
+	if errors.Is(err, provider.ErrNotFound) {
+		return nil
+	}
+
+	return lerr.Wrap(err, http.StatusInternalServerError, "unable to check if the object exists")
+}
+
+// retentionEnabled reports if objects in the provider can have a retention.
+// Only write-once providers or providers with a default retention accept retention on uploads, so the other providers do not have to check it when deleting.
+func retentionEnabled(policy provider.Policy) bool {
+	return policy.Mode == provider.ModeWriteOnce || policy.Retention > 0
+}
+
+// checkRetention returns an error if the object is under retention and can not be deleted yet
+func checkRetention(ctx context.Context, p provider.Provider, key string) error {
+	if !retentionEnabled(p.Policy()) {
+		return nil
+	}
+
+	info, err := provider.Stat(ctx, p, key)
+	if err != nil {
+		if errors.Is(err, provider.ErrNotFound) {
+			// Let the delete itself decide how to handle a missing object
+			return nil
+		}
+
+		return lerr.Wrap(err, http.StatusInternalServerError, "unable to check the retention of the object")
+	}
+
+	raw, ok := info.Metadata[retainUntilMetadataKey]
+	if !ok {
+		return nil
+	}
+
+	retainUntil, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return lerr.Newf(http.StatusInternalServerError, "invalid retention %q on object %s", raw, key)
+	}
+
+	if time.Now().Before(retainUntil) {
+		return lerr.Newf(http.StatusForbidden, "the object is retained until %s", retainUntil.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// parseRetainUntil returns the time the uploaded object must be retained until, or nil if it has no retention.
+// The provider retention is the minimum, an upload can only extend it using the X-Retain-Until header.
+func parseRetainUntil(r *http.Request, policy provider.Policy) (*time.Time, error) {
+	var retainUntil *time.Time
+
+	if policy.Retention > 0 {
+		t := time.Now().Add(time.Duration(policy.Retention)).UTC().Truncate(time.Second)
+		retainUntil = &t
+	}
+
+	if h := r.Header.Get("X-Retain-Until"); h != "" {
+		if !retentionEnabled(policy) {
+			return nil, lerr.New(http.StatusBadRequest, "retention is not enabled for this provider")
+		}
+
+		t, err := time.Parse(time.RFC3339, h)
+		if err != nil {
+			return nil, lerr.New(http.StatusBadRequest, "invalid X-Retain-Until header, must be RFC3339")
+		}
+
+		if retainUntil != nil && t.Before(*retainUntil) {
+			return nil, lerr.Newf(http.StatusBadRequest, "X-Retain-Until can not be earlier than the provider retention of %s", time.Duration(policy.Retention))
+		}
+
+		t = t.UTC()
+		retainUntil = &t
+	}
+
+	return retainUntil, nil
+}
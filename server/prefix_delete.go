@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	authorization "github.com/theleeeo/file-butler/authorization/v1"
+	"github.com/theleeeo/file-butler/lerr"
+	"github.com/theleeeo/file-butler/provider"
+)
+
+const (
+	// prefixDeleteBatchSize is how many keys are deleted between each progress report
+	prefixDeleteBatchSize = 1000
+
+	// prefixDeleteConcurrency is how many objects are deleted at the same time when the provider does not support batch deletes
+	prefixDeleteConcurrency = 16
+)
+
+// prefixDeleteEvent is a line in the streamed response of a prefix delete
+type prefixDeleteEvent struct {
+	// Key is set when reporting a single object, either one that would be deleted in a dry-run or one that failed
+	Key   string `json:"key,omitempty"`
+	Error string `json:"error,omitempty"`
+
+	Deleted int  `json:"deleted"`
+	Failed  int  `json:"failed"`
+	Total   int  `json:"total"`
+	DryRun  bool `json:"dry_run,omitempty"`
+	Done    bool `json:"done,omitempty"`
+}
+
+func (s *Server) handleDeletePrefix(w http.ResponseWriter, r *http.Request) {
+	providerName := r.PathValue("provider")
+	p, release := s.getProvider(providerName)
+	defer release()
+
+	if p == nil {
+		http.Error(w, "provider not found", http.StatusNotFound)
+		return
+	}
+
+	prefix := strings.TrimPrefix(r.URL.Path, "/prefix/"+providerName+"/")
+	// Deleting everything in a provider is too easy to do by mistake to be allowed
+	if prefix == "" {
+		http.Error(w, "prefix is required", http.StatusBadRequest)
+		return
+	}
+
+	if isHiddenKey(p, prefix) {
+		http.Error(w, provider.ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	dryRun := r.URL.Query().Get("dry-run") == "true"
+
+	// The prefix must be repeated to confirm the delete, to protect against requests to the wrong path
+	if !dryRun && r.URL.Query().Get("confirm") != prefix {
+		http.Error(w, "the confirm parameter must be set to the prefix to delete", http.StatusBadRequest)
+		return
+	}
+
+	if err := checkMode(p, authorization.RequestType_REQUEST_TYPE_DELETE_PREFIX); err != nil {
+		lerr.ToHTTP(w, err)
+		return
+	}
+
+	if err := s.authorizeRequest(r.Context(), authorization.RequestType_REQUEST_TYPE_DELETE_PREFIX, r.Header, prefix, p); err != nil {
+		lerr.ToHTTP(w, err)
+		return
+	}
+
+	objects, err := p.ListObjects(r.Context(), prefix)
+	if err != nil {
+		writeProviderError(w, err)
+		return
+	}
+
+	var keys []string
+	for _, k := range objects.Keys {
+		if !isHiddenKey(p, k) {
+			keys = append(keys, k)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	emit := func(e prefixDeleteEvent) {
+		if err := enc.Encode(e); err != nil {
+			log.Println("error encoding prefix delete progress:", err)
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	progress := prefixDeleteEvent{Total: len(keys), DryRun: dryRun}
+
+	if dryRun {
+		for _, k := range keys {
+			emit(prefixDeleteEvent{Key: k, Total: len(keys), DryRun: true})
+		}
+
+		progress.Done = true
+		emit(progress)
+		return
+	}
+
+	for start := 0; start < len(keys); start += prefixDeleteBatchSize {
+		batch := keys[start:min(start+prefixDeleteBatchSize, len(keys))]
+
+		failed, err := s.deleteBatch(r.Context(), p, batch)
+		if err != nil {
+			// The whole batch failed, there is no point in continuing with the rest
+			progress.Error = err.Error()
+			progress.Failed += len(batch)
+			break
+		}
+
+		for k, err := range failed {
+			emit(prefixDeleteEvent{Key: k, Error: err.Error(), Total: len(keys)})
+		}
+
+		progress.Deleted += len(batch) - len(failed)
+		progress.Failed += len(failed)
+		emit(progress)
+	}
+
+	progress.Done = true
+	emit(progress)
+}
+
+// deleteBatch deletes the keys using the batch delete of the provider if possible.
+// Providers with a trash or retention must go through deleteObject for each key so that it is respected.
+func (s *Server) deleteBatch(ctx context.Context, p provider.Provider, keys []string) (map[string]error, error) {
+	policy := p.Policy()
+
+	if batchDeleter, ok := p.(provider.BatchDeleter); ok && policy.Trash == nil && !retentionEnabled(policy) {
+		return batchDeleter.DeleteObjects(ctx, keys)
+	}
+
+	var mx sync.Mutex
+	failed := make(map[string]error)
+
+	sem := make(chan struct{}, prefixDeleteConcurrency)
+	var wg sync.WaitGroup
+
+	for _, k := range keys {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(key string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := deleteObject(ctx, p, key, provider.DeleteOptions{}); err != nil && !errors.Is(err, provider.ErrNotFound) {
+				mx.Lock()
+				failed[key] = err
+				mx.Unlock()
+			}
+		}(k)
+	}
+
+	wg.Wait()
+
+	return failed, ctx.Err()
+}
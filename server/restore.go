@@ -0,0 +1,109 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	authorization "github.com/theleeeo/file-butler/authorization/v1"
+	"github.com/theleeeo/file-butler/lerr"
+	"github.com/theleeeo/file-butler/provider"
+)
+
+const (
+	defaultRestoreTier = "Standard"
+	defaultRestoreDays = 1
+)
+
+// restoreTiers are the valid restore tiers by their lowercase name
+var restoreTiers = map[string]string{
+	"expedited": "Expedited",
+	"standard":  "Standard",
+	"bulk":      "Bulk",
+}
+
+// parseRestoreOptions returns the tier and number of days of a restore from the query of the request
+func parseRestoreOptions(r *http.Request) (provider.RestoreOptions, error) {
+	opts := provider.RestoreOptions{
+		Tier: defaultRestoreTier,
+		Days: defaultRestoreDays,
+	}
+
+	if tier := r.URL.Query().Get("tier"); tier != "" {
+		canonical, ok := restoreTiers[strings.ToLower(tier)]
+		if !ok {
+			return provider.RestoreOptions{}, lerr.Newf(http.StatusBadRequest, "unknown restore tier %s, must be expedited, standard or bulk", tier)
+		}
+		opts.Tier = canonical
+	}
+
+	if days := r.URL.Query().Get("days"); days != "" {
+		n, err := strconv.ParseInt(days, 10, 32)
+		if err != nil || n < 1 {
+			return provider.RestoreOptions{}, lerr.New(http.StatusBadRequest, "days must be a positive number")
+		}
+		opts.Days = int32(n)
+	}
+
+	return opts, nil
+}
+
+// handleRestoreObject starts a restore of an archived object so that it can be downloaded.
+// The restore takes from minutes to hours depending on the tier, its progress is reported by the meta request.
+func (s *Server) handleRestoreObject(w http.ResponseWriter, r *http.Request) {
+	providerName := r.PathValue("provider")
+	p, release := s.getProvider(providerName)
+	defer release()
+
+	if p == nil {
+		http.Error(w, "provider not found", http.StatusNotFound)
+		return
+	}
+
+	restorer, ok := p.(provider.ArchiveRestorer)
+	if !ok {
+		http.Error(w, "the provider does not archive objects", http.StatusNotFound)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/restore/"+providerName+"/")
+	if key == "" {
+		http.Error(w, "key is required", http.StatusBadRequest)
+		return
+	}
+
+	if isHiddenKey(p, key) {
+		http.Error(w, provider.ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	opts, err := parseRestoreOptions(r)
+	if err != nil {
+		lerr.ToHTTP(w, err)
+		return
+	}
+
+	if err := s.authorizeRequest(r.Context(), authorization.RequestType_REQUEST_TYPE_RESTORE_OBJECT, r.Header, key, p); err != nil {
+		lerr.ToHTTP(w, err)
+		return
+	}
+
+	if err := restorer.RestoreArchived(r.Context(), key, opts); err != nil {
+		writeProviderError(w, err)
+		return
+	}
+
+	status, err := restorer.ArchiveStatus(r.Context(), key)
+	if err != nil {
+		writeProviderError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		log.Println("error encoding archive status:", err)
+	}
+}
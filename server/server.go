@@ -23,11 +23,27 @@ type Config struct {
 
 	// DefaultAuthPlugin is the name of the default auth plugin to use if the provider does not specify one
 	DefaultAuthPlugin string
+
+	// TrashPurgeInterval is how often the trash of the providers is checked for objects that have passed their retention period
+	// Default is 1 hour
+	TrashPurgeInterval time.Duration
+
+	// ExpirySweepInterval is how often the providers are checked for expired objects to delete
+	// Default is 10 minutes
+	ExpirySweepInterval time.Duration
+
+	// MaxArchiveFiles is the maximum number of files in an archive download
+	// Default is 1000
+	MaxArchiveFiles int
+
+	// MaxArchiveBytes is the maximum size in bytes of the files in an archive download, before they are compressed
+	// Default is 1 GiB
+	MaxArchiveBytes int64
 }
 
-func defaultAuthPluginExists(plugins []authPlugin.Plugin, defaultAuthPlugin string) bool {
+func pluginExists(plugins []authPlugin.Plugin, name string) bool {
 	for _, p := range plugins {
-		if p.Name() == defaultAuthPlugin {
+		if p.Name() == name {
 			return true
 		}
 	}
@@ -37,32 +53,25 @@ func defaultAuthPluginExists(plugins []authPlugin.Plugin, defaultAuthPlugin stri
 
 // NewServer creates a new server instance
 // No providers are registered by default, they must be registered using the RegisterProvider method
+// The server takes ownership of the plugins, they are stopped when the server is stopped or when they are replaced using Reconfigure
 func NewServer(serverCfg Config, plugins []authPlugin.Plugin) (*Server, error) {
 	if serverCfg.Addr == "" {
 		return nil, errors.New("address is required")
 	}
 
-	if len(plugins) == 0 {
-		return nil, errors.New("at least one auth plugin is required")
-	}
-
-	if serverCfg.DefaultAuthPlugin == "" {
-		return nil, errors.New("default auth plugin is required")
-	}
-
-	if !defaultAuthPluginExists(plugins, serverCfg.DefaultAuthPlugin) {
-		return nil, errors.New("default auth plugin not found")
-	}
-
-	if err := validateUniquePluginNames(plugins); err != nil {
+	cfg, err := newSettings(serverCfg, plugins)
+	if err != nil {
 		return nil, err
 	}
 
 	s := &Server{
-		allowRawBody:      serverCfg.AllowRawBody,
-		defaultAuthPlugin: serverCfg.DefaultAuthPlugin,
-		providers:         make(map[string]provider.Provider),
-		plugins:           plugins,
+		cfg:       cfg,
+		plugins:   make([]*registeredPlugin, 0, len(plugins)),
+		providers: make(map[string]*registeredProvider),
+	}
+
+	for _, p := range plugins {
+		s.plugins = append(s.plugins, &registeredPlugin{Plugin: p})
 	}
 
 	mux := http.NewServeMux()
@@ -72,6 +81,14 @@ func NewServer(serverCfg Config, plugins []authPlugin.Plugin) (*Server, error) {
 	mux.HandleFunc("GET /tags/{provider}/", s.handleTags)
 	mux.HandleFunc("GET /meta/{provider}/", s.handleMetadata)
 	mux.HandleFunc("GET /list/{provider}/", s.handleList)
+	mux.HandleFunc("GET /versions/{provider}/", s.handleListVersions)
+	mux.HandleFunc("POST /versions/{provider}/", s.handleRestoreVersion)
+	mux.HandleFunc("GET /trash/{provider}/", s.handleListTrash)
+	mux.HandleFunc("POST /trash/{provider}/", s.handleRestoreTrash)
+	mux.HandleFunc("POST /restore/{provider}/", s.handleRestoreObject)
+	mux.HandleFunc("DELETE /prefix/{provider}/", s.handleDeletePrefix)
+	mux.HandleFunc("POST /batch", s.handleBatch)
+	mux.HandleFunc("GET /archive/{provider}/", s.handleArchive)
 	s.srv = &http.Server{
 		Addr:              serverCfg.Addr,
 		Handler:           InternalErrorRedacter(CorsMiddleware(mux)),
@@ -83,13 +100,18 @@ func NewServer(serverCfg Config, plugins []authPlugin.Plugin) (*Server, error) {
 }
 
 type Server struct {
-	allowRawBody      bool
-	defaultAuthPlugin string
+	// configMx is held while the providers or the settings are changed, so that the providers are always validated against the plugins they will be used with
+	configMx sync.Mutex
 
-	providerMx sync.RWMutex
-	providers  map[string]provider.Provider
+	settingsMx sync.RWMutex
+	cfg        settings
 	// the plugins is a slice instead of a map because there will usually be a small number of plugins so it is not worth the overhead of a map
-	plugins []authPlugin.Plugin
+	plugins []*registeredPlugin
+
+	providerMx sync.RWMutex
+	providers  map[string]*registeredProvider
+	// retiring counts the replaced providers and plugins that are waiting to be closed
+	retiring sync.WaitGroup
 
 	srv *http.Server
 }
@@ -107,54 +129,168 @@ func validateUniquePluginNames(providers []authPlugin.Plugin) error {
 	return nil
 }
 
-func (s *Server) getPlugin(name string) authPlugin.Plugin {
-	for _, p := range s.plugins {
-		if p.Name() == name {
-			return p
-		}
-	}
+// registeredProvider is a registered provider and the requests that are using it
+type registeredProvider struct {
+	provider.Provider
 
-	return nil
+	// inFlight counts the requests that are using the provider, they are waited for before the provider is closed
+	inFlight sync.WaitGroup
 }
 
-func (s *Server) RegisterProvider(p provider.Provider) error {
+// validateProvider checks that a provider can be registered
+func (s *Server) validateProvider(p provider.Provider) error {
 	if p == nil || p.Id() == "" {
 		return errors.New("provider is nil or has no ID")
 	}
 
 	id := p.Id()
 
+	if err := p.Policy().Validate(); err != nil {
+		return fmt.Errorf("invalid policy for provider %s: %w", id, err)
+	}
+
 	// If the provider specifies an auth plugin to use instead of the default one, make sure it exists
 	if specifiedPlugin := p.AuthPlugin(); specifiedPlugin != "" {
-		if plg := s.getPlugin(specifiedPlugin); plg == nil {
+		s.settingsMx.RLock()
+		_, ok := findPlugin(s.plugins, specifiedPlugin)
+		s.settingsMx.RUnlock()
+
+		if !ok {
 			return fmt.Errorf("auth plugin %s not found for provider %s", specifiedPlugin, id)
 		}
 	}
 
-	s.providerMx.RLock()
-	if _, ok := s.providers[id]; ok {
-		s.providerMx.RUnlock()
-		return errors.New("provider already registered")
+	return nil
+}
+
+func (s *Server) RegisterProvider(p provider.Provider) error {
+	s.configMx.Lock()
+	defer s.configMx.Unlock()
+
+	if err := s.validateProvider(p); err != nil {
+		return err
 	}
-	s.providerMx.RUnlock()
 
-	log.Println("Registering provider", id)
+	id := p.Id()
 
 	s.providerMx.Lock()
 	defer s.providerMx.Unlock()
 
-	s.providers[id] = p
+	if _, ok := s.providers[id]; ok {
+		return errors.New("provider already registered")
+	}
+
+	log.Println("Registering provider", id)
+
+	s.providers[id] = &registeredProvider{Provider: p}
 
 	return nil
 }
 
+// RemoveProvider unregisters a provider.
+// It is closed once the requests that are using it have finished.
 func (s *Server) RemoveProvider(id string) {
 	log.Println("Removing provider", id)
 
 	s.providerMx.Lock()
-	defer s.providerMx.Unlock()
-
+	rp, ok := s.providers[id]
 	delete(s.providers, id)
+	s.providerMx.Unlock()
+
+	if ok {
+		s.retireProviders([]*registeredProvider{rp})
+	}
+}
+
+// ReplaceProviders replaces all registered providers with the given ones in a single step,
+// so that there is never a moment where a request finds some of the providers missing.
+// A provider that is already registered, the same instance and not only the same ID, is kept as it is.
+// The providers that are replaced or removed are closed once the requests that are using them have finished.
+// If any of the providers is invalid nothing is changed.
+func (s *Server) ReplaceProviders(providers []provider.Provider) error {
+	s.configMx.Lock()
+	defer s.configMx.Unlock()
+
+	ids := make(map[string]struct{}, len(providers))
+	for _, p := range providers {
+		if err := s.validateProvider(p); err != nil {
+			return err
+		}
+
+		if _, ok := ids[p.Id()]; ok {
+			return fmt.Errorf("duplicate provider id: %s", p.Id())
+		}
+		ids[p.Id()] = struct{}{}
+	}
+
+	s.providerMx.Lock()
+
+	old := s.providers
+	s.providers = make(map[string]*registeredProvider, len(providers))
+
+	for _, p := range providers {
+		id := p.Id()
+
+		if rp, ok := old[id]; ok && rp.Provider == p {
+			s.providers[id] = rp
+			delete(old, id)
+			continue
+		}
+
+		if _, ok := old[id]; ok {
+			log.Println("Replacing provider", id)
+		} else {
+			log.Println("Registering provider", id)
+		}
+
+		s.providers[id] = &registeredProvider{Provider: p}
+	}
+
+	s.providerMx.Unlock()
+
+	retired := make([]*registeredProvider, 0, len(old))
+	for id, rp := range old {
+		// A replaced provider has a new entry, only the ones that are gone are removed
+		if _, ok := ids[id]; !ok {
+			log.Println("Removing provider", id)
+		}
+		retired = append(retired, rp)
+	}
+
+	s.retireProviders(retired)
+
+	return nil
+}
+
+// retireProviders closes the providers in the background once all requests that are using them have finished.
+// The providers must already have been unregistered so that no new requests can start using them.
+func (s *Server) retireProviders(providers []*registeredProvider) {
+	for _, rp := range providers {
+		s.retiring.Add(1)
+		go func() {
+			defer s.retiring.Done()
+
+			rp.inFlight.Wait()
+
+			if err := provider.Close(rp.Provider); err != nil {
+				log.Printf("error closing provider %s: %s", rp.Id(), err)
+			}
+		}()
+	}
+}
+
+// closeProviders unregisters and closes all providers, it waits until all of them are closed
+func (s *Server) closeProviders() {
+	s.providerMx.Lock()
+	providers := make([]*registeredProvider, 0, len(s.providers))
+	for _, rp := range s.providers {
+		providers = append(providers, rp)
+	}
+	clear(s.providers)
+	s.providerMx.Unlock()
+
+	s.retireProviders(providers)
+	s.retiring.Wait()
 }
 
 func (s *Server) ProviderIds() []string {
@@ -169,35 +305,82 @@ func (s *Server) ProviderIds() []string {
 	return providerIds
 }
 
-func (s *Server) getProvider(id string) provider.Provider {
+// providerList returns a snapshot of all registered providers.
+// The providers are not closed until release is called, even if they are replaced in the meantime.
+func (s *Server) providerList() (providers []provider.Provider, release func()) {
+	s.providerMx.RLock()
+	defer s.providerMx.RUnlock()
+
+	providers = make([]provider.Provider, 0, len(s.providers))
+	entries := make([]*registeredProvider, 0, len(s.providers))
+	for _, rp := range s.providers {
+		rp.inFlight.Add(1)
+		providers = append(providers, rp.Provider)
+		entries = append(entries, rp)
+	}
+
+	return providers, func() {
+		for _, rp := range entries {
+			rp.inFlight.Done()
+		}
+	}
+}
+
+// getProvider returns the provider with the id, or nil if there is none.
+// The provider is not closed until release is called, even if it is replaced in the meantime.
+// release is never nil and must be called exactly once.
+func (s *Server) getProvider(id string) (p provider.Provider, release func()) {
 	s.providerMx.RLock()
 	defer s.providerMx.RUnlock()
 
-	p, ok := s.providers[id]
+	rp, ok := s.providers[id]
 	if !ok {
-		return nil
+		return nil, func() {}
 	}
-	return p
+
+	rp.inFlight.Add(1)
+	return rp.Provider, rp.inFlight.Done
+}
+
+// Handler returns the handler of all endpoints, eg. to serve them with an httptest.Server instead of Run
+func (s *Server) Handler() http.Handler {
+	return s.srv.Handler
 }
 
 func (s *Server) Run(ctx context.Context) error {
+	go s.runTrashPurger(ctx)
+	go s.runExpirySweeper(ctx)
+
+	shutdownDone := make(chan struct{})
 	go func() {
+		defer close(shutdownDone)
+
 		<-ctx.Done()
 
-		shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		// The requests get some time to finish, the context of the server is already canceled at this point
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
 		defer cancel()
 
 		if err := s.srv.Shutdown(shutdownCtx); err != nil {
 			log.Println("Error shutting down server:", err)
+			// The connections that are still active are closed so that the providers they are using can be closed
+			s.srv.Close()
 		}
 	}()
 
 	log.Printf("Server is listening on %s", s.srv.Addr)
 	if err := s.srv.ListenAndServe(); err != nil {
 		if !errors.Is(err, http.ErrServerClosed) {
+			s.closeProviders()
+			s.stopPlugins()
 			return err
 		}
 	}
 
+	// ListenAndServe returns as soon as the shutdown starts, the providers are closed once the requests have finished
+	<-shutdownDone
+	s.closeProviders()
+	s.stopPlugins()
+
 	return nil
 }
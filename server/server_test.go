@@ -1,20 +1,41 @@
 package server
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/ed25519"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/pkg/sftp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	authPlugin "github.com/theleeeo/file-butler/authorization/plugin"
+	authorization "github.com/theleeeo/file-butler/authorization/v1"
 	"github.com/theleeeo/file-butler/mocks"
 	"github.com/theleeeo/file-butler/provider"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/webdav"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func getValidPort() (int, error) {
@@ -31,6 +52,23 @@ func getValidPort() (int, error) {
 	return l.Addr().(*net.TCPAddr).Port, nil
 }
 
+// waitForServer blocks until the server started in the background accepts connections
+func waitForServer(t *testing.T, port int) {
+	t.Helper()
+
+	for i := 0; i < 100; i++ {
+		conn, err := net.Dial("tcp", fmt.Sprint("localhost:", port))
+		if err == nil {
+			conn.Close()
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("server on port %d did not start", port)
+}
+
 func ptrTo[T any](v T) *T {
 	return &v
 }
@@ -96,6 +134,7 @@ func Test_Download_NotAllowed(t *testing.T) {
 	go func() {
 		assert.Nil(t, srv.Run(ctx))
 	}()
+	waitForServer(t, port)
 
 	t.Run("Get object", func(t *testing.T) {
 		prov.On("GetObject", mock.Anything, "123", provider.GetOptions{}).Return(
@@ -142,6 +181,7 @@ func Test_Download(t *testing.T) {
 	go func() {
 		assert.Nil(t, srv.Run(ctx))
 	}()
+	waitForServer(t, port)
 
 	t.Run("Get object", func(t *testing.T) {
 		prov.On("GetObject", mock.Anything, "123", provider.GetOptions{}).Return(
@@ -206,6 +246,7 @@ func Test_Upload_NotAllowed(t *testing.T) {
 	go func() {
 		assert.Nil(t, srv.Run(ctx))
 	}()
+	waitForServer(t, port)
 
 	t.Run("Put object", func(t *testing.T) {
 		prov.On("PutObject", mock.Anything, "123", []byte("hello"), int64(5), map[string]string(nil)).Return(nil).Once()
@@ -252,6 +293,7 @@ func Test_Upload(t *testing.T) {
 	go func() {
 		assert.Nil(t, srv.Run(ctx))
 	}()
+	waitForServer(t, port)
 
 	t.Run("Put object", func(t *testing.T) {
 		prov.On("PutObject", mock.Anything, "123", []byte("hello"), provider.PutOptions{
@@ -351,6 +393,7 @@ func Test_Upload_DontAllowRawBody(t *testing.T) {
 	go func() {
 		assert.Nil(t, srv.Run(ctx))
 	}()
+	waitForServer(t, port)
 
 	t.Run("Put object", func(t *testing.T) {
 		req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://localhost:%d/file/mock/123", port), strings.NewReader("hello"))
@@ -395,6 +438,7 @@ func Test_Presign(t *testing.T) {
 	go func() {
 		assert.Nil(t, srv.Run(ctx))
 	}()
+	waitForServer(t, port)
 
 	t.Run("download", func(t *testing.T) {
 		prov.On("PresignURL", mock.Anything, "123", provider.PresignOperationDownload).Return(
@@ -475,6 +519,7 @@ func Test_GetTags(t *testing.T) {
 	go func() {
 		assert.Nil(t, srv.Run(ctx))
 	}()
+	waitForServer(t, port)
 
 	t.Run("Get tags", func(t *testing.T) {
 		prov.On("GetTags", mock.Anything, "123").Return(
@@ -538,6 +583,7 @@ func Test_GetMetadata(t *testing.T) {
 	go func() {
 		assert.Nil(t, srv.Run(ctx))
 	}()
+	waitForServer(t, port)
 
 	t.Run("Get metadata", func(t *testing.T) {
 		prov.On("GetTags", mock.Anything, "123").Return(
@@ -601,6 +647,7 @@ func Test_Download_WithLastModified(t *testing.T) {
 	go func() {
 		assert.Nil(t, srv.Run(ctx))
 	}()
+	waitForServer(t, port)
 
 	t.Run("Object not modified", func(t *testing.T) {
 		prov.On("GetObject", mock.Anything, "123", provider.GetOptions{LastModified: ptrTo(time.Unix(100, 0).UTC())}).Return(
@@ -657,4 +704,3708 @@ func Test_Download_WithLastModified(t *testing.T) {
 
 		assert.Equal(t, time.Unix(100, 0).UTC().Format(http.TimeFormat), resp.Header.Get("Last-Modified"))
 	})
+
+	t.Run("Conditions not supported", func(t *testing.T) {
+		// The mock provider does not evaluate the conditions, so they are rejected instead of returning the object
+		for header, value := range map[string]string{
+			"If-Match":            `"v1"`,
+			"If-None-Match":       `"v1"`,
+			"If-Unmodified-Since": time.Unix(100, 0).UTC().Format(http.TimeFormat),
+		} {
+			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost:%d/file/mock/123", port), nil)
+			assert.NoError(t, err)
+			req.Header.Set(header, value)
+
+			client := http.Client{}
+			resp, err := client.Do(req)
+			assert.NoError(t, err)
+
+			assert.Equal(t, http.StatusNotImplemented, resp.StatusCode, header)
+		}
+
+		prov.AssertExpectations(t)
+	})
+}
+
+func Test_Versions(t *testing.T) {
+	plg, err := authPlugin.NewPlugin(authPlugin.Config{
+		Name:    "default",
+		BuiltIn: "allow-types",
+		Args:    []string{"download", "delete", "list_versions", "restore_version"},
+	})
+	assert.NoError(t, err)
+
+	port, err := getValidPort()
+	assert.NoError(t, err)
+
+	srv, err := NewServer(Config{
+		Addr:              fmt.Sprint("localhost:", port),
+		DefaultAuthPlugin: "default",
+	}, []authPlugin.Plugin{plg})
+	assert.NoError(t, err)
+
+	prov := mocks.NewVersionProvider(provider.ConfigBase{ID: "mock"})
+	err = srv.RegisterProvider(prov)
+	assert.NoError(t, err)
+
+	trashProv := mocks.NewVersionProvider(provider.ConfigBase{ID: "trash", Policy: provider.Policy{Trash: &provider.TrashConfig{}}})
+	err = srv.RegisterProvider(trashProv)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		assert.Nil(t, srv.Run(ctx))
+	}()
+	waitForServer(t, port)
+
+	t.Run("Get version", func(t *testing.T) {
+		prov.On("GetObject", mock.Anything, "123", provider.GetOptions{VersionID: "v1"}).Return(
+			"hello", provider.ObjectInfo{VersionID: ptrTo("v1")}, nil).Once()
+
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost:%d/file/mock/123?version=v1", port), nil)
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(d))
+		assert.Equal(t, "v1", resp.Header.Get("X-Version-Id"))
+	})
+
+	t.Run("Delete version", func(t *testing.T) {
+		prov.On("DeleteObject", mock.Anything, "123", provider.DeleteOptions{VersionID: "v1"}).Return(nil).Once()
+
+		req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("http://localhost:%d/file/mock/123?version=v1", port), nil)
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("List versions", func(t *testing.T) {
+		prov.On("ListVersions", mock.Anything, "123/456/abc").Return(provider.ListVersionsResponse{
+			Versions: []provider.ObjectVersion{
+				{VersionID: "v2", IsLatest: true},
+				{VersionID: "v1"},
+			},
+		}, nil).Once()
+
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost:%d/versions/mock/123/456/abc", port), nil)
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"versions":[{"version_id":"v2","is_latest":true},{"version_id":"v1","is_latest":false}]}`, string(d))
+	})
+
+	t.Run("Restore version", func(t *testing.T) {
+		prov.On("RestoreVersion", mock.Anything, "123", "v1").Return(nil).Once()
+
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://localhost:%d/versions/mock/123?version=v1", port), nil)
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("Restore without version", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://localhost:%d/versions/mock/123", port), nil)
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("Trash is hidden", func(t *testing.T) {
+		client := http.Client{}
+
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/versions/trash/.trash/123/1000000000", port))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp, err = client.Post(fmt.Sprintf("http://localhost:%d/versions/trash/.trash/123/1000000000?version=v1", port), "", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		// The provider is never asked for the versions of the trash
+		trashProv.AssertNotCalled(t, "ListVersions", mock.Anything, mock.Anything)
+		trashProv.AssertNotCalled(t, "RestoreVersion", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	prov.AssertExpectations(t)
+}
+
+func Test_Trash(t *testing.T) {
+	plg, err := authPlugin.NewPlugin(authPlugin.Config{
+		Name:    "default",
+		BuiltIn: "allow-types",
+		Args:    []string{"download", "delete", "list", "list_trash", "restore_trash"},
+	})
+	assert.NoError(t, err)
+
+	port, err := getValidPort()
+	assert.NoError(t, err)
+
+	srv, err := NewServer(Config{
+		Addr:              fmt.Sprint("localhost:", port),
+		DefaultAuthPlugin: "default",
+	}, []authPlugin.Plugin{plg})
+	assert.NoError(t, err)
+
+	prov := mocks.NewProvider(provider.ConfigBase{ID: "mock", Policy: provider.Policy{Trash: &provider.TrashConfig{}}})
+	err = srv.RegisterProvider(prov)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		assert.Nil(t, srv.Run(ctx))
+	}()
+	waitForServer(t, port)
+
+	t.Run("Delete moves to trash", func(t *testing.T) {
+		prov.On("GetObject", mock.Anything, "123/abc", provider.GetOptions{}).Return(
+			"hello", provider.ObjectInfo{
+				ContentType:   ptrTo("text/plain"),
+				ContentLength: ptrTo(int64(5)),
+				Metadata:      map[string]string{"expires-at": "2100-01-01T00:00:00Z", "owner": "john"},
+			}, nil).Once()
+		prov.On("GetTags", mock.Anything, "123/abc").Return(map[string]string{"hello": "world"}, nil).Once()
+		// The metadata of the object is kept in the trash so that it is restored with the object, except for the expiry
+		prov.On("PutObject", mock.Anything, mock.MatchedBy(func(key string) bool {
+			return strings.HasPrefix(key, ".trash/123/abc/")
+		}), []byte("hello"), mock.MatchedBy(func(opts provider.PutOptions) bool {
+			_, hasExpiry := opts.Metadata["expires-at"]
+			return opts.ContentType == "text/plain" && opts.ContentLength == 5 && opts.Tags["hello"] == "world" &&
+				opts.Metadata["deleted-at"] != "" && !hasExpiry && opts.Metadata["owner"] == "john"
+		})).Return(nil).Once()
+		prov.On("DeleteObject", mock.Anything, "123/abc", provider.DeleteOptions{}).Return(nil).Once()
+
+		req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("http://localhost:%d/file/mock/123/abc", port), nil)
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("Trash is hidden", func(t *testing.T) {
+		prov.On("ListObjects", mock.Anything, "").Return(provider.ListObjectsResponse{
+			Keys: []string{"123/def", ".trash/123/abc/1000000000"},
+		}, nil).Once()
+
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost:%d/list/mock/", port), nil)
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"Keys":["123/def"]}`, string(d))
+
+		req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost:%d/file/mock/.trash/123/abc/1000000000", port), nil)
+		assert.NoError(t, err)
+
+		resp, err = client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("List trash", func(t *testing.T) {
+		prov.On("ListObjects", mock.Anything, ".trash/123").Return(provider.ListObjectsResponse{
+			Keys: []string{".trash/123/abc/1000000000", ".trash/123/abc/2000000000"},
+		}, nil).Once()
+
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost:%d/trash/mock/123", port), nil)
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"objects":[
+			{"key":"123/abc","id":"2000000000","deleted_at":"1970-01-01T00:00:02Z"},
+			{"key":"123/abc","id":"1000000000","deleted_at":"1970-01-01T00:00:01Z"}
+		]}`, string(d))
+	})
+
+	t.Run("Restore latest", func(t *testing.T) {
+		prov.On("ListObjects", mock.Anything, ".trash/123/abc/").Return(provider.ListObjectsResponse{
+			Keys: []string{".trash/123/abc/1000000000", ".trash/123/abc/2000000000"},
+		}, nil).Once()
+		prov.On("GetObject", mock.Anything, ".trash/123/abc/2000000000", provider.GetOptions{}).Return(
+			"hello", provider.ObjectInfo{
+				ContentLength: ptrTo(int64(5)),
+				Metadata: map[string]string{
+					"deleted-at":   "1970-01-01T00:00:02Z",
+					"retain-until": "2100-01-01T00:00:00Z",
+					"owner":        "john",
+					// Left by an older version that kept the expiry in the trash
+					"expires-at": "1970-01-01T00:00:03Z",
+				},
+			}, nil).Once()
+		prov.On("GetTags", mock.Anything, ".trash/123/abc/2000000000").Return(nil, nil).Once()
+		// Only the deletion time and the expiry are removed from the metadata
+		prov.On("PutObject", mock.Anything, "123/abc", []byte("hello"), provider.PutOptions{
+			ContentLength: 5,
+			Metadata:      map[string]string{"retain-until": "2100-01-01T00:00:00Z", "owner": "john"},
+		}).Return(nil).Once()
+		prov.On("DeleteObject", mock.Anything, ".trash/123/abc/2000000000", provider.DeleteOptions{}).Return(nil).Once()
+
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://localhost:%d/trash/mock/123/abc", port), nil)
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("Restore unknown id", func(t *testing.T) {
+		prov.On("ListObjects", mock.Anything, ".trash/123/abc/").Return(provider.ListObjectsResponse{
+			Keys: []string{".trash/123/abc/1000000000"},
+		}, nil).Once()
+
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://localhost:%d/trash/mock/123/abc?id=5", port), nil)
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	prov.AssertExpectations(t)
+}
+
+func Test_PurgeTrash(t *testing.T) {
+	prov := mocks.NewProvider(provider.ConfigBase{ID: "mock", Policy: provider.Policy{Trash: &provider.TrashConfig{
+		Prefix:    "bin",
+		Retention: provider.Duration(time.Hour),
+	}}})
+
+	recent := time.Now().Add(-time.Minute).UnixNano()
+	prov.On("ListObjects", mock.Anything, "bin/").Return(provider.ListObjectsResponse{
+		Keys: []string{"bin/old/1000000000", fmt.Sprintf("bin/new/%d", recent)},
+	}, nil).Once()
+	prov.On("DeleteObject", mock.Anything, "bin/old/1000000000", provider.DeleteOptions{}).Return(nil).Once()
+
+	purged, err := purgeTrash(context.Background(), prov)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	prov.AssertExpectations(t)
+}
+
+func Test_ProviderModes(t *testing.T) {
+	plg, err := authPlugin.NewPlugin(authPlugin.Config{
+		Name:    "default",
+		BuiltIn: "allow-types",
+		Args:    []string{"upload", "delete"},
+	})
+	assert.NoError(t, err)
+
+	denyAll, err := authPlugin.NewPlugin(authPlugin.Config{
+		Name:    "deny-all",
+		BuiltIn: "allow-types",
+		Args:    []string{"list"},
+	})
+	assert.NoError(t, err)
+
+	port, err := getValidPort()
+	assert.NoError(t, err)
+
+	srv, err := NewServer(Config{
+		Addr:              fmt.Sprint("localhost:", port),
+		DefaultAuthPlugin: "default",
+		AllowRawBody:      true,
+	}, []authPlugin.Plugin{plg, denyAll})
+	assert.NoError(t, err)
+
+	readOnly := mocks.NewProvider(provider.ConfigBase{ID: "readonly", AuthPlugin: "deny-all", Policy: provider.Policy{Mode: provider.ModeReadOnly}})
+	assert.NoError(t, srv.RegisterProvider(readOnly))
+
+	writeOnce := mocks.NewProvider(provider.ConfigBase{ID: "writeonce", Policy: provider.Policy{Mode: provider.ModeWriteOnce, Retention: provider.Duration(time.Hour)}})
+	assert.NoError(t, srv.RegisterProvider(writeOnce))
+
+	appendOnly := mocks.NewProvider(provider.ConfigBase{ID: "appendonly", Policy: provider.Policy{Mode: provider.ModeAppendOnly}})
+	assert.NoError(t, srv.RegisterProvider(appendOnly))
+
+	assert.Error(t, srv.RegisterProvider(mocks.NewProvider(provider.ConfigBase{ID: "invalid", Policy: provider.Policy{Mode: "write-twice"}})))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		assert.Nil(t, srv.Run(ctx))
+	}()
+	waitForServer(t, port)
+
+	t.Run("Read-only is checked before auth", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://localhost:%d/file/readonly/123", port), strings.NewReader("hello"))
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "the provider is read-only\n", string(d))
+	})
+
+	t.Run("Write-once upload of new key", func(t *testing.T) {
+		writeOnce.On("GetObject", mock.Anything, "new", provider.GetOptions{}).Return(nil, provider.ObjectInfo{}, provider.ErrNotFound).Once()
+		writeOnce.On("PutObject", mock.Anything, "new", []byte("hello"), mock.MatchedBy(func(opts provider.PutOptions) bool {
+			return opts.RetainUntil != nil && opts.RetainUntil.After(time.Now().Add(59*time.Minute)) && opts.Metadata["retain-until"] != ""
+		})).Return(nil).Once()
+
+		req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://localhost:%d/file/writeonce/new", port), strings.NewReader("hello"))
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("Write-once retention can not be shortened", func(t *testing.T) {
+		writeOnce.On("GetObject", mock.Anything, "new", provider.GetOptions{}).Return(nil, provider.ObjectInfo{}, provider.ErrNotFound).Once()
+
+		req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://localhost:%d/file/writeonce/new", port), strings.NewReader("hello"))
+		assert.NoError(t, err)
+		req.Header.Set("X-Retain-Until", time.Now().Add(time.Minute).Format(time.RFC3339))
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("Write-once overwrite", func(t *testing.T) {
+		writeOnce.On("GetObject", mock.Anything, "existing", provider.GetOptions{}).Return("hello", provider.ObjectInfo{}, nil).Once()
+
+		req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://localhost:%d/file/writeonce/existing", port), strings.NewReader("hello"))
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	})
+
+	t.Run("Write-once delete under retention", func(t *testing.T) {
+		writeOnce.On("GetObject", mock.Anything, "existing", provider.GetOptions{}).Return("hello", provider.ObjectInfo{
+			Metadata: map[string]string{"retain-until": time.Now().Add(time.Hour).Format(time.RFC3339)},
+		}, nil).Once()
+
+		req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("http://localhost:%d/file/writeonce/existing", port), nil)
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("Write-once delete after retention", func(t *testing.T) {
+		writeOnce.On("GetObject", mock.Anything, "existing", provider.GetOptions{}).Return("hello", provider.ObjectInfo{
+			Metadata: map[string]string{"retain-until": time.Now().Add(-time.Hour).Format(time.RFC3339)},
+		}, nil).Once()
+		writeOnce.On("DeleteObject", mock.Anything, "existing", provider.DeleteOptions{}).Return(nil).Once()
+
+		req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("http://localhost:%d/file/writeonce/existing", port), nil)
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("Append-only delete", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("http://localhost:%d/file/appendonly/existing", port), nil)
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	readOnly.AssertExpectations(t)
+	writeOnce.AssertExpectations(t)
+	appendOnly.AssertExpectations(t)
+}
+
+func Test_Expiry(t *testing.T) {
+	plg, err := authPlugin.NewPlugin(authPlugin.Config{
+		Name:    "default",
+		BuiltIn: "allow-types",
+		Args:    []string{"upload", "download", "list"},
+	})
+	assert.NoError(t, err)
+
+	port, err := getValidPort()
+	assert.NoError(t, err)
+
+	srv, err := NewServer(Config{
+		Addr:              fmt.Sprint("localhost:", port),
+		DefaultAuthPlugin: "default",
+		AllowRawBody:      true,
+	}, []authPlugin.Plugin{plg})
+	assert.NoError(t, err)
+
+	prov := mocks.NewProvider(provider.ConfigBase{ID: "mock", Policy: provider.Policy{MaxExpiry: provider.Duration(48 * time.Hour)}})
+	assert.NoError(t, srv.RegisterProvider(prov))
+
+	noExpiry := mocks.NewProvider(provider.ConfigBase{ID: "noexpiry"})
+	assert.NoError(t, srv.RegisterProvider(noExpiry))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		assert.Nil(t, srv.Run(ctx))
+	}()
+	waitForServer(t, port)
+
+	expired := provider.ObjectInfo{Metadata: map[string]string{"expires-at": time.Now().Add(-time.Minute).Format(time.RFC3339)}}
+	notExpired := provider.ObjectInfo{Metadata: map[string]string{"expires-at": time.Now().Add(time.Hour).Format(time.RFC3339)}}
+
+	t.Run("Upload with expiry", func(t *testing.T) {
+		prov.On("PutObject", mock.Anything, "123", []byte("hello"), mock.MatchedBy(func(opts provider.PutOptions) bool {
+			expiresAt, err := time.Parse(time.RFC3339, opts.Metadata["expires-at"])
+			return err == nil && expiresAt.After(time.Now().Add(23*time.Hour)) && expiresAt.Before(time.Now().Add(25*time.Hour))
+		})).Return(nil).Once()
+
+		req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://localhost:%d/file/mock/123?expires-in=24h", port), strings.NewReader("hello"))
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("Expiry longer than max", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://localhost:%d/file/mock/123", port), strings.NewReader("hello"))
+		assert.NoError(t, err)
+		req.Header.Set("X-Expires-In", "72h")
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("Expiry not enabled", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://localhost:%d/file/noexpiry/123?expires-in=1h", port), strings.NewReader("hello"))
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("Download expired", func(t *testing.T) {
+		prov.On("GetObject", mock.Anything, "old", provider.GetOptions{}).Return("hello", expired, nil).Once()
+
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost:%d/file/mock/old", port), nil)
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("List hides expired", func(t *testing.T) {
+		prov.On("ListObjects", mock.Anything, "").Return(provider.ListObjectsResponse{Keys: []string{"old", "new", "forever"}}, nil).Once()
+		prov.On("GetObject", mock.Anything, "old", provider.GetOptions{}).Return("hello", expired, nil).Once()
+		prov.On("GetObject", mock.Anything, "new", provider.GetOptions{}).Return("hello", notExpired, nil).Once()
+		prov.On("GetObject", mock.Anything, "forever", provider.GetOptions{}).Return("hello", provider.ObjectInfo{}, nil).Once()
+
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost:%d/list/mock/", port), nil)
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"Keys":["new","forever"]}`, string(d))
+	})
+
+	t.Run("Large listings are not filtered", func(t *testing.T) {
+		keys := make([]string, maxListExpiryChecks+1)
+		for i := range keys {
+			keys[i] = fmt.Sprint(i)
+		}
+		// No object is read since checking each of them would be too expensive
+		prov.On("ListObjects", mock.Anything, "").Return(provider.ListObjectsResponse{Keys: keys}, nil).Once()
+
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost:%d/list/mock/", port), nil)
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		var res provider.ListObjectsResponse
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		assert.Len(t, res.Keys, len(keys))
+	})
+
+	t.Run("Sweep expired", func(t *testing.T) {
+		prov.On("ListObjects", mock.Anything, "").Return(provider.ListObjectsResponse{Keys: []string{"old", "new"}}, nil).Once()
+		prov.On("GetObject", mock.Anything, "old", provider.GetOptions{}).Return("hello", expired, nil).Once()
+		prov.On("GetObject", mock.Anything, "new", provider.GetOptions{}).Return("hello", notExpired, nil).Once()
+		prov.On("DeleteObject", mock.Anything, "old", provider.DeleteOptions{}).Return(nil).Once()
+
+		deleted, err := sweepExpired(context.Background(), prov)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, deleted)
+	})
+
+	prov.AssertExpectations(t)
+	noExpiry.AssertExpectations(t)
+}
+
+func Test_ExpiryRetention(t *testing.T) {
+	plg, err := authPlugin.NewPlugin(authPlugin.Config{
+		Name:    "default",
+		BuiltIn: "allow-types",
+		Args:    []string{"upload"},
+	})
+	assert.NoError(t, err)
+
+	port, err := getValidPort()
+	assert.NoError(t, err)
+
+	srv, err := NewServer(Config{
+		Addr:              fmt.Sprint("localhost:", port),
+		DefaultAuthPlugin: "default",
+		AllowRawBody:      true,
+	}, []authPlugin.Plugin{plg})
+	assert.NoError(t, err)
+
+	prov := mocks.NewProvider(provider.ConfigBase{ID: "mock", Policy: provider.Policy{
+		Mode:      provider.ModeWriteOnce,
+		MaxExpiry: provider.Duration(48 * time.Hour),
+	}})
+	assert.NoError(t, srv.RegisterProvider(prov))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		assert.Nil(t, srv.Run(ctx))
+	}()
+	waitForServer(t, port)
+
+	past := time.Now().Add(-time.Minute).Format(time.RFC3339)
+	future := time.Now().Add(time.Hour).Format(time.RFC3339)
+
+	t.Run("Upload expiring before its retention", func(t *testing.T) {
+		prov.On("GetObject", mock.Anything, "123", provider.GetOptions{}).Return(nil, provider.ObjectInfo{}, provider.ErrNotFound).Once()
+
+		req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://localhost:%d/file/mock/123?expires-in=1h", port), strings.NewReader("hello"))
+		assert.NoError(t, err)
+		req.Header.Set("X-Retain-Until", time.Now().Add(24*time.Hour).Format(time.RFC3339))
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("Sweep keeps retained objects", func(t *testing.T) {
+		retained := provider.ObjectInfo{Metadata: map[string]string{"expires-at": past, "retain-until": future}}
+		released := provider.ObjectInfo{Metadata: map[string]string{"expires-at": past, "retain-until": past}}
+
+		prov.On("ListObjects", mock.Anything, "").Return(provider.ListObjectsResponse{Keys: []string{"retained", "released"}}, nil).Once()
+		// Each object is read once to check the expiry and once more to check the retention before it is deleted
+		prov.On("GetObject", mock.Anything, "retained", provider.GetOptions{}).Return("hello", retained, nil).Twice()
+		prov.On("GetObject", mock.Anything, "released", provider.GetOptions{}).Return("hello", released, nil).Twice()
+		prov.On("DeleteObject", mock.Anything, "released", provider.DeleteOptions{}).Return(nil).Once()
+
+		deleted, err := sweepExpired(context.Background(), prov)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, deleted)
+	})
+
+	t.Run("Sweep moves to trash", func(t *testing.T) {
+		trashProv := mocks.NewProvider(provider.ConfigBase{ID: "trash", Policy: provider.Policy{
+			Trash:     &provider.TrashConfig{},
+			MaxExpiry: provider.Duration(48 * time.Hour),
+		}})
+
+		expired := provider.ObjectInfo{ContentLength: ptrTo(int64(5)), Metadata: map[string]string{"expires-at": past}}
+
+		trashProv.On("ListObjects", mock.Anything, "").Return(provider.ListObjectsResponse{Keys: []string{"old", ".trash/old/1000000000"}}, nil).Once()
+		trashProv.On("GetObject", mock.Anything, "old", provider.GetOptions{}).Return("hello", expired, nil).Twice()
+		trashProv.On("GetTags", mock.Anything, "old").Return(nil, nil).Once()
+		trashProv.On("PutObject", mock.Anything, mock.MatchedBy(func(key string) bool {
+			return strings.HasPrefix(key, ".trash/old/")
+		}), []byte("hello"), mock.Anything).Return(nil).Once()
+		trashProv.On("DeleteObject", mock.Anything, "old", provider.DeleteOptions{}).Return(nil).Once()
+
+		deleted, err := sweepExpired(context.Background(), trashProv)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, deleted)
+
+		trashProv.AssertExpectations(t)
+	})
+
+	t.Run("Sweep skips append-only providers", func(t *testing.T) {
+		appendOnly := mocks.NewProvider(provider.ConfigBase{ID: "append", Policy: provider.Policy{
+			Mode:      provider.ModeAppendOnly,
+			MaxExpiry: provider.Duration(48 * time.Hour),
+		}})
+
+		deleted, err := sweepExpired(context.Background(), appendOnly)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, deleted)
+
+		appendOnly.AssertExpectations(t)
+	})
+
+	prov.AssertExpectations(t)
+}
+
+func Test_DeletePrefix(t *testing.T) {
+	plg, err := authPlugin.NewPlugin(authPlugin.Config{
+		Name:    "default",
+		BuiltIn: "allow-types",
+		Args:    []string{"delete_prefix"},
+	})
+	assert.NoError(t, err)
+
+	port, err := getValidPort()
+	assert.NoError(t, err)
+
+	srv, err := NewServer(Config{
+		Addr:              fmt.Sprint("localhost:", port),
+		DefaultAuthPlugin: "default",
+	}, []authPlugin.Plugin{plg})
+	assert.NoError(t, err)
+
+	batch := mocks.NewBatchDeleteProvider(provider.ConfigBase{ID: "batch"})
+	assert.NoError(t, srv.RegisterProvider(batch))
+
+	single := mocks.NewProvider(provider.ConfigBase{ID: "single"})
+	assert.NoError(t, srv.RegisterProvider(single))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		assert.Nil(t, srv.Run(ctx))
+	}()
+	waitForServer(t, port)
+
+	t.Run("Confirmation required", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("http://localhost:%d/prefix/batch/folder/", port), nil)
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("Dry run", func(t *testing.T) {
+		batch.On("ListObjects", mock.Anything, "folder/").Return(provider.ListObjectsResponse{Keys: []string{"folder/a", "folder/b"}}, nil).Once()
+
+		req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("http://localhost:%d/prefix/batch/folder/?dry-run=true", port), nil)
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"key":"folder/a","deleted":0,"failed":0,"total":2,"dry_run":true}
+{"key":"folder/b","deleted":0,"failed":0,"total":2,"dry_run":true}
+{"deleted":0,"failed":0,"total":2,"dry_run":true,"done":true}
+`, string(d))
+	})
+
+	t.Run("Batch delete", func(t *testing.T) {
+		batch.On("ListObjects", mock.Anything, "folder/").Return(provider.ListObjectsResponse{Keys: []string{"folder/a", "folder/b"}}, nil).Once()
+		batch.On("DeleteObjects", mock.Anything, []string{"folder/a", "folder/b"}).Return(map[string]error{"folder/b": provider.ErrDenied}, nil).Once()
+
+		req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("http://localhost:%d/prefix/batch/folder/?confirm=folder/", port), nil)
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"key":"folder/b","error":"access denied","deleted":0,"failed":0,"total":2}
+{"deleted":1,"failed":1,"total":2}
+{"deleted":1,"failed":1,"total":2,"done":true}
+`, string(d))
+	})
+
+	t.Run("Delete one at a time", func(t *testing.T) {
+		single.On("ListObjects", mock.Anything, "folder/").Return(provider.ListObjectsResponse{Keys: []string{"folder/a", "folder/b"}}, nil).Once()
+		single.On("DeleteObject", mock.Anything, "folder/a", provider.DeleteOptions{}).Return(nil).Once()
+		single.On("DeleteObject", mock.Anything, "folder/b", provider.DeleteOptions{}).Return(nil).Once()
+
+		req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("http://localhost:%d/prefix/single/folder/?confirm=folder/", port), nil)
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"deleted":2,"failed":0,"total":2}
+{"deleted":2,"failed":0,"total":2,"done":true}
+`, string(d))
+	})
+
+	batch.AssertExpectations(t)
+	single.AssertExpectations(t)
+}
+
+func Test_Batch(t *testing.T) {
+	plg, err := authPlugin.NewPlugin(authPlugin.Config{
+		Name:    "default",
+		BuiltIn: "allow-types",
+		Args:    []string{"download", "upload", "delete", "get_metadata"},
+	})
+	assert.NoError(t, err)
+
+	noUpload, err := authPlugin.NewPlugin(authPlugin.Config{
+		Name:    "no-upload",
+		BuiltIn: "allow-types",
+		Args:    []string{"download"},
+	})
+	assert.NoError(t, err)
+
+	port, err := getValidPort()
+	assert.NoError(t, err)
+
+	srv, err := NewServer(Config{
+		Addr:              fmt.Sprint("localhost:", port),
+		DefaultAuthPlugin: "default",
+	}, []authPlugin.Plugin{plg, noUpload})
+	assert.NoError(t, err)
+
+	prov := mocks.NewProvider(provider.ConfigBase{ID: "mock"})
+	assert.NoError(t, srv.RegisterProvider(prov))
+
+	dst := mocks.NewProvider(provider.ConfigBase{ID: "dst"})
+	assert.NoError(t, srv.RegisterProvider(dst))
+
+	readOnlyDst := mocks.NewProvider(provider.ConfigBase{ID: "readonly", AuthPlugin: "no-upload"})
+	assert.NoError(t, srv.RegisterProvider(readOnlyDst))
+
+	worm := mocks.NewProvider(provider.ConfigBase{ID: "worm", Policy: provider.Policy{
+		Mode:      provider.ModeWriteOnce,
+		Retention: provider.Duration(24 * time.Hour),
+	}})
+	assert.NoError(t, srv.RegisterProvider(worm))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		assert.Nil(t, srv.Run(ctx))
+	}()
+	waitForServer(t, port)
+
+	t.Run("Mixed operations", func(t *testing.T) {
+		prov.On("DeleteObject", mock.Anything, "a", provider.DeleteOptions{}).Return(nil).Once()
+		prov.On("DeleteObject", mock.Anything, "missing", provider.DeleteOptions{}).Return(provider.ErrNotFound).Once()
+		prov.On("GetObject", mock.Anything, "b", provider.GetOptions{}).Return("hello", provider.ObjectInfo{ContentType: ptrTo("text/plain"), ContentLength: ptrTo(int64(5))}, nil).Twice()
+		prov.On("GetTags", mock.Anything, "b").Return(map[string]string{"x": "y"}, nil).Once()
+		dst.On("PutObject", mock.Anything, "c", []byte("hello"), provider.PutOptions{
+			ContentType:   "text/plain",
+			ContentLength: 5,
+			Tags:          map[string]string{"x": "y"},
+		}).Return(nil).Once()
+
+		body := `[
+			{"op":"delete","provider":"mock","key":"a"},
+			{"op":"delete","provider":"mock","key":"missing"},
+			{"op":"copy","provider":"mock","key":"b","dest_provider":"dst","dest_key":"c"},
+			{"op":"copy","provider":"mock","key":"b","dest_provider":"readonly","dest_key":"c"},
+			{"op":"stat","provider":"mock","key":"b"},
+			{"op":"set-tags","provider":"mock","key":"b","tags":{"x":"z"}},
+			{"op":"delete","provider":"unknown","key":"a"},
+			{"op":"rename","provider":"mock","key":"a"}
+		]`
+
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://localhost:%d/batch", port), strings.NewReader(body))
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `[
+			{"status":200},
+			{"status":404,"error":"resource not found"},
+			{"status":200},
+			{"status":403,"error":"permission denied: request type is not allowed"},
+			{"status":200,"info":{"content_type":"text/plain","content_length":5}},
+			{"status":400,"error":"setting tags is not supported for this provider"},
+			{"status":404,"error":"provider not found"},
+			{"status":400,"error":"unknown operation: rename"}
+		]`, string(d))
+	})
+
+	t.Run("Copy retention and expiry", func(t *testing.T) {
+		retainUntil := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+
+		prov.On("GetObject", mock.Anything, "plain", provider.GetOptions{}).Return("hello", provider.ObjectInfo{ContentLength: ptrTo(int64(5))}, nil).Once()
+		prov.On("GetTags", mock.Anything, "plain").Return(nil, nil).Once()
+		worm.On("GetObject", mock.Anything, "plain", provider.GetOptions{}).Return(nil, provider.ObjectInfo{}, provider.ErrNotFound).Once()
+		// The retention of the destination provider is applied to the copy
+		worm.On("PutObject", mock.Anything, "plain", []byte("hello"), mock.MatchedBy(func(opts provider.PutOptions) bool {
+			return opts.RetainUntil != nil && opts.RetainUntil.After(time.Now().Add(23*time.Hour)) && opts.RetainUntil.Before(time.Now().Add(25*time.Hour)) &&
+				opts.Metadata["retain-until"] == opts.RetainUntil.Format(time.RFC3339)
+		})).Return(nil).Once()
+
+		retained := provider.ObjectInfo{ContentLength: ptrTo(int64(5)), Metadata: map[string]string{"retain-until": retainUntil.Format(time.RFC3339)}}
+		prov.On("GetObject", mock.Anything, "retained", provider.GetOptions{}).Return("hello", retained, nil).Twice()
+		prov.On("GetTags", mock.Anything, "retained").Return(nil, nil).Twice()
+		worm.On("GetObject", mock.Anything, "retained", provider.GetOptions{}).Return(nil, provider.ObjectInfo{}, provider.ErrNotFound).Once()
+		// A retention of the source that is longer than the one of the destination is kept
+		worm.On("PutObject", mock.Anything, "retained", []byte("hello"), provider.PutOptions{
+			ContentLength: 5,
+			Metadata:      map[string]string{"retain-until": retainUntil.Format(time.RFC3339)},
+			RetainUntil:   &retainUntil,
+		}).Return(nil).Once()
+		// The destination does not support retention, so the retention is dropped since it only protects the source
+		dst.On("PutObject", mock.Anything, "retained", []byte("hello"), provider.PutOptions{ContentLength: 5}).Return(nil).Once()
+
+		expired := provider.ObjectInfo{Metadata: map[string]string{"expires-at": time.Now().Add(-time.Minute).Format(time.RFC3339)}}
+		prov.On("GetObject", mock.Anything, "expired", provider.GetOptions{}).Return("hello", expired, nil).Once()
+		prov.On("GetTags", mock.Anything, "expired").Return(nil, nil).Once()
+
+		expiring := provider.ObjectInfo{Metadata: map[string]string{"expires-at": time.Now().Add(time.Hour).Format(time.RFC3339)}}
+		prov.On("GetObject", mock.Anything, "expiring", provider.GetOptions{}).Return("hello", expiring, nil).Once()
+		prov.On("GetTags", mock.Anything, "expiring").Return(nil, nil).Once()
+
+		body := `[
+			{"op":"copy","provider":"mock","key":"plain","dest_provider":"worm","dest_key":"plain"},
+			{"op":"copy","provider":"mock","key":"retained","dest_provider":"worm","dest_key":"retained"},
+			{"op":"copy","provider":"mock","key":"retained","dest_provider":"dst","dest_key":"retained"},
+			{"op":"copy","provider":"mock","key":"expired","dest_provider":"dst","dest_key":"expired"},
+			{"op":"copy","provider":"mock","key":"expiring","dest_provider":"dst","dest_key":"expiring"}
+		]`
+
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://localhost:%d/batch", port), strings.NewReader(body))
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `[
+			{"status":200},
+			{"status":200},
+			{"status":200},
+			{"status":404,"error":"resource not found"},
+			{"status":400,"error":"the object expires and expiry is not enabled for the destination provider"}
+		]`, string(d))
+	})
+
+	t.Run("Invalid body", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://localhost:%d/batch", port), strings.NewReader(`{"op":"delete"}`))
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	prov.AssertExpectations(t)
+	dst.AssertExpectations(t)
+	readOnlyDst.AssertExpectations(t)
+	worm.AssertExpectations(t)
+}
+
+func Test_Archive(t *testing.T) {
+	plg, err := authPlugin.NewPlugin(authPlugin.Config{
+		Name:    "default",
+		BuiltIn: "allow-types",
+		Args:    []string{"list", "download"},
+	})
+	assert.NoError(t, err)
+
+	listOnly, err := authPlugin.NewPlugin(authPlugin.Config{
+		Name:    "list-only",
+		BuiltIn: "allow-types",
+		Args:    []string{"list"},
+	})
+	assert.NoError(t, err)
+
+	port, err := getValidPort()
+	assert.NoError(t, err)
+
+	srv, err := NewServer(Config{
+		Addr:              fmt.Sprint("localhost:", port),
+		DefaultAuthPlugin: "default",
+		MaxArchiveFiles:   2,
+		MaxArchiveBytes:   1 << 20,
+	}, []authPlugin.Plugin{plg, listOnly})
+	assert.NoError(t, err)
+
+	prov := mocks.NewProvider(provider.ConfigBase{ID: "mock"})
+	assert.NoError(t, srv.RegisterProvider(prov))
+
+	denied := mocks.NewProvider(provider.ConfigBase{ID: "denied", AuthPlugin: "list-only"})
+	assert.NoError(t, srv.RegisterProvider(denied))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		assert.Nil(t, srv.Run(ctx))
+	}()
+	waitForServer(t, port)
+
+	modTime := time.Unix(1700000000, 0).UTC()
+
+	t.Run("Zip", func(t *testing.T) {
+		prov.On("ListObjects", mock.Anything, "folder/").Return(provider.ListObjectsResponse{Keys: []string{"folder/a.txt", "folder/sub/b.txt"}}, nil).Once()
+		prov.On("GetObject", mock.Anything, "folder/a.txt", provider.GetOptions{}).Return("hello", provider.ObjectInfo{LastModified: &modTime}, nil).Once()
+		prov.On("GetObject", mock.Anything, "folder/sub/b.txt", provider.GetOptions{}).Return("world", provider.ObjectInfo{}, nil).Once()
+
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/archive/mock/folder/", port))
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/zip", resp.Header.Get("Content-Type"))
+		assert.Equal(t, `attachment; filename="folder.zip"`, resp.Header.Get("Content-Disposition"))
+
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+
+		zr, err := zip.NewReader(bytes.NewReader(d), int64(len(d)))
+		assert.NoError(t, err)
+		assert.Len(t, zr.File, 2)
+
+		files := make(map[string]string)
+		for _, f := range zr.File {
+			r, err := f.Open()
+			assert.NoError(t, err)
+			content, err := io.ReadAll(r)
+			assert.NoError(t, err)
+			files[f.Name] = string(content)
+		}
+		assert.Equal(t, map[string]string{"a.txt": "hello", "sub/b.txt": "world"}, files)
+		assert.True(t, modTime.Equal(zr.File[0].Modified))
+	})
+
+	t.Run("Tar gz", func(t *testing.T) {
+		prov.On("ListObjects", mock.Anything, "folder/").Return(provider.ListObjectsResponse{Keys: []string{"folder/a.txt"}}, nil).Once()
+		prov.On("GetObject", mock.Anything, "folder/a.txt", provider.GetOptions{}).Return("hello", provider.ObjectInfo{ContentLength: ptrTo(int64(5))}, nil).Once()
+
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/archive/mock/folder/?format=tar.gz", port))
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/gzip", resp.Header.Get("Content-Type"))
+
+		gr, err := gzip.NewReader(resp.Body)
+		assert.NoError(t, err)
+		tr := tar.NewReader(gr)
+
+		hdr, err := tr.Next()
+		assert.NoError(t, err)
+		assert.Equal(t, "a.txt", hdr.Name)
+		assert.Equal(t, int64(5), hdr.Size)
+
+		content, err := io.ReadAll(tr)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(content))
+
+		_, err = tr.Next()
+		assert.Equal(t, io.EOF, err)
+	})
+
+	t.Run("Unsupported format", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/archive/mock/folder/?format=rar", port))
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("Too many files", func(t *testing.T) {
+		prov.On("ListObjects", mock.Anything, "many/").Return(provider.ListObjectsResponse{Keys: []string{"many/a", "many/b", "many/c"}}, nil).Once()
+
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/archive/mock/many/", port))
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	})
+
+	t.Run("Expired files", func(t *testing.T) {
+		expired := provider.ObjectInfo{Metadata: map[string]string{"expires-at": time.Now().Add(-time.Minute).Format(time.RFC3339)}}
+
+		// Each object is only read once, the expiry is checked when it is added to the archive
+		prov.On("ListObjects", mock.Anything, "exp/").Return(provider.ListObjectsResponse{Keys: []string{"exp/old", "exp/new"}}, nil).Once()
+		prov.On("GetObject", mock.Anything, "exp/old", provider.GetOptions{}).Return("hello", expired, nil).Once()
+		prov.On("GetObject", mock.Anything, "exp/new", provider.GetOptions{}).Return("world", provider.ObjectInfo{}, nil).Once()
+
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/archive/mock/exp/", port))
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+
+		zr, err := zip.NewReader(bytes.NewReader(d), int64(len(d)))
+		assert.NoError(t, err)
+		if assert.Len(t, zr.File, 1) {
+			assert.Equal(t, "new", zr.File[0].Name)
+		}
+	})
+
+	t.Run("Too many bytes", func(t *testing.T) {
+		// The files compress to far less than the limit, it is the size of the files that is limited and not the size of the archive
+		for _, format := range []string{"zip", "tar.gz"} {
+			prov.On("ListObjects", mock.Anything, "big/").Return(provider.ListObjectsResponse{Keys: []string{"big/a", "big/b"}}, nil).Once()
+			prov.On("GetObject", mock.Anything, "big/a", provider.GetOptions{}).Return(make([]byte, 600<<10), provider.ObjectInfo{}, nil).Once()
+			prov.On("GetObject", mock.Anything, "big/b", provider.GetOptions{}).Return(make([]byte, 600<<10), provider.ObjectInfo{}, nil).Once()
+
+			resp, err := http.Get(fmt.Sprintf("http://localhost:%d/archive/mock/big/?format=%s", port, format))
+			assert.NoError(t, err)
+
+			// The response has already started when the limit is reached, so the transfer is aborted
+			_, err = io.ReadAll(resp.Body)
+			assert.Error(t, err, format)
+		}
+	})
+
+	t.Run("Too many bytes with known size", func(t *testing.T) {
+		prov.On("ListObjects", mock.Anything, "big/").Return(provider.ListObjectsResponse{Keys: []string{"big/a"}}, nil).Once()
+		prov.On("GetObject", mock.Anything, "big/a", provider.GetOptions{}).Return(make([]byte, 2<<20), provider.ObjectInfo{ContentLength: ptrTo(int64(2 << 20))}, nil).Once()
+
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/archive/mock/big/", port))
+		assert.NoError(t, err)
+
+		_, err = io.ReadAll(resp.Body)
+		assert.Error(t, err)
+	})
+
+	t.Run("Download denied", func(t *testing.T) {
+		denied.On("ListObjects", mock.Anything, "folder/").Return(provider.ListObjectsResponse{Keys: []string{"folder/a.txt"}}, nil).Once()
+
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/archive/denied/folder/", port))
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	prov.AssertExpectations(t)
+	denied.AssertExpectations(t)
+}
+
+func Test_Extract(t *testing.T) {
+	plg, err := authPlugin.NewPlugin(authPlugin.Config{
+		Name:    "default",
+		BuiltIn: "allow-types",
+		Args:    []string{"upload"},
+	})
+	assert.NoError(t, err)
+
+	port, err := getValidPort()
+	assert.NoError(t, err)
+
+	srv, err := NewServer(Config{
+		Addr:              fmt.Sprint("localhost:", port),
+		DefaultAuthPlugin: "default",
+		AllowRawBody:      true,
+		MaxArchiveFiles:   4,
+		MaxArchiveBytes:   10 << 20,
+	}, []authPlugin.Plugin{plg})
+	assert.NoError(t, err)
+
+	prov := mocks.NewProvider(provider.ConfigBase{ID: "mock"})
+	assert.NoError(t, srv.RegisterProvider(prov))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		assert.Nil(t, srv.Run(ctx))
+	}()
+	waitForServer(t, port)
+
+	txtOpts := func(size int64) provider.PutOptions {
+		return provider.PutOptions{ContentType: "text/plain; charset=utf-8", ContentLength: size}
+	}
+
+	t.Run("Tar gz", func(t *testing.T) {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gw)
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "sub/", Mode: 0o755}))
+		for _, f := range []struct{ name, content string }{{"a.txt", "hello"}, {"sub/b.txt", "world"}, {"../evil.txt", "evil"}} {
+			assert.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: f.name, Size: int64(len(f.content)), Mode: 0o644}))
+			_, err := tw.Write([]byte(f.content))
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "link", Linkname: "/etc/passwd"}))
+		assert.NoError(t, tw.Close())
+		assert.NoError(t, gw.Close())
+
+		prov.On("PutObject", mock.Anything, "upload/a.txt", []byte("hello"), txtOpts(5)).Return(nil).Once()
+		prov.On("PutObject", mock.Anything, "upload/sub/b.txt", []byte("world"), txtOpts(5)).Return(nil).Once()
+
+		req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://localhost:%d/file/mock/upload/?extract=tar.gz", port), &buf)
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"entry":"a.txt","key":"upload/a.txt","status":200,"uploaded":1,"failed":0}
+{"entry":"sub/b.txt","key":"upload/sub/b.txt","status":200,"uploaded":2,"failed":0}
+{"entry":"../evil.txt","status":400,"error":"the path of the entry is outside of the target prefix","uploaded":2,"failed":1}
+{"entry":"link","status":400,"error":"only regular files can be extracted","uploaded":2,"failed":2}
+{"uploaded":2,"failed":2,"done":true}
+`, string(d))
+	})
+
+	t.Run("Zip", func(t *testing.T) {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		w, err := zw.Create("data/a.txt")
+		assert.NoError(t, err)
+		_, err = w.Write([]byte("hello"))
+		assert.NoError(t, err)
+		assert.NoError(t, zw.Close())
+
+		prov.On("PutObject", mock.Anything, "upload/data/a.txt", []byte("hello"), txtOpts(5)).Return(nil).Once()
+
+		req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://localhost:%d/file/mock/upload?extract=zip", port), &buf)
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"entry":"data/a.txt","key":"upload/data/a.txt","status":200,"uploaded":1,"failed":0}
+{"uploaded":1,"failed":0,"done":true}
+`, string(d))
+	})
+
+	t.Run("Too many files", func(t *testing.T) {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for i := 0; i < 5; i++ {
+			_, err := zw.Create(fmt.Sprint(i, ".txt"))
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, zw.Close())
+
+		req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://localhost:%d/file/mock/upload?extract=zip", port), &buf)
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	})
+
+	t.Run("Zip bomb", func(t *testing.T) {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gw)
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "zeros", Size: 5 << 20, Mode: 0o644}))
+		_, err := tw.Write(make([]byte, 5<<20))
+		assert.NoError(t, err)
+		assert.NoError(t, tw.Close())
+		assert.NoError(t, gw.Close())
+
+		req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://localhost:%d/file/mock/upload?extract=tar.gz", port), &buf)
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"error":"the archive expands too much, it may be a zip bomb","uploaded":0,"failed":1,"done":true}
+`, string(d))
+	})
+
+	t.Run("Unsupported format", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://localhost:%d/file/mock/upload?extract=rar", port), strings.NewReader("data"))
+		assert.NoError(t, err)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	prov.AssertExpectations(t)
+}
+
+func Test_FilesystemProvider(t *testing.T) {
+	plg, err := authPlugin.NewPlugin(authPlugin.Config{
+		Name:    "default",
+		BuiltIn: "allow-types",
+		Args:    []string{"upload", "download", "list", "get_tags", "get_metadata", "delete"},
+	})
+	assert.NoError(t, err)
+
+	port, err := getValidPort()
+	assert.NoError(t, err)
+
+	srv, err := NewServer(Config{
+		Addr:              fmt.Sprint("localhost:", port),
+		DefaultAuthPlugin: "default",
+		AllowRawBody:      true,
+	}, []authPlugin.Plugin{plg})
+	assert.NoError(t, err)
+
+	root := t.TempDir()
+	prov, err := provider.NewFilesystemProvider(&provider.FilesystemConfig{ConfigBase: provider.ConfigBase{ID: "fs"}, Root: root})
+	assert.NoError(t, err)
+	assert.NoError(t, srv.RegisterProvider(prov))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		assert.Nil(t, srv.Run(ctx))
+	}()
+	waitForServer(t, port)
+
+	client := http.Client{}
+
+	for _, key := range []string{"docs/a.txt", "docs/sub/b.txt", "top.txt"} {
+		req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://localhost:%d/file/fs/%s?tag=team:core", port, key), strings.NewReader("hello world"))
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", "text/plain")
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	t.Run("Download", func(t *testing.T) {
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/file/fs/docs/a.txt", port))
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello world", string(d))
+	})
+
+	t.Run("Range", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost:%d/file/fs/docs/a.txt", port), nil)
+		assert.NoError(t, err)
+		req.Header.Set("Range", "bytes=6-")
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+		assert.Equal(t, "bytes 6-10/11", resp.Header.Get("Content-Range"))
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "world", string(d))
+
+		req.Header.Set("Range", "bytes=100-")
+		resp, err = client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, resp.StatusCode)
+	})
+
+	t.Run("Not modified", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost:%d/file/fs/docs/a.txt", port), nil)
+		assert.NoError(t, err)
+		req.Header.Set("If-Modified-Since", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	})
+
+	t.Run("Conditions", func(t *testing.T) {
+		fileURL := fmt.Sprintf("http://localhost:%d/file/fs/top.txt", port)
+
+		resp, err := client.Get(fileURL)
+		assert.NoError(t, err)
+		etag := resp.Header.Get("ETag")
+		assert.NotEmpty(t, etag)
+		modTime, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+		assert.NoError(t, err)
+
+		for _, tc := range []struct {
+			header, value string
+			status        int
+		}{
+			{"If-None-Match", etag, http.StatusNotModified},
+			{"If-None-Match", `"other"`, http.StatusOK},
+			{"If-Match", etag, http.StatusOK},
+			{"If-Match", `"other"`, http.StatusPreconditionFailed},
+			{"If-Unmodified-Since", modTime.Add(-time.Hour).Format(http.TimeFormat), http.StatusPreconditionFailed},
+			{"If-Unmodified-Since", modTime.Format(http.TimeFormat), http.StatusOK},
+		} {
+			req, err := http.NewRequest(http.MethodGet, fileURL, nil)
+			assert.NoError(t, err)
+			req.Header.Set(tc.header, tc.value)
+
+			resp, err := client.Do(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.status, resp.StatusCode, "%s: %s", tc.header, tc.value)
+		}
+
+		// The entity tag changes when the file does, the size is changed since the modification time may not have changed yet
+		req, err := http.NewRequest(http.MethodPut, fileURL, strings.NewReader("hello world!"))
+		assert.NoError(t, err)
+		resp, err = client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		req, err = http.NewRequest(http.MethodGet, fileURL, nil)
+		assert.NoError(t, err)
+		req.Header.Set("If-Match", etag)
+
+		resp, err = client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	})
+
+	t.Run("Tags", func(t *testing.T) {
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/tags/fs/docs/a.txt", port))
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"team":"core"}`, string(d))
+	})
+
+	t.Run("List", func(t *testing.T) {
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/list/fs/docs/", port))
+		assert.NoError(t, err)
+
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"Keys":["docs/a.txt","docs/sub/b.txt"]}`, string(d))
+	})
+
+	t.Run("List with delimiter", func(t *testing.T) {
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/list/fs/?delimiter=/", port))
+		assert.NoError(t, err)
+
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"Keys":["top.txt"],"CommonPrefixes":["docs/"]}`, string(d))
+	})
+
+	t.Run("Key outside of root", func(t *testing.T) {
+		resp, err := client.Post(fmt.Sprintf("http://localhost:%d/batch", port), "application/json", strings.NewReader(`[{"op":"stat","provider":"fs","key":"../outside.txt"},{"op":"stat","provider":"fs","key":".file-butler/meta/top.txt.json"}]`))
+		assert.NoError(t, err)
+
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `[{"status":403,"error":"access denied"},{"status":403,"error":"access denied"}]`, string(d))
+	})
+
+	t.Run("Prefix outside of root", func(t *testing.T) {
+		// The server cleans the paths of the requests, so the provider is called directly
+		for _, prefix := range []string{"../", "../../etc/", "docs/../../", ".file-butler/meta/"} {
+			_, err := prov.ListObjects(context.Background(), prefix)
+			assert.ErrorIs(t, err, provider.ErrDenied, prefix)
+
+			_, err = prov.ListObjectsDelimited(context.Background(), prefix, "/")
+			assert.ErrorIs(t, err, provider.ErrDenied, prefix)
+		}
+	})
+
+	t.Run("Keys that look like sidecars", func(t *testing.T) {
+		// The sidecar of one key must not be in the way of the sidecar of another
+		for _, keys := range [][]string{{"a", "a.json/x"}, {"b.json/x", "b"}} {
+			for _, key := range keys {
+				req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://localhost:%d/file/fs/%s?tag=key:%s", port, key, key), strings.NewReader(key))
+				assert.NoError(t, err)
+
+				resp, err := client.Do(req)
+				assert.NoError(t, err)
+				assert.Equal(t, http.StatusOK, resp.StatusCode, key)
+			}
+
+			for _, key := range keys {
+				resp, err := client.Get(fmt.Sprintf("http://localhost:%d/tags/fs/%s", port, key))
+				assert.NoError(t, err)
+
+				d, err := io.ReadAll(resp.Body)
+				assert.NoError(t, err)
+				assert.JSONEq(t, fmt.Sprintf(`{"key":%q}`, key), string(d))
+			}
+		}
+
+		for _, key := range []string{"a", "a.json/x", "b.json/x", "b"} {
+			assert.NoError(t, prov.DeleteObject(context.Background(), key, provider.DeleteOptions{}))
+		}
+	})
+
+	t.Run("Symlinks", func(t *testing.T) {
+		outside := t.TempDir()
+		assert.NoError(t, os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o644))
+		assert.NoError(t, os.Symlink(outside, filepath.Join(root, "outside")))
+		assert.NoError(t, os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "secret.txt")))
+		assert.NoError(t, os.Symlink(filepath.Join(root, ".file-butler"), filepath.Join(root, "reserved")))
+		assert.NoError(t, os.Symlink(filepath.Join(root, "docs"), filepath.Join(root, "docs-link")))
+		defer func() {
+			for _, name := range []string{"outside", "secret.txt", "reserved", "docs-link"} {
+				os.Remove(filepath.Join(root, name))
+			}
+		}()
+
+		for _, key := range []string{"outside/secret.txt", "secret.txt", "reserved/meta/top.txt.json"} {
+			resp, err := client.Get(fmt.Sprintf("http://localhost:%d/file/fs/%s", port, key))
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusForbidden, resp.StatusCode, key)
+		}
+
+		req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://localhost:%d/file/fs/outside/new.txt", port), strings.NewReader("new"))
+		assert.NoError(t, err)
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		_, err = os.Stat(filepath.Join(outside, "new.txt"))
+		assert.True(t, os.IsNotExist(err))
+
+		_, err = prov.ListObjects(context.Background(), "outside/")
+		assert.ErrorIs(t, err, provider.ErrDenied)
+
+		// Symlinks that stay in the root can be used
+		resp, err = client.Get(fmt.Sprintf("http://localhost:%d/file/fs/docs-link/a.txt", port))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("Failed upload keeps the sidecar", func(t *testing.T) {
+		// The file of the object can not replace the docs directory, so the upload fails after the sidecar has been written
+		sidecarPath := filepath.Join(root, ".file-butler", "meta", "docs.json")
+		assert.NoError(t, os.WriteFile(sidecarPath, []byte(`{"content_type":"text/plain"}`), 0o644))
+
+		err := prov.PutObject(context.Background(), "docs", strings.NewReader("new"), provider.PutOptions{ContentType: "application/json"})
+		assert.Error(t, err)
+
+		b, err := os.ReadFile(sidecarPath)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"content_type":"text/plain"}`, string(b))
+
+		// Without a previous sidecar the new one is removed
+		assert.NoError(t, os.Remove(sidecarPath))
+
+		err = prov.PutObject(context.Background(), "docs", strings.NewReader("new"), provider.PutOptions{ContentType: "application/json"})
+		assert.Error(t, err)
+
+		_, err = os.Stat(sidecarPath)
+		assert.True(t, os.IsNotExist(err))
+
+		// No temporary files are left behind
+		entries, err := os.ReadDir(filepath.Join(root, ".file-butler", "tmp"))
+		assert.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("http://localhost:%d/file/fs/docs/sub/b.txt", port), nil)
+		assert.NoError(t, err)
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		// The directory is removed once it is empty
+		_, err = os.Stat(filepath.Join(root, "docs", "sub"))
+		assert.True(t, os.IsNotExist(err))
+
+		resp, err = client.Get(fmt.Sprintf("http://localhost:%d/file/fs/docs/sub/b.txt", port))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+// headerPlugin allows the requests that have the X-Test-Allow header set to yes
+type headerPlugin struct{}
+
+func (headerPlugin) Name() string { return "header" }
+
+func (headerPlugin) Stop() error { return nil }
+
+func (headerPlugin) Authorize(_ context.Context, req *authorization.AuthorizeRequest) error {
+	for _, h := range req.Headers {
+		if h.Key == "X-Test-Allow" && len(h.Values) == 1 && h.Values[0] == "yes" {
+			return nil
+		}
+	}
+
+	return status.Error(codes.PermissionDenied, "missing header")
+}
+
+func Test_MemoryProvider(t *testing.T) {
+	port, err := getValidPort()
+	assert.NoError(t, err)
+
+	srv, err := NewServer(Config{
+		Addr:              fmt.Sprint("localhost:", port),
+		DefaultAuthPlugin: "header",
+		AllowRawBody:      true,
+	}, []authPlugin.Plugin{headerPlugin{}})
+	assert.NoError(t, err)
+
+	prov, err := provider.NewMemoryProvider(&provider.MemoryConfig{
+		ConfigBase:     provider.ConfigBase{ID: "mem"},
+		MaxSize:        16,
+		PresignBaseURL: fmt.Sprintf("http://localhost:%d", port),
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, srv.RegisterProvider(prov))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		assert.Nil(t, srv.Run(ctx))
+	}()
+	waitForServer(t, port)
+
+	client := http.Client{}
+
+	do := func(method, url string, body string, allow bool) *http.Response {
+		req, err := http.NewRequest(method, url, strings.NewReader(body))
+		assert.NoError(t, err)
+		if allow {
+			req.Header.Set("X-Test-Allow", "yes")
+		}
+		req.Header.Set("Content-Type", "text/plain")
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	fileURL := func(key string) string {
+		return fmt.Sprintf("http://localhost:%d/file/mem/%s", port, key)
+	}
+
+	t.Run("Upload and download", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, do(http.MethodPut, fileURL("a.txt"), "aaaaaa", true).StatusCode)
+
+		resp := do(http.MethodGet, fileURL("a.txt"), "", true)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+		assert.NotEmpty(t, resp.Header.Get("Last-Modified"))
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "aaaaaa", string(d))
+	})
+
+	t.Run("Conditions", func(t *testing.T) {
+		resp := do(http.MethodGet, fileURL("a.txt"), "", true)
+		etag := resp.Header.Get("ETag")
+		assert.NotEmpty(t, etag)
+		modTime, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+		assert.NoError(t, err)
+
+		for _, tc := range []struct {
+			header, value string
+			status        int
+		}{
+			{"If-None-Match", etag, http.StatusNotModified},
+			{"If-None-Match", "W/" + etag, http.StatusNotModified},
+			{"If-None-Match", `"other", ` + etag, http.StatusNotModified},
+			{"If-None-Match", "*", http.StatusNotModified},
+			{"If-None-Match", `"other"`, http.StatusOK},
+			{"If-Match", etag, http.StatusOK},
+			{"If-Match", "*", http.StatusOK},
+			{"If-Match", `"other"`, http.StatusPreconditionFailed},
+			// If-Match uses the strong comparison
+			{"If-Match", "W/" + etag, http.StatusPreconditionFailed},
+			{"If-Unmodified-Since", modTime.Add(-time.Hour).Format(http.TimeFormat), http.StatusPreconditionFailed},
+			{"If-Unmodified-Since", modTime.Format(http.TimeFormat), http.StatusOK},
+		} {
+			req, err := http.NewRequest(http.MethodGet, fileURL("a.txt"), nil)
+			assert.NoError(t, err)
+			req.Header.Set("X-Test-Allow", "yes")
+			req.Header.Set(tc.header, tc.value)
+
+			resp, err := client.Do(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.status, resp.StatusCode, "%s: %s", tc.header, tc.value)
+		}
+
+		// A new upload changes the entity tag
+		assert.Equal(t, http.StatusOK, do(http.MethodPut, fileURL("a.txt"), "aaaaaa", true).StatusCode)
+
+		req, err := http.NewRequest(http.MethodGet, fileURL("a.txt"), nil)
+		assert.NoError(t, err)
+		req.Header.Set("X-Test-Allow", "yes")
+		req.Header.Set("If-Match", etag)
+
+		resp, err = client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	})
+
+	t.Run("Least recently used is evicted", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, do(http.MethodPut, fileURL("b.txt"), "bbbbbb", true).StatusCode)
+		// Reading a.txt makes b.txt the least recently used
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, fileURL("a.txt"), "", true).StatusCode)
+		assert.Equal(t, http.StatusOK, do(http.MethodPut, fileURL("c.txt"), "cccccc", true).StatusCode)
+
+		resp := do(http.MethodGet, fmt.Sprintf("http://localhost:%d/list/mem/", port), "", true)
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"Keys":["a.txt","c.txt"]}`, string(d))
+	})
+
+	t.Run("Too large", func(t *testing.T) {
+		assert.Equal(t, http.StatusRequestEntityTooLarge, do(http.MethodPut, fileURL("big.txt"), strings.Repeat("x", 17), true).StatusCode)
+	})
+
+	t.Run("Presigned download", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, do(http.MethodGet, fileURL("a.txt"), "", false).StatusCode)
+
+		resp := do(http.MethodPost, fmt.Sprintf("http://localhost:%d/presign/mem/a.txt?op=download", port), "", true)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		url, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+
+		resp = do(http.MethodGet, string(url), "", false)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "aaaaaa", string(d))
+
+		// The signature is only valid for the operation and key it was created for
+		assert.Equal(t, http.StatusForbidden, do(http.MethodPut, string(url), "changed", false).StatusCode)
+		assert.Equal(t, http.StatusForbidden, do(http.MethodGet, strings.Replace(string(url), "a.txt", "c.txt", 1), "", false).StatusCode)
+	})
+
+	t.Run("Presigned upload", func(t *testing.T) {
+		resp := do(http.MethodPost, fmt.Sprintf("http://localhost:%d/presign/mem/d.txt?op=upload", port), "", true)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		url, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, do(http.MethodPut, string(url), "dddddd", false).StatusCode)
+
+		resp = do(http.MethodGet, fileURL("d.txt"), "", true)
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "dddddd", string(d))
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, do(http.MethodDelete, fileURL("d.txt"), "", true).StatusCode)
+		assert.Equal(t, http.StatusNotFound, do(http.MethodGet, fileURL("d.txt"), "", true).StatusCode)
+	})
+}
+
+func Test_HTTPProvider(t *testing.T) {
+	modTime := time.Unix(1700000000, 0).UTC()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer origin-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/assets/logo v1.txt":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("ETag", `"v1"`)
+			http.ServeContent(w, r, "", modTime, strings.NewReader("hello origin"))
+		case "/assets/private.txt":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer origin.Close()
+
+	plg, err := authPlugin.NewPlugin(authPlugin.Config{
+		Name:    "default",
+		BuiltIn: "allow-types",
+		Args:    []string{"download", "upload", "list", "get_tags", "get_metadata"},
+	})
+	assert.NoError(t, err)
+
+	port, err := getValidPort()
+	assert.NoError(t, err)
+
+	srv, err := NewServer(Config{
+		Addr:              fmt.Sprint("localhost:", port),
+		DefaultAuthPlugin: "default",
+		AllowRawBody:      true,
+	}, []authPlugin.Plugin{plg})
+	assert.NoError(t, err)
+
+	_, err = provider.NewHTTPProvider(&provider.HTTPConfig{ConfigBase: provider.ConfigBase{ID: "web", Policy: provider.Policy{Mode: provider.ModeAppendOnly}}, BaseURL: origin.URL})
+	assert.Error(t, err)
+
+	prov, err := provider.NewHTTPProvider(&provider.HTTPConfig{
+		ConfigBase: provider.ConfigBase{ID: "web"},
+		BaseURL:    origin.URL + "/assets/",
+		Headers:    map[string]string{"Authorization": "Bearer origin-token"},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, srv.RegisterProvider(prov))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		assert.Nil(t, srv.Run(ctx))
+	}()
+	waitForServer(t, port)
+
+	client := http.Client{}
+	fileURL := fmt.Sprintf("http://localhost:%d/file/web/logo%%20v1.txt", port)
+
+	t.Run("Download", func(t *testing.T) {
+		resp, err := client.Get(fileURL)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+		assert.Equal(t, modTime.Format(http.TimeFormat), resp.Header.Get("Last-Modified"))
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello origin", string(d))
+	})
+
+	t.Run("Range", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, fileURL, nil)
+		assert.NoError(t, err)
+		req.Header.Set("Range", "bytes=6-")
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+		assert.Equal(t, "bytes 6-11/12", resp.Header.Get("Content-Range"))
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "origin", string(d))
+	})
+
+	t.Run("Not modified", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, fileURL, nil)
+		assert.NoError(t, err)
+		req.Header.Set("If-Modified-Since", modTime.Format(http.TimeFormat))
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	})
+
+	t.Run("Conditions", func(t *testing.T) {
+		for _, tc := range []struct {
+			header, value string
+			status        int
+		}{
+			{"If-None-Match", `"v1"`, http.StatusNotModified},
+			{"If-None-Match", `"v0"`, http.StatusOK},
+			{"If-Match", `"v0"`, http.StatusPreconditionFailed},
+			{"If-Match", `"v1"`, http.StatusOK},
+			{"If-Unmodified-Since", modTime.Add(-time.Hour).Format(http.TimeFormat), http.StatusPreconditionFailed},
+			{"If-Unmodified-Since", modTime.Format(http.TimeFormat), http.StatusOK},
+		} {
+			req, err := http.NewRequest(http.MethodGet, fileURL, nil)
+			assert.NoError(t, err)
+			req.Header.Set(tc.header, tc.value)
+
+			resp, err := client.Do(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.status, resp.StatusCode, "%s: %s", tc.header, tc.value)
+
+			if tc.status == http.StatusOK {
+				assert.Equal(t, `"v1"`, resp.Header.Get("ETag"))
+			}
+		}
+	})
+
+	t.Run("Key outside of base URL", func(t *testing.T) {
+		// The server cleans the paths of the requests, so the keys are sent in a batch
+		resp, err := client.Post(fmt.Sprintf("http://localhost:%d/batch", port), "application/json", strings.NewReader(`[{"op":"stat","provider":"web","key":"../secret.txt"},{"op":"stat","provider":"web","key":"sub/../../secret.txt"}]`))
+		assert.NoError(t, err)
+
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `[{"status":403,"error":"access denied"},{"status":403,"error":"access denied"}]`, string(d))
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/file/web/missing.txt", port))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp, err = client.Get(fmt.Sprintf("http://localhost:%d/file/web/private.txt", port))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("Tags", func(t *testing.T) {
+		// The origin has no tags, but the object must still exist
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/tags/web/logo%%20v1.txt", port))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		for path, status := range map[string]int{
+			"/tags/web/missing.txt": http.StatusNotFound,
+			"/meta/web/missing.txt": http.StatusNotFound,
+			"/tags/web/private.txt": http.StatusForbidden,
+			"/meta/web/private.txt": http.StatusForbidden,
+		} {
+			resp, err := client.Get(fmt.Sprintf("http://localhost:%d%s", port, path))
+			assert.NoError(t, err)
+			assert.Equal(t, status, resp.StatusCode, path)
+		}
+	})
+
+	t.Run("Stat", func(t *testing.T) {
+		resp, err := client.Post(fmt.Sprintf("http://localhost:%d/batch", port), "application/json", strings.NewReader(`[{"op":"stat","provider":"web","key":"logo v1.txt"}]`))
+		assert.NoError(t, err)
+
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `[{"status":200,"info":{"content_length":12,"content_type":"text/plain","last_modified":"2023-11-14T22:13:20Z"}}]`, string(d))
+	})
+
+	t.Run("Read-only", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPut, fileURL, strings.NewReader("changed"))
+		assert.NoError(t, err)
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		resp, err = client.Get(fmt.Sprintf("http://localhost:%d/list/web/", port))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+	})
+}
+
+func Test_WebDAVProvider(t *testing.T) {
+	dav := &webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	}
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "butler" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		dav.ServeHTTP(w, r)
+	}))
+	defer origin.Close()
+
+	// The base collection must exist on the server
+	req, err := http.NewRequest("MKCOL", origin.URL+"/dav/files/", nil)
+	assert.NoError(t, err)
+	req.SetBasicAuth("butler", "secret")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	plg, err := authPlugin.NewPlugin(authPlugin.Config{
+		Name:    "default",
+		BuiltIn: "allow-types",
+		Args:    []string{"upload", "download", "list", "get_tags", "get_metadata", "delete"},
+	})
+	assert.NoError(t, err)
+
+	port, err := getValidPort()
+	assert.NoError(t, err)
+
+	srv, err := NewServer(Config{
+		Addr:              fmt.Sprint("localhost:", port),
+		DefaultAuthPlugin: "default",
+		AllowRawBody:      true,
+	}, []authPlugin.Plugin{plg})
+	assert.NoError(t, err)
+
+	prov, err := provider.NewWebDAVProvider(&provider.WebDAVConfig{
+		ConfigBase: provider.ConfigBase{ID: "dav"},
+		URL:        origin.URL + "/dav/files/",
+		Username:   "butler",
+		Password:   "secret",
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, srv.RegisterProvider(prov))
+
+	unauthorized, err := provider.NewWebDAVProvider(&provider.WebDAVConfig{
+		ConfigBase: provider.ConfigBase{ID: "dav-unauthorized"},
+		URL:        origin.URL + "/dav/files/",
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, srv.RegisterProvider(unauthorized))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		assert.Nil(t, srv.Run(ctx))
+	}()
+	waitForServer(t, port)
+
+	client := http.Client{}
+
+	for _, key := range []string{"docs/a.txt", "docs/sub/b c.txt", "top.txt"} {
+		req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://localhost:%d/file/dav/%s?tag=team:core", port, key), strings.NewReader("hello world"))
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", "text/x-butler")
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	t.Run("Download", func(t *testing.T) {
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/file/dav/docs/sub/b%%20c.txt", port))
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/x-butler", resp.Header.Get("Content-Type"))
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello world", string(d))
+	})
+
+	t.Run("Range", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost:%d/file/dav/docs/a.txt", port), nil)
+		assert.NoError(t, err)
+		req.Header.Set("Range", "bytes=6-")
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+		assert.Equal(t, "bytes 6-10/11", resp.Header.Get("Content-Range"))
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "world", string(d))
+	})
+
+	t.Run("Tags", func(t *testing.T) {
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/tags/dav/docs/a.txt", port))
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"team":"core"}`, string(d))
+	})
+
+	t.Run("Stat", func(t *testing.T) {
+		resp, err := client.Post(fmt.Sprintf("http://localhost:%d/batch", port), "application/json", strings.NewReader(`[{"op":"stat","provider":"dav","key":"top.txt"},{"op":"stat","provider":"dav","key":"docs"},{"op":"stat","provider":"dav","key":"../outside.txt"}]`))
+		assert.NoError(t, err)
+
+		var results []struct {
+			Status int `json:"status"`
+			Info   struct {
+				ContentLength int64  `json:"content_length"`
+				ContentType   string `json:"content_type"`
+			} `json:"info"`
+		}
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&results))
+		assert.Len(t, results, 3)
+		assert.Equal(t, http.StatusOK, results[0].Status)
+		assert.Equal(t, int64(11), results[0].Info.ContentLength)
+		assert.Equal(t, "text/x-butler", results[0].Info.ContentType)
+		// Collections are not objects
+		assert.Equal(t, http.StatusNotFound, results[1].Status)
+		assert.Equal(t, http.StatusForbidden, results[2].Status)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/list/dav/docs/", port))
+		assert.NoError(t, err)
+
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"Keys":["docs/a.txt","docs/sub/b c.txt"]}`, string(d))
+
+		resp, err = client.Get(fmt.Sprintf("http://localhost:%d/list/dav/", port))
+		assert.NoError(t, err)
+
+		d, err = io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"Keys":["docs/a.txt","docs/sub/b c.txt","top.txt"]}`, string(d))
+
+		resp, err = client.Get(fmt.Sprintf("http://localhost:%d/list/dav/missing/", port))
+		assert.NoError(t, err)
+
+		d, err = io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"Keys":[]}`, string(d))
+	})
+
+	t.Run("Credentials", func(t *testing.T) {
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/file/dav-unauthorized/top.txt", port))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("http://localhost:%d/file/dav/docs/a.txt", port), nil)
+		assert.NoError(t, err)
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp, err = client.Get(fmt.Sprintf("http://localhost:%d/file/dav/docs/a.txt", port))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		// Collections can not be deleted through the provider
+		req, err = http.NewRequest(http.MethodDelete, fmt.Sprintf("http://localhost:%d/file/dav/docs", port), nil)
+		assert.NoError(t, err)
+
+		resp, err = client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+// startSFTPServer serves the directory over SFTP to the user butler, who can log in with the password secret or the client key
+func startSFTPServer(t *testing.T, root string, clientKey ssh.PublicKey) (string, ssh.PublicKey) {
+	_, hostKey, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	assert.NoError(t, err)
+
+	cfg := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if c.User() == "butler" && string(password) == "secret" {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("invalid password")
+		},
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if c.User() == "butler" && bytes.Equal(key.Marshal(), clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("invalid key")
+		},
+	}
+	cfg.AddHostKey(hostSigner)
+
+	l, err := net.Listen("tcp", "localhost:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+
+				for newChan := range chans {
+					if newChan.ChannelType() != "session" {
+						newChan.Reject(ssh.UnknownChannelType, "")
+						continue
+					}
+
+					ch, requests, err := newChan.Accept()
+					if err != nil {
+						return
+					}
+
+					go func() {
+						for req := range requests {
+							ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+							req.Reply(ok, nil)
+							if !ok {
+								continue
+							}
+
+							srv, err := sftp.NewServer(ch, sftp.WithServerWorkingDirectory(root))
+							if err != nil {
+								ch.Close()
+								return
+							}
+							go func() {
+								srv.Serve()
+								srv.Close()
+							}()
+						}
+					}()
+				}
+			}()
+		}
+	}()
+
+	return l.Addr().String(), hostSigner.PublicKey()
+}
+
+func Test_SFTPProvider(t *testing.T) {
+	clientPub, clientKey, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	clientSSHPub, err := ssh.NewPublicKey(clientPub)
+	assert.NoError(t, err)
+
+	block, err := ssh.MarshalPrivateKey(clientKey, "")
+	assert.NoError(t, err)
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(block), 0o600))
+
+	root := t.TempDir()
+	addr, hostKey := startSFTPServer(t, root, clientSSHPub)
+	pinnedHostKey := string(ssh.MarshalAuthorizedKey(hostKey))
+
+	plg, err := authPlugin.NewPlugin(authPlugin.Config{
+		Name:    "default",
+		BuiltIn: "allow-types",
+		Args:    []string{"upload", "download", "list", "get_tags", "get_metadata", "delete"},
+	})
+	assert.NoError(t, err)
+
+	port, err := getValidPort()
+	assert.NoError(t, err)
+
+	srv, err := NewServer(Config{
+		Addr:              fmt.Sprint("localhost:", port),
+		DefaultAuthPlugin: "default",
+		AllowRawBody:      true,
+	}, []authPlugin.Plugin{plg})
+	assert.NoError(t, err)
+
+	_, err = provider.NewSFTPProvider(&provider.SFTPConfig{ConfigBase: provider.ConfigBase{ID: "sftp"}, Host: addr, User: "butler", Password: "secret"})
+	assert.Error(t, err, "the host key must be pinned")
+
+	prov, err := provider.NewSFTPProvider(&provider.SFTPConfig{
+		ConfigBase: provider.ConfigBase{ID: "sftp"},
+		Host:       addr,
+		User:       "butler",
+		Password:   "secret",
+		HostKey:    pinnedHostKey,
+		Root:       "finance",
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, srv.RegisterProvider(prov))
+
+	keyProv, err := provider.NewSFTPProvider(&provider.SFTPConfig{
+		ConfigBase:     provider.ConfigBase{ID: "sftp-key"},
+		Host:           addr,
+		User:           "butler",
+		PrivateKeyFile: keyFile,
+		HostKey:        pinnedHostKey,
+		Root:           filepath.Join(root, "finance"),
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, srv.RegisterProvider(keyProv))
+
+	_, otherHostKey, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	otherSigner, err := ssh.NewSignerFromKey(otherHostKey)
+	assert.NoError(t, err)
+
+	wrongHostProv, err := provider.NewSFTPProvider(&provider.SFTPConfig{
+		ConfigBase: provider.ConfigBase{ID: "sftp-wrong-host"},
+		Host:       addr,
+		User:       "butler",
+		Password:   "secret",
+		HostKey:    string(ssh.MarshalAuthorizedKey(otherSigner.PublicKey())),
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, srv.RegisterProvider(wrongHostProv))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		assert.Nil(t, srv.Run(ctx))
+	}()
+	waitForServer(t, port)
+
+	client := http.Client{}
+
+	for _, key := range []string{"in/a.csv", "in/2024/b.csv", "top.csv"} {
+		req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://localhost:%d/file/sftp/%s?tag=partner:bank", port, key), strings.NewReader("hello world"))
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", "text/csv")
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	t.Run("Download", func(t *testing.T) {
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/file/sftp/in/a.csv", port))
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/csv", resp.Header.Get("Content-Type"))
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello world", string(d))
+
+		// The files are stored under the root on the server
+		d, err = os.ReadFile(filepath.Join(root, "finance", "in", "a.csv"))
+		assert.NoError(t, err)
+		assert.Equal(t, "hello world", string(d))
+	})
+
+	t.Run("Range", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost:%d/file/sftp/in/a.csv", port), nil)
+		assert.NoError(t, err)
+		req.Header.Set("Range", "bytes=6-")
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+		assert.Equal(t, "bytes 6-10/11", resp.Header.Get("Content-Range"))
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "world", string(d))
+	})
+
+	t.Run("Conditions", func(t *testing.T) {
+		fileURL := fmt.Sprintf("http://localhost:%d/file/sftp/in/a.csv", port)
+
+		resp, err := client.Get(fileURL)
+		assert.NoError(t, err)
+		etag := resp.Header.Get("ETag")
+		assert.NotEmpty(t, etag)
+
+		for _, tc := range []struct {
+			header, value string
+			status        int
+		}{
+			{"If-None-Match", etag, http.StatusNotModified},
+			{"If-Match", etag, http.StatusOK},
+			{"If-Match", `"other"`, http.StatusPreconditionFailed},
+			{"If-Unmodified-Since", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), http.StatusPreconditionFailed},
+		} {
+			req, err := http.NewRequest(http.MethodGet, fileURL, nil)
+			assert.NoError(t, err)
+			req.Header.Set(tc.header, tc.value)
+
+			resp, err := client.Do(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.status, resp.StatusCode, "%s: %s", tc.header, tc.value)
+		}
+	})
+
+	t.Run("Overwrite", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://localhost:%d/file/sftp/top.csv", port), strings.NewReader("updated"))
+		assert.NoError(t, err)
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp, err = client.Get(fmt.Sprintf("http://localhost:%d/file/sftp/top.csv", port))
+		assert.NoError(t, err)
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "updated", string(d))
+
+		// No temporary files are left behind
+		entries, err := os.ReadDir(filepath.Join(root, "finance", ".file-butler", "tmp"))
+		assert.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+
+	t.Run("Tags", func(t *testing.T) {
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/tags/sftp/in/a.csv", port))
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"partner":"bank"}`, string(d))
+	})
+
+	t.Run("Stat", func(t *testing.T) {
+		resp, err := client.Post(fmt.Sprintf("http://localhost:%d/batch", port), "application/json", strings.NewReader(`[{"op":"stat","provider":"sftp","key":"in/a.csv"},{"op":"stat","provider":"sftp","key":"in"},{"op":"stat","provider":"sftp","key":"../outside.csv"}]`))
+		assert.NoError(t, err)
+
+		var results []struct {
+			Status int `json:"status"`
+			Info   struct {
+				ContentLength int64  `json:"content_length"`
+				ContentType   string `json:"content_type"`
+			} `json:"info"`
+		}
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&results))
+		assert.Len(t, results, 3)
+		assert.Equal(t, http.StatusOK, results[0].Status)
+		assert.Equal(t, int64(11), results[0].Info.ContentLength)
+		assert.Equal(t, "text/csv", results[0].Info.ContentType)
+		assert.Equal(t, http.StatusNotFound, results[1].Status)
+		assert.Equal(t, http.StatusForbidden, results[2].Status)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/list/sftp/in/", port))
+		assert.NoError(t, err)
+
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"Keys":["in/2024/b.csv","in/a.csv"]}`, string(d))
+
+		resp, err = client.Get(fmt.Sprintf("http://localhost:%d/list/sftp/", port))
+		assert.NoError(t, err)
+
+		d, err = io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"Keys":["in/2024/b.csv","in/a.csv","top.csv"]}`, string(d))
+
+		resp, err = client.Get(fmt.Sprintf("http://localhost:%d/list/sftp/missing/", port))
+		assert.NoError(t, err)
+
+		d, err = io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"Keys":[]}`, string(d))
+	})
+
+	t.Run("Keys that look like sidecars", func(t *testing.T) {
+		// The sidecar of one key must not be in the way of the sidecar of another
+		for _, keys := range [][]string{{"a", "a.json/x"}, {"b.json/x", "b"}} {
+			for _, key := range keys {
+				req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://localhost:%d/file/sftp/%s?tag=key:%s", port, key, key), strings.NewReader(key))
+				assert.NoError(t, err)
+
+				resp, err := client.Do(req)
+				assert.NoError(t, err)
+				assert.Equal(t, http.StatusOK, resp.StatusCode, key)
+			}
+
+			for _, key := range keys {
+				resp, err := client.Get(fmt.Sprintf("http://localhost:%d/tags/sftp/%s", port, key))
+				assert.NoError(t, err)
+
+				d, err := io.ReadAll(resp.Body)
+				assert.NoError(t, err)
+				assert.JSONEq(t, fmt.Sprintf(`{"key":%q}`, key), string(d))
+			}
+		}
+
+		for _, key := range []string{"a", "a.json/x", "b.json/x", "b"} {
+			assert.NoError(t, prov.DeleteObject(context.Background(), key, provider.DeleteOptions{}))
+		}
+	})
+
+	t.Run("Prefix outside of root", func(t *testing.T) {
+		// The server cleans the paths of the requests, so the provider is called directly
+		for _, prefix := range []string{"../", "../../etc/", "in/../../", ".file-butler/meta/"} {
+			_, err := prov.ListObjects(context.Background(), prefix)
+			assert.ErrorIs(t, err, provider.ErrDenied, prefix)
+		}
+	})
+
+	t.Run("Key auth", func(t *testing.T) {
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/file/sftp-key/in/a.csv", port))
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello world", string(d))
+	})
+
+	t.Run("Wrong host key", func(t *testing.T) {
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/file/sftp-wrong-host/in/a.csv", port))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("http://localhost:%d/file/sftp/in/2024/b.csv", port), nil)
+		assert.NoError(t, err)
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		// The directory is removed once it is empty
+		_, err = os.Stat(filepath.Join(root, "finance", "in", "2024"))
+		assert.True(t, os.IsNotExist(err))
+
+		resp, err = client.Get(fmt.Sprintf("http://localhost:%d/file/sftp/in/2024/b.csv", port))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+func Test_SQLiteProvider(t *testing.T) {
+	plg, err := authPlugin.NewPlugin(authPlugin.Config{
+		Name:    "default",
+		BuiltIn: "allow-types",
+		Args:    []string{"upload", "download", "list", "get_tags", "get_metadata", "delete"},
+	})
+	assert.NoError(t, err)
+
+	port, err := getValidPort()
+	assert.NoError(t, err)
+
+	srv, err := NewServer(Config{
+		Addr:              fmt.Sprint("localhost:", port),
+		DefaultAuthPlugin: "default",
+		AllowRawBody:      true,
+	}, []authPlugin.Plugin{plg})
+	assert.NoError(t, err)
+
+	dbPath := filepath.Join(t.TempDir(), "objects.db")
+	// A small chunk size makes every object span several chunks
+	prov, err := provider.NewSQLiteProvider(&provider.SQLiteConfig{ConfigBase: provider.ConfigBase{ID: "db"}, Path: dbPath, ChunkSize: 4})
+	assert.NoError(t, err)
+	assert.NoError(t, srv.RegisterProvider(prov))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		assert.Nil(t, srv.Run(ctx))
+	}()
+	waitForServer(t, port)
+
+	client := http.Client{}
+
+	for _, key := range []string{"docs/a.txt", "docs/sub/b.txt", "docs_other.txt", "top.txt"} {
+		req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://localhost:%d/file/db/%s?tag=team:edge", port, key), strings.NewReader("hello world"))
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", "text/plain")
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	t.Run("Download", func(t *testing.T) {
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/file/db/docs/a.txt", port))
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+		assert.Equal(t, "11", resp.Header.Get("Content-Length"))
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello world", string(d))
+	})
+
+	t.Run("Range", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost:%d/file/db/docs/a.txt", port), nil)
+		assert.NoError(t, err)
+		req.Header.Set("Range", "bytes=3-8")
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+		assert.Equal(t, "bytes 3-8/11", resp.Header.Get("Content-Range"))
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "lo wor", string(d))
+
+		req.Header.Set("Range", "bytes=100-")
+		resp, err = client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, resp.StatusCode)
+	})
+
+	t.Run("Not modified", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost:%d/file/db/docs/a.txt", port), nil)
+		assert.NoError(t, err)
+		req.Header.Set("If-Modified-Since", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+		req.Header.Set("If-Modified-Since", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+
+		resp, err = client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("Conditions", func(t *testing.T) {
+		fileURL := fmt.Sprintf("http://localhost:%d/file/db/docs/a.txt", port)
+
+		resp, err := client.Get(fileURL)
+		assert.NoError(t, err)
+		etag := resp.Header.Get("ETag")
+		assert.NotEmpty(t, etag)
+		modTime, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+		assert.NoError(t, err)
+
+		for _, tc := range []struct {
+			header, value string
+			status        int
+		}{
+			{"If-None-Match", etag, http.StatusNotModified},
+			{"If-None-Match", `"other"`, http.StatusOK},
+			{"If-Match", etag, http.StatusOK},
+			{"If-Match", `"other"`, http.StatusPreconditionFailed},
+			{"If-Unmodified-Since", modTime.Add(-time.Hour).Format(http.TimeFormat), http.StatusPreconditionFailed},
+			{"If-Unmodified-Since", modTime.Format(http.TimeFormat), http.StatusOK},
+		} {
+			req, err := http.NewRequest(http.MethodGet, fileURL, nil)
+			assert.NoError(t, err)
+			req.Header.Set(tc.header, tc.value)
+
+			resp, err := client.Do(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.status, resp.StatusCode, "%s: %s", tc.header, tc.value)
+		}
+
+		// A failed If-Match takes precedence over an unmodified If-Modified-Since
+		req, err := http.NewRequest(http.MethodGet, fileURL, nil)
+		assert.NoError(t, err)
+		req.Header.Set("If-Match", `"other"`)
+		req.Header.Set("If-Modified-Since", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+
+		resp, err = client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	})
+
+	t.Run("Overwrite", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://localhost:%d/file/db/top.txt", port), strings.NewReader("bye"))
+		assert.NoError(t, err)
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp, err = client.Get(fmt.Sprintf("http://localhost:%d/file/db/top.txt", port))
+		assert.NoError(t, err)
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "bye", string(d))
+
+		resp, err = client.Get(fmt.Sprintf("http://localhost:%d/tags/db/top.txt", port))
+		assert.NoError(t, err)
+		d, err = io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{}`, string(d))
+	})
+
+	t.Run("Tags", func(t *testing.T) {
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/tags/db/docs/a.txt", port))
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"team":"edge"}`, string(d))
+	})
+
+	t.Run("List", func(t *testing.T) {
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/list/db/docs/", port))
+		assert.NoError(t, err)
+
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"Keys":["docs/a.txt","docs/sub/b.txt"]}`, string(d))
+
+		resp, err = client.Get(fmt.Sprintf("http://localhost:%d/list/db/?delimiter=/", port))
+		assert.NoError(t, err)
+
+		d, err = io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"Keys":["docs_other.txt","top.txt"],"CommonPrefixes":["docs/"]}`, string(d))
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("http://localhost:%d/file/db/docs/sub/b.txt", port), nil)
+		assert.NoError(t, err)
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp, err = client.Get(fmt.Sprintf("http://localhost:%d/file/db/docs/sub/b.txt", port))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp, err = client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("Persisted", func(t *testing.T) {
+		reopened, err := provider.NewSQLiteProvider(&provider.SQLiteConfig{ConfigBase: provider.ConfigBase{ID: "db"}, Path: dbPath})
+		assert.NoError(t, err)
+
+		r, info, err := reopened.GetObject(context.Background(), "docs/a.txt", provider.GetOptions{Range: "bytes=6-"})
+		assert.NoError(t, err)
+		defer r.Close()
+
+		assert.Equal(t, "bytes 6-10/11", *info.ContentRange)
+		d, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, "world", string(d))
+	})
+}
+
+func Test_S3Provider(t *testing.T) {
+	backend := s3mem.New()
+	assert.NoError(t, backend.CreateBucket("files"))
+
+	// The stand-in is served over TLS with a self-signed certificate to test the trust settings
+	stub := httptest.NewTLSServer(gofakes3.New(backend).Server())
+	defer stub.Close()
+
+	caBundle := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(t, os.WriteFile(caBundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: stub.Certificate().Raw}), 0o600))
+
+	plg, err := authPlugin.NewPlugin(authPlugin.Config{
+		Name:    "default",
+		BuiltIn: "allow-types",
+		Args:    []string{"upload", "download", "list", "get_metadata", "delete"},
+	})
+	assert.NoError(t, err)
+
+	port, err := getValidPort()
+	assert.NoError(t, err)
+
+	srv, err := NewServer(Config{
+		Addr:              fmt.Sprint("localhost:", port),
+		DefaultAuthPlugin: "default",
+		AllowRawBody:      true,
+	}, []authPlugin.Plugin{plg})
+	assert.NoError(t, err)
+
+	_, err = provider.NewS3Provider(&provider.S3Config{ConfigBase: provider.ConfigBase{ID: "s3"}, Bucket: "files", Endpoint: stub.URL, AccessKey: "butler"})
+	assert.Error(t, err, "the secret key is missing")
+
+	newProvider := func(id string, configure func(cfg *provider.S3Config)) {
+		cfg := &provider.S3Config{
+			ConfigBase: provider.ConfigBase{ID: id},
+			Bucket:     "files",
+			Endpoint:   stub.URL,
+			PathStyle:  true,
+			AccessKey:  "butler",
+			SecretKey:  "secret",
+		}
+		configure(cfg)
+
+		prov, err := provider.NewS3Provider(cfg)
+		assert.NoError(t, err)
+		assert.NoError(t, srv.RegisterProvider(prov))
+	}
+
+	newProvider("s3", func(cfg *provider.S3Config) { cfg.CABundle = caBundle })
+	newProvider("s3-insecure", func(cfg *provider.S3Config) { cfg.InsecureSkipVerify = true })
+	newProvider("s3-untrusted", func(cfg *provider.S3Config) {})
+
+	// The SDK retries the requests to the untrusted endpoint with a backoff, which can take several seconds
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	go func() {
+		assert.Nil(t, srv.Run(ctx))
+	}()
+	waitForServer(t, port)
+
+	client := http.Client{}
+
+	for _, key := range []string{"docs/a.txt", "docs/sub/b.txt", "top.txt"} {
+		req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://localhost:%d/file/s3/%s", port, key), strings.NewReader("hello world"))
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", "text/plain")
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	t.Run("Download", func(t *testing.T) {
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/file/s3/docs/a.txt", port))
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello world", string(d))
+
+		// The object is stored in the bucket of the stand-in
+		obj, err := backend.HeadObject("files", "docs/a.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(11), obj.Size)
+	})
+
+	t.Run("Range", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost:%d/file/s3/docs/a.txt", port), nil)
+		assert.NoError(t, err)
+		req.Header.Set("Range", "bytes=6-")
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+		assert.Equal(t, "bytes 6-10/11", resp.Header.Get("Content-Range"))
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "world", string(d))
+	})
+
+	t.Run("Conditions", func(t *testing.T) {
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/file/s3/docs/a.txt", port))
+		assert.NoError(t, err)
+		etag := resp.Header.Get("ETag")
+		assert.NotEmpty(t, etag)
+
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost:%d/file/s3/docs/a.txt", port), nil)
+		assert.NoError(t, err)
+		req.Header.Set("If-None-Match", etag)
+
+		resp, err = client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/list/s3/docs/", port))
+		assert.NoError(t, err)
+
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"Keys":["docs/a.txt","docs/sub/b.txt"]}`, string(d))
+
+		resp, err = client.Get(fmt.Sprintf("http://localhost:%d/list/s3/?delimiter=/", port))
+		assert.NoError(t, err)
+
+		d, err = io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"Keys":["top.txt"],"CommonPrefixes":["docs/"]}`, string(d))
+	})
+
+	t.Run("TLS", func(t *testing.T) {
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/file/s3-insecure/top.txt", port))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		// The self-signed certificate is not trusted without the CA bundle
+		resp, err = client.Get(fmt.Sprintf("http://localhost:%d/file/s3-untrusted/top.txt", port))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("http://localhost:%d/file/s3/docs/sub/b.txt", port), nil)
+		assert.NoError(t, err)
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp, err = client.Get(fmt.Sprintf("http://localhost:%d/file/s3/docs/sub/b.txt", port))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+func Test_S3ErrorMapping(t *testing.T) {
+	// The stand-in answers every request with the error that the key or bucket asks for
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError := func(status int, code string) {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(status)
+			// Responses to HEAD requests have no body, so the client only sees the status
+			if r.Method != http.MethodHead {
+				fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>stub error</Message></Error>`, code)
+			}
+		}
+
+		if strings.HasPrefix(r.URL.Path, "/gone/") {
+			writeError(http.StatusNotFound, "NoSuchBucket")
+			return
+		}
+
+		switch strings.TrimPrefix(r.URL.Path, "/files/") {
+		case "denied.txt":
+			writeError(http.StatusForbidden, "AccessDenied")
+		case "locked.txt":
+			writeError(http.StatusPreconditionFailed, "PreconditionFailed")
+		case "range.txt":
+			writeError(http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+		case "broken.txt":
+			writeError(http.StatusBadRequest, "InvalidArgument")
+		case "changed.txt":
+			// The stand-in fails any condition, so the object is only reported as missing if they are not forwarded
+			if r.Header.Get("If-Match") != "" || r.Header.Get("If-Unmodified-Since") != "" {
+				writeError(http.StatusPreconditionFailed, "PreconditionFailed")
+				return
+			}
+			writeError(http.StatusNotFound, "NoSuchKey")
+		default:
+			writeError(http.StatusNotFound, "NoSuchKey")
+		}
+	}))
+	defer stub.Close()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow.txt":
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer origin.Close()
+
+	plg, err := authPlugin.NewPlugin(authPlugin.Config{
+		Name:    "default",
+		BuiltIn: "allow-types",
+		Args:    []string{"download", "get_metadata"},
+	})
+	assert.NoError(t, err)
+
+	port, err := getValidPort()
+	assert.NoError(t, err)
+
+	srv, err := NewServer(Config{
+		Addr:              fmt.Sprint("localhost:", port),
+		DefaultAuthPlugin: "default",
+	}, []authPlugin.Plugin{plg})
+	assert.NoError(t, err)
+
+	for id, bucket := range map[string]string{"s3": "files", "s3-gone": "gone"} {
+		prov, err := provider.NewS3Provider(&provider.S3Config{
+			ConfigBase: provider.ConfigBase{ID: id},
+			Bucket:     bucket,
+			Endpoint:   stub.URL,
+			PathStyle:  true,
+			AccessKey:  "butler",
+			SecretKey:  "secret",
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, srv.RegisterProvider(prov))
+	}
+
+	web, err := provider.NewHTTPProvider(&provider.HTTPConfig{ConfigBase: provider.ConfigBase{ID: "web"}, BaseURL: origin.URL})
+	assert.NoError(t, err)
+	assert.NoError(t, srv.RegisterProvider(web))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		assert.Nil(t, srv.Run(ctx))
+	}()
+	waitForServer(t, port)
+
+	client := http.Client{}
+
+	tests := []struct {
+		path       string
+		rangeValue string
+		ifMatch    string
+		status     int
+		retryAfter string
+	}{
+		{path: "/file/s3/missing.txt", status: http.StatusNotFound},
+		{path: "/file/s3/denied.txt", status: http.StatusForbidden},
+		{path: "/file/s3/locked.txt", status: http.StatusPreconditionFailed},
+		{path: "/file/s3/range.txt", rangeValue: "bytes=100-", status: http.StatusRequestedRangeNotSatisfiable},
+		{path: "/file/s3/broken.txt", status: http.StatusInternalServerError},
+		{path: "/file/s3/changed.txt", ifMatch: `"v0"`, status: http.StatusPreconditionFailed},
+		{path: "/file/s3/changed.txt", status: http.StatusNotFound},
+		{path: "/file/s3-gone/missing.txt", status: http.StatusBadGateway},
+		{path: "/meta/s3/denied.txt", status: http.StatusForbidden},
+		{path: "/file/web/slow.txt", status: http.StatusTooManyRequests, retryAfter: "7"},
+		{path: "/file/web/busy.txt", status: http.StatusServiceUnavailable, retryAfter: "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost:%d%s", port, tt.path), nil)
+			assert.NoError(t, err)
+			if tt.rangeValue != "" {
+				req.Header.Set("Range", tt.rangeValue)
+			}
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+
+			resp, err := client.Do(req)
+			assert.NoError(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, tt.status, resp.StatusCode)
+			assert.Equal(t, tt.retryAfter, resp.Header.Get("Retry-After"))
+		})
+	}
+
+	t.Run("StatNotFound", func(t *testing.T) {
+		// HeadObject reports a missing key with only the status code
+		p, release := srv.getProvider("s3")
+		defer release()
+
+		_, err := p.(provider.Stater).StatObject(ctx, "missing.txt")
+		assert.ErrorIs(t, err, provider.ErrNotFound)
+
+		_, err = p.(provider.Stater).StatObject(ctx, "locked.txt")
+		assert.ErrorIs(t, err, provider.ErrPreconditionFailed)
+	})
+}
+
+func Test_S3Encryption(t *testing.T) {
+	backend := s3mem.New()
+	assert.NoError(t, backend.CreateBucket("files"))
+
+	// The headers of the requests to the stand-in are recorded per method and path, since it does not implement encryption itself
+	var mu sync.Mutex
+	received := map[string]http.Header{}
+	fake := gofakes3.New(backend).Server()
+	// TLS is used since the SDK only streams the body of an upload without hashing it first over TLS
+	stub := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		received[r.Method+" "+r.URL.Path] = r.Header.Clone()
+		mu.Unlock()
+		fake.ServeHTTP(w, r)
+	}))
+	defer stub.Close()
+
+	lastHeaders := func(method, path string) http.Header {
+		mu.Lock()
+		defer mu.Unlock()
+		return received[method+" "+path]
+	}
+
+	customerKey := bytes.Repeat([]byte{7}, 32)
+	keyFile := filepath.Join(t.TempDir(), "sse.key")
+	assert.NoError(t, os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(customerKey)), 0o600))
+
+	plg, err := authPlugin.NewPlugin(authPlugin.Config{
+		Name:    "default",
+		BuiltIn: "allow-types",
+		Args:    []string{"upload", "download", "get_metadata"},
+	})
+	assert.NoError(t, err)
+
+	port, err := getValidPort()
+	assert.NoError(t, err)
+
+	srv, err := NewServer(Config{
+		Addr:              fmt.Sprint("localhost:", port),
+		DefaultAuthPlugin: "default",
+		AllowRawBody:      true,
+	}, []authPlugin.Plugin{plg})
+	assert.NoError(t, err)
+
+	baseCfg := func(id string) *provider.S3Config {
+		return &provider.S3Config{
+			ConfigBase: provider.ConfigBase{ID: id},
+			Bucket:     "files",
+			Endpoint:   stub.URL,
+			PathStyle:  true,
+			AccessKey:  "butler",
+			SecretKey:  "secret",
+
+			InsecureSkipVerify: true,
+		}
+	}
+
+	invalid := []func(cfg *provider.S3Config){
+		func(cfg *provider.S3Config) { cfg.SSE = "rot13" },
+		func(cfg *provider.S3Config) { cfg.SSEKMSKeyID = "alias/files" },
+		func(cfg *provider.S3Config) { cfg.SSE = "customer" },
+		func(cfg *provider.S3Config) {
+			cfg.SSE = "customer"
+			cfg.SSECustomerKeyFile = keyFile
+			cfg.PresignEnabled = true
+		},
+	}
+	for _, configure := range invalid {
+		cfg := baseCfg("invalid")
+		configure(cfg)
+		_, err := provider.NewS3Provider(cfg)
+		assert.Error(t, err)
+	}
+
+	kmsCfg := baseCfg("kms")
+	kmsCfg.SSE = "kms"
+	kmsCfg.SSEKMSKeyID = "alias/files"
+	kmsCfg.StorageClass = "STANDARD_IA"
+	kmsCfg.AllowedStorageClasses = []string{"GLACIER_IR"}
+	kmsCfg.PresignEnabled = true
+	kms, err := provider.NewS3Provider(kmsCfg)
+	assert.NoError(t, err)
+	assert.NoError(t, srv.RegisterProvider(kms))
+
+	customerCfg := baseCfg("customer")
+	customerCfg.SSE = "customer"
+	customerCfg.SSECustomerKeyFile = keyFile
+	customer, err := provider.NewS3Provider(customerCfg)
+	assert.NoError(t, err)
+	assert.NoError(t, srv.RegisterProvider(customer))
+
+	memory, err := provider.NewMemoryProvider(&provider.MemoryConfig{ConfigBase: provider.ConfigBase{ID: "memory"}})
+	assert.NoError(t, err)
+	assert.NoError(t, srv.RegisterProvider(memory))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		assert.Nil(t, srv.Run(ctx))
+	}()
+	waitForServer(t, port)
+
+	client := http.Client{}
+
+	upload := func(providerID, key, storageClass string) *http.Response {
+		req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://localhost:%d/file/%s/%s", port, providerID, key), strings.NewReader("secret data"))
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", "text/plain")
+		if storageClass != "" {
+			req.Header.Set("X-Storage-Class", storageClass)
+		}
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	t.Run("KMS", func(t *testing.T) {
+		resp := upload("kms", "default.txt", "")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		h := lastHeaders(http.MethodPut, "/files/default.txt")
+		assert.Equal(t, "aws:kms", h.Get("X-Amz-Server-Side-Encryption"))
+		assert.Equal(t, "alias/files", h.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"))
+		assert.Equal(t, "STANDARD_IA", h.Get("X-Amz-Storage-Class"))
+	})
+
+	t.Run("StorageClassOverride", func(t *testing.T) {
+		resp := upload("kms", "cold.txt", "GLACIER_IR")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "GLACIER_IR", lastHeaders(http.MethodPut, "/files/cold.txt").Get("X-Amz-Storage-Class"))
+
+		// Only the storage classes in the allow list can be chosen
+		resp = upload("kms", "colder.txt", "DEEP_ARCHIVE")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp = upload("memory", "cold.txt", "GLACIER_IR")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("CustomerKey", func(t *testing.T) {
+		digest := md5.Sum(customerKey) //nolint:gosec // S3 requires MD5
+		encodedKey := base64.StdEncoding.EncodeToString(customerKey)
+		encodedDigest := base64.StdEncoding.EncodeToString(digest[:])
+
+		resp := upload("customer", "private.txt", "")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		h := lastHeaders(http.MethodPut, "/files/private.txt")
+		assert.Equal(t, "AES256", h.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm"))
+		assert.Equal(t, encodedKey, h.Get("X-Amz-Server-Side-Encryption-Customer-Key"))
+		assert.Equal(t, encodedDigest, h.Get("X-Amz-Server-Side-Encryption-Customer-Key-Md5"))
+
+		// The key must also be sent to read the object
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/file/customer/private.txt", port))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "secret data", string(d))
+
+		assert.Equal(t, encodedKey, lastHeaders(http.MethodGet, "/files/private.txt").Get("X-Amz-Server-Side-Encryption-Customer-Key"))
+
+		// Presigning is not possible since the client would need the key
+		resp, err = client.Post(fmt.Sprintf("http://localhost:%d/presign/customer/private.txt?op=download", port), "", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("PresignUpload", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://localhost:%d/presign/kms/presigned.txt?op=upload", port), nil)
+		assert.NoError(t, err)
+		req.Header.Set("X-Storage-Class", "GLACIER_IR")
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		presigned, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+
+		required := resp.Header.Values("X-Presign-Header")
+		assert.Contains(t, required, "X-Amz-Server-Side-Encryption: aws:kms")
+		assert.Contains(t, required, "X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id: alias/files")
+		assert.Contains(t, required, "X-Amz-Storage-Class: GLACIER_IR")
+
+		// The client sends the listed headers with the upload
+		put, err := http.NewRequest(http.MethodPut, string(presigned), strings.NewReader("presigned data"))
+		assert.NoError(t, err)
+		for _, h := range required {
+			name, value, _ := strings.Cut(h, ": ")
+			put.Header.Set(name, value)
+		}
+
+		resp, err = stub.Client().Do(put)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "GLACIER_IR", lastHeaders(http.MethodPut, "/files/presigned.txt").Get("X-Amz-Storage-Class"))
+
+		req.Header.Set("X-Storage-Class", "DEEP_ARCHIVE")
+		resp, err = client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func Test_RestoreArchived(t *testing.T) {
+	expiry := time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	var mu sync.Mutex
+	restoring := false
+	var restoreBodies []string
+
+	// The stand-in has one archived object, one standard object and one archived object that has already been restored
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		key := strings.TrimPrefix(r.URL.Path, "/files/")
+
+		writeError := func(status int, code, extra string) {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(status)
+			if r.Method != http.MethodHead {
+				fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>stub error</Message>%s</Error>`, code, extra)
+			}
+		}
+
+		if key != "cold.txt" && key != "hot.txt" && key != "thawed.txt" {
+			writeError(http.StatusNotFound, "NoSuchKey", "")
+			return
+		}
+
+		switch {
+		case r.Method == http.MethodPost && r.URL.Query().Has("restore"):
+			if restoring {
+				writeError(http.StatusConflict, "RestoreAlreadyInProgress", "")
+				return
+			}
+			body, _ := io.ReadAll(r.Body)
+			restoreBodies = append(restoreBodies, string(body))
+			restoring = true
+			w.WriteHeader(http.StatusAccepted)
+			return
+		case r.URL.Query().Has("tagging"):
+			w.Header().Set("Content-Type", "application/xml")
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><Tagging><TagSet></TagSet></Tagging>`)
+			return
+		}
+
+		if key != "hot.txt" {
+			w.Header().Set("x-amz-storage-class", "GLACIER")
+		}
+
+		switch {
+		case key == "thawed.txt":
+			w.Header().Set("x-amz-restore", fmt.Sprintf(`ongoing-request="false", expiry-date="%s"`, expiry.Format(http.TimeFormat)))
+		case key == "cold.txt" && restoring:
+			w.Header().Set("x-amz-restore", `ongoing-request="true"`)
+		case key == "cold.txt" && r.Method == http.MethodGet:
+			writeError(http.StatusForbidden, "InvalidObjectState", "<StorageClass>GLACIER</StorageClass>")
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Last-Modified", expiry.AddDate(-10, 0, 0).Format(http.TimeFormat))
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", "4")
+			return
+		}
+		fmt.Fprint(w, "data")
+	}))
+	defer stub.Close()
+
+	plg, err := authPlugin.NewPlugin(authPlugin.Config{
+		Name:    "default",
+		BuiltIn: "allow-types",
+		Args:    []string{"download", "get_metadata", "restore_object"},
+	})
+	assert.NoError(t, err)
+
+	noRestore, err := authPlugin.NewPlugin(authPlugin.Config{
+		Name:    "no-restore",
+		BuiltIn: "allow-types",
+		Args:    []string{"download", "get_metadata"},
+	})
+	assert.NoError(t, err)
+
+	port, err := getValidPort()
+	assert.NoError(t, err)
+
+	srv, err := NewServer(Config{
+		Addr:              fmt.Sprint("localhost:", port),
+		DefaultAuthPlugin: "default",
+	}, []authPlugin.Plugin{plg, noRestore})
+	assert.NoError(t, err)
+
+	for id, auth := range map[string]string{"s3": "", "s3-no-restore": "no-restore"} {
+		prov, err := provider.NewS3Provider(&provider.S3Config{
+			ConfigBase: provider.ConfigBase{ID: id, AuthPlugin: auth},
+			Bucket:     "files",
+			Endpoint:   stub.URL,
+			PathStyle:  true,
+			AccessKey:  "butler",
+			SecretKey:  "secret",
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, srv.RegisterProvider(prov))
+	}
+
+	memory, err := provider.NewMemoryProvider(&provider.MemoryConfig{ConfigBase: provider.ConfigBase{ID: "memory"}})
+	assert.NoError(t, err)
+	assert.NoError(t, srv.RegisterProvider(memory))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		assert.Nil(t, srv.Run(ctx))
+	}()
+	waitForServer(t, port)
+
+	client := http.Client{}
+
+	getMeta := func(t *testing.T, key string) string {
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/meta/s3/%s", port, key))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		return string(d)
+	}
+
+	restore := func(providerID, key, query string) (*http.Response, string) {
+		resp, err := client.Post(fmt.Sprintf("http://localhost:%d/restore/%s/%s%s", port, providerID, key, query), "", nil)
+		assert.NoError(t, err)
+
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		return resp, string(d)
+	}
+
+	t.Run("DownloadArchived", func(t *testing.T) {
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/file/s3/cold.txt", port))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		d, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Contains(t, string(d), "GLACIER")
+
+		assert.JSONEq(t, `{"archive":{"storage_class":"GLACIER","archived":true}}`, getMeta(t, "cold.txt"))
+		assert.JSONEq(t, `{"archive":{"storage_class":"STANDARD","archived":false}}`, getMeta(t, "hot.txt"))
+	})
+
+	t.Run("InvalidRequests", func(t *testing.T) {
+		resp, _ := restore("s3", "cold.txt", "?tier=instant")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp, _ = restore("s3", "cold.txt", "?days=0")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp, _ = restore("s3-no-restore", "cold.txt", "")
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		resp, _ = restore("memory", "cold.txt", "")
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp, _ = restore("s3", "hot.txt", "")
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		resp, _ = restore("s3", "missing.txt", "")
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		mu.Lock()
+		assert.Empty(t, restoreBodies)
+		mu.Unlock()
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		resp, body := restore("s3", "cold.txt", "?tier=bulk&days=3")
+		assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+		assert.JSONEq(t, `{"storage_class":"GLACIER","archived":true,"restore_in_progress":true}`, body)
+
+		mu.Lock()
+		assert.Len(t, restoreBodies, 1)
+		assert.Contains(t, restoreBodies[0], "<Days>3</Days>")
+		assert.Contains(t, restoreBodies[0], "<Tier>Bulk</Tier>")
+		mu.Unlock()
+
+		// Requesting the restore again while it is in progress is not an error
+		resp, _ = restore("s3", "cold.txt", "")
+		assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+		assert.JSONEq(t, `{"archive":{"storage_class":"GLACIER","archived":true,"restore_in_progress":true}}`, getMeta(t, "cold.txt"))
+	})
+
+	t.Run("Restored", func(t *testing.T) {
+		assert.JSONEq(t, `{"archive":{"storage_class":"GLACIER","archived":true,"restored_until":"2030-01-02T00:00:00Z"}}`, getMeta(t, "thawed.txt"))
+
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/file/s3/thawed.txt", port))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+}
+
+func Test_ReplaceProviders(t *testing.T) {
+	plg, err := authPlugin.NewPlugin(authPlugin.Config{
+		Name:    "default",
+		BuiltIn: "allow-types",
+		Args:    []string{"download"},
+	})
+	assert.NoError(t, err)
+
+	port, err := getValidPort()
+	assert.NoError(t, err)
+
+	srv, err := NewServer(Config{
+		Addr:              fmt.Sprint("localhost:", port),
+		DefaultAuthPlugin: "default",
+	}, []authPlugin.Plugin{plg})
+	assert.NoError(t, err)
+
+	// closed returns a channel that is closed when the provider is closed
+	closed := func(p *mocks.CloseProvider) chan struct{} {
+		ch := make(chan struct{})
+		p.On("Close").Run(func(mock.Arguments) { close(ch) }).Return(nil).Once()
+		return ch
+	}
+
+	oldFiles := mocks.NewCloseProvider(provider.ConfigBase{ID: "files"})
+	oldFilesClosed := closed(oldFiles)
+	kept := mocks.NewCloseProvider(provider.ConfigBase{ID: "kept"})
+	removed := mocks.NewCloseProvider(provider.ConfigBase{ID: "removed"})
+	removedClosed := closed(removed)
+
+	assert.NoError(t, srv.ReplaceProviders([]provider.Provider{oldFiles, kept, removed}))
+	assert.ElementsMatch(t, []string{"files", "kept", "removed"}, srv.ProviderIds())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		assert.Nil(t, srv.Run(ctx))
+	}()
+	waitForServer(t, port)
+
+	get := func(url string) (string, error) {
+		resp, err := http.Get(url)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		b, err := io.ReadAll(resp.Body)
+		return string(b), err
+	}
+
+	// The download from the old provider is kept waiting until the providers have been replaced
+	pr, pw := io.Pipe()
+	started := make(chan struct{})
+	oldFiles.On("GetObject", mock.Anything, "a.txt", provider.GetOptions{}).Run(func(mock.Arguments) { close(started) }).Return(pr, provider.ObjectInfo{}, nil).Once()
+
+	type result struct {
+		body string
+		err  error
+	}
+	oldResult := make(chan result, 1)
+	go func() {
+		body, err := get(fmt.Sprintf("http://localhost:%d/file/files/a.txt", port))
+		oldResult <- result{body, err}
+	}()
+	<-started
+
+	t.Run("Invalid set is not applied", func(t *testing.T) {
+		err := srv.ReplaceProviders([]provider.Provider{
+			mocks.NewProvider(provider.ConfigBase{ID: "dup"}),
+			mocks.NewProvider(provider.ConfigBase{ID: "dup"}),
+		})
+		assert.Error(t, err)
+
+		err = srv.ReplaceProviders([]provider.Provider{
+			mocks.NewProvider(provider.ConfigBase{ID: "plugin", AuthPlugin: "missing"}),
+		})
+		assert.Error(t, err)
+
+		assert.ElementsMatch(t, []string{"files", "kept", "removed"}, srv.ProviderIds())
+	})
+
+	newFiles := mocks.NewCloseProvider(provider.ConfigBase{ID: "files"})
+	newFiles.On("GetObject", mock.Anything, "a.txt", provider.GetOptions{}).Return("new", provider.ObjectInfo{}, nil).Once()
+
+	assert.NoError(t, srv.ReplaceProviders([]provider.Provider{newFiles, kept}))
+	assert.ElementsMatch(t, []string{"files", "kept"}, srv.ProviderIds())
+
+	t.Run("New requests use the new provider", func(t *testing.T) {
+		body, err := get(fmt.Sprintf("http://localhost:%d/file/files/a.txt", port))
+		assert.NoError(t, err)
+		assert.Equal(t, "new", body)
+	})
+
+	t.Run("Unused provider is closed", func(t *testing.T) {
+		select {
+		case <-removedClosed:
+		case <-time.After(time.Second):
+			t.Fatal("the removed provider was not closed")
+		}
+	})
+
+	t.Run("Provider in use is closed after the request", func(t *testing.T) {
+		select {
+		case <-oldFilesClosed:
+			t.Fatal("the replaced provider was closed while it was in use")
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		_, err := pw.Write([]byte("old"))
+		assert.NoError(t, err)
+		assert.NoError(t, pw.Close())
+
+		res := <-oldResult
+		assert.NoError(t, res.err)
+		assert.Equal(t, "old", res.body)
+
+		select {
+		case <-oldFilesClosed:
+		case <-time.After(time.Second):
+			t.Fatal("the replaced provider was not closed")
+		}
+	})
+
+	t.Run("Unchanged provider is kept", func(t *testing.T) {
+		kept.AssertNotCalled(t, "Close")
+		newFiles.AssertNotCalled(t, "Close")
+	})
+
+	// The remaining providers are closed when the server stops
+	kept.On("Close").Return(nil).Once()
+	newFiles.On("Close").Return(nil).Once()
+}
+
+// stopPlugin records when the plugin it wraps is stopped
+type stopPlugin struct {
+	authPlugin.Plugin
+	stopped chan struct{}
+}
+
+func (p *stopPlugin) Stop() error {
+	close(p.stopped)
+	return p.Plugin.Stop()
+}
+
+func Test_Reconfigure(t *testing.T) {
+	newPlugin := func(name string, types ...string) *stopPlugin {
+		plg, err := authPlugin.NewPlugin(authPlugin.Config{
+			Name:    name,
+			BuiltIn: "allow-types",
+			Args:    types,
+		})
+		assert.NoError(t, err)
+
+		return &stopPlugin{Plugin: plg, stopped: make(chan struct{})}
+	}
+
+	defaultPlg := newPlugin("default", "download")
+	specialPlg := newPlugin("special", "download")
+
+	port, err := getValidPort()
+	assert.NoError(t, err)
+
+	srv, err := NewServer(Config{
+		Addr:              fmt.Sprint("localhost:", port),
+		DefaultAuthPlugin: "default",
+	}, []authPlugin.Plugin{defaultPlg, specialPlg})
+	assert.NoError(t, err)
+
+	prov := mocks.NewProvider(provider.ConfigBase{ID: "mock"})
+	assert.NoError(t, srv.RegisterProvider(prov))
+	assert.NoError(t, srv.RegisterProvider(mocks.NewProvider(provider.ConfigBase{ID: "special", AuthPlugin: "special"})))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		assert.Nil(t, srv.Run(ctx))
+	}()
+	waitForServer(t, port)
+
+	put := func() int {
+		req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://localhost:%d/file/mock/123", port), strings.NewReader("hello"))
+		assert.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	t.Run("Invalid config is not applied", func(t *testing.T) {
+		uploadPlg := newPlugin("uploads", "upload")
+
+		// The special provider would be left without its plugin
+		err := srv.Reconfigure(Config{DefaultAuthPlugin: "uploads", AllowRawBody: true}, []authPlugin.Plugin{uploadPlg})
+		assert.ErrorContains(t, err, "auth plugin special not found for provider special")
+
+		err = srv.Reconfigure(Config{DefaultAuthPlugin: "missing", AllowRawBody: true}, []authPlugin.Plugin{uploadPlg, specialPlg})
+		assert.Error(t, err)
+
+		// The previous default plugin does not allow uploads
+		assert.Equal(t, http.StatusForbidden, put())
+
+		select {
+		case <-defaultPlg.stopped:
+			t.Fatal("the plugin was stopped by an invalid config")
+		case <-specialPlg.stopped:
+			t.Fatal("the plugin was stopped by an invalid config")
+		default:
+		}
+	})
+
+	t.Run("Settings and plugins are replaced", func(t *testing.T) {
+		uploadPlg := newPlugin("uploads", "upload")
+
+		err := srv.Reconfigure(Config{DefaultAuthPlugin: "uploads", AllowRawBody: true}, []authPlugin.Plugin{uploadPlg, specialPlg})
+		assert.NoError(t, err)
+
+		prov.On("PutObject", mock.Anything, "123", []byte("hello"), provider.PutOptions{
+			ContentType:   "text/plain; charset=utf-8",
+			ContentLength: int64(5),
+			Tags:          map[string]string(nil),
+		}).Return(nil).Once()
+
+		assert.Equal(t, http.StatusOK, put())
+
+		select {
+		case <-defaultPlg.stopped:
+		case <-time.After(time.Second):
+			t.Fatal("the removed plugin was not stopped")
+		}
+
+		select {
+		case <-specialPlg.stopped:
+			t.Fatal("the unchanged plugin was stopped")
+		default:
+		}
+	})
+
+	t.Run("Providers are validated against the new plugins", func(t *testing.T) {
+		err := srv.RegisterProvider(mocks.NewProvider(provider.ConfigBase{ID: "old", AuthPlugin: "default"}))
+		assert.ErrorContains(t, err, "auth plugin default not found")
+	})
 }
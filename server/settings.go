@@ -0,0 +1,205 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	authPlugin "github.com/theleeeo/file-butler/authorization/plugin"
+)
+
+// settings are the parts of the config that can be changed while the server is running
+type settings struct {
+	allowRawBody        bool
+	defaultAuthPlugin   string
+	trashPurgeInterval  time.Duration
+	expirySweepInterval time.Duration
+	maxArchiveFiles     int
+	maxArchiveBytes     int64
+}
+
+// newSettings validates the config and the plugins and fills in the defaults
+func newSettings(serverCfg Config, plugins []authPlugin.Plugin) (settings, error) {
+	if len(plugins) == 0 {
+		return settings{}, errors.New("at least one auth plugin is required")
+	}
+
+	if serverCfg.DefaultAuthPlugin == "" {
+		return settings{}, errors.New("default auth plugin is required")
+	}
+
+	if !pluginExists(plugins, serverCfg.DefaultAuthPlugin) {
+		return settings{}, errors.New("default auth plugin not found")
+	}
+
+	if err := validateUniquePluginNames(plugins); err != nil {
+		return settings{}, err
+	}
+
+	if serverCfg.TrashPurgeInterval == 0 {
+		serverCfg.TrashPurgeInterval = defaultTrashPurgeInterval
+	}
+
+	if serverCfg.ExpirySweepInterval == 0 {
+		serverCfg.ExpirySweepInterval = defaultExpirySweepInterval
+	}
+
+	if serverCfg.MaxArchiveFiles == 0 {
+		serverCfg.MaxArchiveFiles = defaultMaxArchiveFiles
+	}
+
+	if serverCfg.MaxArchiveBytes == 0 {
+		serverCfg.MaxArchiveBytes = defaultMaxArchiveBytes
+	}
+
+	return settings{
+		allowRawBody:        serverCfg.AllowRawBody,
+		defaultAuthPlugin:   serverCfg.DefaultAuthPlugin,
+		trashPurgeInterval:  serverCfg.TrashPurgeInterval,
+		expirySweepInterval: serverCfg.ExpirySweepInterval,
+		maxArchiveFiles:     serverCfg.MaxArchiveFiles,
+		maxArchiveBytes:     serverCfg.MaxArchiveBytes,
+	}, nil
+}
+
+// currentSettings returns a snapshot of the settings
+func (s *Server) currentSettings() settings {
+	s.settingsMx.RLock()
+	defer s.settingsMx.RUnlock()
+
+	return s.cfg
+}
+
+// registeredPlugin is an auth plugin of the server and the authorizations that are using it
+type registeredPlugin struct {
+	authPlugin.Plugin
+
+	// inFlight counts the authorizations that are using the plugin, they are waited for before the plugin is stopped
+	inFlight sync.WaitGroup
+}
+
+func findPlugin(plugins []*registeredPlugin, name string) (*registeredPlugin, bool) {
+	for _, p := range plugins {
+		if p.Name() == name {
+			return p, true
+		}
+	}
+
+	return nil, false
+}
+
+// getPlugin returns the plugin with the name, or the default plugin if the name is empty.
+// The plugin is not stopped until release is called, even if it is replaced in the meantime.
+// release is never nil and must be called exactly once.
+func (s *Server) getPlugin(name string) (p authPlugin.Plugin, release func()) {
+	s.settingsMx.RLock()
+	defer s.settingsMx.RUnlock()
+
+	if name == "" {
+		name = s.cfg.defaultAuthPlugin
+	}
+
+	rp, ok := findPlugin(s.plugins, name)
+	if !ok {
+		return nil, func() {}
+	}
+
+	rp.inFlight.Add(1)
+	return rp.Plugin, rp.inFlight.Done
+}
+
+// Reconfigure replaces the settings and the auth plugins of the running server in a single step.
+// The address can not be changed while the server is running, it is ignored.
+//
+// A plugin that is already used by the server, the same instance and not only the same name, is kept as it is.
+// The plugins that are replaced or removed are stopped once the authorizations that are using them have finished.
+// If the config is invalid, or any of the registered providers would be left without its auth plugin, nothing is changed
+// and it is up to the caller to stop the new plugins.
+func (s *Server) Reconfigure(serverCfg Config, plugins []authPlugin.Plugin) error {
+	cfg, err := newSettings(serverCfg, plugins)
+	if err != nil {
+		return err
+	}
+
+	s.configMx.Lock()
+	defer s.configMx.Unlock()
+
+	// The providers can not change while the config mutex is held, so they are still valid when the plugins are swapped
+	s.providerMx.RLock()
+	for _, p := range s.providers {
+		if specifiedPlugin := p.AuthPlugin(); specifiedPlugin != "" && !pluginExists(plugins, specifiedPlugin) {
+			s.providerMx.RUnlock()
+			return fmt.Errorf("auth plugin %s not found for provider %s", specifiedPlugin, p.Id())
+		}
+	}
+	s.providerMx.RUnlock()
+
+	s.settingsMx.Lock()
+
+	old := s.plugins
+	s.cfg = cfg
+	s.plugins = make([]*registeredPlugin, 0, len(plugins))
+
+	for _, p := range plugins {
+		if rp, ok := findPlugin(old, p.Name()); ok && rp.Plugin == p {
+			s.plugins = append(s.plugins, rp)
+			continue
+		}
+
+		if _, ok := findPlugin(old, p.Name()); ok {
+			log.Println("Replacing auth plugin", p.Name())
+		} else {
+			log.Println("Adding auth plugin", p.Name())
+		}
+
+		s.plugins = append(s.plugins, &registeredPlugin{Plugin: p})
+	}
+
+	var retired []*registeredPlugin
+	for _, rp := range old {
+		if kept, ok := findPlugin(s.plugins, rp.Name()); ok && kept == rp {
+			continue
+		}
+
+		if !pluginExists(plugins, rp.Name()) {
+			log.Println("Removing auth plugin", rp.Name())
+		}
+		retired = append(retired, rp)
+	}
+
+	s.settingsMx.Unlock()
+
+	s.retirePlugins(retired)
+
+	return nil
+}
+
+// retirePlugins stops the plugins in the background once all authorizations that are using them have finished.
+// The plugins must already have been removed from the server so that no new authorizations can start using them.
+func (s *Server) retirePlugins(plugins []*registeredPlugin) {
+	for _, rp := range plugins {
+		s.retiring.Add(1)
+		go func() {
+			defer s.retiring.Done()
+
+			rp.inFlight.Wait()
+
+			if err := rp.Stop(); err != nil {
+				log.Printf("error stopping auth plugin %s: %s", rp.Name(), err)
+			}
+		}()
+	}
+}
+
+// stopPlugins removes and stops all plugins, it waits until all of them are stopped
+func (s *Server) stopPlugins() {
+	s.settingsMx.Lock()
+	plugins := s.plugins
+	s.plugins = nil
+	s.settingsMx.Unlock()
+
+	s.retirePlugins(plugins)
+	s.retiring.Wait()
+}
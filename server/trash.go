@@ -0,0 +1,278 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/theleeeo/file-butler/provider"
+)
+
+const (
+	defaultTrashPurgeInterval = time.Hour
+
+	// trashDeletedAtMetadataKey is the metadata key that the deletion time of a trashed object is stored in
+	trashDeletedAtMetadataKey = "deleted-at"
+)
+
+// TrashedObject is an object that has been soft deleted and can be restored
+type TrashedObject struct {
+	// The key the object had before it was deleted
+	Key string `json:"key"`
+	// ID identifies this deletion of the key, the same key can be deleted multiple times
+	ID        string    `json:"id"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+type listTrashResponse struct {
+	Objects []TrashedObject `json:"objects"`
+}
+
+// trashKey returns the key that an object deleted at the given time is stored under in the trash.
+// The deletion time is the last path segment so the same key can be in the trash multiple times.
+func trashKey(cfg *provider.TrashConfig, key string, deletedAt time.Time) string {
+	return cfg.TrashPrefix() + key + "/" + strconv.FormatInt(deletedAt.UnixNano(), 10)
+}
+
+// parseTrashKey is the reverse of trashKey
+func parseTrashKey(cfg *provider.TrashConfig, key string) (TrashedObject, bool) {
+	trimmed, ok := strings.CutPrefix(key, cfg.TrashPrefix())
+	if !ok {
+		return TrashedObject{}, false
+	}
+
+	i := strings.LastIndex(trimmed, "/")
+	if i <= 0 {
+		return TrashedObject{}, false
+	}
+
+	nanos, err := strconv.ParseInt(trimmed[i+1:], 10, 64)
+	if err != nil {
+		return TrashedObject{}, false
+	}
+
+	return TrashedObject{
+		Key:       trimmed[:i],
+		ID:        trimmed[i+1:],
+		DeletedAt: time.Unix(0, nanos).UTC(),
+	}, true
+}
+
+// isHiddenKey reports if the key is reserved by the server and must not be accessed directly through the provider endpoints
+func isHiddenKey(p provider.Provider, key string) bool {
+	trash := p.Policy().Trash
+	return trash != nil && strings.HasPrefix(key, trash.TrashPrefix())
+}
+
+// copyOptionsFunc adjusts the options that a copied object is written with, based on the info of the source object.
+// The options already contain the content type, tags and metadata of the source.
+type copyOptionsFunc func(info provider.ObjectInfo, opts *provider.PutOptions) error
+
+// copyObject copies an object including its content type, tags and metadata to a key in the same or another provider.
+// The providers have no generic copy operation so the data is streamed through the server.
+// If prepare is not nil it is called with the info of the source before anything is written.
+func copyObject(ctx context.Context, src provider.Provider, srcKey string, dst provider.Provider, dstKey string, prepare copyOptionsFunc) error {
+	data, info, err := src.GetObject(ctx, srcKey, provider.GetOptions{})
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	tags, err := src.GetTags(ctx, srcKey)
+	if err != nil {
+		return fmt.Errorf("unable to get tags: %w", err)
+	}
+
+	opts := provider.PutOptions{
+		Tags:     tags,
+		Metadata: maps.Clone(info.Metadata),
+	}
+
+	if info.ContentType != nil {
+		opts.ContentType = *info.ContentType
+	}
+
+	if prepare != nil {
+		if err := prepare(info, &opts); err != nil {
+			return err
+		}
+	}
+
+	var r io.Reader = data
+	if info.ContentLength != nil {
+		opts.ContentLength = *info.ContentLength
+	} else {
+		b, err := io.ReadAll(data)
+		if err != nil {
+			return err
+		}
+		opts.ContentLength = int64(len(b))
+		r = bytes.NewReader(b)
+	}
+
+	if err := dst.PutObject(ctx, dstKey, r, opts); err != nil {
+		return fmt.Errorf("unable to write %s: %w", dstKey, err)
+	}
+
+	return nil
+}
+
+// moveObject copies an object to a new key in the same provider and then deletes the original
+func moveObject(ctx context.Context, p provider.Provider, srcKey, dstKey string, prepare copyOptionsFunc) error {
+	if err := copyObject(ctx, p, srcKey, p, dstKey, prepare); err != nil {
+		return err
+	}
+
+	if err := p.DeleteObject(ctx, srcKey, provider.DeleteOptions{}); err != nil {
+		return fmt.Errorf("unable to delete %s: %w", srcKey, err)
+	}
+
+	return nil
+}
+
+// trashObject soft deletes an object by moving it into the trash of the provider.
+// The deletion time is added to the metadata of the object, the rest of it is kept so that a restore returns the object as it was.
+func trashObject(ctx context.Context, p provider.Provider, key string) error {
+	now := time.Now()
+
+	return moveObject(ctx, p, key, trashKey(p.Policy().Trash, key, now), func(_ provider.ObjectInfo, opts *provider.PutOptions) error {
+		if opts.Metadata == nil {
+			opts.Metadata = make(map[string]string)
+		}
+		opts.Metadata[trashDeletedAtMetadataKey] = now.UTC().Format(time.RFC3339)
+		// The trash retention decides when a trashed object is removed, and a restored object should not expire right away
+		delete(opts.Metadata, expiresAtMetadataKey)
+
+		return nil
+	})
+}
+
+// listTrash returns all trashed objects that had a key starting with the prefix, most recently deleted first
+func listTrash(ctx context.Context, p provider.Provider, prefix string) ([]TrashedObject, error) {
+	cfg := p.Policy().Trash
+
+	resp, err := p.ListObjects(ctx, cfg.TrashPrefix()+prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := []TrashedObject{}
+	for _, k := range resp.Keys {
+		obj, ok := parseTrashKey(cfg, k)
+		if !ok {
+			continue
+		}
+
+		objects = append(objects, obj)
+	}
+
+	sort.SliceStable(objects, func(i, j int) bool {
+		return objects[i].DeletedAt.After(objects[j].DeletedAt)
+	})
+
+	return objects, nil
+}
+
+// restoreTrash moves a trashed object back to its original key, overwriting anything that has been written there since.
+// If id is empty the most recently deleted object with the key is restored.
+func restoreTrash(ctx context.Context, p provider.Provider, key, id string) error {
+	cfg := p.Policy().Trash
+
+	objects, err := listTrash(ctx, p, key+"/")
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range objects {
+		if obj.Key != key || (id != "" && obj.ID != id) {
+			continue
+		}
+
+		// The deletion time and the expiry are removed, the retention and other metadata of the object are restored with it.
+		// Objects trashed by older versions may still have their expiry.
+		return moveObject(ctx, p, cfg.TrashPrefix()+obj.Key+"/"+obj.ID, key, func(_ provider.ObjectInfo, opts *provider.PutOptions) error {
+			delete(opts.Metadata, trashDeletedAtMetadataKey)
+			delete(opts.Metadata, expiresAtMetadataKey)
+			if len(opts.Metadata) == 0 {
+				opts.Metadata = nil
+			}
+
+			return nil
+		})
+	}
+
+	return provider.ErrNotFound
+}
+
+// purgeTrash permanently deletes all objects that have been in the trash for longer than the retention period
+func purgeTrash(ctx context.Context, p provider.Provider) (int, error) {
+	cfg := p.Policy().Trash
+	if cfg == nil || cfg.Retention <= 0 {
+		return 0, nil
+	}
+
+	objects, err := listTrash(ctx, p, "")
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-time.Duration(cfg.Retention))
+
+	var purged int
+	for _, obj := range objects {
+		if obj.DeletedAt.After(cutoff) {
+			continue
+		}
+
+		err := p.DeleteObject(ctx, cfg.TrashPrefix()+obj.Key+"/"+obj.ID, provider.DeleteOptions{})
+		if err != nil && !errors.Is(err, provider.ErrNotFound) {
+			return purged, err
+		}
+
+		purged++
+	}
+
+	return purged, nil
+}
+
+// runTrashPurger periodically purges expired objects from the trash of all providers until the context is canceled
+func (s *Server) runTrashPurger(ctx context.Context) {
+	interval := s.currentSettings().trashPurgeInterval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// A changed interval takes effect from the next run
+		if current := s.currentSettings().trashPurgeInterval; current != interval {
+			interval = current
+			ticker.Reset(interval)
+		}
+
+		providers, release := s.providerList()
+		for _, p := range providers {
+			purged, err := purgeTrash(ctx, p)
+			if err != nil {
+				log.Printf("error purging trash of provider %s: %s", p.Id(), err)
+			}
+
+			if purged > 0 {
+				log.Printf("Purged %d objects from the trash of provider %s", purged, p.Id())
+			}
+		}
+		release()
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/viper"
+	authPlugin "github.com/theleeeo/file-butler/authorization/plugin"
+)
+
+// configProblems collects the problems found in the config files
+type configProblems []string
+
+func (p *configProblems) add(file, format string, args ...any) {
+	*p = append(*p, fmt.Sprintf("%s: %s", file, fmt.Sprintf(format, args...)))
+}
+
+// runValidate checks the main config and the provider configs without starting anything.
+// All problems are written to w, and it returns false if there were any.
+func runValidate(w io.Writer) bool {
+	var problems configProblems
+
+	if err := readMainConfig(); err != nil {
+		fmt.Fprintln(w, color.RedString("ERROR: %s", err))
+		return false
+	}
+	mainFile := viper.ConfigFileUsed()
+
+	pluginNames := validateMainConfig(mainFile, &problems)
+
+	providers := 0
+	sources, err := findProviderSources()
+	if err != nil {
+		problems.add(mainFile, "%s", err)
+	} else {
+		providers = validateProviders(sources, pluginNames, &problems)
+	}
+
+	if len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Fprintln(w, color.RedString("%s", p))
+		}
+		fmt.Fprintln(w, color.RedString("found %d problems", len(problems)))
+		return false
+	}
+
+	fmt.Fprintln(w, color.GreenString("the configuration is valid, %d auth plugins and %d providers", len(pluginNames), providers))
+	return true
+}
+
+// validateMainConfig checks the server settings and the auth plugins, it returns the names of the plugins
+func validateMainConfig(file string, problems *configProblems) map[string]bool {
+	for key := range viper.AllSettings() {
+		if !slices.Contains(mainConfigKeys, key) {
+			problems.add(file, "unknown key %q", key)
+		}
+	}
+
+	if server, ok := viper.Get("server").(map[string]any); ok {
+		for key := range server {
+			if _, ok := serverConfigKeys[strings.ToLower(key)]; !ok {
+				problems.add(file, "unknown key %q", "server."+key)
+			}
+		}
+	}
+
+	if viper.GetString("server.addr") == "" {
+		problems.add(file, "server.addr is required")
+	}
+
+	var cfgs []authPlugin.Config
+	if err := unmarshalPluginConfigs(&cfgs); err != nil {
+		problems.add(file, "auth-plugins: %s", err)
+		return map[string]bool{}
+	}
+
+	rawPlugins, _ := viper.Get("auth-plugins").([]any)
+
+	pluginNames := make(map[string]bool, len(cfgs))
+	for i, cfg := range cfgs {
+		where := fmt.Sprintf("auth-plugins[%d]", i)
+		if cfg.Name != "" {
+			where = fmt.Sprintf("auth-plugins[%d] (%s)", i, cfg.Name)
+		}
+
+		if i < len(rawPlugins) {
+			if raw, ok := rawPlugins[i].(map[string]any); ok {
+				for _, key := range unknownKeys(reflect.TypeOf(cfg), raw, "") {
+					problems.add(file, "%s: unknown key %q", where, key)
+				}
+			}
+		}
+
+		for _, key := range missingRequired(reflect.ValueOf(cfg)) {
+			problems.add(file, "%s: %s is required", where, key)
+		}
+
+		if pluginNames[cfg.Name] {
+			problems.add(file, "%s: the name is used by another plugin", where)
+		}
+		pluginNames[cfg.Name] = true
+
+		kinds := 0
+		for _, set := range []bool{len(cfg.Cmd) > 0, cfg.Addr != "", cfg.BuiltIn != ""} {
+			if set {
+				kinds++
+			}
+		}
+		if kinds != 1 {
+			problems.add(file, "%s: exactly one of cmd, addr or builtin must be set", where)
+		}
+
+		switch cfg.BuiltIn {
+		case "":
+		case "allow-types":
+			if _, err := authPlugin.NewAllowTypesPlugin(cfg.Args); err != nil {
+				problems.add(file, "%s: %s", where, err)
+			}
+		default:
+			problems.add(file, "%s: unknown built-in plugin %q", where, cfg.BuiltIn)
+		}
+	}
+
+	if len(cfgs) == 0 {
+		problems.add(file, "at least one auth plugin is required")
+	}
+
+	if defaultPlugin := viper.GetString("server.default_auth_plugin"); defaultPlugin == "" {
+		problems.add(file, "server.default_auth_plugin is required")
+	} else if !pluginNames[defaultPlugin] {
+		problems.add(file, "server.default_auth_plugin: auth plugin %q is not defined", defaultPlugin)
+	}
+
+	return pluginNames
+}
+
+// validateProviders checks every provider in every provider config file, it returns the number of providers
+func validateProviders(sources providerSources, pluginNames map[string]bool, problems *configProblems) int {
+	files, err := sources.files()
+	if err != nil {
+		problems.add(sources.dir, "%s", err)
+		return 0
+	}
+
+	definedIn := make(map[string][]string)
+
+	for _, file := range files {
+		v := viper.New()
+		v.SetConfigFile(file)
+		if err := v.ReadInConfig(); err != nil {
+			problems.add(file, "%s", err)
+			continue
+		}
+
+		ids := make([]string, 0, len(v.AllSettings()))
+		for id := range v.AllSettings() {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		for _, id := range ids {
+			definedIn[id] = append(definedIn[id], file)
+			validateProvider(file, id, v.Get(id), pluginNames, problems)
+		}
+	}
+
+	ids := make([]string, 0, len(definedIn))
+	for id := range definedIn {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		if files := definedIn[id]; len(files) > 1 {
+			problems.add(files[0], "provider %s is also defined in %s", id, strings.Join(files[1:], ", "))
+		}
+	}
+
+	return len(definedIn)
+}
+
+func validateProvider(file, id string, val any, pluginNames map[string]bool, problems *configProblems) {
+	cfg, err := decodeProviderConfig(id, val)
+	if err != nil {
+		problems.add(file, "provider %s: %s", id, err)
+		return
+	}
+
+	for _, key := range unknownProviderKeys(cfg, val) {
+		problems.add(file, "provider %s: unknown key %q", id, key)
+	}
+
+	for _, key := range missingRequired(reflect.ValueOf(cfg)) {
+		problems.add(file, "provider %s: %s is required", id, key)
+	}
+
+	base := cfg.Base()
+
+	if err := base.Policy.Validate(); err != nil {
+		problems.add(file, "provider %s: %s", id, err)
+	}
+
+	if base.AuthPlugin != "" && !pluginNames[base.AuthPlugin] {
+		problems.add(file, "provider %s: auth-plugin %q is not defined", id, base.AuthPlugin)
+	}
+}
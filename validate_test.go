@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ValidateProvider(t *testing.T) {
+	pluginNames := map[string]bool{"plugin": true}
+
+	t.Run("Valid", func(t *testing.T) {
+		var problems configProblems
+		validateProvider("providers.toml", "files", map[string]any{"type": "s3", "bucket": "files", "presign-enabled": true, "auth-plugin": "plugin"}, pluginNames, &problems)
+		assert.Empty(t, problems)
+	})
+
+	t.Run("Problems", func(t *testing.T) {
+		var problems configProblems
+		validateProvider("providers.toml", "files", map[string]any{"type": "s3", "presign_enabled": true, "auth-plugin": "other", "mode": "sometimes"}, pluginNames, &problems)
+		assert.Equal(t, configProblems{
+			`providers.toml: provider files: unknown key "presign_enabled"`,
+			"providers.toml: provider files: bucket is required",
+			"providers.toml: provider files: unknown mode: sometimes",
+			`providers.toml: provider files: auth-plugin "other" is not defined`,
+		}, problems)
+	})
+
+	t.Run("Unknown type", func(t *testing.T) {
+		var problems configProblems
+		validateProvider("providers.toml", "files", map[string]any{"type": "ftp"}, pluginNames, &problems)
+		assert.Len(t, problems, 1)
+	})
+}